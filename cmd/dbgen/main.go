@@ -0,0 +1,169 @@
+// Package main contains dbgen, the generator of the database mappings of the models.
+//
+// For every model given with -types it reads the fields with a "db" struct tag (skipping "-")
+// from the models file and writes, in the order of the fields:
+//
+//   - <model>Columns, the list of mapped columns;
+//   - <model>Args, the fields as query arguments;
+//   - <model>Dest, pointers to the fields as scan destinations;
+//
+// and the mappings list used by the drift checks of the db package. It is run by go generate:
+//
+//	go generate ./internal/db
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"text/template"
+	"unicode"
+)
+
+// model is a struct mapped to the columns of a table.
+type model struct {
+	Type    string
+	Var     string
+	Table   string
+	Fields  []string
+	Columns []string
+}
+
+var output = template.Must(template.New("mapping").Parse(`// Code generated by dbgen from {{.Source}}; DO NOT EDIT.
+
+package {{.Package}}
+
+import "{{.Import}}"
+{{range .Models}}
+// {{.Var}}Columns are the columns of the {{.Table}} table mapped to the fields of models.{{.Type}}, in order.
+var {{.Var}}Columns = []string{ {{- range $i, $c := .Columns}}{{if $i}}, {{end}}"{{$c}}"{{end -}} }
+
+// {{.Var}}Args returns the mapped fields of v as query arguments, in the order of {{.Var}}Columns.
+func {{.Var}}Args(v *models.{{.Type}}) []interface{} {
+	return []interface{}{ {{- range $i, $f := .Fields}}{{if $i}}, {{end}}v.{{$f}}{{end -}} }
+}
+
+// {{.Var}}Dest returns pointers to the mapped fields of v as scan destinations, in the order of {{.Var}}Columns.
+func {{.Var}}Dest(v *models.{{.Type}}) []interface{} {
+	return []interface{}{ {{- range $i, $f := .Fields}}{{if $i}}, {{end}}&v.{{$f}}{{end -}} }
+}
+{{end}}
+// mappings lists the mapped models for the drift checks.
+var mappings = []mapping{
+{{- range .Models}}
+	{
+		table:   "{{.Table}}",
+		columns: {{.Var}}Columns,
+		dest:    func() (interface{}, []interface{}) { v := &models.{{.Type}}{}; return v, {{.Var}}Dest(v) },
+	},
+{{- end}}
+}
+`))
+
+func main() {
+	in := flag.String("in", "", "Go file declaring the models")
+	out := flag.String("out", "", "generated file")
+	pkg := flag.String("package", "db", "package of the generated file")
+	importPath := flag.String("import", "demo_service/internal/models", "import path of the models package")
+	types := flag.String("types", "", "comma-separated Type=table pairs")
+	flag.Parse()
+
+	if *in == "" || *out == "" || *types == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	models, err := parseModels(*in, *types)
+	if err != nil {
+		log.Fatalf("(dbgen) | %v", err)
+	}
+
+	var buf bytes.Buffer
+	err = output.Execute(&buf, map[string]interface{}{
+		"Source":  filepath.Base(*in),
+		"Package": *pkg,
+		"Import":  *importPath,
+		"Models":  models,
+	})
+	if err != nil {
+		log.Fatalf("(dbgen) | %v", err)
+	}
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		log.Fatalf("(dbgen) | generated invalid code: %v\n%s", err, buf.Bytes())
+	}
+	if err := os.WriteFile(*out, src, 0o644); err != nil {
+		log.Fatalf("(dbgen) | %v", err)
+	}
+}
+
+// parseModels reads the mapped fields of the types (Type=table pairs) from the Go file.
+func parseModels(file, types string) ([]model, error) {
+	f, err := parser.ParseFile(token.NewFileSet(), file, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	structs := make(map[string]*ast.StructType)
+	ast.Inspect(f, func(n ast.Node) bool {
+		if spec, ok := n.(*ast.TypeSpec); ok {
+			if st, ok := spec.Type.(*ast.StructType); ok {
+				structs[spec.Name.Name] = st
+			}
+		}
+		return true
+	})
+
+	var models []model
+	for _, pair := range strings.Split(types, ",") {
+		typeName, table, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid type %q, want Type=table", pair)
+		}
+		st, ok := structs[typeName]
+		if !ok {
+			return nil, fmt.Errorf("struct %s not found in %s", typeName, file)
+		}
+
+		m := model{Type: typeName, Var: lowerFirst(typeName), Table: table}
+		for _, field := range st.Fields.List {
+			if field.Tag == nil || len(field.Names) == 0 {
+				continue
+			}
+			tag, err := strconv.Unquote(field.Tag.Value)
+			if err != nil {
+				return nil, err
+			}
+			column := reflect.StructTag(tag).Get("db")
+			if column == "" || column == "-" {
+				continue
+			}
+			for _, name := range field.Names {
+				m.Fields = append(m.Fields, name.Name)
+				m.Columns = append(m.Columns, column)
+			}
+		}
+		if len(m.Columns) == 0 {
+			return nil, fmt.Errorf("struct %s has no db tags", typeName)
+		}
+		models = append(models, m)
+	}
+	return models, nil
+}
+
+// lowerFirst returns the name with its first letter in lower case.
+func lowerFirst(name string) string {
+	r := []rune(name)
+	r[0] = unicode.ToLower(r[0])
+	return string(r)
+}
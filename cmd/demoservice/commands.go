@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"demo_service/internal/cache"
+	"demo_service/internal/config"
+	"demo_service/internal/db"
+	"demo_service/internal/ingest"
+	"demo_service/internal/kafka"
+	"demo_service/internal/migrate"
+	orderModule "demo_service/internal/modules"
+	"demo_service/internal/retry"
+	"demo_service/internal/rules"
+	"demo_service/migrations"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// usage describes the subcommands of the service.
+const usage = `Usage:
+  demoservice                         run the service
+  demoservice consumer status         show whether consumption is paused
+  demoservice consumer pause          pause consumption
+  demoservice consumer resume         resume consumption
+  demoservice consumer reset -to earliest|offset|timestamp [-offset N] [-timestamp RFC3339]
+                                      reset the offsets of the consumer group
+  demoservice import FILE...          store the orders from JSON or NDJSON files ("-" for stdin)
+  demoservice migrate status          show the applied and pending migrations of the database schema
+  demoservice migrate up              apply all pending migrations
+  demoservice migrate down [N]        revert the last N (default 1) applied migrations
+  demoservice migrate to N            migrate the schema to version N (0 reverts everything)`
+
+// runCommand runs the subcommand given in the arguments.
+func runCommand(cfg *config.Config, args []string) error {
+	switch args[0] {
+	case "consumer":
+		return consumerCommand(cfg, args[1:])
+	case "import":
+		return importCommand(cfg, args[1:])
+	case "migrate":
+		return migrateCommand(cfg, args[1:])
+	case "help", "-h", "--help":
+		fmt.Println(usage)
+		return nil
+	default:
+		return fmt.Errorf("unknown command %q\n%s", args[0], usage)
+	}
+}
+
+// consumerCommand controls the consumer of the running service through its admin API.
+func consumerCommand(cfg *config.Config, args []string) error {
+	const fn = "consumerCommand"
+
+	if len(args) == 0 {
+		return errors.New(usage)
+	}
+
+	var (
+		method = http.MethodPost
+		path   = "/admin/consumer/" + args[0]
+		body   []byte
+	)
+	switch args[0] {
+	case "status":
+		method, path = http.MethodGet, "/admin/consumer"
+	case "pause", "resume":
+	case "reset":
+		flags := flag.NewFlagSet("consumer reset", flag.ContinueOnError)
+		to := flags.String("to", "", "reset target: earliest, offset or timestamp")
+		offset := flags.Int64("offset", 0, "offset to reset every partition to")
+		timestamp := flags.String("timestamp", "", "time (RFC 3339) to reset every partition to")
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+
+		target := kafka.ResetTarget{To: *to, Offset: *offset}
+		if *timestamp != "" {
+			t, err := time.Parse(time.RFC3339, *timestamp)
+			if err != nil {
+				return fmt.Errorf("(%s) | invalid timestamp: %w", fn, err)
+			}
+			target.Timestamp = t
+		}
+		if err := target.Validate(); err != nil {
+			return fmt.Errorf("(%s) | %w", fn, err)
+		}
+
+		var err error
+		if body, err = json.Marshal(target); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown consumer command %q\n%s", args[0], usage)
+	}
+
+	if cfg.HTTPServer.AdminToken == "" {
+		return fmt.Errorf("(%s) | admin token is not configured (ADMIN_TOKEN)", fn)
+	}
+
+	req, err := http.NewRequest(method, "http://"+cfg.HTTPServer.Address+path, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("(%s) | %w", fn, err)
+	}
+	req.Header.Set("Authorization", "Bearer "+cfg.HTTPServer.AdminToken)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := (&http.Client{Timeout: 15 * time.Second}).Do(req)
+	if err != nil {
+		return fmt.Errorf("(%s) | request failed: %w", fn, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("(%s) | %s: %s", fn, resp.Status, bytes.TrimSpace(msg))
+	}
+	_, err = io.Copy(os.Stdout, resp.Body)
+	return err
+}
+
+// importCommand stores the orders from the files (a single order as in model.json, an array of orders
+// or NDJSON) directly in the configured storage, printing the result of every order to stdout as NDJSON.
+func importCommand(cfg *config.Config, files []string) error {
+	const fn = "importCommand"
+
+	if len(files) == 0 {
+		return errors.New(usage)
+	}
+
+	ctx := context.Background()
+	storage, err := db.Open(ctx, cfg.DB)
+	if err != nil {
+		return fmt.Errorf("(%s) | %w", fn, err)
+	}
+	defer storage.Close()
+
+	rulesEngine, err := rules.New(cfg.Rules)
+	if err != nil {
+		return fmt.Errorf("(%s) | %w", fn, err)
+	}
+	module := orderModule.New(ctx, cache.New(cfg.Cache.Capacity), storage, rulesEngine)
+	ingester := ingest.New(module, retry.New(cfg.Broker.Retry, db.IsTransient))
+
+	counts := make(map[string]int)
+	enc := json.NewEncoder(os.Stdout)
+	for _, name := range files {
+		err := importFile(ctx, ingester, name, func(result ingest.Result) error {
+			counts[result.Status]++
+			return enc.Encode(struct {
+				File string `json:"file"`
+				ingest.Result
+			}{name, result})
+		})
+		if err != nil {
+			return fmt.Errorf("(%s) | %s: %w", fn, name, err)
+		}
+	}
+
+	log.Printf("(%s) | created: %d, updated: %d, unchanged: %d, invalid: %d, rejected: %d, failed: %d\n", fn,
+		counts[ingest.StatusCreated], counts[ingest.StatusUpdated], counts[ingest.StatusUnchanged],
+		counts[ingest.StatusInvalid], counts[ingest.StatusRejected], counts[ingest.StatusFailed])
+	if counts[ingest.StatusFailed] > 0 {
+		return fmt.Errorf("(%s) | %d order(s) failed to be stored", fn, counts[ingest.StatusFailed])
+	}
+	return nil
+}
+
+// importFile ingests the orders from the file, or from stdin if the name is "-".
+func importFile(ctx context.Context, ingester *ingest.Ingester, name string, emit func(ingest.Result) error) error {
+	if name == "-" {
+		return ingester.Ingest(ctx, os.Stdin, emit)
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return ingester.Ingest(ctx, f, emit)
+}
+
+// migrateCommand manages the versions of the PostgreSQL schema with the embedded migrations.
+func migrateCommand(cfg *config.Config, args []string) error {
+	const fn = "migrateCommand"
+
+	if len(args) == 0 {
+		return errors.New(usage)
+	}
+
+	ctx := context.Background()
+	migrator, closeFn, err := newMigrator(ctx, cfg.DB)
+	if err != nil {
+		return fmt.Errorf("(%s) | %w", fn, err)
+	}
+	defer closeFn()
+
+	var count int
+	switch args[0] {
+	case "status":
+		states, err := migrator.Status(ctx)
+		if err != nil {
+			return fmt.Errorf("(%s) | %w", fn, err)
+		}
+		for _, state := range states {
+			applied := "pending"
+			if state.AppliedAt != nil {
+				applied = "applied " + state.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%04d  %-32s %s\n", state.Version, state.Name, applied)
+		}
+		return nil
+	case "up":
+		count, err = migrator.Up(ctx)
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			if steps, err = strconv.Atoi(args[1]); err != nil {
+				return fmt.Errorf("(%s) | invalid number of steps: %w", fn, err)
+			}
+		}
+		count, err = migrator.Down(ctx, steps)
+	case "to":
+		if len(args) < 2 {
+			return errors.New(usage)
+		}
+		version, convErr := strconv.Atoi(args[1])
+		if convErr != nil {
+			return fmt.Errorf("(%s) | invalid version: %w", fn, convErr)
+		}
+		count, err = migrator.To(ctx, version)
+	default:
+		return fmt.Errorf("unknown migrate command %q\n%s", args[0], usage)
+	}
+	if err != nil {
+		return fmt.Errorf("(%s) | %w", fn, err)
+	}
+
+	log.Printf("(%s) | %d migration(s) changed\n", fn, count)
+	return nil
+}
+
+// newMigrator connects to the PostgreSQL database and creates a Migrator with the embedded migrations.
+// The returned function closes the connection.
+func newMigrator(ctx context.Context, dbCfg config.DataBase) (*migrate.Migrator, func(), error) {
+	if dbCfg.Backend != config.BackendPostgres {
+		return nil, nil, fmt.Errorf("migrations apply to the %q backend only, not %q", config.BackendPostgres, dbCfg.Backend)
+	}
+
+	pool, err := db.Connect(ctx, dbCfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	migrator, err := migrate.New(pool, migrations.FS)
+	if err != nil {
+		pool.Close()
+		return nil, nil, err
+	}
+	return migrator, pool.Close, nil
+}
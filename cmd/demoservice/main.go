@@ -6,10 +6,12 @@ import (
 	"demo_service/internal/cache"
 	"demo_service/internal/config"
 	"demo_service/internal/db"
-	"demo_service/internal/kafka"
-	"demo_service/internal/models"
+	"demo_service/internal/ingest"
 	orderModule "demo_service/internal/modules"
+	"demo_service/internal/retry"
+	"demo_service/internal/rules"
 	"demo_service/internal/server"
+	"demo_service/internal/transport"
 	"fmt"
 	"log"
 	"os"
@@ -19,18 +21,36 @@ import (
 )
 
 var (
-	storage       *db.Storage
+	storage       db.OrderRepository
 	cacheInstance *cache.Cache
-	kfkAdapter    *kafka.ConsumerAdapter
+	ingestion     transport.Transport
+	ordModule     *orderModule.Order
 )
 
 func main() {
 	var err error
 
 	cfg := config.MustLoad()
+
+	// Подкоманды (например, "consumer pause") выполняются вместо запуска сервиса
+	if len(os.Args) > 1 {
+		if err := runCommand(cfg, os.Args[1:]); err != nil {
+			log.Fatalf("Fatal ERROR: %v", err)
+		}
+		return
+	}
+
 	ctx, ctxCancel := context.WithCancel(context.Background())
 
-	storage, err = db.New(ctx, cfg.DB)
+	// Схема PostgreSQL должна быть актуальной до запуска сервиса
+	if cfg.DB.Backend == config.BackendPostgres {
+		if err := ensureSchema(ctx, cfg.DB); err != nil {
+			log.Fatalf("Fatal ERROR: %v", err)
+		}
+	}
+
+	// Хранилище выбирается в конфиге (PostgreSQL или память)
+	storage, err = db.Open(ctx, cfg.DB)
 	if err != nil {
 		log.Fatalf("Fatal ERROR: %v", err)
 	}
@@ -40,17 +60,31 @@ func main() {
 		log.Fatalf("Fatal ERROR: %v", err)
 	}
 
-	// Создаем адаптер Kafka
-	kfkAdapter, err = kafka.NewConsumerAdapter(cfg.Broker)
+	// Создаем транспорт (Kafka или NATS JetStream)
+	ingestion, err = transport.New(cfg)
+	if err != nil {
+		log.Fatalf("Error creating a transport: %v\n", err)
+	}
+
+	rulesEngine, err := rules.New(cfg.Rules)
 	if err != nil {
-		log.Fatalf("Error creating a Kafka adapter: %v\n", err)
+		log.Fatalf("Fatal ERROR: %v", err)
 	}
+	ordModule = orderModule.New(ctx, cacheInstance, storage, rulesEngine)
 
 	log.Println("Starting service... version: ", cfg.Version)
-	go consumerProcessor(ctx)
+	policy := retry.New(cfg.Broker.Retry, db.IsTransient)
+	go consumerProcessor(ctx, policy)
 
-	ordModule := orderModule.New(ctx, cacheInstance, storage)
 	apiServer := server.New(ctx, ordModule, &cfg.HTTPServer)
+	if controller, ok := ingestion.(server.ConsumerController); ok {
+		apiServer.SetConsumerController(controller)
+	}
+	// Заказы, присланные через API, проходят ту же обработку, что и из брокера
+	apiServer.SetIngester(ingest.New(ordModule, policy))
+	if store, ok := storage.(server.IdempotencyStore); ok {
+		apiServer.SetIdempotencyStore(store)
+	}
 
 	log.Println("Starting server...")
 	go func() {
@@ -76,9 +110,9 @@ func gracefulShutdown(ctxCancel context.CancelFunc) error {
 	log.Println("Shutting down gracefully...")
 	ctxCancel()
 
-	if kfkAdapter != nil {
-		if err := kfkAdapter.Close(); err != nil {
-			return fmt.Errorf("(%s) | Error closing Kafka adapter: %w", fn, err)
+	if ingestion != nil {
+		if err := ingestion.Close(); err != nil {
+			return fmt.Errorf("(%s) | Error closing transport: %w", fn, err)
 		}
 	}
 
@@ -91,6 +125,32 @@ func gracefulShutdown(ctxCancel context.CancelFunc) error {
 	return nil
 }
 
+// ensureSchema applies pending migrations if auto-migration is enabled,
+// otherwise it returns an error if the schema is behind.
+func ensureSchema(ctx context.Context, dbCfg config.DataBase) error {
+	const fn = "ensureSchema"
+
+	migrator, closeFn, err := newMigrator(ctx, dbCfg)
+	if err != nil {
+		return fmt.Errorf("(%s) | %w", fn, err)
+	}
+	defer closeFn()
+
+	if !dbCfg.AutoMigrate {
+		if err := migrator.Check(ctx); err != nil {
+			return fmt.Errorf("(%s) | %w (run \"demoservice migrate up\" or enable auto_migrate)", fn, err)
+		}
+		return nil
+	}
+
+	count, err := migrator.Up(ctx)
+	if err != nil {
+		return fmt.Errorf("(%s) | %w", fn, err)
+	}
+	log.Printf("(%s) | Schema is at version %d, %d migration(s) applied\n", fn, migrator.Latest(), count)
+	return nil
+}
+
 func cacheFill(ctx context.Context, limit int) error {
 	const fn = "cacheFill"
 
@@ -112,19 +172,6 @@ func cacheFill(ctx context.Context, limit int) error {
 	return nil
 }
 
-func consumerProcessor(ctx context.Context) {
-	const fn = "consumerStart"
-
-	kfkAdapter.Start(ctx, func(order models.Order) error {
-		log.Printf("(%s) Message received: %s\n", fn, order.OrderUID)
-		if err := storage.SaveOrder(ctx, order); err != nil {
-			log.Printf("(%s) | Error saving order: %v\n", fn, err)
-			return err
-		}
-		if !cacheInstance.Set(order.OrderUID, order) {
-			log.Printf("(%s) | Error caching order!\n", fn)
-			return fmt.Errorf("(%s) | error caching order", fn)
-		}
-		return nil
-	})
+func consumerProcessor(ctx context.Context, policy *retry.Policy) {
+	ingestion.Start(ctx, transport.NewHandler(ctx, ordModule, policy))
 }
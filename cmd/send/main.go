@@ -4,8 +4,9 @@ package main
 import (
 	"demo_service/cmd/send/fake_order"
 	"demo_service/internal/config"
+	"demo_service/internal/kafka"
 	"demo_service/internal/models"
-	"encoding/json"
+	"flag"
 	"fmt"
 	"log"
 
@@ -17,6 +18,23 @@ func main() {
 	cfg := config.MustLoad()
 	// fmt.Println(cfg)
 
+	// Формат сообщения: json, protobuf, avro или all (по одному заказу в каждом формате)
+	codecName := flag.String("codec", cfg.Broker.Codec, "message format: json, protobuf, avro or all")
+	flag.Parse()
+
+	codecs, err := kafka.NewCodecs(cfg.Broker)
+	if err != nil {
+		log.Fatalf("Ошибка при создании кодеков: %v\n", err)
+	}
+	selected := codecs.All()
+	if *codecName != "all" {
+		codec, ok := codecs.Get(*codecName)
+		if !ok {
+			log.Fatalf("Неизвестный или недоступный формат: %s\n", *codecName)
+		}
+		selected = []kafka.Codec{codec}
+	}
+
 	// Создаем новый продюсер Kafka
 	producer, err := newKafkaProducer(cfg.Broker)
 	if err != nil {
@@ -24,11 +42,13 @@ func main() {
 	}
 	defer producer.Close()
 
-	order := fake_order.GenerateFakeOrder()
-	if err := sendMessage(producer, cfg.Broker.Topic, order); err != nil {
-		log.Fatalf("Ошибка при отправке сообщения: %v\n", err)
+	for _, codec := range selected {
+		order := fake_order.GenerateFakeOrder()
+		if err := sendMessage(producer, cfg.Broker.Topic, codec, order); err != nil {
+			log.Fatalf("Ошибка при отправке сообщения: %v\n", err)
+		}
+		log.Printf("Сообщение c Order_UID:(%s) отправлено в Kafka в формате %s.", order.OrderUID, codec.Name())
 	}
-	log.Printf("Сообщение c Order_UID:(%s) отправлено в Kafka.", order.OrderUID)
 }
 
 // newKafkaProducer - создает новый Kafka продюсер
@@ -46,16 +66,21 @@ func newKafkaProducer(brokerCfg config.Broker) (sarama.SyncProducer, error) {
 }
 
 // sendMessage - отправка сообщения в Kafka
-func sendMessage(producer sarama.SyncProducer, topic string, order models.Order) error {
-	message, err := json.Marshal(order)
+func sendMessage(producer sarama.SyncProducer, topic string, codec kafka.Codec, order models.Order) error {
+	message, err := codec.Encode(order)
 	if err != nil {
 		return fmt.Errorf("ошибка при маршаллинге сообщения: %w", err)
 	}
 
 	// Создаем сообщение для Kafka
+	// Ключ - order_uid: обновления одного заказа попадают в одну партицию и сохраняют порядок
 	kafkaMessage := &sarama.ProducerMessage{
 		Topic: topic,
+		Key:   sarama.StringEncoder(order.OrderUID),
 		Value: sarama.ByteEncoder(message),
+		Headers: []sarama.RecordHeader{
+			{Key: []byte(kafka.HeaderContentType), Value: []byte(codec.ContentType())},
+		},
 	}
 
 	// Отправляем сообщение в Kafka
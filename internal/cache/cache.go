@@ -5,6 +5,13 @@
 // memory, while evicting the least recently used orders
 // when the cache reaches its specified capacity.
 //
+// Besides their UID, the cached orders are indexed by their track number, payment transaction
+// and customer. The secondary indexes point at the entries of the orders, so they are updated
+// along with them: an order is reindexed when its entry is updated and unindexed when it is evicted or deleted.
+// A lookup by track number, transaction or customer is only served while the cache is known to hold
+// the newest (or, for a customer, every) order of the key, as read from the database;
+// an older order left in the cache is never returned in place of an evicted newer one.
+//
 // The cache is thread-safe, supporting concurrent access and modification through proper
 // synchronization mechanisms.
 package cache
@@ -12,6 +19,7 @@ package cache
 import (
 	"container/list"
 	"demo_service/internal/models"
+	"sort"
 	"sync"
 )
 
@@ -27,14 +35,26 @@ type Cache struct {
 	cache    map[string]*list.Element
 	queue    *list.List
 	mu       sync.RWMutex
+
+	// Secondary indexes. A track number or a transaction shared by several cached orders
+	// points at the newest of them.
+	byTrack       *newestIndex
+	byTransaction *newestIndex
+	byCustomer    map[string]map[string]*list.Element
+	// complete holds the customers all of whose orders are cached (see SetCustomerOrders).
+	complete map[string]bool
 }
 
 // New creates and returns a new Cache instance with the specified capacity.
 func New(capacity int) *Cache {
 	return &Cache{
-		capacity: capacity,
-		cache:    make(map[string]*list.Element),
-		queue:    list.New(),
+		capacity:      capacity,
+		cache:         make(map[string]*list.Element),
+		queue:         list.New(),
+		byTrack:       newNewestIndex(),
+		byTransaction: newNewestIndex(),
+		byCustomer:    make(map[string]map[string]*list.Element),
+		complete:      make(map[string]bool),
 	}
 }
 
@@ -44,10 +64,18 @@ func (c *Cache) Set(key string, value models.Order) bool {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	c.set(key, value)
+	return true
+}
+
+// set adds or updates an order and keeps the secondary indexes up to date. c.mu must be held.
+func (c *Cache) set(key string, value models.Order) {
 	if element, exists := c.cache[key]; exists {
 		c.queue.MoveToFront(element)
+		c.unindex(element)
 		element.Value.(*cacheItem).Value = value
-		return true
+		c.index(element)
+		return
 	}
 
 	if c.queue.Len() == c.capacity {
@@ -61,8 +89,7 @@ func (c *Cache) Set(key string, value models.Order) bool {
 
 	element := c.queue.PushFront(cacheItem)
 	c.cache[key] = element
-
-	return true
+	c.index(element)
 }
 
 // Get retrieves an order from the cache by its key, moves it to the front of the queue,
@@ -84,9 +111,199 @@ func (c *Cache) Get(key string) (models.Order, bool) {
 	return element.Value.(*cacheItem).Value, true
 }
 
+// GetByTrackNumber retrieves the newest order with the track number, moves it to the front of the queue,
+// and returns it along with a boolean indicating its existence.
+// The boolean is false unless the order has been cached with SetByTrackNumber and is still in the cache.
+func (c *Cache) GetByTrackNumber(trackNumber string) (models.Order, bool) {
+	return c.getBy(c.byTrack, trackNumber)
+}
+
+// GetByTransaction retrieves the newest order paid by the transaction, moves it to the front of the queue,
+// and returns it along with a boolean indicating its existence.
+// The boolean is false unless the order has been cached with SetByTransaction and is still in the cache.
+func (c *Cache) GetByTransaction(transaction string) (models.Order, bool) {
+	return c.getBy(c.byTransaction, transaction)
+}
+
+// SetByTrackNumber adds or updates the newest order with the track number, as read from the database,
+// so that GetByTrackNumber serves it until it leaves the cache.
+func (c *Cache) SetByTrackNumber(trackNumber string, order models.Order) bool {
+	return c.setBy(c.byTrack, trackNumber, order)
+}
+
+// SetByTransaction adds or updates the newest order paid by the transaction, as read from the database,
+// so that GetByTransaction serves it until it leaves the cache.
+func (c *Cache) SetByTransaction(transaction string, order models.Order) bool {
+	return c.setBy(c.byTransaction, transaction, order)
+}
+
+// getBy looks the order up in the secondary index, if the newest order of the key is known to be cached.
+func (c *Cache) getBy(index *newestIndex, key string) (models.Order, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, exists := index.entries[key]
+	if !exists || !index.complete[key] {
+		return models.Order{}, false
+	}
+	c.queue.MoveToFront(element)
+	return element.Value.(*cacheItem).Value, true
+}
+
+// setBy adds or updates the order and marks it as the newest of the key in the secondary index.
+func (c *Cache) setBy(index *newestIndex, key string, order models.Order) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.set(order.OrderUID, order)
+	if element := index.entries[key]; element != nil && element == c.cache[order.OrderUID] {
+		index.complete[key] = true
+	}
+	return true
+}
+
+// GetCustomerOrders returns the orders of the customer, newest (by date_created) first,
+// if all of them are cached, and moves them to the front of the queue.
+// The boolean is false if the customer is unknown to the cache or some of their orders may be missing.
+func (c *Cache) GetCustomerOrders(customerID string) ([]models.Order, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.complete[customerID] {
+		return nil, false
+	}
+	orders := make([]models.Order, 0, len(c.byCustomer[customerID]))
+	for _, element := range c.byCustomer[customerID] {
+		c.queue.MoveToFront(element)
+		orders = append(orders, element.Value.(*cacheItem).Value)
+	}
+	sort.Slice(orders, func(i, j int) bool { return newer(orders[i], orders[j]) })
+	return orders, true
+}
+
+// SetCustomerOrders adds or updates all the orders of the customer, as read from the database,
+// so that GetCustomerOrders serves them until one of them leaves the cache.
+// It returns false if they do not fit in the cache and only some of them are kept.
+func (c *Cache) SetCustomerOrders(customerID string, orders []models.Order) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, order := range orders {
+		c.set(order.OrderUID, order)
+	}
+
+	cached := c.byCustomer[customerID]
+	if len(cached) != len(orders) {
+		return false
+	}
+	for _, order := range orders {
+		if cached[order.OrderUID] == nil {
+			return false
+		}
+	}
+	if len(orders) > 0 {
+		c.complete[customerID] = true
+	}
+	return true
+}
+
+// Delete removes an order from the cache by its key
+// and returns a boolean indicating whether it was present.
+func (c *Cache) Delete(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, exists := c.cache[key]
+	if !exists {
+		return false
+	}
+	c.remove(element)
+	return true
+}
+
 func (c *Cache) purge() {
 	if element := c.queue.Back(); element != nil {
-		cacheItem := c.queue.Remove(element).(*cacheItem)
-		delete(c.cache, cacheItem.Key)
+		c.remove(element)
+	}
+}
+
+// remove removes the entry from the queue and the indexes.
+// The customer of the order is no longer known to have all their orders cached. c.mu must be held.
+func (c *Cache) remove(element *list.Element) {
+	cacheItem := c.queue.Remove(element).(*cacheItem)
+	delete(c.cache, cacheItem.Key)
+	c.unindex(element)
+	delete(c.complete, cacheItem.Value.CustomerID)
+}
+
+// index adds the entry to the secondary indexes. c.mu must be held.
+func (c *Cache) index(element *list.Element) {
+	item := element.Value.(*cacheItem)
+	c.byTrack.add(item.Value.TrackNumber, element)
+	c.byTransaction.add(item.Value.Payment.Transaction, element)
+
+	if customerID := item.Value.CustomerID; customerID != "" {
+		if c.byCustomer[customerID] == nil {
+			c.byCustomer[customerID] = make(map[string]*list.Element)
+		}
+		c.byCustomer[customerID][item.Key] = element
+	}
+}
+
+// unindex removes the entry from the secondary indexes. c.mu must be held.
+func (c *Cache) unindex(element *list.Element) {
+	item := element.Value.(*cacheItem)
+	c.byTrack.remove(item.Value.TrackNumber, element)
+	c.byTransaction.remove(item.Value.Payment.Transaction, element)
+
+	if orders := c.byCustomer[item.Value.CustomerID]; orders != nil {
+		delete(orders, item.Key)
+		if len(orders) == 0 {
+			delete(c.byCustomer, item.Value.CustomerID)
+		}
+	}
+}
+
+// newestIndex points a key shared by several orders at the newest cached one.
+type newestIndex struct {
+	entries map[string]*list.Element
+	// complete holds the keys whose entry is the newest of all their orders, not only of the cached ones
+	// (see setBy). It is cleared when the entry leaves the index, since an older order may take its place.
+	complete map[string]bool
+}
+
+func newNewestIndex() *newestIndex {
+	return &newestIndex{
+		entries:  make(map[string]*list.Element),
+		complete: make(map[string]bool),
+	}
+}
+
+// add points the key at the entry unless it already points at a newer order.
+// A newer order replacing the newest one of the key is the newest one in turn.
+func (i *newestIndex) add(key string, element *list.Element) {
+	if key == "" {
+		return
+	}
+	if current, exists := i.entries[key]; exists &&
+		newer(current.Value.(*cacheItem).Value, element.Value.(*cacheItem).Value) {
+		return
+	}
+	i.entries[key] = element
+}
+
+// remove removes the key if it points at the entry.
+func (i *newestIndex) remove(key string, element *list.Element) {
+	if i.entries[key] == element {
+		delete(i.entries, key)
+		delete(i.complete, key)
+	}
+}
+
+// newer reports whether the order a is listed before b newest first: by date_created, then by UID, descending.
+func newer(a, b models.Order) bool {
+	if !a.DateCreated.Equal(b.DateCreated) {
+		return a.DateCreated.After(b.DateCreated)
 	}
+	return a.OrderUID > b.OrderUID
 }
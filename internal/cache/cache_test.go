@@ -0,0 +1,67 @@
+package cache
+
+import (
+	"demo_service/internal/models"
+	"testing"
+	"time"
+)
+
+func trackOrder(uid, track string, created time.Time) models.Order {
+	return models.Order{OrderUID: uid, TrackNumber: track, DateCreated: created}
+}
+
+func TestGetByTrackNumberNeedsNewestFromDatabase(t *testing.T) {
+	now := time.Now()
+	c := New(10)
+
+	// Заказ, закэшированный по UID, может быть не самым новым с этим трек-номером
+	c.Set("old", trackOrder("old", "TRACK", now.Add(-time.Hour)))
+	if _, ok := c.GetByTrackNumber("TRACK"); ok {
+		t.Fatal("GetByTrackNumber served an order not known to be the newest")
+	}
+
+	c.SetByTrackNumber("TRACK", trackOrder("new", "TRACK", now))
+	if got, ok := c.GetByTrackNumber("TRACK"); !ok || got.OrderUID != "new" {
+		t.Fatalf("GetByTrackNumber = %s, %t; want new", got.OrderUID, ok)
+	}
+
+	// Сохранённый позже более новый заказ становится самым новым
+	c.Set("newer", trackOrder("newer", "TRACK", now.Add(time.Hour)))
+	if got, ok := c.GetByTrackNumber("TRACK"); !ok || got.OrderUID != "newer" {
+		t.Fatalf("GetByTrackNumber = %s, %t; want newer", got.OrderUID, ok)
+	}
+}
+
+func TestGetByTrackNumberAfterNewestEvicted(t *testing.T) {
+	now := time.Now()
+	c := New(2)
+
+	c.SetByTrackNumber("TRACK", trackOrder("new", "TRACK", now))
+	c.Set("old", trackOrder("old", "TRACK", now.Add(-time.Hour)))
+	c.Set("other", trackOrder("other", "OTHER", now)) // evicts "new"
+
+	if got, ok := c.GetByTrackNumber("TRACK"); ok {
+		t.Fatalf("GetByTrackNumber = %s after the newest order was evicted; want a miss", got.OrderUID)
+	}
+}
+
+func TestGetByTransactionAfterNewestDeleted(t *testing.T) {
+	now := time.Now()
+	c := New(10)
+
+	old := trackOrder("old", "TRACK", now.Add(-time.Hour))
+	old.Payment.Transaction = "tx"
+	newest := trackOrder("new", "TRACK", now)
+	newest.Payment.Transaction = "tx"
+
+	c.Set("old", old)
+	c.SetByTransaction("tx", newest)
+	if got, ok := c.GetByTransaction("tx"); !ok || got.OrderUID != "new" {
+		t.Fatalf("GetByTransaction = %s, %t; want new", got.OrderUID, ok)
+	}
+
+	c.Delete("new")
+	if got, ok := c.GetByTransaction("tx"); ok {
+		t.Fatalf("GetByTransaction = %s after the newest order was deleted; want a miss", got.OrderUID)
+	}
+}
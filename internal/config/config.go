@@ -5,6 +5,7 @@ package config
 import (
 	"log"
 	"os"
+	"time"
 
 	"github.com/ilyakaznacheev/cleanenv"
 	"github.com/joho/godotenv"
@@ -15,30 +16,135 @@ import (
 type Config struct {
 	HTTPServer HTTPServer `yaml:"HTTPServer"`
 	DB         DataBase   `yaml:"DataBase"`
+	Transport  Transport  `yaml:"Transport"`
 	Broker     Broker     `yaml:"Broker"`
 	Cache      Cache      `yaml:"Cache"`
+	Rules      Rules      `yaml:"Rules"`
 	Version    string     `yaml:"version"`
 }
 
 // HTTPServer contains configuration details for the HTTP server.
+// The admin endpoints are enabled only when AdminToken is set;
+// requests to them must carry it as a bearer token.
 type HTTPServer struct {
-	Address string `yaml:"address" env-default:"localhost:8080"`
+	Address    string `yaml:"address" env-default:"localhost:8080"`
+	AdminToken string `yaml:"admin_token" env:"ADMIN_TOKEN"`
 }
 
 // DataBase contains configuration information for connecting to the database.
+// WriteMode decides what happens when an order with an existing UID is received.
+// Backend selects where orders are stored: PostgreSQL or memory; the in-memory store
+// is persisted to File if it is set. With AutoMigrate the service applies pending migrations
+// of the PostgreSQL schema on start; otherwise it refuses to start while the schema is behind.
 type DataBase struct {
-	DbName   string `yaml:"db_name"`
-	Host     string `yaml:"host"`
-	Port     string `yaml:"port"`
-	Username string `yaml:"username"`
-	Password string `yaml:"password"`
+	Backend     string `yaml:"backend" env:"DB_BACKEND" env-default:"postgres"`
+	DbName      string `yaml:"db_name"`
+	Host        string `yaml:"host"`
+	Port        string `yaml:"port"`
+	Username    string `yaml:"username"`
+	Password    string `yaml:"password"`
+	WriteMode   string `yaml:"write_mode" env-default:"insert"`
+	File        string `yaml:"file"`
+	AutoMigrate bool   `yaml:"auto_migrate" env:"DB_AUTO_MIGRATE"`
+}
+
+// Storage backends.
+const (
+	BackendPostgres = "postgres"
+	BackendMemory   = "memory"
+)
+
+// Order write modes.
+const (
+	// WriteModeInsert keeps the first received revision of an order (first write wins).
+	WriteModeInsert = "insert"
+	// WriteModeUpsert replaces the stored order with a revision whose date_created
+	// is not older than the stored one and whose content differs, and increments its version.
+	WriteModeUpsert = "upsert"
+)
+
+// Transport selects the message broker orders are ingested from:
+// Kafka (configured in the Broker section) or NATS JetStream.
+type Transport struct {
+	Type string `yaml:"type" env-default:"kafka"`
+	NATS NATS   `yaml:"nats"`
+}
+
+// Transport types.
+const (
+	TransportKafka = "kafka"
+	TransportNATS  = "nats"
+)
+
+// NATS contains configuration for ingestion from NATS JetStream.
+// Orders are published to Subject, stored in Stream and consumed by the Durable consumer;
+// a message that is not acknowledged within AckWait is redelivered, at most MaxDeliver times.
+// Payload formats, retries and batching are configured in the Broker section.
+type NATS struct {
+	URL        string        `yaml:"url" env-default:"nats://localhost:4222"`
+	Stream     string        `yaml:"stream" env-default:"ORDERS"`
+	Subject    string        `yaml:"subject" env-default:"orders"`
+	Durable    string        `yaml:"durable" env-default:"order-consumer"`
+	AckWait    time.Duration `yaml:"ack_wait" env-default:"30s"`
+	MaxDeliver int           `yaml:"max_deliver" env-default:"5"`
+	DLQ        NATSDLQ       `yaml:"dlq"`
+}
+
+// NATSDLQ contains configuration for the dead-letter subject where messages that can never be processed
+// (undecodable, invalid, rejected or failed on their last delivery) are republished before they are terminated.
+// The subject is stored in Stream, which is created if it does not exist.
+// Without it, such messages are only logged and dropped.
+type NATSDLQ struct {
+	Enabled bool   `yaml:"enabled"`
+	Stream  string `yaml:"stream" env-default:"ORDERS_DLQ"`
+	Subject string `yaml:"subject" env-default:"orders.dlq"`
 }
 
 // Broker contains configuration for the message broker.
+// AtLeastOnce disables auto-commit and commits offsets only after an order is stored.
+// Codec is the payload format (json, protobuf or avro) of messages without a content-type header.
+// Workers above 1 processes messages of a partition concurrently, keeping the order of messages per order UID.
 type Broker struct {
-	Hosts   []string `yaml:"hosts"`
-	GroupID string   `yaml:"group_id"`
-	Topic   string   `yaml:"topic"`
+	Hosts          []string       `yaml:"hosts"`
+	GroupID        string         `yaml:"group_id"`
+	Topic          string         `yaml:"topic"`
+	AtLeastOnce    bool           `yaml:"at_least_once"`
+	Workers        int            `yaml:"workers" env-default:"1"`
+	Codec          string         `yaml:"codec" env-default:"json"`
+	SchemaRegistry SchemaRegistry `yaml:"schema_registry"`
+	DLQ            DLQ            `yaml:"dlq"`
+	Retry          Retry          `yaml:"retry"`
+	Batch          Batch          `yaml:"batch"`
+}
+
+// SchemaRegistry contains configuration for the schema registry that Avro schemas are resolved from.
+// The Avro codec is only available when URL is set.
+type SchemaRegistry struct {
+	URL     string        `yaml:"url"`
+	Subject string        `yaml:"subject" env-default:"orders-value"`
+	Timeout time.Duration `yaml:"timeout" env-default:"5s"`
+}
+
+// DLQ contains configuration for the dead-letter topic where
+// messages that could not be decoded or persisted are republished.
+type DLQ struct {
+	Enabled bool   `yaml:"enabled"`
+	Topic   string `yaml:"topic" env-default:"orders.dlq"`
+}
+
+// Retry contains the retry policy applied to a message before giving up on it.
+type Retry struct {
+	MaxAttempts    int           `yaml:"max_attempts" env-default:"5"`
+	InitialBackoff time.Duration `yaml:"initial_backoff" env-default:"200ms"`
+	MaxBackoff     time.Duration `yaml:"max_backoff" env-default:"10s"`
+}
+
+// Batch contains configuration for batched consumption.
+// Messages are accumulated up to Size or for at most Linger before being stored at once.
+// A Size of 1 (the default) disables batching.
+type Batch struct {
+	Size   int           `yaml:"size" env-default:"1"`
+	Linger time.Duration `yaml:"linger" env-default:"200ms"`
 }
 
 // Cache contains configuration for the cache, including its capacity.
@@ -46,6 +152,12 @@ type Cache struct {
 	Capacity int `yaml:"capacity"`
 }
 
+// Rules contains the action (reject, warn, tag or off) applied
+// when an order violates a business rule, keyed by rule name.
+type Rules struct {
+	Actions map[string]string `yaml:"actions"`
+}
+
 // MustLoad loads the configuration from the .env file
 // and a config file specified by the CONFIG_PATH environment variable,
 // and returns the parsed Config. The function terminates the program on errors.
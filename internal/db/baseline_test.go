@@ -0,0 +1,134 @@
+package db
+
+import (
+	"context"
+	"demo_service/internal/models"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// baselineQueries are the queries of the former read path, which hydrated every order with queries of its own.
+// It is kept only as the baseline of the warmup benchmarks.
+var baselineQueries = map[string]string{
+	"getLastLimitOrders": `
+		SELECT *
+		FROM (
+			SELECT ` + columnList("", orderColumns) + `, delivery_id, payment_id
+			FROM orders
+			ORDER BY date_created DESC
+			LIMIT $1
+		) AS subquery
+		ORDER BY date_created ASC;
+	`,
+	"getPayment": `
+		SELECT ` + columnList("", paymentColumns) + `
+		FROM payments
+		WHERE id = $1
+	`,
+	"getOrderItems": `
+		SELECT quantity, ` + columnList("", itemColumns) + `
+		FROM order_lines
+		WHERE order_uid = $1
+		ORDER BY line_no;
+	`,
+}
+
+// GetLastLimitOrdersN1 reads the same orders as GetLastLimitOrders the way the service did before the
+// single-query read path: the order rows first, then the delivery, the payment and the items of each order
+// with three more queries, run concurrently. That is 3N+1 queries for N orders.
+func (s *Storage) GetLastLimitOrdersN1(ctx context.Context, limit int) ([]models.Order, error) {
+	const fn = "GetLastLimitOrdersN1"
+
+	rows, err := s.pool.Query(ctx, baselineQueries["getLastLimitOrders"], limit)
+	if err != nil {
+		return nil, fmt.Errorf("(%s) | failed to query orders: %w", fn, err)
+	}
+	defer rows.Close()
+
+	var (
+		orders      []models.Order
+		deliveryIDs []int
+		paymentIDs  []int
+	)
+	for rows.Next() {
+		var (
+			order                 models.Order
+			deliveryID, paymentID int
+		)
+		if err := rows.Scan(append(orderDest(&order), &deliveryID, &paymentID)...); err != nil {
+			return nil, fmt.Errorf("(%s) | failed to scan row: %w", fn, err)
+		}
+		orders = append(orders, order)
+		deliveryIDs = append(deliveryIDs, deliveryID)
+		paymentIDs = append(paymentIDs, paymentID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("(%s) | failed to read order rows: %w", fn, err)
+	}
+	rows.Close()
+
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []error
+	)
+	fail := func(err error) {
+		mu.Lock()
+		errs = append(errs, err)
+		mu.Unlock()
+	}
+	for i := range orders {
+		order := &orders[i]
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			if err := s.pool.QueryRow(ctx, queries["getDelivery"], deliveryIDs[i]).Scan(deliveryDest(&order.Delivery)...); err != nil {
+				fail(fmt.Errorf("(%s) | failed to get delivery: %w", fn, err))
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			if err := s.pool.QueryRow(ctx, baselineQueries["getPayment"], paymentIDs[i]).Scan(paymentDest(&order.Payment)...); err != nil {
+				fail(fmt.Errorf("(%s) | failed to get payment: %w", fn, err))
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			items, err := s.baselineItems(ctx, order.OrderUID)
+			if err != nil {
+				fail(fmt.Errorf("(%s) | failed to get items: %w", fn, err))
+			}
+			order.Items = items
+		}()
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return orders, nil
+}
+
+// baselineItems reads the items of the order, repeating the item of a line by its quantity.
+func (s *Storage) baselineItems(ctx context.Context, orderUID string) ([]models.Item, error) {
+	rows, err := s.pool.Query(ctx, baselineQueries["getOrderItems"], orderUID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []models.Item
+	for rows.Next() {
+		var (
+			item     models.Item
+			quantity int
+		)
+		if err := rows.Scan(append([]interface{}{&quantity}, itemDest(&item)...)...); err != nil {
+			return nil, err
+		}
+		for range quantity {
+			items = append(items, item)
+		}
+	}
+	return items, rows.Err()
+}
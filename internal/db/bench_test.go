@@ -0,0 +1,82 @@
+package db_test
+
+import (
+	"context"
+	"demo_service/internal/config"
+	"demo_service/internal/db"
+	"demo_service/internal/db/dbtest"
+	"demo_service/internal/models"
+	"testing"
+)
+
+const (
+	// warmupOrders is the number of orders read by the warmup benchmarks.
+	warmupOrders = 10000
+	// saveBatchSize is the batch size of the SaveOrders benchmarks.
+	saveBatchSize = 100
+)
+
+func BenchmarkMemoryWarmup(b *testing.B) {
+	repo := openMemory(b, config.WriteModeInsert)
+	defer repo.Close()
+	dbtest.BenchmarkWarmup(b, repo, warmupOrders)
+}
+
+func BenchmarkMemoryGetOrder(b *testing.B) {
+	repo := openMemory(b, config.WriteModeInsert)
+	defer repo.Close()
+	dbtest.BenchmarkGetOrder(b, repo)
+}
+
+func BenchmarkPostgresWarmup(b *testing.B) {
+	repo := openPostgres(b, config.WriteModeInsert)
+	defer repo.Close()
+	dbtest.BenchmarkWarmup(b, repo, warmupOrders)
+}
+
+// n1Storage reads the warmup orders with the former N+1 read path, the baseline of BenchmarkPostgresWarmup:
+//
+//	go test -run '^$' -bench PostgresWarmup ./internal/db
+type n1Storage struct {
+	*db.Storage
+}
+
+func (s n1Storage) GetLastLimitOrders(ctx context.Context, limit int) ([]models.Order, error) {
+	return s.GetLastLimitOrdersN1(ctx, limit)
+}
+
+func BenchmarkPostgresWarmupN1(b *testing.B) {
+	repo := openPostgres(b, config.WriteModeInsert)
+	defer repo.Close()
+	dbtest.BenchmarkWarmup(b, n1Storage{repo}, warmupOrders)
+}
+
+func BenchmarkPostgresGetOrder(b *testing.B) {
+	repo := openPostgres(b, config.WriteModeInsert)
+	defer repo.Close()
+	dbtest.BenchmarkGetOrder(b, repo)
+}
+
+func BenchmarkMemorySaveOrder(b *testing.B) {
+	repo := openMemory(b, config.WriteModeInsert)
+	defer repo.Close()
+	dbtest.BenchmarkSaveOrder(b, repo)
+}
+
+func BenchmarkMemorySaveOrders(b *testing.B) {
+	repo := openMemory(b, config.WriteModeInsert)
+	defer repo.Close()
+	dbtest.BenchmarkSaveOrders(b, repo, saveBatchSize)
+}
+
+func BenchmarkPostgresSaveOrder(b *testing.B) {
+	repo := openPostgres(b, config.WriteModeInsert)
+	defer repo.Close()
+	dbtest.BenchmarkSaveOrder(b, repo)
+}
+
+func BenchmarkPostgresSaveOrders(b *testing.B) {
+	repo := openPostgres(b, config.WriteModeInsert)
+	defer repo.Close()
+	dbtest.BenchmarkSaveOrders(b, repo, saveBatchSize)
+}
@@ -0,0 +1,141 @@
+package dbtest
+
+import (
+	"context"
+	"demo_service/internal/db"
+	"demo_service/internal/models"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// seedBatch is the number of orders Seed saves at once.
+const seedBatch = 500
+
+// poolStater is implemented by repositories that expose the statistics of their connection pool (db.Storage).
+type poolStater interface {
+	Stat() *pgxpool.Stat
+}
+
+// Seed saves n orders with UIDs made of the prefix and their index, created one second apart.
+// Orders that are already stored are kept, so a database can be seeded once for several runs.
+func Seed(ctx context.Context, repo db.OrderRepository, prefix string, n int) error {
+	const fn = "dbtest.Seed"
+
+	for start := 0; start < n; start += seedBatch {
+		batch := make([]models.Order, 0, seedBatch)
+		for i := start; i < n && i < start+seedBatch; i++ {
+			batch = append(batch, NewOrder(fmt.Sprintf("%s-%d", prefix, i), time.Duration(i)*time.Second))
+		}
+		if _, err := repo.SaveOrders(ctx, batch); err != nil {
+			return fmt.Errorf("(%s) | %w", fn, err)
+		}
+	}
+	return nil
+}
+
+// BenchmarkWarmup measures the read path of the cache warmup: GetLastLimitOrders of n orders,
+// seeded beforehand. Besides the time per warmup it reports the connections acquired
+// from the pool per warmup (acquires/op) for repositories that expose the pool statistics.
+// internal/db runs it for both backends:
+//
+//	go test -run '^$' -bench Warmup ./internal/db
+func BenchmarkWarmup(b *testing.B, repo db.OrderRepository, n int) {
+	ctx := context.Background()
+	if err := Seed(ctx, repo, "warmup", n); err != nil {
+		b.Fatal(err)
+	}
+
+	measure := acquires(repo)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		orders, err := repo.GetLastLimitOrders(ctx, n)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if len(orders) != n {
+			b.Fatalf("GetLastLimitOrders() = %d orders; want %d", len(orders), n)
+		}
+	}
+	b.StopTimer()
+	measure(b)
+}
+
+// BenchmarkGetOrder measures reading a complete order by its UID.
+func BenchmarkGetOrder(b *testing.B, repo db.OrderRepository) {
+	const orders = 100
+
+	ctx := context.Background()
+	if err := Seed(ctx, repo, "get", orders); err != nil {
+		b.Fatal(err)
+	}
+
+	measure := acquires(repo)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := repo.GetOrderByUID(ctx, fmt.Sprintf("get-%d", i%orders)); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.StopTimer()
+	measure(b)
+}
+
+// BenchmarkSaveOrder measures the throughput of saving new orders one by one with SaveOrder.
+// Besides the time per order it reports orders/s, to be compared with BenchmarkSaveOrders.
+func BenchmarkSaveOrder(b *testing.B, repo db.OrderRepository) {
+	ctx := context.Background()
+	prefix := fmt.Sprintf("save-%d", time.Now().UnixNano())
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		order := NewOrder(fmt.Sprintf("%s-%d", prefix, i), time.Duration(i)*time.Second)
+		if _, err := repo.SaveOrder(ctx, &order); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.StopTimer()
+	b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "orders/s")
+}
+
+// BenchmarkSaveOrders measures the throughput of saving new orders in batches of the size with SaveOrders.
+// Besides the time per batch it reports orders/s, to be compared with BenchmarkSaveOrder.
+func BenchmarkSaveOrders(b *testing.B, repo db.OrderRepository, size int) {
+	ctx := context.Background()
+	prefix := fmt.Sprintf("save-batch-%d", time.Now().UnixNano())
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		batch := make([]models.Order, 0, size)
+		for j := 0; j < size; j++ {
+			batch = append(batch, NewOrder(fmt.Sprintf("%s-%d-%d", prefix, i, j), time.Duration(j)*time.Second))
+		}
+		b.StartTimer()
+
+		written, err := repo.SaveOrders(ctx, batch)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if len(written) != size {
+			b.Fatalf("SaveOrders() wrote %d orders; want %d", len(written), size)
+		}
+	}
+	b.StopTimer()
+	b.ReportMetric(float64(b.N*size)/b.Elapsed().Seconds(), "orders/s")
+}
+
+// acquires starts counting the connections the repository acquires from its pool;
+// the returned function reports them per benchmark iteration. It is a no-op for repositories without a pool.
+func acquires(repo db.OrderRepository) func(b *testing.B) {
+	stater, ok := repo.(poolStater)
+	if !ok {
+		return func(*testing.B) {}
+	}
+	start := stater.Stat().AcquireCount()
+	return func(b *testing.B) {
+		b.ReportMetric(float64(stater.Stat().AcquireCount()-start)/float64(b.N), "acquires/op")
+	}
+}
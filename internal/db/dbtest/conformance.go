@@ -0,0 +1,711 @@
+// Package dbtest provides the conformance suite of db.OrderRepository implementations.
+//
+// Every implementation must pass the same suite, so the backends stay interchangeable:
+//
+//	func TestMemoryConformance(t *testing.T) {
+//		dbtest.Run(t, func(t *testing.T, writeMode string) db.OrderRepository {
+//			repo, err := db.NewMemory(config.DataBase{WriteMode: writeMode})
+//			if err != nil {
+//				t.Fatal(err)
+//			}
+//			return repo
+//		})
+//	}
+//
+// The factory must return an empty repository (e.g. a truncated database) for every call.
+// The read paths can be benchmarked the same way with BenchmarkWarmup and BenchmarkGetOrder.
+package dbtest
+
+import (
+	"context"
+	"demo_service/internal/config"
+	"demo_service/internal/db"
+	"demo_service/internal/listing"
+	"demo_service/internal/models"
+	"demo_service/internal/search"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Factory returns an empty repository with the write mode (config.WriteModeInsert or config.WriteModeUpsert).
+type Factory func(t *testing.T, writeMode string) db.OrderRepository
+
+// Run runs the conformance suite against the repositories created by the factory.
+func Run(t *testing.T, open Factory) {
+	cases := []struct {
+		name      string
+		writeMode string
+		run       func(t *testing.T, repo db.OrderRepository)
+	}{
+		{"Mappings", config.WriteModeInsert, testMappings},
+		{"SaveAndGet", config.WriteModeInsert, testSaveAndGet},
+		{"GetMissing", config.WriteModeInsert, testGetMissing},
+		{"InsertKeepsFirst", config.WriteModeInsert, testInsertKeepsFirst},
+		{"UpsertReplacesNewer", config.WriteModeUpsert, testUpsertReplacesNewer},
+		{"SaveOrdersInsert", config.WriteModeInsert, testSaveOrdersInsert},
+		{"SaveOrdersUpsert", config.WriteModeUpsert, testSaveOrdersUpsert},
+		{"RevisionSourceOnce", config.WriteModeUpsert, testRevisionSourceOnce},
+		{"UpsertSameContent", config.WriteModeUpsert, testUpsertSameContent},
+		{"RepeatedItems", config.WriteModeUpsert, testRepeatedItems},
+		{"Delete", config.WriteModeUpsert, testDelete},
+		{"Anonymize", config.WriteModeUpsert, testAnonymize},
+		{"LastLimit", config.WriteModeInsert, testLastLimit},
+		{"List", config.WriteModeInsert, testList},
+		{"FindOrders", config.WriteModeInsert, testFindOrders},
+		{"Lookups", config.WriteModeInsert, testLookups},
+		{"Search", config.WriteModeInsert, testSearch},
+		{"ConcurrentSaves", config.WriteModeUpsert, testConcurrentSaves},
+		{"IdempotencyKeys", config.WriteModeInsert, testIdempotencyKeys},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			repo := open(t, c.writeMode)
+			defer repo.Close()
+			c.run(t, repo)
+		})
+	}
+}
+
+// baseTime is the date_created of the orders of the suite. It is representable in a TIMESTAMP column.
+var baseTime = time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)
+
+// NewOrder returns a valid order with the UID, created at baseTime plus the offset.
+func NewOrder(uid string, created time.Duration) models.Order {
+	return models.Order{
+		OrderUID:    uid,
+		TrackNumber: "TRACK-" + uid,
+		Entry:       "WBIL",
+		Delivery: models.Delivery{
+			Name:    "Test Testov",
+			Phone:   "+9720000000",
+			Zip:     "2639809",
+			City:    "Kiryat Mozkin",
+			Address: "Ploshad Mira 15",
+			Region:  "Kraiot",
+			Email:   "test@gmail.com",
+		},
+		Payment: models.Payment{
+			Transaction:  uid,
+			Currency:     "USD",
+			Provider:     "wbpay",
+			Amount:       1817,
+			PaymentDT:    1637907727,
+			Bank:         "alpha",
+			DeliveryCost: 1500,
+			GoodsTotal:   317,
+		},
+		Items: []models.Item{
+			{ChrtID: 9934930, TrackNumber: "TRACK-" + uid, Price: 453, RID: "rid-1-" + uid, Name: "Mascaras",
+				Sale: 30, Size: "0", TotalPrice: 317, NMID: 2389212, Brand: "Vivienne Sabo", Status: 202},
+			{ChrtID: 9934931, TrackNumber: "TRACK-" + uid, Price: 100, RID: "rid-2-" + uid, Name: "Brush",
+				Sale: 0, Size: "0", TotalPrice: 100, NMID: 2389213, Brand: "Vivienne Sabo", Status: 202},
+		},
+		Locale:          "en",
+		CustomerID:      "test",
+		DeliveryService: "meest",
+		Shardkey:        "9",
+		SmID:            99,
+		DateCreated:     baseTime.Add(created),
+		OofShard:        "1",
+	}
+}
+
+// schemaChecker is implemented by repositories whose schema is checked against the models (db.Storage).
+type schemaChecker interface {
+	CheckSchema(ctx context.Context) error
+}
+
+func testMappings(t *testing.T, repo db.OrderRepository) {
+	if err := db.CheckMappings(); err != nil {
+		t.Error(err)
+	}
+	if checker, ok := repo.(schemaChecker); ok {
+		if err := checker.CheckSchema(context.Background()); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+// idempotencyStore is implemented by repositories that keep the responses of requests made with an Idempotency-Key.
+type idempotencyStore interface {
+	ReserveIdempotencyKey(ctx context.Context, key, requestHash string) (*models.IdempotencyRecord, error)
+	CompleteIdempotencyKey(ctx context.Context, key string, statusCode int, response []byte) error
+	ReleaseIdempotencyKey(ctx context.Context, key string) error
+}
+
+func testIdempotencyKeys(t *testing.T, repo db.OrderRepository) {
+	store, ok := repo.(idempotencyStore)
+	if !ok {
+		t.Skip("the repository does not keep idempotency keys")
+	}
+	ctx := context.Background()
+
+	if record, err := store.ReserveIdempotencyKey(ctx, "key-1", "hash-1"); err != nil || record != nil {
+		t.Fatalf("ReserveIdempotencyKey() = %+v, %v; want the key reserved", record, err)
+	}
+	record, err := store.ReserveIdempotencyKey(ctx, "key-1", "hash-1")
+	if err != nil || record == nil || record.StatusCode != nil || record.RequestHash != "hash-1" {
+		t.Fatalf("ReserveIdempotencyKey() in progress = %+v, %v; want the record without a response", record, err)
+	}
+
+	// Незавершенный ключ освобождается, и запрос можно повторить
+	if err := store.ReleaseIdempotencyKey(ctx, "key-1"); err != nil {
+		t.Fatal(err)
+	}
+	if record, err := store.ReserveIdempotencyKey(ctx, "key-1", "hash-2"); err != nil || record != nil {
+		t.Fatalf("ReserveIdempotencyKey() after release = %+v, %v; want the key reserved again", record, err)
+	}
+
+	response := []byte(`{"results":[]}`)
+	if err := store.CompleteIdempotencyKey(ctx, "key-1", 200, response); err != nil {
+		t.Fatal(err)
+	}
+	// Завершенный ключ не освобождается
+	if err := store.ReleaseIdempotencyKey(ctx, "key-1"); err != nil {
+		t.Fatal(err)
+	}
+	record, err = store.ReserveIdempotencyKey(ctx, "key-1", "hash-3")
+	if err != nil || record == nil {
+		t.Fatalf("ReserveIdempotencyKey() after completion = %+v, %v; want the stored record", record, err)
+	}
+	if record.RequestHash != "hash-2" || record.StatusCode == nil || *record.StatusCode != 200 ||
+		string(record.Response) != string(response) {
+		t.Errorf("stored record = %+v; want hash-2 with the completed response", record)
+	}
+
+	if record, err := store.ReserveIdempotencyKey(ctx, "key-2", "hash-1"); err != nil || record != nil {
+		t.Errorf("ReserveIdempotencyKey(key-2) = %+v, %v; want keys independent of each other", record, err)
+	}
+}
+
+func testSaveAndGet(t *testing.T, repo db.OrderRepository) {
+	ctx := context.Background()
+	order := NewOrder("save-and-get", 0)
+	order.Checks = []models.Check{{Rule: "goods_total", Action: "warn", Message: "mismatch"}}
+
+	saved := order
+	written, err := repo.SaveOrder(ctx, &saved)
+	if err != nil || !written {
+		t.Fatalf("SaveOrder() = %v, %v; want true, nil", written, err)
+	}
+	if saved.Version != 1 || saved.UpdatedAt.IsZero() {
+		t.Errorf("saved version %d, updated at %v; want version 1 and an update time", saved.Version, saved.UpdatedAt)
+	}
+
+	got := mustGet(t, repo, order.OrderUID)
+	assertSameOrder(t, order, got)
+	if got.Version != 1 {
+		t.Errorf("stored version %d; want 1", got.Version)
+	}
+}
+
+func testGetMissing(t *testing.T, repo db.OrderRepository) {
+	_, err := repo.GetOrderByUID(context.Background(), "missing")
+	if !errors.Is(err, db.ErrOrderNotFound) {
+		t.Fatalf("GetOrderByUID(missing) error = %v; want ErrOrderNotFound", err)
+	}
+}
+
+func testInsertKeepsFirst(t *testing.T, repo db.OrderRepository) {
+	ctx := context.Background()
+	first := NewOrder("insert-keeps-first", 0)
+	second := NewOrder("insert-keeps-first", time.Hour)
+	second.Delivery.Name = "Second"
+
+	mustSave(t, repo, first, true)
+	mustSave(t, repo, second, false)
+	assertSameOrder(t, first, mustGet(t, repo, first.OrderUID))
+
+	revisions, err := repo.GetOrderHistory(ctx, first.OrderUID)
+	if err != nil {
+		t.Fatalf("GetOrderHistory() error = %v", err)
+	}
+	if len(revisions) != 2 {
+		t.Fatalf("%d revisions; want 2", len(revisions))
+	}
+	if revisions[0].Version == nil || *revisions[0].Version != 1 || revisions[1].Version != nil {
+		t.Errorf("revision versions %v, %v; want 1 and nil", revisions[0].Version, revisions[1].Version)
+	}
+}
+
+func testUpsertReplacesNewer(t *testing.T, repo db.OrderRepository) {
+	first := NewOrder("upsert", 0)
+	newer := NewOrder("upsert", time.Hour)
+	newer.Delivery.Name = "Newer"
+	newer.Items = newer.Items[:1]
+	stale := NewOrder("upsert", -time.Hour)
+	stale.Delivery.Name = "Stale"
+
+	mustSave(t, repo, first, true)
+	if saved := mustSave(t, repo, newer, true); saved.Version != 2 {
+		t.Errorf("newer revision saved as version %d; want 2", saved.Version)
+	}
+	mustSave(t, repo, stale, false)
+
+	got := mustGet(t, repo, newer.OrderUID)
+	assertSameOrder(t, newer, got)
+	if got.Version != 2 {
+		t.Errorf("stored version %d; want 2", got.Version)
+	}
+}
+
+func testSaveOrdersInsert(t *testing.T, repo db.OrderRepository) {
+	ctx := context.Background()
+	existing := NewOrder("batch-existing", 0)
+	mustSave(t, repo, existing, true)
+
+	first := NewOrder("batch-new", 0)
+	repeated := NewOrder("batch-new", time.Hour)
+	repeated.Delivery.Name = "Repeated"
+	written, err := repo.SaveOrders(ctx, []models.Order{NewOrder("batch-existing", time.Hour), first, repeated})
+	if err != nil {
+		t.Fatalf("SaveOrders() error = %v", err)
+	}
+	if len(written) != 1 || written[0].OrderUID != first.OrderUID || written[0].Version != 1 {
+		t.Fatalf("SaveOrders() wrote %v; want only the first revision of %s", uids(written), first.OrderUID)
+	}
+	assertSameOrder(t, first, mustGet(t, repo, first.OrderUID))
+	assertSameOrder(t, existing, mustGet(t, repo, existing.OrderUID))
+}
+
+func testSaveOrdersUpsert(t *testing.T, repo db.OrderRepository) {
+	ctx := context.Background()
+	a := NewOrder("batch-a", 0)
+	b := NewOrder("batch-b", 0)
+	newerA := NewOrder("batch-a", time.Hour)
+	newerA.Delivery.Name = "Newer"
+
+	written, err := repo.SaveOrders(ctx, []models.Order{a, b, newerA})
+	if err != nil {
+		t.Fatalf("SaveOrders() error = %v", err)
+	}
+	if len(written) != 2 {
+		t.Fatalf("SaveOrders() wrote %v; want %s and %s", uids(written), a.OrderUID, b.OrderUID)
+	}
+	assertSameOrder(t, newerA, mustGet(t, repo, a.OrderUID))
+	assertSameOrder(t, b, mustGet(t, repo, b.OrderUID))
+
+	revisions, err := repo.GetOrderHistory(ctx, a.OrderUID)
+	if err != nil {
+		t.Fatalf("GetOrderHistory() error = %v", err)
+	}
+	if len(revisions) != 2 {
+		t.Errorf("%d revisions of %s; want 2", len(revisions), a.OrderUID)
+	}
+}
+
+func testRevisionSourceOnce(t *testing.T, repo db.OrderRepository) {
+	ctx := context.Background()
+	order := NewOrder("source-once", 0)
+	order.Source = &models.Source{Topic: "orders", Partition: 1, Offset: 42}
+
+	mustSave(t, repo, order, true)
+	mustSave(t, repo, order, false) // повторная доставка той же записи брокера
+
+	revisions, err := repo.GetOrderHistory(ctx, order.OrderUID)
+	if err != nil {
+		t.Fatalf("GetOrderHistory() error = %v", err)
+	}
+	if len(revisions) != 1 {
+		t.Fatalf("%d revisions; want 1 per broker record", len(revisions))
+	}
+	if src := revisions[0].Source; src == nil || src.Topic != "orders" || src.Partition != 1 || src.Offset != 42 {
+		t.Errorf("revision source %+v; want orders/1@42", src)
+	}
+	if got := mustGet(t, repo, order.OrderUID); got.Source == nil || got.Source.Offset != 42 {
+		t.Errorf("order source %+v; want orders/1@42", got.Source)
+	}
+}
+
+func testUpsertSameContent(t *testing.T, repo db.OrderRepository) {
+	ctx := context.Background()
+	order := NewOrder("same-content", 0)
+	order.Source = &models.Source{Topic: "orders", Partition: 1, Offset: 42}
+	mustSave(t, repo, order, true)
+
+	// Та же ревизия из другой записи брокера не применяется повторно
+	redelivered := order
+	redelivered.Source = &models.Source{Topic: "orders", Partition: 1, Offset: 43}
+	mustSave(t, repo, redelivered, false)
+
+	got := mustGet(t, repo, order.OrderUID)
+	if got.Version != 1 {
+		t.Errorf("stored version %d; want 1 after an identical revision", got.Version)
+	}
+	revisions, err := repo.GetOrderHistory(ctx, order.OrderUID)
+	if err != nil {
+		t.Fatalf("GetOrderHistory() error = %v", err)
+	}
+	if len(revisions) != 2 || revisions[1].Version != nil {
+		t.Errorf("%d revisions; want the identical revision recorded as not applied", len(revisions))
+	}
+
+	written, err := repo.SaveOrders(ctx, []models.Order{order})
+	if err != nil {
+		t.Fatalf("SaveOrders() error = %v", err)
+	}
+	if len(written) != 0 {
+		t.Errorf("SaveOrders() wrote %v; want nothing for an identical revision", uids(written))
+	}
+}
+
+func testRepeatedItems(t *testing.T, repo db.OrderRepository) {
+	ctx := context.Background()
+	first, second := NewOrder("repeated-1", 0), NewOrder("repeated-2", 0)
+	mascara, brush := first.Items[0], first.Items[1]
+	// Одинаковые позиции внутри заказа и в разных заказах не должны схлопываться
+	first.Items = []models.Item{mascara, mascara, brush, mascara}
+	second.Items = []models.Item{brush, mascara, mascara}
+	mustSave(t, repo, first, true)
+	if _, err := repo.SaveOrders(ctx, []models.Order{second}); err != nil {
+		t.Fatalf("SaveOrders() error = %v", err)
+	}
+
+	assertSameOrder(t, first, mustGet(t, repo, first.OrderUID))
+	assertSameOrder(t, second, mustGet(t, repo, second.OrderUID))
+
+	if deleted, err := repo.DeleteOrder(ctx, second.OrderUID); err != nil || !deleted {
+		t.Fatalf("DeleteOrder() = %v, %v; want true, nil", deleted, err)
+	}
+	assertSameOrder(t, first, mustGet(t, repo, first.OrderUID))
+
+	first.Items = []models.Item{brush, brush}
+	first.DateCreated = first.DateCreated.Add(time.Minute)
+	mustSave(t, repo, first, true)
+	assertSameOrder(t, first, mustGet(t, repo, first.OrderUID))
+}
+
+func testDelete(t *testing.T, repo db.OrderRepository) {
+	ctx := context.Background()
+	order := NewOrder("delete", 0)
+	mustSave(t, repo, order, true)
+
+	if deleted, err := repo.DeleteOrder(ctx, order.OrderUID); err != nil || !deleted {
+		t.Fatalf("DeleteOrder() = %v, %v; want true, nil", deleted, err)
+	}
+	if _, err := repo.GetOrderByUID(ctx, order.OrderUID); !errors.Is(err, db.ErrOrderNotFound) {
+		t.Errorf("GetOrderByUID() after delete error = %v; want ErrOrderNotFound", err)
+	}
+	if revisions, err := repo.GetOrderHistory(ctx, order.OrderUID); err != nil || len(revisions) != 0 {
+		t.Errorf("GetOrderHistory() after delete = %d revisions, %v; want none", len(revisions), err)
+	}
+	if deleted, err := repo.DeleteOrder(ctx, order.OrderUID); err != nil || deleted {
+		t.Errorf("second DeleteOrder() = %v, %v; want false, nil", deleted, err)
+	}
+}
+
+func testAnonymize(t *testing.T, repo db.OrderRepository) {
+	ctx := context.Background()
+	order := NewOrder("anonymize", 0)
+	mustSave(t, repo, order, true)
+
+	if anonymized, err := repo.AnonymizeOrder(ctx, order.OrderUID); err != nil || !anonymized {
+		t.Fatalf("AnonymizeOrder() = %v, %v; want true, nil", anonymized, err)
+	}
+	want := models.Delivery{City: order.Delivery.City, Region: order.Delivery.Region}
+	if got := mustGet(t, repo, order.OrderUID).Delivery; got != want {
+		t.Errorf("anonymized delivery %+v; want %+v", got, want)
+	}
+
+	revisions, err := repo.GetOrderHistory(ctx, order.OrderUID)
+	if err != nil || len(revisions) != 1 {
+		t.Fatalf("GetOrderHistory() = %d revisions, %v; want 1", len(revisions), err)
+	}
+	var payload models.Order
+	if err := json.Unmarshal(revisions[0].Payload, &payload); err != nil {
+		t.Fatalf("revision payload: %v", err)
+	}
+	if payload.Delivery != want {
+		t.Errorf("anonymized revision delivery %+v; want %+v", payload.Delivery, want)
+	}
+
+	if anonymized, err := repo.AnonymizeOrder(ctx, "missing"); err != nil || anonymized {
+		t.Errorf("AnonymizeOrder(missing) = %v, %v; want false, nil", anonymized, err)
+	}
+}
+
+func testLastLimit(t *testing.T, repo db.OrderRepository) {
+	for i := 0; i < 5; i++ {
+		mustSave(t, repo, NewOrder(fmt.Sprintf("last-%d", i), time.Duration(i)*time.Minute), true)
+	}
+
+	orders, err := repo.GetLastLimitOrders(context.Background(), 3)
+	if err != nil {
+		t.Fatalf("GetLastLimitOrders() error = %v", err)
+	}
+	if got, want := uids(orders), []string{"last-2", "last-3", "last-4"}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("GetLastLimitOrders(3) = %v; want %v", got, want)
+	}
+}
+
+func testList(t *testing.T, repo db.OrderRepository) {
+	for i := 0; i < 5; i++ {
+		mustSave(t, repo, NewOrder(fmt.Sprintf("list-%d", i), time.Duration(i)*time.Minute), true)
+	}
+
+	orders, err := repo.ListOrders(context.Background(), 1, 2)
+	if err != nil {
+		t.Fatalf("ListOrders() error = %v", err)
+	}
+	if got, want := uids(orders), []string{"list-3", "list-2"}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("ListOrders(1, 2) = %v; want %v", got, want)
+	}
+	if len(orders) > 0 {
+		assertSameOrder(t, NewOrder("list-3", 3*time.Minute), orders[0])
+	}
+}
+
+func testFindOrders(t *testing.T, repo db.OrderRepository) {
+	for i := 0; i < 6; i++ {
+		order := NewOrder(fmt.Sprintf("find-%d", i), time.Duration(i)*time.Minute)
+		order.CustomerID = []string{"alice", "bob"}[i%2]
+		order.Payment.Amount = 100 * (i%3 + 1)
+		if i == 5 {
+			order.Payment.Currency = "RUB"
+		}
+		mustSave(t, repo, order, true)
+	}
+
+	cases := []struct {
+		query string
+		want  [][]string
+	}{
+		{"limit=2", [][]string{{"find-5", "find-4"}, {"find-3", "find-2"}, {"find-1", "find-0"}}},
+		{"sort=amount&limit=4", [][]string{{"find-0", "find-3", "find-1", "find-4"}, {"find-2", "find-5"}}},
+		{"sort=-amount&limit=3", [][]string{{"find-5", "find-2", "find-4"}, {"find-1", "find-3", "find-0"}}},
+		{"customer_id=bob&amount_min=200", [][]string{{"find-5", "find-1"}}},
+		{"amount_max=100&sort=date_created", [][]string{{"find-0", "find-3"}}},
+		{"created_from=2024-01-02T15:05:05Z&created_to=2024-01-02T15:07:05Z", [][]string{{"find-2", "find-1"}}},
+		{"payment.currency=RUB&payment.provider=wbpay", [][]string{{"find-5"}}},
+		{"customer_id=carol", [][]string{{}}},
+	}
+	for _, c := range cases {
+		if got := findPages(t, repo, c.query); fmt.Sprint(got) != fmt.Sprint(c.want) {
+			t.Errorf("FindOrders(%s) pages = %v; want %v", c.query, got, c.want)
+		}
+	}
+
+	values, _ := url.ParseQuery("limit=1")
+	q, err := listing.ParseQuery(values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, err := repo.FindOrders(context.Background(), q)
+	if err != nil || len(page.Orders) != 1 {
+		t.Fatalf("FindOrders(limit=1) = %d orders, %v; want 1", len(page.Orders), err)
+	}
+	want := NewOrder("find-5", 5*time.Minute)
+	want.CustomerID, want.Payment.Amount, want.Payment.Currency = "bob", 300, "RUB"
+	assertSameOrder(t, want, page.Orders[0])
+}
+
+// findPages lists the orders with the URL query, following the cursors, and returns the UIDs of every page.
+func findPages(t *testing.T, repo db.OrderRepository, query string) [][]string {
+	t.Helper()
+
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pages [][]string
+	for {
+		q, err := listing.ParseQuery(values)
+		if err != nil {
+			t.Fatalf("ParseQuery(%s) error = %v", values.Encode(), err)
+		}
+		page, err := repo.FindOrders(context.Background(), q)
+		if err != nil {
+			t.Fatalf("FindOrders(%s) error = %v", values.Encode(), err)
+		}
+		pages = append(pages, uids(page.Orders))
+		if page.Next == "" || len(pages) > 10 {
+			return pages
+		}
+		values.Set("cursor", page.Next)
+	}
+}
+
+func testLookups(t *testing.T, repo db.OrderRepository) {
+	ctx := context.Background()
+	for i, customerID := range []string{"carol", "carol", "dave"} {
+		order := NewOrder(fmt.Sprintf("lookup-%d", i), time.Duration(i)*time.Minute)
+		order.CustomerID = customerID
+		if i == 2 {
+			order.TrackNumber = "TRACK-lookup-0" // the newest order with the track number is returned
+		}
+		mustSave(t, repo, order, true)
+	}
+
+	for track, want := range map[string]string{"TRACK-lookup-0": "lookup-2", "TRACK-lookup-1": "lookup-1"} {
+		if got, err := repo.GetOrderByTrackNumber(ctx, track); err != nil || got.OrderUID != want {
+			t.Errorf("GetOrderByTrackNumber(%s) = %s, %v; want %s", track, got.OrderUID, err, want)
+		}
+	}
+	if _, err := repo.GetOrderByTrackNumber(ctx, "TRACK-missing"); !errors.Is(err, db.ErrOrderNotFound) {
+		t.Errorf("GetOrderByTrackNumber(missing) error = %v; want ErrOrderNotFound", err)
+	}
+
+	got, err := repo.GetOrderByTransaction(ctx, "lookup-1")
+	if err != nil {
+		t.Fatalf("GetOrderByTransaction() error = %v", err)
+	}
+	want := NewOrder("lookup-1", time.Minute)
+	want.CustomerID = "carol"
+	assertSameOrder(t, want, got)
+	if _, err := repo.GetOrderByTransaction(ctx, "missing"); !errors.Is(err, db.ErrOrderNotFound) {
+		t.Errorf("GetOrderByTransaction(missing) error = %v; want ErrOrderNotFound", err)
+	}
+
+	for customerID, want := range map[string][]string{"carol": {"lookup-1", "lookup-0"}, "dave": {"lookup-2"}, "erin": {}} {
+		orders, err := repo.GetCustomerOrders(ctx, customerID)
+		if err != nil {
+			t.Fatalf("GetCustomerOrders(%s) error = %v", customerID, err)
+		}
+		if got := uids(orders); fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("GetCustomerOrders(%s) = %v; want %v", customerID, got, want)
+		}
+	}
+}
+
+func testSearch(t *testing.T, repo db.OrderRepository) {
+	orders := []models.Order{NewOrder("search-0", 0), NewOrder("search-1", time.Minute), NewOrder("search-2", 2*time.Minute)}
+	orders[1].Items[0].Name, orders[1].Items[0].Brand = "Lipstick", "Chanel"
+	orders[1].Delivery.City = "Novosibirsk"
+	for i := range orders[2].Items {
+		orders[2].Items[i].Name, orders[2].Items[i].Brand = "Lipstick", "Chanel"
+	}
+	orders[2].Delivery.Email = "anna@example.com"
+	for _, order := range orders {
+		mustSave(t, repo, order, true)
+	}
+
+	cases := []struct {
+		query string
+		limit int
+		want  []string
+	}{
+		{"novosibirsk", 10, []string{"search-1"}},
+		{"Lipstick", 10, []string{"search-1", "search-2"}},
+		{"test@gmail.com", 10, []string{"search-0", "search-1"}},
+		{"lipstick novosibirsk", 10, []string{"search-1"}},
+		{"vivienne", 1, []string{"search-0"}},
+		{"missing", 10, []string{}},
+	}
+	for _, c := range cases {
+		results, err := repo.SearchOrders(context.Background(), search.Query{Text: c.query, Limit: c.limit})
+		if err != nil {
+			t.Fatalf("SearchOrders(%s) error = %v", c.query, err)
+		}
+		got := make([]string, 0, len(results))
+		for _, result := range results {
+			got = append(got, result.Order.OrderUID)
+		}
+		if c.limit > 1 {
+			slices.Sort(got) // the order of equally relevant results is up to the ranking of the backend
+		}
+		if fmt.Sprint(got) != fmt.Sprint(c.want) {
+			t.Errorf("SearchOrders(%s) = %v; want %v", c.query, got, c.want)
+		}
+	}
+
+	results, err := repo.SearchOrders(context.Background(), search.Query{Text: "Novosibirsk", Limit: 10})
+	if err != nil || len(results) != 1 {
+		t.Fatalf("SearchOrders(Novosibirsk) = %d results, %v; want 1", len(results), err)
+	}
+	assertSameOrder(t, orders[1], results[0].Order)
+	if !strings.Contains(results[0].Snippet, search.MarkStart+"Novosibirsk"+search.MarkStop) {
+		t.Errorf("SearchOrders(Novosibirsk) snippet = %q; want the city highlighted", results[0].Snippet)
+	}
+}
+
+func testConcurrentSaves(t *testing.T, repo db.OrderRepository) {
+	const n = 20
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			order := NewOrder(fmt.Sprintf("concurrent-%02d", i), time.Duration(i)*time.Second)
+			if _, err := repo.SaveOrder(ctx, &order); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("concurrent SaveOrder() error = %v", err)
+	}
+
+	orders, err := repo.ListOrders(ctx, 0, 2*n)
+	if err != nil {
+		t.Fatalf("ListOrders() error = %v", err)
+	}
+	if len(orders) != n {
+		t.Errorf("%d orders stored; want %d", len(orders), n)
+	}
+}
+
+// mustSave saves a copy of the order and checks whether it has been written.
+func mustSave(t *testing.T, repo db.OrderRepository, order models.Order, wantWritten bool) models.Order {
+	t.Helper()
+
+	written, err := repo.SaveOrder(context.Background(), &order)
+	if err != nil {
+		t.Fatalf("SaveOrder(%s) error = %v", order.OrderUID, err)
+	}
+	if written != wantWritten {
+		t.Fatalf("SaveOrder(%s) written = %v; want %v", order.OrderUID, written, wantWritten)
+	}
+	return order
+}
+
+// mustGet returns the stored order.
+func mustGet(t *testing.T, repo db.OrderRepository, orderUID string) models.Order {
+	t.Helper()
+
+	order, err := repo.GetOrderByUID(context.Background(), orderUID)
+	if err != nil {
+		t.Fatalf("GetOrderByUID(%s) error = %v", orderUID, err)
+	}
+	return order
+}
+
+// assertSameOrder compares the received fields of the orders, ignoring those maintained by the storage.
+func assertSameOrder(t *testing.T, want, got models.Order) {
+	t.Helper()
+
+	if w, g := fingerprint(want), fingerprint(got); w != g {
+		t.Errorf("stored order differs:\n got: %s\nwant: %s", g, w)
+	}
+}
+
+// fingerprint returns the JSON of the received fields of the order.
+func fingerprint(order models.Order) string {
+	order.Version, order.UpdatedAt, order.Source = 0, time.Time{}, nil
+	order.DateCreated = order.DateCreated.UTC()
+	if len(order.Checks) == 0 {
+		order.Checks = nil
+	}
+	data, _ := json.Marshal(order)
+	return string(data)
+}
+
+// uids returns the UIDs of the orders.
+func uids(orders []models.Order) []string {
+	uids := make([]string, 0, len(orders))
+	for _, order := range orders {
+		uids = append(uids, order.OrderUID)
+	}
+	return uids
+}
@@ -0,0 +1,91 @@
+package db
+
+import (
+	"context"
+	"demo_service/internal/models"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// IdempotencyKeyTTL is how long an idempotency key is remembered.
+// An expired key can be reused for a different request.
+const IdempotencyKeyTTL = 24 * time.Hour
+
+var idempotencyQueries = map[string]string{
+	"reserveIdempotencyKey": `
+		INSERT INTO idempotency_keys (key, request_hash)
+		VALUES ($1, $2)
+		ON CONFLICT (key) DO UPDATE
+		SET request_hash = EXCLUDED.request_hash, status_code = NULL, response = NULL,
+			created_at = now(), completed_at = NULL
+		WHERE idempotency_keys.created_at < now() - make_interval(secs => $3)
+		RETURNING key
+	`,
+	"getIdempotencyKey": `
+		SELECT key, request_hash, status_code, response, created_at
+		FROM idempotency_keys
+		WHERE key = $1
+	`,
+	"completeIdempotencyKey": `
+		UPDATE idempotency_keys
+		SET status_code = $2, response = $3, completed_at = now()
+		WHERE key = $1
+	`,
+	"releaseIdempotencyKey": `
+		DELETE FROM idempotency_keys
+		WHERE key = $1 AND status_code IS NULL
+	`,
+}
+
+// ReserveIdempotencyKey reserves the key for a request with the given hash.
+// It returns nil if the key has been reserved, or the record of the key if it is already in use
+// (by a request that is in progress or has been completed).
+func (s *Storage) ReserveIdempotencyKey(ctx context.Context, key, requestHash string) (*models.IdempotencyRecord, error) {
+	const fn = "ReserveIdempotencyKey"
+
+	var reserved string
+	err := s.pool.QueryRow(ctx, idempotencyQueries["reserveIdempotencyKey"],
+		key, requestHash, IdempotencyKeyTTL.Seconds()).Scan(&reserved)
+	if err == nil {
+		return nil, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("(%s) | failed to reserve key: %w", fn, err)
+	}
+
+	var record models.IdempotencyRecord
+	err = s.pool.QueryRow(ctx, idempotencyQueries["getIdempotencyKey"], key).
+		Scan(&record.Key, &record.RequestHash, &record.StatusCode, &record.Response, &record.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		// Ключ освобожден между запросами — повторяем резервирование
+		return s.ReserveIdempotencyKey(ctx, key, requestHash)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("(%s) | failed to get key: %w", fn, err)
+	}
+	return &record, nil
+}
+
+// CompleteIdempotencyKey stores the response of the request the key has been reserved for.
+func (s *Storage) CompleteIdempotencyKey(ctx context.Context, key string, statusCode int, response []byte) error {
+	const fn = "CompleteIdempotencyKey"
+
+	if _, err := s.pool.Exec(ctx, idempotencyQueries["completeIdempotencyKey"], key, statusCode, response); err != nil {
+		return fmt.Errorf("(%s) | failed to complete key: %w", fn, err)
+	}
+	return nil
+}
+
+// ReleaseIdempotencyKey removes the reservation of a key whose request has not been completed,
+// so that the request can be retried with the same key.
+func (s *Storage) ReleaseIdempotencyKey(ctx context.Context, key string) error {
+	const fn = "ReleaseIdempotencyKey"
+
+	if _, err := s.pool.Exec(ctx, idempotencyQueries["releaseIdempotencyKey"], key); err != nil {
+		return fmt.Errorf("(%s) | failed to release key: %w", fn, err)
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+//go:generate go run ../../cmd/dbgen -in ../models/models.go -out mapping_gen.go -types Order=orders,Delivery=deliveries,Payment=payments,Item=order_lines
+
+// mapping describes how a model is mapped to the columns of a table (see mapping_gen.go).
+// dest returns a new model along with the scan destinations of its mapped fields.
+type mapping struct {
+	table   string
+	columns []string
+	dest    func() (interface{}, []interface{})
+}
+
+// columnList returns the columns separated by commas, qualified with the table alias if it is set.
+func columnList(alias string, columns []string) string {
+	if alias == "" {
+		return strings.Join(columns, ", ")
+	}
+	return alias + "." + strings.Join(columns, ", "+alias+".")
+}
+
+// placeholders returns n query placeholders separated by commas, starting at $first.
+func placeholders(first, n int) string {
+	ph := make([]string, n)
+	for i := range ph {
+		ph[i] = fmt.Sprintf("$%d", first+i)
+	}
+	return strings.Join(ph, ", ")
+}
+
+// excludedSet returns the SET list of an upsert assigning the proposed values to the columns but the key.
+func excludedSet(columns []string, key string) string {
+	set := make([]string, 0, len(columns))
+	for _, column := range columns {
+		if column != key {
+			set = append(set, column+" = EXCLUDED."+column)
+		}
+	}
+	return strings.Join(set, ",\n\t\t\t")
+}
+
+// CheckMappings returns an error if the generated mappings have drifted from the db tags of the models,
+// i.e. mapping_gen.go has not been regenerated with go generate after a model changed.
+func CheckMappings() error {
+	const fn = "CheckMappings"
+
+	for _, m := range mappings {
+		model, dest := m.dest()
+		val := reflect.ValueOf(model).Elem()
+
+		var tagged []string
+		fields := make(map[string]uintptr)
+		for i := 0; i < val.NumField(); i++ {
+			column := val.Type().Field(i).Tag.Get("db")
+			if column == "" || column == "-" {
+				continue
+			}
+			tagged = append(tagged, column)
+			fields[column] = val.Field(i).Addr().Pointer()
+		}
+
+		if strings.Join(tagged, ",") != strings.Join(m.columns, ",") {
+			return fmt.Errorf("(%s) | %s: mapped columns %v, db tags of %s %v; run go generate ./internal/db",
+				fn, m.table, m.columns, val.Type(), tagged)
+		}
+		for i, column := range m.columns {
+			if reflect.ValueOf(dest[i]).Pointer() != fields[column] {
+				return fmt.Errorf("(%s) | %s: column %s is scanned into another field; run go generate ./internal/db",
+					fn, m.table, column)
+			}
+		}
+	}
+	return nil
+}
+
+// CheckSchema returns an error if a mapped table or column is missing from the database,
+// i.e. the models and the migrated schema have drifted apart.
+func (s *Storage) CheckSchema(ctx context.Context) error {
+	const fn = "CheckSchema"
+
+	for _, m := range mappings {
+		rows, err := s.pool.Query(ctx, queries["getTableColumns"], m.table)
+		if err != nil {
+			return fmt.Errorf("(%s) | failed to get columns of %s: %w", fn, m.table, err)
+		}
+		existing := make(map[string]bool)
+		for rows.Next() {
+			var column string
+			if err := rows.Scan(&column); err != nil {
+				rows.Close()
+				return fmt.Errorf("(%s) | failed to scan row: %w", fn, err)
+			}
+			existing[column] = true
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("(%s) | failed to get columns of %s: %w", fn, m.table, err)
+		}
+
+		if len(existing) == 0 {
+			return fmt.Errorf("(%s) | table %s does not exist", fn, m.table)
+		}
+		for _, column := range m.columns {
+			if !existing[column] {
+				return fmt.Errorf("(%s) | column %s.%s does not exist", fn, m.table, column)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+// Code generated by dbgen from models.go; DO NOT EDIT.
+
+package db
+
+import "demo_service/internal/models"
+
+// orderColumns are the columns of the orders table mapped to the fields of models.Order, in order.
+var orderColumns = []string{"order_uid", "track_number", "entry", "locale", "internal_signature", "customer_id", "delivery_service", "shardkey", "sm_id", "date_created", "oof_shard"}
+
+// orderArgs returns the mapped fields of v as query arguments, in the order of orderColumns.
+func orderArgs(v *models.Order) []interface{} {
+	return []interface{}{v.OrderUID, v.TrackNumber, v.Entry, v.Locale, v.InternalSignature, v.CustomerID, v.DeliveryService, v.Shardkey, v.SmID, v.DateCreated, v.OofShard}
+}
+
+// orderDest returns pointers to the mapped fields of v as scan destinations, in the order of orderColumns.
+func orderDest(v *models.Order) []interface{} {
+	return []interface{}{&v.OrderUID, &v.TrackNumber, &v.Entry, &v.Locale, &v.InternalSignature, &v.CustomerID, &v.DeliveryService, &v.Shardkey, &v.SmID, &v.DateCreated, &v.OofShard}
+}
+
+// deliveryColumns are the columns of the deliveries table mapped to the fields of models.Delivery, in order.
+var deliveryColumns = []string{"name", "phone", "zip", "city", "address", "region", "email"}
+
+// deliveryArgs returns the mapped fields of v as query arguments, in the order of deliveryColumns.
+func deliveryArgs(v *models.Delivery) []interface{} {
+	return []interface{}{v.Name, v.Phone, v.Zip, v.City, v.Address, v.Region, v.Email}
+}
+
+// deliveryDest returns pointers to the mapped fields of v as scan destinations, in the order of deliveryColumns.
+func deliveryDest(v *models.Delivery) []interface{} {
+	return []interface{}{&v.Name, &v.Phone, &v.Zip, &v.City, &v.Address, &v.Region, &v.Email}
+}
+
+// paymentColumns are the columns of the payments table mapped to the fields of models.Payment, in order.
+var paymentColumns = []string{"transaction", "request_id", "currency", "provider", "amount", "payment_dt", "bank", "delivery_cost", "goods_total", "custom_fee"}
+
+// paymentArgs returns the mapped fields of v as query arguments, in the order of paymentColumns.
+func paymentArgs(v *models.Payment) []interface{} {
+	return []interface{}{v.Transaction, v.RequestID, v.Currency, v.Provider, v.Amount, v.PaymentDT, v.Bank, v.DeliveryCost, v.GoodsTotal, v.CustomFee}
+}
+
+// paymentDest returns pointers to the mapped fields of v as scan destinations, in the order of paymentColumns.
+func paymentDest(v *models.Payment) []interface{} {
+	return []interface{}{&v.Transaction, &v.RequestID, &v.Currency, &v.Provider, &v.Amount, &v.PaymentDT, &v.Bank, &v.DeliveryCost, &v.GoodsTotal, &v.CustomFee}
+}
+
+// itemColumns are the columns of the order_lines table mapped to the fields of models.Item, in order.
+var itemColumns = []string{"chrt_id", "track_number", "price", "rid", "name", "sale", "size", "total_price", "nm_id", "brand", "status"}
+
+// itemArgs returns the mapped fields of v as query arguments, in the order of itemColumns.
+func itemArgs(v *models.Item) []interface{} {
+	return []interface{}{v.ChrtID, v.TrackNumber, v.Price, v.RID, v.Name, v.Sale, v.Size, v.TotalPrice, v.NMID, v.Brand, v.Status}
+}
+
+// itemDest returns pointers to the mapped fields of v as scan destinations, in the order of itemColumns.
+func itemDest(v *models.Item) []interface{} {
+	return []interface{}{&v.ChrtID, &v.TrackNumber, &v.Price, &v.RID, &v.Name, &v.Sale, &v.Size, &v.TotalPrice, &v.NMID, &v.Brand, &v.Status}
+}
+
+// mappings lists the mapped models for the drift checks.
+var mappings = []mapping{
+	{
+		table:   "orders",
+		columns: orderColumns,
+		dest:    func() (interface{}, []interface{}) { v := &models.Order{}; return v, orderDest(v) },
+	},
+	{
+		table:   "deliveries",
+		columns: deliveryColumns,
+		dest:    func() (interface{}, []interface{}) { v := &models.Delivery{}; return v, deliveryDest(v) },
+	},
+	{
+		table:   "payments",
+		columns: paymentColumns,
+		dest:    func() (interface{}, []interface{}) { v := &models.Payment{}; return v, paymentDest(v) },
+	},
+	{
+		table:   "order_lines",
+		columns: itemColumns,
+		dest:    func() (interface{}, []interface{}) { v := &models.Item{}; return v, itemDest(v) },
+	},
+}
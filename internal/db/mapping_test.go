@@ -0,0 +1,64 @@
+package db_test
+
+import (
+	"bytes"
+	"demo_service/internal/db"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckMappings(t *testing.T) {
+	if err := db.CheckMappings(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestMappingsGenerated runs the go:generate command of mapping.go into a temporary file
+// and fails if mapping_gen.go differs from its output.
+func TestMappingsGenerated(t *testing.T) {
+	gobin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go command is not available")
+	}
+
+	src, err := os.ReadFile("mapping.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var args []string
+	for _, line := range strings.Split(string(src), "\n") {
+		if cmd, ok := strings.CutPrefix(line, "//go:generate go "); ok {
+			args = strings.Fields(cmd)
+			break
+		}
+	}
+	if args == nil {
+		t.Fatal("mapping.go has no go:generate directive")
+	}
+
+	out := filepath.Join(t.TempDir(), "mapping_gen.go")
+	for i := range args[:len(args)-1] {
+		if args[i] == "-out" {
+			args[i+1] = out
+		}
+	}
+	cmd := exec.Command(gobin, args...)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("go %s: %v\n%s", strings.Join(args, " "), err, output)
+	}
+
+	want, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile("mapping_gen.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Error("mapping_gen.go is out of date, run go generate ./internal/db")
+	}
+}
@@ -0,0 +1,663 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"demo_service/internal/config"
+	"demo_service/internal/listing"
+	"demo_service/internal/models"
+	"demo_service/internal/search"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Memory is a thread-safe in-memory order storage with the semantics of Storage:
+// write modes, versions, the audit trail of revisions, deletion, anonymization and idempotency keys.
+// It lets the whole pipeline run without PostgreSQL, for demos and tests.
+//
+// If a file is configured, the storage is loaded from it on creation and written to it
+// (atomically, through a temporary file) after every change. A change that cannot be written
+// is rolled back, so the storage never holds what its file does not.
+type Memory struct {
+	upsert bool
+	file   string
+
+	mu          sync.RWMutex
+	orders      map[string]models.Order
+	revisions   []models.Revision
+	sources     map[recordPosition]bool // broker records whose revision has been recorded
+	lastID      int64
+	idempotency map[string]models.IdempotencyRecord
+}
+
+// memorySnapshot is the file format of a persisted Memory.
+type memorySnapshot struct {
+	Orders          []models.Order             `json:"orders"`
+	Revisions       []models.Revision          `json:"revisions"`
+	LastRevisionID  int64                      `json:"last_revision_id"`
+	IdempotencyKeys []models.IdempotencyRecord `json:"idempotency_keys,omitempty"`
+}
+
+// memoryState is the state of a Memory, taken before a change to roll it back if the change cannot be persisted.
+type memoryState struct {
+	orders      map[string]models.Order
+	revisions   []models.Revision
+	sources     map[recordPosition]bool
+	lastID      int64
+	idempotency map[string]models.IdempotencyRecord
+}
+
+// recordPosition identifies a broker record, like the unique_revision_source constraint.
+type recordPosition struct {
+	topic     string
+	partition int32
+	offset    int64
+}
+
+// NewMemory creates an in-memory storage with the write mode of the database configuration.
+// It is loaded from the configured file if it exists, and empty otherwise.
+func NewMemory(dbCfg config.DataBase) (*Memory, error) {
+	const fn = "NewMemory"
+
+	switch dbCfg.WriteMode {
+	case config.WriteModeInsert, config.WriteModeUpsert:
+	default:
+		return nil, fmt.Errorf("(%s) | unknown write mode: %q", fn, dbCfg.WriteMode)
+	}
+
+	m := &Memory{
+		upsert:      dbCfg.WriteMode == config.WriteModeUpsert,
+		file:        dbCfg.File,
+		orders:      make(map[string]models.Order),
+		sources:     make(map[recordPosition]bool),
+		idempotency: make(map[string]models.IdempotencyRecord),
+	}
+	if err := m.load(); err != nil {
+		return nil, fmt.Errorf("(%s) | %w", fn, err)
+	}
+
+	log.Printf("(%s) | In-memory storage ready with %d orders\n", fn, len(m.orders))
+	return m, nil
+}
+
+// load restores the storage from its file, if it exists.
+func (m *Memory) load() error {
+	if m.file == "" {
+		return nil
+	}
+	data, err := os.ReadFile(m.file)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to read %s: %w", m.file, err)
+	}
+
+	var snapshot memorySnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", m.file, err)
+	}
+	for _, order := range snapshot.Orders {
+		m.orders[order.OrderUID] = order
+	}
+	for _, rev := range snapshot.Revisions {
+		if rev.Source != nil {
+			m.sources[recordPosition{rev.Source.Topic, rev.Source.Partition, rev.Source.Offset}] = true
+		}
+	}
+	m.revisions = snapshot.Revisions
+	m.lastID = snapshot.LastRevisionID
+	for _, record := range snapshot.IdempotencyKeys {
+		m.idempotency[record.Key] = record
+	}
+	return nil
+}
+
+// state returns a copy of the state of the storage to restore if a change fails. m.mu must be held.
+// Without a file a change cannot fail to be persisted, and nothing is copied.
+// The maps and the revisions are copied; their values are replaced, never modified in place.
+func (m *Memory) state() memoryState {
+	if m.file == "" {
+		return memoryState{}
+	}
+	return memoryState{
+		orders:      maps.Clone(m.orders),
+		revisions:   slices.Clone(m.revisions),
+		sources:     maps.Clone(m.sources),
+		lastID:      m.lastID,
+		idempotency: maps.Clone(m.idempotency),
+	}
+}
+
+// commit persists a change, restoring the state taken before it if that fails. m.mu must be held.
+func (m *Memory) commit(before memoryState) error {
+	if err := m.persist(); err != nil {
+		m.orders, m.revisions, m.sources = before.orders, before.revisions, before.sources
+		m.lastID, m.idempotency = before.lastID, before.idempotency
+		return err
+	}
+	return nil
+}
+
+// persist writes the storage to its file, if one is configured: to a temporary file in the same directory,
+// which then replaces the file, so a failed write leaves the previous file intact. m.mu must be held.
+func (m *Memory) persist() error {
+	if m.file == "" {
+		return nil
+	}
+
+	snapshot := memorySnapshot{
+		Orders:         make([]models.Order, 0, len(m.orders)),
+		Revisions:      m.revisions,
+		LastRevisionID: m.lastID,
+	}
+	for _, order := range m.orders {
+		snapshot.Orders = append(snapshot.Orders, order)
+	}
+	sort.Slice(snapshot.Orders, func(i, j int) bool {
+		return snapshot.Orders[i].OrderUID < snapshot.Orders[j].OrderUID
+	})
+	for _, record := range m.idempotency {
+		snapshot.IdempotencyKeys = append(snapshot.IdempotencyKeys, record)
+	}
+	sort.Slice(snapshot.IdempotencyKeys, func(i, j int) bool {
+		return snapshot.IdempotencyKeys[i].Key < snapshot.IdempotencyKeys[j].Key
+	})
+
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return fmt.Errorf("failed to marshal storage: %w", err)
+	}
+	dir := filepath.Dir(m.file)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create directory of %s: %w", m.file, err)
+	}
+	tmp, err := os.CreateTemp(dir, filepath.Base(m.file)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file of %s: %w", m.file, err)
+	}
+	if err := writeFile(tmp, data); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write %s: %w", tmp.Name(), err)
+	}
+	if err := os.Rename(tmp.Name(), m.file); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to replace %s: %w", m.file, err)
+	}
+	return nil
+}
+
+// writeFile writes the data to the file, flushes it to disk and closes it.
+func writeFile(f *os.File, data []byte) error {
+	_, err := f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Close writes the storage to its file, if one is configured.
+func (m *Memory) Close() {
+	const fn = "Memory.Close"
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.persist(); err != nil {
+		log.Printf("(%s) | %v\n", fn, err)
+		return
+	}
+	log.Println("In-memory storage closed!")
+}
+
+// SaveOrder works like Storage.SaveOrder.
+func (m *Memory) SaveOrder(_ context.Context, order *models.Order) (bool, error) {
+	const fn = "Memory.SaveOrder"
+
+	payload, err := revisionPayload(*order)
+	if err != nil {
+		return false, fmt.Errorf("(%s) | %w", fn, err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	before := m.state()
+	saved := *order
+	written, err := m.writeOrder(&saved, payload)
+	if err != nil {
+		return false, fmt.Errorf("(%s) | %w", fn, err)
+	}
+	var version *int
+	if written {
+		version = &saved.Version
+	}
+	m.saveRevision(saved, version, payload)
+	if err := m.commit(before); err != nil {
+		return false, fmt.Errorf("(%s) | %w", fn, err)
+	}
+	*order = saved
+	return written, nil
+}
+
+// SaveOrders works like Storage.SaveOrders: all orders are saved atomically.
+func (m *Memory) SaveOrders(ctx context.Context, orders []models.Order) ([]models.Order, error) {
+	const fn = "Memory.SaveOrders"
+
+	payloads := make([][]byte, len(orders))
+	for i, order := range orders {
+		var err error
+		if payloads[i], err = revisionPayload(order); err != nil {
+			return nil, fmt.Errorf("(%s) | %w", fn, err)
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	before := m.state()
+	var pendingIdx []int
+	if m.upsert {
+		var err error
+		if pendingIdx, err = pendingOrders(ctx, nil, orders, true); err != nil {
+			return nil, fmt.Errorf("(%s) | %w", fn, err)
+		}
+	} else {
+		seen := make(map[string]bool, len(orders))
+		for i, order := range orders {
+			if _, ok := m.orders[order.OrderUID]; ok || seen[order.OrderUID] {
+				continue
+			}
+			seen[order.OrderUID] = true
+			pendingIdx = append(pendingIdx, i)
+		}
+	}
+
+	written := make([]models.Order, 0, len(pendingIdx))
+	versions := make(map[int]*int, len(pendingIdx))
+	for _, i := range pendingIdx {
+		order := orders[i]
+		ok, err := m.writeOrder(&order, payloads[i])
+		if err != nil {
+			return nil, fmt.Errorf("(%s) | %w", fn, err)
+		}
+		if ok {
+			versions[i] = &order.Version
+			written = append(written, order)
+		}
+	}
+	for i, order := range orders {
+		m.saveRevision(order, versions[i], payloads[i])
+	}
+	if err := m.commit(before); err != nil {
+		return nil, fmt.Errorf("(%s) | %w", fn, err)
+	}
+	return written, nil
+}
+
+// writeOrder stores the order with the revision payload if the write mode allows it,
+// filling in its version and update time. m.mu must be held.
+func (m *Memory) writeOrder(order *models.Order, payload []byte) (bool, error) {
+	stored, exists := m.orders[order.OrderUID]
+	if exists && (!m.upsert || stored.DateCreated.After(order.DateCreated)) {
+		return false, nil
+	}
+	// Like the content hash of Storage, the same content is not applied again
+	if exists {
+		storedPayload, err := revisionPayload(stored)
+		if err != nil {
+			return false, err
+		}
+		if bytes.Equal(storedPayload, payload) {
+			return false, nil
+		}
+	}
+
+	order.Version = 1
+	if exists {
+		order.Version = stored.Version + 1
+	}
+	order.UpdatedAt = time.Now()
+	m.orders[order.OrderUID] = cloneOrder(*order)
+	return true, nil
+}
+
+// saveRevision records the received order in its audit trail, once per broker record. m.mu must be held.
+func (m *Memory) saveRevision(order models.Order, version *int, payload []byte) {
+	var source *models.Source
+	if order.Source != nil {
+		position := recordPosition{order.Source.Topic, order.Source.Partition, order.Source.Offset}
+		if m.sources[position] {
+			return
+		}
+		m.sources[position] = true
+		source = &models.Source{Topic: position.topic, Partition: position.partition, Offset: position.offset}
+	}
+
+	m.lastID++
+	m.revisions = append(m.revisions, models.Revision{
+		ID:         m.lastID,
+		OrderUID:   order.OrderUID,
+		Version:    version,
+		Payload:    payload,
+		Source:     source,
+		ReceivedAt: time.Now(),
+	})
+}
+
+// revisionPayload returns the audit trail payload of the order, as recorded by Storage.
+func revisionPayload(order models.Order) ([]byte, error) {
+	values, err := revisionValues(order, nil)
+	if err != nil {
+		return nil, err
+	}
+	return values[2].([]byte), nil
+}
+
+// GetOrderByUID returns the stored order, or ErrOrderNotFound.
+func (m *Memory) GetOrderByUID(_ context.Context, orderUID string) (models.Order, error) {
+	const fn = "Memory.GetOrderByUID"
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	order, ok := m.orders[orderUID]
+	if !ok {
+		return models.Order{}, fmt.Errorf("(%s) | %s: %w", fn, orderUID, ErrOrderNotFound)
+	}
+	return cloneOrder(order), nil
+}
+
+// GetOrderByTrackNumber returns the newest (by date_created) order with the track number, or ErrOrderNotFound.
+func (m *Memory) GetOrderByTrackNumber(_ context.Context, trackNumber string) (models.Order, error) {
+	const fn = "Memory.GetOrderByTrackNumber"
+
+	orders := m.newestOrders(func(order models.Order) bool { return order.TrackNumber == trackNumber })
+	if len(orders) == 0 {
+		return models.Order{}, fmt.Errorf("(%s) | track number %s: %w", fn, trackNumber, ErrOrderNotFound)
+	}
+	return orders[0], nil
+}
+
+// GetOrderByTransaction returns the newest (by date_created) order paid by the transaction, or ErrOrderNotFound.
+func (m *Memory) GetOrderByTransaction(_ context.Context, transaction string) (models.Order, error) {
+	const fn = "Memory.GetOrderByTransaction"
+
+	orders := m.newestOrders(func(order models.Order) bool { return order.Payment.Transaction == transaction })
+	if len(orders) == 0 {
+		return models.Order{}, fmt.Errorf("(%s) | transaction %s: %w", fn, transaction, ErrOrderNotFound)
+	}
+	return orders[0], nil
+}
+
+// GetCustomerOrders returns all orders of the customer, newest (by date_created) first.
+func (m *Memory) GetCustomerOrders(_ context.Context, customerID string) ([]models.Order, error) {
+	return m.newestOrders(func(order models.Order) bool { return order.CustomerID == customerID }), nil
+}
+
+// SearchOrders returns the orders whose searchable text matches the query, best ranked first,
+// with their snippets (see search.Query.Match).
+func (m *Memory) SearchOrders(_ context.Context, q search.Query) ([]search.Result, error) {
+	newest := listing.Query{Sort: listing.SortDateCreated, Desc: true}
+
+	m.mu.RLock()
+	results := []search.Result{}
+	for _, order := range m.orders {
+		if result, ok := q.Match(order); ok {
+			result.Order = cloneOrder(order)
+			results = append(results, result)
+		}
+	}
+	m.mu.RUnlock()
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Rank != results[j].Rank {
+			return results[i].Rank > results[j].Rank
+		}
+		return newest.Compare(results[i].Order, results[j].Order) < 0
+	})
+	return results[:min(len(results), q.Limit)], nil
+}
+
+// newestOrders returns copies of the orders matching the predicate, newest first (see listing.SortDateCreated).
+func (m *Memory) newestOrders(match func(order models.Order) bool) []models.Order {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	newest := listing.Query{Sort: listing.SortDateCreated, Desc: true}
+	var orders []models.Order
+	for _, order := range m.orders {
+		if match(order) {
+			orders = append(orders, cloneOrder(order))
+		}
+	}
+	sort.Slice(orders, func(i, j int) bool { return newest.Compare(orders[i], orders[j]) < 0 })
+	return orders
+}
+
+// GetLastLimitOrders returns the last 'limit' orders by date_created, oldest first.
+func (m *Memory) GetLastLimitOrders(_ context.Context, limit int) ([]models.Order, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	orders := m.sortedOrders()
+	if len(orders) > limit {
+		orders = orders[len(orders)-limit:]
+	}
+	return orders, nil
+}
+
+// ListOrders returns up to limit orders starting at offset, newest (by date_created) first.
+func (m *Memory) ListOrders(_ context.Context, offset, limit int) ([]models.Order, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	orders := m.sortedOrders()
+	slices.Reverse(orders)
+	offset = min(max(offset, 0), len(orders))
+	return orders[offset:min(offset+max(limit, 0), len(orders))], nil
+}
+
+// FindOrders returns a page of the orders matching the filter of the query, in its sort order.
+func (m *Memory) FindOrders(_ context.Context, q listing.Query) (listing.Page, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var matched []models.Order
+	for _, order := range m.orders {
+		if q.Filter.Match(order) && q.IsAfter(order) {
+			matched = append(matched, order)
+		}
+	}
+	sort.Slice(matched, func(i, j int) bool { return q.Compare(matched[i], matched[j]) < 0 })
+
+	matched = matched[:min(len(matched), q.Limit+1)]
+	for i := range matched {
+		matched[i] = cloneOrder(matched[i])
+	}
+	return listing.NewPage(q, matched)
+}
+
+// sortedOrders returns copies of all orders, oldest (by date_created) first. m.mu must be held.
+func (m *Memory) sortedOrders() []models.Order {
+	orders := make([]models.Order, 0, len(m.orders))
+	for _, order := range m.orders {
+		orders = append(orders, cloneOrder(order))
+	}
+	sort.Slice(orders, func(i, j int) bool {
+		if !orders[i].DateCreated.Equal(orders[j].DateCreated) {
+			return orders[i].DateCreated.Before(orders[j].DateCreated)
+		}
+		return orders[i].OrderUID > orders[j].OrderUID
+	})
+	return orders
+}
+
+// GetOrderHistory returns all received revisions of the order, oldest first.
+func (m *Memory) GetOrderHistory(_ context.Context, orderUID string) ([]models.Revision, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var revisions []models.Revision
+	for _, rev := range m.revisions {
+		if rev.OrderUID == orderUID {
+			rev.Payload = slices.Clone(rev.Payload)
+			revisions = append(revisions, rev)
+		}
+	}
+	return revisions, nil
+}
+
+// DeleteOrder deletes the order along with its revisions. It returns false if the order does not exist.
+func (m *Memory) DeleteOrder(_ context.Context, orderUID string) (bool, error) {
+	const fn = "Memory.DeleteOrder"
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.orders[orderUID]; !ok {
+		return false, nil
+	}
+	before := m.state()
+	delete(m.orders, orderUID)
+	m.revisions = slices.DeleteFunc(m.revisions, func(rev models.Revision) bool {
+		if rev.OrderUID != orderUID {
+			return false
+		}
+		if rev.Source != nil {
+			delete(m.sources, recordPosition{rev.Source.Topic, rev.Source.Partition, rev.Source.Offset})
+		}
+		return true
+	})
+	if err := m.commit(before); err != nil {
+		return false, fmt.Errorf("(%s) | %w", fn, err)
+	}
+	return true, nil
+}
+
+// AnonymizeOrder works like Storage.AnonymizeOrder. It returns false if the order does not exist.
+func (m *Memory) AnonymizeOrder(_ context.Context, orderUID string) (bool, error) {
+	const fn = "Memory.AnonymizeOrder"
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	order, ok := m.orders[orderUID]
+	if !ok {
+		return false, nil
+	}
+
+	anonymized := anonymizeDelivery(order.Delivery)
+	delivery, err := json.Marshal(anonymized)
+	if err != nil {
+		return false, fmt.Errorf("(%s) | failed to marshal Delivery: %w", fn, err)
+	}
+	before := m.state()
+	for i, rev := range m.revisions {
+		if rev.OrderUID != orderUID {
+			continue
+		}
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(rev.Payload, &fields); err != nil {
+			return false, fmt.Errorf("(%s) | failed to unmarshal revision: %w", fn, err)
+		}
+		fields["delivery"] = delivery
+		if m.revisions[i].Payload, err = json.Marshal(fields); err != nil {
+			return false, fmt.Errorf("(%s) | failed to marshal revision: %w", fn, err)
+		}
+	}
+
+	order.Delivery = anonymized
+	order.UpdatedAt = time.Now()
+	m.orders[orderUID] = order
+	if err := m.commit(before); err != nil {
+		return false, fmt.Errorf("(%s) | %w", fn, err)
+	}
+	return true, nil
+}
+
+// cloneOrder returns a copy of the order that shares no slices or pointers with it.
+func cloneOrder(order models.Order) models.Order {
+	order.Items = slices.Clone(order.Items)
+	order.Checks = slices.Clone(order.Checks)
+	if order.Source != nil {
+		source := *order.Source
+		if source.Headers != nil {
+			headers := make(map[string]string, len(source.Headers))
+			for k, v := range source.Headers {
+				headers[k] = v
+			}
+			source.Headers = headers
+		}
+		order.Source = &source
+	}
+	return order
+}
+
+// ReserveIdempotencyKey works like Storage.ReserveIdempotencyKey.
+func (m *Memory) ReserveIdempotencyKey(_ context.Context, key, requestHash string) (*models.IdempotencyRecord, error) {
+	const fn = "Memory.ReserveIdempotencyKey"
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if record, ok := m.idempotency[key]; ok && time.Since(record.CreatedAt) < IdempotencyKeyTTL {
+		record.Response = slices.Clone(record.Response)
+		return &record, nil
+	}
+	before := m.state()
+	m.idempotency[key] = models.IdempotencyRecord{Key: key, RequestHash: requestHash, CreatedAt: time.Now()}
+	if err := m.commit(before); err != nil {
+		return nil, fmt.Errorf("(%s) | %w", fn, err)
+	}
+	return nil, nil
+}
+
+// CompleteIdempotencyKey works like Storage.CompleteIdempotencyKey.
+func (m *Memory) CompleteIdempotencyKey(_ context.Context, key string, statusCode int, response []byte) error {
+	const fn = "Memory.CompleteIdempotencyKey"
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	record, ok := m.idempotency[key]
+	if !ok {
+		return nil
+	}
+	before := m.state()
+	record.StatusCode, record.Response = &statusCode, slices.Clone(response)
+	m.idempotency[key] = record
+	if err := m.commit(before); err != nil {
+		return fmt.Errorf("(%s) | %w", fn, err)
+	}
+	return nil
+}
+
+// ReleaseIdempotencyKey works like Storage.ReleaseIdempotencyKey.
+func (m *Memory) ReleaseIdempotencyKey(_ context.Context, key string) error {
+	const fn = "Memory.ReleaseIdempotencyKey"
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if record, ok := m.idempotency[key]; !ok || record.StatusCode != nil {
+		return nil
+	}
+	before := m.state()
+	delete(m.idempotency, key)
+	if err := m.commit(before); err != nil {
+		return fmt.Errorf("(%s) | %w", fn, err)
+	}
+	return nil
+}
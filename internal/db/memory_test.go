@@ -0,0 +1,152 @@
+package db_test
+
+import (
+	"context"
+	"demo_service/internal/config"
+	"demo_service/internal/db"
+	"demo_service/internal/db/dbtest"
+	"demo_service/internal/models"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMemoryConformance(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T, writeMode string) db.OrderRepository {
+		return openMemory(t, writeMode)
+	})
+}
+
+// openMemory returns an empty in-memory storage with the write mode.
+func openMemory(tb testing.TB, writeMode string) *db.Memory {
+	tb.Helper()
+
+	repo, err := db.NewMemory(config.DataBase{Backend: config.BackendMemory, WriteMode: writeMode})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return repo
+}
+
+// openMemoryFile returns an in-memory storage persisted to the file.
+func openMemoryFile(t *testing.T, file string) *db.Memory {
+	t.Helper()
+
+	repo, err := db.NewMemory(config.DataBase{Backend: config.BackendMemory, WriteMode: config.WriteModeUpsert, File: file})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return repo
+}
+
+func TestMemoryRestoresFromFile(t *testing.T) {
+	ctx := context.Background()
+	file := filepath.Join(t.TempDir(), "orders.json")
+
+	repo := openMemoryFile(t, file)
+	order := dbtest.NewOrder("order-1", 0)
+	if _, err := repo.SaveOrder(ctx, &order); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.ReserveIdempotencyKey(ctx, "key-1", "hash"); err != nil {
+		t.Fatal(err)
+	}
+	repo.Close()
+
+	restored := openMemoryFile(t, file)
+	if got, err := restored.GetOrderByUID(ctx, "order-1"); err != nil || got.Version != 1 {
+		t.Errorf("GetOrderByUID() after a restart = %+v, %v; want version 1", got, err)
+	}
+	if revs, err := restored.GetOrderHistory(ctx, "order-1"); err != nil || len(revs) != 1 {
+		t.Errorf("GetOrderHistory() after a restart = %d revisions, %v; want 1", len(revs), err)
+	}
+	if record, err := restored.ReserveIdempotencyKey(ctx, "key-1", "hash"); err != nil || record == nil {
+		t.Errorf("ReserveIdempotencyKey() after a restart = %+v, %v; want the reserved key", record, err)
+	}
+}
+
+func TestMemoryRollsBackUnpersistedChanges(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	file := filepath.Join(dir, "orders.json")
+
+	repo := openMemoryFile(t, file)
+	stored := dbtest.NewOrder("order-1", 0)
+	if _, err := repo.SaveOrder(ctx, &stored); err != nil {
+		t.Fatal(err)
+	}
+	persisted, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Каталог на месте файла: временный файл пишется, но заменить им файл нельзя
+	if err := os.Remove(file); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(file, "blocker"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	order := dbtest.NewOrder("order-2", time.Second)
+	if _, err := repo.SaveOrder(ctx, &order); err == nil {
+		t.Fatal("SaveOrder() error = nil; want the failed write reported")
+	}
+	if order.Version != 0 {
+		t.Errorf("order version = %d after a failed SaveOrder; want it untouched", order.Version)
+	}
+	changed := dbtest.NewOrder("order-1", time.Second)
+	if _, err := repo.SaveOrders(ctx, []models.Order{changed}); err == nil {
+		t.Error("SaveOrders() error = nil; want the failed write reported")
+	}
+	if _, err := repo.DeleteOrder(ctx, "order-1"); err == nil {
+		t.Error("DeleteOrder() error = nil; want the failed write reported")
+	}
+	if _, err := repo.AnonymizeOrder(ctx, "order-1"); err == nil {
+		t.Error("AnonymizeOrder() error = nil; want the failed write reported")
+	}
+	if _, err := repo.ReserveIdempotencyKey(ctx, "key-1", "hash"); err == nil {
+		t.Error("ReserveIdempotencyKey() error = nil; want the failed write reported")
+	}
+
+	// В памяти осталось то же, что и на диске
+	if _, err := repo.GetOrderByUID(ctx, "order-2"); !errors.Is(err, db.ErrOrderNotFound) {
+		t.Errorf("GetOrderByUID(order-2) error = %v; want ErrOrderNotFound", err)
+	}
+	if got, err := repo.GetOrderByUID(ctx, "order-1"); err != nil || got.Version != 1 || got.Delivery != stored.Delivery {
+		t.Errorf("GetOrderByUID(order-1) = %+v, %v; want the order as persisted", got, err)
+	}
+	if revs, err := repo.GetOrderHistory(ctx, "order-1"); err != nil || len(revs) != 1 {
+		t.Errorf("GetOrderHistory(order-1) = %d revisions, %v; want 1", len(revs), err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("%d entries in the directory of the file; want no temporary files left", len(entries))
+	}
+
+	if err := os.RemoveAll(file); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(file, persisted, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if created, err := repo.SaveOrder(ctx, &order); err != nil || !created {
+		t.Errorf("SaveOrder() once the file is writable = %v, %v; want the order created", created, err)
+	}
+	if record, err := repo.ReserveIdempotencyKey(ctx, "key-1", "hash"); err != nil || record != nil {
+		t.Errorf("ReserveIdempotencyKey() once the file is writable = %+v, %v; want the key reserved", record, err)
+	}
+	repo.Close()
+
+	restored := openMemoryFile(t, file)
+	for _, uid := range []string{"order-1", "order-2"} {
+		if got, err := restored.GetOrderByUID(ctx, uid); err != nil || got.Version != 1 {
+			t.Errorf("GetOrderByUID(%s) after a restart = %+v, %v; want version 1", uid, got, err)
+		}
+	}
+}
@@ -2,97 +2,232 @@
 //
 // It manages the connection pool, executes queries, and handles database transactions for the service.
 //
-// This includes transactions such as storing orders, payments, deliveries and items, and retrieving orders.
+// This includes transactions such as storing orders, payments, deliveries and items, retrieving orders,
+// and deleting or anonymizing them.
 package db
 
 import (
 	"context"
+	"crypto/sha256"
 	"demo_service/internal/config"
+	"demo_service/internal/listing"
 	"demo_service/internal/models"
+	"demo_service/internal/search"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
-	"reflect"
+	"strings"
 
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
-	"golang.org/x/sync/errgroup"
 )
 
+// orderRowColumns are the columns written by the order write queries:
+// the mapped fields of the order followed by those maintained by the storage.
+var orderRowColumns = append(append([]string{}, orderColumns...), "delivery_id", "payment_id", "checks", "source", "content_hash")
+
+// selectOrders selects complete orders in one statement: the delivery and the payment are joined,
+// and the items are aggregated into a JSON array, each line repeated by its quantity.
+// The queries that read orders append their WHERE and ORDER BY clauses to it; scanOrder scans its rows.
+// Queries selecting more columns are built on orderSelectList and orderSources.
+var selectOrders = `
+	SELECT ` + orderSelectList + orderSources
+
+var orderSelectList = columnList("o", orderColumns) + `,
+		o.checks, o.version, o.updated_at, o.source,
+		` + columnList("d", deliveryColumns) + `,
+		` + columnList("p", paymentColumns) + `,
+		i.items`
+
+var orderSources = `
+	FROM orders o
+	JOIN deliveries d ON d.id = o.delivery_id
+	JOIN payments p ON p.id = o.payment_id
+	LEFT JOIN LATERAL (
+		SELECT json_agg(l ORDER BY l.line_no) AS items
+		FROM order_lines l
+		CROSS JOIN generate_series(1, l.quantity)
+		WHERE l.order_uid = o.order_uid
+	) i ON true
+`
+
 var queries = map[string]string{
-	"checkOrderByUID": `
-		SELECT order_uid
-		FROM orders
-		WHERE order_uid = $1;
-	`,
 	"insertDelivery": `
-		INSERT INTO deliveries (name, phone, zip, city, address, region, email)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		INSERT INTO deliveries (` + columnList("", deliveryColumns) + `)
+		VALUES (` + placeholders(1, len(deliveryColumns)) + `)
 		ON CONFLICT ON CONSTRAINT unique_deliveries
 		DO UPDATE SET id = deliveries.id
 		RETURNING id;
 	`,
 	"insertPayment": `
-		INSERT INTO payments (transaction, request_id, currency, provider, amount, payment_dt, bank, delivery_cost, goods_total, custom_fee)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		INSERT INTO payments (` + columnList("", paymentColumns) + `)
+		VALUES (` + placeholders(1, len(paymentColumns)) + `)
 		ON CONFLICT ON CONSTRAINT unique_payments
 		DO UPDATE SET id = payments.id
 		RETURNING id;
 	`,
-	"insertItem": `
-		INSERT INTO items (chrt_id, track_number, price, rid, name, sale, size, total_price, nm_id, brand, status)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
-		ON CONFLICT ON CONSTRAINT unique_items
-		DO UPDATE SET id = items.id
-		RETURNING id;
-	`,
 	"insertOrder": `
-		INSERT INTO orders (order_uid, track_number, entry, locale, internal_signature, customer_id, delivery_service, shardkey, sm_id, date_created, oof_shard, delivery_id, payment_id)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+		INSERT INTO orders (` + columnList("", orderRowColumns) + `)
+		VALUES (` + placeholders(1, len(orderRowColumns)) + `)
 		ON CONFLICT (order_uid)
-		DO NOTHING;
+		DO NOTHING
+		RETURNING version, updated_at, true;
+	`,
+	"upsertOrder": `
+		INSERT INTO orders (` + columnList("", orderRowColumns) + `)
+		VALUES (` + placeholders(1, len(orderRowColumns)) + `)
+		ON CONFLICT (order_uid)
+		DO UPDATE SET
+			` + excludedSet(orderRowColumns, "order_uid") + `,
+			version = orders.version + 1,
+			updated_at = now()
+		WHERE orders.date_created <= EXCLUDED.date_created
+		AND orders.content_hash IS DISTINCT FROM EXCLUDED.content_hash
+		RETURNING version, updated_at, (xmax = 0);
+	`,
+	"deleteOrderLines": `
+		DELETE FROM order_lines
+		WHERE order_uid = ANY($1);
+	`,
+	"insertOrderLine": `
+		INSERT INTO order_lines (order_uid, line_no, quantity, ` + columnList("", itemColumns) + `)
+		VALUES (` + placeholders(1, 3+len(itemColumns)) + `);
 	`,
-	"insertOrderItems": `
-		INSERT INTO order_items (order_uid, item_id)
-		VALUES ($1, $2)
-		ON CONFLICT ON CONSTRAINT unique_order_items
+	"insertRevision": `
+		INSERT INTO order_revisions (order_uid, version, payload, source_topic, source_partition, source_offset)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT ON CONSTRAINT unique_revision_source
 		DO NOTHING;
 	`,
-	"getLastLimitOrders": `
-		SELECT *
-		FROM (
-			SELECT *
+	"getOrderRevisions": `
+		SELECT id, order_uid, version, payload, source_topic, source_partition, source_offset, received_at
+		FROM order_revisions
+		WHERE order_uid = $1
+		ORDER BY id;
+	`,
+	"deleteOrder": `
+		DELETE FROM orders
+		WHERE order_uid = $1
+		RETURNING delivery_id, payment_id;
+	`,
+	"lockOrderDelivery": `
+		SELECT delivery_id
+		FROM orders
+		WHERE order_uid = $1
+		FOR UPDATE;
+	`,
+	"setOrderDelivery": `
+		UPDATE orders
+		SET delivery_id = $2, updated_at = now()
+		WHERE order_uid = $1;
+	`,
+	"anonymizeRevisions": `
+		UPDATE order_revisions
+		SET payload = jsonb_set(payload, '{delivery}', $2::jsonb)
+		WHERE order_uid = $1;
+	`,
+	"lockDeliveries": `
+		SELECT id
+		FROM deliveries
+		WHERE id = ANY($1)
+		ORDER BY id
+		FOR UPDATE;
+	`,
+	"lockPayments": `
+		SELECT id
+		FROM payments
+		WHERE id = ANY($1)
+		ORDER BY id
+		FOR UPDATE;
+	`,
+	"deleteOrphanDeliveries": `
+		DELETE FROM deliveries d
+		WHERE d.id = ANY($1)
+		AND NOT EXISTS (SELECT 1 FROM orders o WHERE o.delivery_id = d.id);
+	`,
+	"deleteOrphanPayments": `
+		DELETE FROM payments p
+		WHERE p.id = ANY($1)
+		AND NOT EXISTS (SELECT 1 FROM orders o WHERE o.payment_id = p.id);
+	`,
+	"getExistingOrderUIDs": `
+		SELECT order_uid
+		FROM orders
+		WHERE order_uid = ANY($1);
+	`,
+	"lockOrderRefs": `
+		SELECT delivery_id, payment_id
+		FROM orders
+		WHERE order_uid = ANY($1)
+		ORDER BY order_uid
+		FOR UPDATE;
+	`,
+	"getLastLimitOrders": selectOrders + `
+		WHERE o.order_uid IN (
+			SELECT order_uid
 			FROM orders
 			ORDER BY date_created DESC
 			LIMIT $1
-		) AS subquery
-		ORDER BY date_created ASC;
+		)
+		ORDER BY o.date_created ASC;
+	`,
+	"listOrders": selectOrders + `
+		ORDER BY o.date_created DESC, o.order_uid
+		LIMIT $1 OFFSET $2
 	`,
 	"getDelivery": `
-		SELECT name, phone, zip, city, address, region, email
+		SELECT ` + columnList("", deliveryColumns) + `
 		FROM deliveries
 		WHERE id = $1
 	`,
-	"getPayment": `
-		SELECT transaction, request_id, currency, provider, amount, payment_dt, bank, delivery_cost, goods_total, custom_fee
-		FROM payments
-		WHERE id = $1
+	"getTableColumns": `
+		SELECT column_name
+		FROM information_schema.columns
+		WHERE table_schema = current_schema() AND table_name = $1;
 	`,
-	"getOrderItems": `
-		SELECT i.chrt_id, i.track_number, i.price, i.rid, i.name, i.sale, i.size, i.total_price, i.nm_id, i.brand, i.status
-		FROM items i
-		JOIN order_items oi ON i.id = oi.item_id
-		JOIN orders o ON oi.order_uid = o.order_uid
-		WHERE o.order_uid = $1;
+	"getOrderByUID": selectOrders + `
+		WHERE o.order_uid = $1
 	`,
-	"getOrderByUID": `
-		SELECT *
-		FROM orders
-		WHERE order_uid = $1
+	// The secondary lookups use idx_orders_track_number, unique_payments (led by transaction)
+	// with idx_orders_payment_id, and idx_orders_customer_id.
+	"getOrderByTrackNumber": selectOrders + `
+		WHERE o.track_number = $1
+		ORDER BY o.date_created DESC, o.order_uid DESC
+		LIMIT 1
+	`,
+	"getOrderByTransaction": selectOrders + `
+		WHERE p.transaction = $1
+		ORDER BY o.date_created DESC, o.order_uid DESC
+		LIMIT 1
+	`,
+	// searchOrders ranks the orders matching the query with the GIN index of their search vectors,
+	// then highlights the words matched in the best of them.
+	"searchOrders": `
+	WITH matches AS (
+		SELECT o.order_uid, ts_rank(o.search_vector, q.query) AS rank, q.query
+		FROM orders o, websearch_to_tsquery('simple', $1) AS q(query)
+		WHERE o.search_vector @@ q.query
+		ORDER BY rank DESC, o.date_created DESC, o.order_uid DESC
+		LIMIT $2
+	)
+	SELECT ` + orderSelectList + `,
+		m.rank, ts_headline('simple', order_search_text(o.order_uid, o.delivery_id), m.query, $3)` + orderSources + `
+	JOIN matches m ON m.order_uid = o.order_uid
+	ORDER BY m.rank DESC, o.date_created DESC, o.order_uid DESC
+	`,
+	"getCustomerOrders": selectOrders + `
+		WHERE o.customer_id = $1
+		ORDER BY o.date_created DESC, o.order_uid DESC
 	`,
 }
 
 // Storage holds the database connection pool for interacting with the PostgreSQL database.
 type Storage struct {
-	pool *pgxpool.Pool
+	pool   *pgxpool.Pool
+	upsert bool
 }
 
 // getPsqlConStr generates a PostgreSQL connection string
@@ -105,12 +240,41 @@ func getPsqlConStr(db config.DataBase) string {
 }
 
 // New creates a new Storage instance, establishing a connection pool to the database
-// and checking the connection by pinging the database.
+// and checking the connection by pinging the database. It fails if the mappings of the models
+// and the schema have drifted apart (see CheckMappings and CheckSchema).
 func New(ctx context.Context, dbCfg config.DataBase) (*Storage, error) {
 	const fn = "New"
-	dsn := getPsqlConStr(dbCfg)
 
-	pool, err := pgxpool.Connect(ctx, dsn)
+	switch dbCfg.WriteMode {
+	case config.WriteModeInsert, config.WriteModeUpsert:
+	default:
+		return nil, fmt.Errorf("(%s) | unknown write mode: %q", fn, dbCfg.WriteMode)
+	}
+
+	if err := CheckMappings(); err != nil {
+		return nil, fmt.Errorf("(%s) | %w", fn, err)
+	}
+
+	pool, err := Connect(ctx, dbCfg)
+	if err != nil {
+		return nil, fmt.Errorf("(%s) | %w", fn, err)
+	}
+
+	storage := &Storage{pool: pool, upsert: dbCfg.WriteMode == config.WriteModeUpsert}
+	if err := storage.CheckSchema(ctx); err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("(%s) | %w", fn, err)
+	}
+
+	log.Println("Connected to DB (using pool) successfully!")
+	return storage, nil
+}
+
+// Connect establishes a connection pool to the database and checks it by pinging the database.
+func Connect(ctx context.Context, dbCfg config.DataBase) (*pgxpool.Pool, error) {
+	const fn = "Connect"
+
+	pool, err := pgxpool.Connect(ctx, getPsqlConStr(dbCfg))
 	if err != nil {
 		return nil, fmt.Errorf("(%s) | failed to create connection pool: %w", fn, err)
 	}
@@ -119,9 +283,7 @@ func New(ctx context.Context, dbCfg config.DataBase) (*Storage, error) {
 		pool.Close()
 		return nil, fmt.Errorf("(%s) | failed to ping database: %w", fn, err)
 	}
-
-	log.Println("Connected to DB (using pool) successfully!")
-	return &Storage{pool: pool}, nil
+	return pool, nil
 }
 
 // Close closes the database connection pool if it's open, logging the closure.
@@ -132,370 +294,930 @@ func (s *Storage) Close() {
 	}
 }
 
-// extractStructFields extracts the fields of a struct as interfaces,
-// optionally returning their addresses,
-// skipping fields without a "db" tag or with a "-" tag,
-// and ensuring the struct is a valid pointer or value.
-func extractStructFields(entity interface{}, returnAddresses bool) ([]interface{}, error) {
-	const fn = "extractStructFields"
+/*
+	DB Saving Functions
+*/
+
+// querier is the subset of query methods shared by the connection pool and a transaction.
+type querier interface {
+	Exec(ctx context.Context, sql string, arguments ...interface{}) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+}
+
+// saveEntityRow inserts a new row of the entity into the database with the query arguments
+// (the mapped fields of the entity) and returns the value of the generated field (e.g., ID).
+func saveEntityRow(ctx context.Context, q querier, query, entityType string, values []interface{}) (interface{}, error) {
+	const fn = "saveEntityRow"
+
+	var returnField interface{}
+
+	err := q.QueryRow(ctx, query, values...).Scan(&returnField)
+	if err != nil {
+		return nil, fmt.Errorf("(%s) | failed to insert %s: %w", fn, entityType, err)
+	}
 
-	val := reflect.ValueOf(entity)
+	log.Printf("(%s) | %s saved with ID: %d\n", fn, entityType, returnField)
+	return returnField, nil
+}
+
+// orderLine is a run of identical consecutive items of an order, stored as one row of order_lines.
+type orderLine struct {
+	item     models.Item
+	quantity int
+}
 
-	if returnAddresses {
-		if val.Kind() != reflect.Ptr || val.IsNil() {
-			return nil, fmt.Errorf("(%s) | entity must be a non-nil pointer to a struct", fn)
+// orderLines groups the consecutive identical items into lines, keeping their order.
+func orderLines(items []models.Item) []orderLine {
+	var lines []orderLine
+	for _, item := range items {
+		if n := len(lines); n > 0 && lines[n-1].item == item {
+			lines[n-1].quantity++
+			continue
 		}
-		val = val.Elem()
-	} else {
-		if val.Kind() == reflect.Ptr {
-			val = val.Elem()
+		lines = append(lines, orderLine{item: item, quantity: 1})
+	}
+	return lines
+}
+
+// orderLineValues returns the arguments of the insertOrderLine query for the line_no-th (1-based) line of the order.
+func orderLineValues(orderUID string, lineNo int, line orderLine) []interface{} {
+	return append([]interface{}{orderUID, lineNo, line.quantity}, itemArgs(&line.item)...)
+}
+
+// saveItems saves the items of the order as its lines, numbered in the order they were received.
+func saveItems(ctx context.Context, q querier, orderUID string, items []models.Item) error {
+	const fn = "saveItems"
+
+	if len(items) == 0 {
+		log.Printf("(%s) | No items to save!\n", fn)
+		return nil
+	}
+
+	for i, line := range orderLines(items) {
+		if _, err := q.Exec(ctx, queries["insertOrderLine"], orderLineValues(orderUID, i+1, line)...); err != nil {
+			return fmt.Errorf("(%s) | failed to save order line: %w", fn, err)
 		}
 	}
 
-	if val.Kind() != reflect.Struct {
-		return nil, fmt.Errorf("(%s) | entity is not a struct or pointer to struct", fn)
+	log.Printf("(%s) | Items saved successfully!\n", fn)
+	return nil
+}
+
+// saveOrderTx writes the order with its delivery, payment and items using the given transaction
+// and fills in the stored version and update time of the order.
+//
+// In insert mode the order row is inserted with "ON CONFLICT DO NOTHING".
+// In upsert mode an existing row is replaced if the incoming order is not older than the stored one,
+// its version is incremented, its lines are replaced and its previous delivery and payment are deleted
+// unless another order shares them.
+// If the order is not written, false is returned and the caller must roll the transaction back.
+func saveOrderTx(ctx context.Context, tx pgx.Tx, order *models.Order, upsert bool) (bool, error) {
+	const fn = "saveOrderTx"
+
+	deliveryID, err := saveEntityRow(ctx, tx, queries["insertDelivery"], "Delivery", deliveryArgs(&order.Delivery))
+	if err != nil {
+		return false, fmt.Errorf("(%s) | failed to save Delivery: %w", fn, err)
+	}
+	paymentID, err := saveEntityRow(ctx, tx, queries["insertPayment"], "Payment", paymentArgs(&order.Payment))
+	if err != nil {
+		return false, fmt.Errorf("(%s) | failed to save Payment: %w", fn, err)
+	}
+
+	var oldDeliveries, oldPayments []int32
+	if upsert {
+		if oldDeliveries, oldPayments, err = lockOrderRefs(ctx, tx, []string{order.OrderUID}); err != nil {
+			return false, fmt.Errorf("(%s) | %w", fn, err)
+		}
 	}
 
-	var fields []interface{}
-	typ := val.Type()
+	var inserted bool
+	values, err := orderValues(order, deliveryID, paymentID)
+	if err != nil {
+		return false, fmt.Errorf("(%s) | %w", fn, err)
+	}
+	err = tx.QueryRow(ctx, orderWriteQuery(upsert), values...).Scan(&order.Version, &order.UpdatedAt, &inserted)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("(%s) | failed to write order: %w", fn, err)
+	}
 
-	for i := 0; i < typ.NumField(); i++ {
-		field := val.Field(i)
-		fieldType := typ.Field(i)
-		dbTag := fieldType.Tag.Get("db")
-		if dbTag == "" || dbTag == "-" {
-			continue
+	if !inserted {
+		if _, err := tx.Exec(ctx, queries["deleteOrderLines"], []string{order.OrderUID}); err != nil {
+			return false, fmt.Errorf("(%s) | failed to delete previous lines: %w", fn, err)
 		}
-		if returnAddresses {
-			if field.CanSet() {
-				fields = append(fields, field.Addr().Interface())
-			}
-		} else {
-			if field.CanInterface() {
-				fields = append(fields, field.Interface())
+		if len(oldDeliveries) > 0 {
+			if _, err := deleteOrphans(ctx, tx, oldDeliveries, oldPayments); err != nil {
+				return false, fmt.Errorf("(%s) | %w", fn, err)
 			}
 		}
 	}
 
-	return fields, nil
+	if err := saveItems(ctx, tx, order.OrderUID, order.Items); err != nil {
+		return false, fmt.Errorf("(%s) | failed to call saveItems: %w", fn, err)
+	}
+	return true, nil
 }
 
-/*
-	DB Saving Functions
-*/
+// orderWriteQuery returns the query that writes an order row in the configured mode.
+func orderWriteQuery(upsert bool) string {
+	if upsert {
+		return queries["upsertOrder"]
+	}
+	return queries["insertOrder"]
+}
 
-// saveEntityRow inserts a new row into the database based on the provided entity,
-// extracting the struct fields, and returns the value of the generated field (e.g., ID).
-func (s *Storage) saveEntityRow(ctx context.Context, query string, entity interface{}) (interface{}, error) {
-	const fn = "saveEntityRow"
+// orderValues returns the arguments of the order write queries, in the order of orderRowColumns.
+func orderValues(order *models.Order, deliveryID, paymentID interface{}) ([]interface{}, error) {
+	const fn = "orderValues"
 
-	values, err := extractStructFields(entity, false)
+	var source interface{} // SQL NULL rather than a JSON null
+	if order.Source != nil {
+		source = order.Source
+	}
+	hash, err := contentHash(*order)
 	if err != nil {
-		return nil, fmt.Errorf("(%s) | failed to extract struct values: %w", fn, err)
+		return nil, fmt.Errorf("(%s) | %w", fn, err)
 	}
+	return append(orderArgs(order), deliveryID, paymentID, orderChecks(*order), source, hash), nil
+}
 
-	entityType := reflect.TypeOf(entity).Name()
-	var returnField interface{}
+// SaveOrder saves the order along with its associated delivery, payment, and items
+// in a single transaction and fills in the stored version and update time of the order.
+// Every received revision is recorded in the order's audit trail, whether it is applied or not.
+// It returns false if nothing has been written: the order already exists (insert mode)
+// or a newer revision of it, or one with the same content, is stored (upsert mode).
+func (s *Storage) SaveOrder(ctx context.Context, order *models.Order) (bool, error) {
+	const fn = "SaveOrder"
 
-	err = s.pool.QueryRow(ctx, query, values...).Scan(&returnField)
+	tx, err := s.pool.Begin(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("(%s) | failed to insert %s: %w", fn, entityType, err)
+		return false, fmt.Errorf("(%s) | failed to begin transaction: %w", fn, err)
 	}
+	defer tx.Rollback(ctx) // no-op after a successful commit
 
-	log.Printf("(%s) | %s saved with ID: %d\n", fn, entityType, returnField)
-	return returnField, nil
+	written, err := saveOrderTx(ctx, tx, order, s.upsert)
+	if err != nil {
+		return false, fmt.Errorf("(%s) | %w", fn, err)
+	}
+	if !written {
+		log.Printf("(%s) | Order with UID %s already exists!\n", fn, order.OrderUID)
+		tx.Rollback(ctx)
+		if err := saveRevision(ctx, s.pool, *order, nil); err != nil {
+			return false, fmt.Errorf("(%s) | %w", fn, err)
+		}
+		return false, nil
+	}
+
+	if err := saveRevision(ctx, tx, *order, &order.Version); err != nil {
+		return false, fmt.Errorf("(%s) | %w", fn, err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return false, fmt.Errorf("(%s) | failed to commit transaction: %w", fn, err)
+	}
+
+	log.Printf("(%s) | Order saved with ID: %s (version %d)\n", fn, order.OrderUID, order.Version)
+	return true, nil
 }
 
-// saveDeliveryAndPayment concurrently saves the delivery and payment entities to the database,
-// returning the generated IDs for both entities after successful insertion.
-func (s *Storage) saveDeliveryAndPayment(ctx context.Context, delivery models.Delivery, payment models.Payment) ([]interface{}, error) {
-	const fn = "saveDeliveryAndPayment"
+// SaveOrders saves the orders along with their deliveries, payments and items in a single transaction.
+// The statements of each step are sent as one pgx batch, so a whole set of orders costs
+// a handful of round trips instead of several per order.
+// It returns the orders that have been written, with their stored version and update time.
+// Orders that are not written follow the rules of SaveOrder.
+func (s *Storage) SaveOrders(ctx context.Context, orders []models.Order) ([]models.Order, error) {
+	const fn = "SaveOrders"
 
-	var deliveryID, paymentID interface{}
-	g, gCtx := errgroup.WithContext(ctx)
-	defer gCtx.Done()
+	if len(orders) == 0 {
+		return nil, nil
+	}
 
-	g.Go(func() error {
-		var err error
-		deliveryID, err = s.saveEntityRow(gCtx, queries["insertDelivery"], delivery)
-		if err != nil {
-			return fmt.Errorf("(%s) | failed to save Delivery: %w", fn, err)
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("(%s) | failed to begin transaction: %w", fn, err)
+	}
+	defer tx.Rollback(ctx) // no-op after a successful commit
+
+	pendingIdx, err := pendingOrders(ctx, tx, orders, s.upsert)
+	if err != nil {
+		return nil, fmt.Errorf("(%s) | %w", fn, err)
+	}
+	pending := make([]models.Order, 0, len(pendingIdx))
+	for _, i := range pendingIdx {
+		pending = append(pending, orders[i])
+	}
+
+	// Deliveries and payments
+	batch := &pgx.Batch{}
+	for _, order := range pending {
+		batch.Queue(queries["insertDelivery"], deliveryArgs(&order.Delivery)...)
+		batch.Queue(queries["insertPayment"], paymentArgs(&order.Payment)...)
+	}
+	refIDs := make([]int32, 2*len(pending))
+	err = sendBatch(ctx, tx, batch, func(br pgx.BatchResults) error {
+		for i := range refIDs {
+			if err := br.QueryRow().Scan(&refIDs[i]); err != nil {
+				return fmt.Errorf("failed to insert delivery/payment: %w", err)
+			}
 		}
 		return nil
 	})
+	if err != nil {
+		return nil, fmt.Errorf("(%s) | %w", fn, err)
+	}
+
+	// Deliveries and payments of the stored orders, replaced along with them in upsert mode
+	var unusedDeliveries, unusedPayments []int32
+	if s.upsert {
+		uids := make([]string, 0, len(pending))
+		for _, order := range pending {
+			uids = append(uids, order.OrderUID)
+		}
+		if unusedDeliveries, unusedPayments, err = lockOrderRefs(ctx, tx, uids); err != nil {
+			return nil, fmt.Errorf("(%s) | %w", fn, err)
+		}
+	}
 
-	g.Go(func() error {
-		var err error
-		paymentID, err = s.saveEntityRow(gCtx, queries["insertPayment"], payment)
+	// Orders
+	batch = &pgx.Batch{}
+	for i, order := range pending {
+		values, err := orderValues(&order, refIDs[2*i], refIDs[2*i+1])
 		if err != nil {
-			return fmt.Errorf("(%s) | failed to save Payment: %w", fn, err)
+			return nil, fmt.Errorf("(%s) | %w", fn, err)
+		}
+		batch.Queue(orderWriteQuery(s.upsert), values...)
+	}
+	written := make([]models.Order, 0, len(pending))
+	versions := make(map[int]*int, len(pending)) // index in orders -> stored version
+	var replaced []string
+	err = sendBatch(ctx, tx, batch, func(br pgx.BatchResults) error {
+		for i, order := range pending {
+			var inserted bool
+			err := br.QueryRow().Scan(&order.Version, &order.UpdatedAt, &inserted)
+			if errors.Is(err, pgx.ErrNoRows) {
+				// Written concurrently by another consumer, stale or unchanged
+				unusedDeliveries = append(unusedDeliveries, refIDs[2*i])
+				unusedPayments = append(unusedPayments, refIDs[2*i+1])
+				continue
+			} else if err != nil {
+				return fmt.Errorf("failed to write order %s: %w", order.OrderUID, err)
+			}
+			if !inserted {
+				replaced = append(replaced, order.OrderUID)
+			}
+			versions[pendingIdx[i]] = &order.Version
+			written = append(written, order)
 		}
 		return nil
 	})
+	if err != nil {
+		return nil, fmt.Errorf("(%s) | %w", fn, err)
+	}
 
-	if err := g.Wait(); err != nil {
-		return nil, fmt.Errorf("(%s) | failed to insert delivery and payment: %w", fn, err)
+	// Deliveries and payments replaced by the written orders or inserted for the orders that have not been written
+	// (SaveOrder rolls the latter back); the rows still referenced by an order are kept
+	if len(unusedDeliveries) > 0 {
+		if _, err := deleteOrphans(ctx, tx, unusedDeliveries, unusedPayments); err != nil {
+			return nil, fmt.Errorf("(%s) | %w", fn, err)
+		}
 	}
-	gCtx.Done()
 
-	log.Printf("(%s) | Delivery and Payment saved successfully!\n", fn)
-	return []interface{}{deliveryID, paymentID}, nil
-}
+	if len(replaced) > 0 {
+		if _, err := tx.Exec(ctx, queries["deleteOrderLines"], replaced); err != nil {
+			return nil, fmt.Errorf("(%s) | failed to delete previous lines: %w", fn, err)
+		}
+	}
 
-// saveItems concurrently saves the items for an order and associates them with the given orderUID,
-// ensuring that each item is inserted into the database and linked to the order.
-func (s *Storage) saveItems(ctx context.Context, orderUID string, items []models.Item) error {
-	const fn = "saveItems"
+	// Order lines
+	batch = &pgx.Batch{}
+	for _, order := range written {
+		for i, line := range orderLines(order.Items) {
+			batch.Queue(queries["insertOrderLine"], orderLineValues(order.OrderUID, i+1, line)...)
+		}
+	}
+	if lines := batch.Len(); lines > 0 {
+		err = sendBatch(ctx, tx, batch, func(br pgx.BatchResults) error {
+			for i := 0; i < lines; i++ {
+				if _, err := br.Exec(); err != nil {
+					return fmt.Errorf("failed to insert order line: %w", err)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("(%s) | %w", fn, err)
+		}
+	}
 
-	if len(items) == 0 {
-		log.Printf("(%s) | No items to save!\n", fn)
+	// Audit trail of every received revision
+	batch = &pgx.Batch{}
+	for i, order := range orders {
+		values, err := revisionValues(order, versions[i])
+		if err != nil {
+			return nil, fmt.Errorf("(%s) | %w", fn, err)
+		}
+		batch.Queue(queries["insertRevision"], values...)
+	}
+	err = sendBatch(ctx, tx, batch, func(br pgx.BatchResults) error {
+		for range orders {
+			if _, err := br.Exec(); err != nil {
+				return fmt.Errorf("failed to insert revision: %w", err)
+			}
+		}
 		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("(%s) | %w", fn, err)
 	}
 
-	g, gCtx := errgroup.WithContext(ctx)
-	defer gCtx.Done()
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("(%s) | failed to commit transaction: %w", fn, err)
+	}
 
-	for _, item := range items {
-		g.Go(func() error {
-			itemID, err := s.saveEntityRow(gCtx, queries["insertItem"], item)
-			if err != nil {
-				return fmt.Errorf("(%s) | failed to save Item: %w", fn, err)
-			}
-			if _, err := s.pool.Exec(gCtx, queries["insertOrderItems"], orderUID, itemID); err != nil {
-				return fmt.Errorf("(%s) | failed to insert OrderUID_ItemsID: %w", fn, err)
+	log.Printf("(%s) | %d of %d orders saved!\n", fn, len(written), len(orders))
+	return written, nil
+}
+
+// pendingOrders returns the indices of the orders that have to be written.
+// In insert mode the orders that are already stored are skipped, and the first of repeated UIDs is kept.
+// In upsert mode the latest of repeated UIDs (by date_created) is kept.
+func pendingOrders(ctx context.Context, q querier, orders []models.Order, upsert bool) ([]int, error) {
+	const fn = "pendingOrders"
+
+	if upsert {
+		latest := make(map[string]int, len(orders))
+		pending := make([]int, 0, len(orders))
+		for i, order := range orders {
+			if j, ok := latest[order.OrderUID]; ok {
+				if !order.DateCreated.Before(orders[pending[j]].DateCreated) {
+					pending[j] = i
+				}
+				continue
 			}
-			return nil
-		})
+			latest[order.OrderUID] = len(pending)
+			pending = append(pending, i)
+		}
+		return pending, nil
 	}
 
-	if err := g.Wait(); err != nil {
-		return fmt.Errorf("(%s) | failed to insert items: %w", fn, err)
+	uids := make([]string, 0, len(orders))
+	for _, order := range orders {
+		uids = append(uids, order.OrderUID)
 	}
-	gCtx.Done()
 
-	log.Printf("(%s) | Items saved successfully!\n", fn)
-	return nil
-}
+	rows, err := q.Query(ctx, queries["getExistingOrderUIDs"], uids)
+	if err != nil {
+		return nil, fmt.Errorf("(%s) | failed to check existing orders: %w", fn, err)
+	}
+	defer rows.Close()
 
-// SaveOrder checks if an order with the given UID already exists, and if not,
-// saves the order along with its associated delivery, payment, and items to the database.
-func (s *Storage) SaveOrder(ctx context.Context, order models.Order) error {
-	const fn = "SaveOrder"
+	seen := make(map[string]bool, len(orders))
+	for rows.Next() {
+		var uid string
+		if err := rows.Scan(&uid); err != nil {
+			return nil, fmt.Errorf("(%s) | failed to scan row: %w", fn, err)
+		}
+		seen[uid] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("(%s) | failed to read existing orders: %w", fn, err)
+	}
 
-	if exist, err := s.checkOrderExists(ctx, order.OrderUID); err != nil {
-		return fmt.Errorf("(%s) | failed to check order by UID: %w", fn, err)
-	} else if exist {
-		return nil
+	pending := make([]int, 0, len(orders))
+	for i, order := range orders {
+		if seen[order.OrderUID] {
+			continue
+		}
+		seen[order.OrderUID] = true
+		pending = append(pending, i)
 	}
+	return pending, nil
+}
+
+// saveRevision records the received order in its audit trail.
+// version is the version the order has been stored as, or nil if it has not been applied.
+// A revision received from the same broker record is recorded only once.
+func saveRevision(ctx context.Context, q querier, order models.Order, version *int) error {
+	const fn = "saveRevision"
 
-	delAndPayIDs, err := s.saveDeliveryAndPayment(ctx, order.Delivery, order.Payment)
+	values, err := revisionValues(order, version)
 	if err != nil {
-		return fmt.Errorf("(%s) | failed to call saveDeliveryAndPayment: %w", fn, err)
+		return fmt.Errorf("(%s) | %w", fn, err)
+	}
+	if _, err := q.Exec(ctx, queries["insertRevision"], values...); err != nil {
+		return fmt.Errorf("(%s) | failed to insert revision: %w", fn, err)
 	}
+	return nil
+}
 
-	values, err := extractStructFields(order, false)
+// revisionValues returns the arguments of the insertRevision query.
+// The payload is the JSON of the order without the fields maintained by the storage.
+func revisionValues(order models.Order, version *int) ([]interface{}, error) {
+	const fn = "revisionValues"
+
+	raw, err := json.Marshal(order)
 	if err != nil {
-		return fmt.Errorf("(%s) | failed to extract: %w", fn, err)
+		return nil, fmt.Errorf("(%s) | failed to marshal order: %w", fn, err)
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil, fmt.Errorf("(%s) | failed to unmarshal order: %w", fn, err)
 	}
-	values = append(values, delAndPayIDs...)
-	if _, err := s.pool.Exec(ctx, queries["insertOrder"], values...); err != nil {
-		return fmt.Errorf("(%s) | failed to insert order: %w", fn, err)
+	delete(fields, "version")
+	delete(fields, "updated_at")
+	delete(fields, "source")
+	payload, err := json.Marshal(fields)
+	if err != nil {
+		return nil, fmt.Errorf("(%s) | failed to marshal payload: %w", fn, err)
 	}
 
-	if err := s.saveItems(ctx, order.OrderUID, order.Items); err != nil {
-		return fmt.Errorf("(%s) | failed to call saveItems: %w", fn, err)
+	var (
+		topic     *string
+		partition *int32
+		offset    *int64
+	)
+	if order.Source != nil {
+		topic, partition, offset = &order.Source.Topic, &order.Source.Partition, &order.Source.Offset
 	}
+	return []interface{}{order.OrderUID, version, payload, topic, partition, offset}, nil
+}
 
-	log.Printf("(%s) | Order saved with ID: %s\n", fn, order.OrderUID)
-	return nil
+// contentHash returns the hex SHA-256 of the audit trail payload of the order,
+// so revisions that differ only in the fields maintained by the storage have the same hash.
+func contentHash(order models.Order) (string, error) {
+	const fn = "contentHash"
+
+	values, err := revisionValues(order, nil)
+	if err != nil {
+		return "", fmt.Errorf("(%s) | %w", fn, err)
+	}
+	sum := sha256.Sum256(values[2].([]byte))
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// sendBatch sends the batch within the transaction, lets read consume the results and closes them.
+func sendBatch(ctx context.Context, tx pgx.Tx, batch *pgx.Batch, read func(br pgx.BatchResults) error) error {
+	br := tx.SendBatch(ctx, batch)
+	if err := read(br); err != nil {
+		br.Close()
+		return err
+	}
+	return br.Close()
 }
 
-// checkOrderExists checks if an order with the given orderUID already exists in the database
-// and returns true if it does, or false if it does not, along with any potential errors.
-func (s *Storage) checkOrderExists(ctx context.Context, orderUID string) (bool, error) {
-	var existing string
-	err := s.pool.QueryRow(ctx, queries["checkOrderByUID"], orderUID).Scan(&existing)
-	if err != nil && err.Error() != "no rows in result set" {
-		return true, err
-	} else if existing != "" {
-		log.Printf("Order with UID %s already exists!\n", orderUID)
-		return true, nil
+// orderChecks returns the business-rule check results of the order for the JSONB "checks" column,
+// which must not be NULL.
+func orderChecks(order models.Order) []models.Check {
+	if order.Checks == nil {
+		return []models.Check{}
 	}
-	return false, nil
+	return order.Checks
 }
 
 /*
 	DB Getting Functions
 */
 
-// getEntityRow executes a query to fetch a single row from the database,
-// scans the result into the provided entity, and logs the success or failure of the operation.
-func (s *Storage) getEntityRow(ctx context.Context, query string, queryValues []interface{}, entity interface{}) error {
-	const fn = "getEntityRow"
+// scanOrder scans a row selected by selectOrders into a complete order.
+// The columns selected after those of selectOrders are scanned into extra.
+func scanOrder(row pgx.Row, extra ...interface{}) (models.Order, error) {
+	const fn = "scanOrder"
+
+	var (
+		order models.Order
+		items []byte
+	)
+	scanArgs := append(orderDest(&order), &order.Checks, &order.Version, &order.UpdatedAt, &order.Source)
+	scanArgs = append(scanArgs, deliveryDest(&order.Delivery)...)
+	scanArgs = append(scanArgs, paymentDest(&order.Payment)...)
+	scanArgs = append(scanArgs, &items)
+	scanArgs = append(scanArgs, extra...)
+
+	if err := row.Scan(scanArgs...); err != nil {
+		return models.Order{}, err
+	}
+	if items != nil {
+		if err := json.Unmarshal(items, &order.Items); err != nil {
+			return models.Order{}, fmt.Errorf("(%s) | failed to unmarshal items: %w", fn, err)
+		}
+	}
+	return order, nil
+}
 
-	scanArgs, err := extractStructFields(entity, true)
+// GetLastLimitOrders retrieves the last 'limit' complete orders from the database
+// in a single query and returns them oldest first.
+func (s *Storage) GetLastLimitOrders(ctx context.Context, limit int) ([]models.Order, error) {
+	const fn = "GetLastLimitOrders"
+
+	orders, err := s.queryOrders(ctx, queries["getLastLimitOrders"], limit)
 	if err != nil {
-		return fmt.Errorf("(%s) | failed to extract scan args: %w", fn, err)
+		return nil, fmt.Errorf("(%s) | %w", fn, err)
 	}
 
-	entityType := reflect.TypeOf(entity)
+	log.Printf("(%s) | %d orders found!", fn, len(orders))
+	return orders, nil
+}
 
-	err = s.pool.QueryRow(ctx, query, queryValues...).Scan(scanArgs...)
+// ListOrders retrieves up to 'limit' orders starting at 'offset', newest (by date_created) first.
+func (s *Storage) ListOrders(ctx context.Context, offset, limit int) ([]models.Order, error) {
+	const fn = "ListOrders"
+
+	orders, err := s.queryOrders(ctx, queries["listOrders"], limit, offset)
 	if err != nil {
-		return fmt.Errorf("(%s) | failed to scan %s: %w", fn, entityType, err)
+		return nil, fmt.Errorf("(%s) | %w", fn, err)
 	}
-
-	log.Printf("(%s) | %s found!\n", fn, entityType)
-	return nil
+	return orders, nil
 }
 
-// getOrderItems retrieves all items for a given orderUID from the database,
-// scans the results concurrently, and returns the list of items associated with the order.
-func (s *Storage) getOrderItems(ctx context.Context, orderUID string) ([]models.Item, error) {
-	const fn = "getOrderItems"
+// sortColumns are the columns the orders are sorted by for the listing sort fields.
+var sortColumns = map[string]string{
+	listing.SortDateCreated: "o.date_created",
+	listing.SortUpdatedAt:   "o.updated_at",
+	listing.SortAmount:      "p.amount",
+}
 
-	var items []models.Item
+// FindOrders returns a page of the orders matching the filter of the query, in its sort order.
+// The page is read with one keyset query (see listing).
+func (s *Storage) FindOrders(ctx context.Context, q listing.Query) (listing.Page, error) {
+	const fn = "FindOrders"
 
-	rows, err := s.pool.Query(ctx, queries["getOrderItems"], orderUID)
+	query, args := findOrdersQuery(q)
+	orders, err := s.queryOrders(ctx, query, args...)
 	if err != nil {
-		return nil, fmt.Errorf("(%s) | failed to get order items: %w", fn, err)
+		return listing.Page{}, fmt.Errorf("(%s) | %w", fn, err)
 	}
-	defer rows.Close()
+	page, err := listing.NewPage(q, orders)
+	if err != nil {
+		return listing.Page{}, fmt.Errorf("(%s) | %w", fn, err)
+	}
+	return page, nil
+}
 
-	g, gCtx := errgroup.WithContext(ctx)
-	defer gCtx.Done()
+// findOrdersQuery builds the query of FindOrders and its arguments.
+// It selects one order more than the limit to tell whether there is a next page.
+func findOrdersQuery(q listing.Query) (string, []interface{}) {
+	var (
+		conds []string
+		args  []interface{}
+	)
+	where := func(cond string, arg interface{}) {
+		args = append(args, arg)
+		conds = append(conds, fmt.Sprintf(cond, len(args)))
+	}
 
-	for rows.Next() {
-		g.Go(func() error {
-			var item models.Item
-			scanArgs, err := extractStructFields(&item, true)
-			if err != nil {
-				return fmt.Errorf("(%s) | failed to extract: %w", fn, err)
-			}
-			if err := rows.Scan(scanArgs...); err != nil {
-				return fmt.Errorf("(%s) | failed to scan row: %w", fn, err)
-			}
-			items = append(items, item)
-			return nil
-		})
+	f := q.Filter
+	for _, eq := range []struct{ column, value string }{
+		{"o.customer_id", f.CustomerID},
+		{"o.track_number", f.TrackNumber},
+		{"o.delivery_service", f.DeliveryService},
+		{"o.entry", f.Entry},
+		{"o.locale", f.Locale},
+		{"p.provider", f.Provider},
+		{"p.currency", f.Currency},
+	} {
+		if eq.value != "" {
+			where(eq.column+" = $%d", eq.value)
+		}
+	}
+	if !f.CreatedFrom.IsZero() {
+		where("o.date_created >= $%d", f.CreatedFrom)
+	}
+	if !f.CreatedTo.IsZero() {
+		where("o.date_created < $%d", f.CreatedTo)
+	}
+	if f.AmountMin != nil {
+		where("p.amount >= $%d", *f.AmountMin)
+	}
+	if f.AmountMax != nil {
+		where("p.amount <= $%d", *f.AmountMax)
 	}
-	rows.Close()
 
-	if err := g.Wait(); err != nil {
-		return nil, fmt.Errorf("(%s) | failed to get items: %w", fn, err)
+	key, ok := sortColumns[q.Sort]
+	if !ok {
+		key = sortColumns[listing.SortDateCreated]
+	}
+	dir, op := "ASC", ">"
+	if q.Desc {
+		dir, op = "DESC", "<"
+	}
+	if q.After != nil {
+		var value interface{} = q.After.Time
+		if q.Sort == listing.SortAmount {
+			value = q.After.Amount
+		}
+		args = append(args, value, q.After.OrderUID)
+		conds = append(conds, fmt.Sprintf("(%s, o.order_uid) %s ($%d, $%d)", key, op, len(args)-1, len(args)))
 	}
-	gCtx.Done()
+	args = append(args, q.Limit+1)
 
-	log.Printf("(%s) | All items found by orderUID: %v", fn, orderUID)
-	return items, nil
+	var query strings.Builder
+	query.WriteString(selectOrders)
+	if len(conds) > 0 {
+		query.WriteString("\tWHERE " + strings.Join(conds, "\n\t\tAND ") + "\n")
+	}
+	fmt.Fprintf(&query, "\tORDER BY %s %s, o.order_uid %s\n\tLIMIT $%d", key, dir, dir, len(args))
+	return query.String(), args
 }
 
-// GetLastLimitOrders retrieves the last 'limit' orders from the database,
-// concurrently finalizes each order by fetching related delivery, payment, and items,
-// and returns the populated (done) orders.
-func (s *Storage) GetLastLimitOrders(ctx context.Context, limit int) ([]models.Order, error) {
-	const fn = "GetLastLimitOrders"
+// queryOrders executes a query built on selectOrders and returns the complete orders
+// in the order of the rows.
+func (s *Storage) queryOrders(ctx context.Context, query string, args ...interface{}) ([]models.Order, error) {
+	const fn = "queryOrders"
 
-	rows, err := s.pool.Query(ctx, queries["getLastLimitOrders"], limit)
+	rows, err := s.pool.Query(ctx, query, args...)
 	if err != nil {
-		return nil, fmt.Errorf("(%s) | failed to execute query getLastLimitOrders: %w", fn, err)
+		return nil, fmt.Errorf("(%s) | failed to execute query: %w", fn, err)
 	}
 	defer rows.Close()
 
-	orders := make([]models.Order, 0, limit)
-	g, gCtx := errgroup.WithContext(ctx)
-	defer gCtx.Done()
+	var orders []models.Order
 	for rows.Next() {
-		g.Go(func() error {
-			var order models.Order
-			var deliveryID, paymentID int
+		order, err := scanOrder(rows)
+		if err != nil {
+			return nil, fmt.Errorf("(%s) | failed to scan row: %w", fn, err)
+		}
+		orders = append(orders, order)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("(%s) | failed to read order rows: %w", fn, err)
+	}
+	return orders, nil
+}
 
-			scanArgs, err := extractStructFields(&order, true)
-			if err != nil {
-				return fmt.Errorf("(%s) | failed to extract: %w", fn, err)
-			}
-			scanArgs = append(scanArgs, &deliveryID, &paymentID)
-			if err := rows.Scan(scanArgs...); err != nil {
-				return fmt.Errorf("(%s) | failed to scan row: %w", fn, err)
-			}
+// GetOrderByUID retrieves the complete order (with its delivery, payment and items)
+// by its UID from the database in a single query.
+func (s *Storage) GetOrderByUID(ctx context.Context, orderUID string) (models.Order, error) {
+	const fn = "GetOrder"
 
-			if err := s.finalizeOrder(gCtx, &order, deliveryID, paymentID); err != nil {
-				return fmt.Errorf("(%s) | failed to call finalizeOrder: %w", fn, err)
-			}
+	order, err := scanOrder(s.pool.QueryRow(ctx, queries["getOrderByUID"], orderUID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return models.Order{}, fmt.Errorf("(%s) | %s: %w", fn, orderUID, ErrOrderNotFound)
+	} else if err != nil {
+		return models.Order{}, fmt.Errorf("(%s) | failed to scan row: %w", fn, err)
+	}
+	return order, nil
+}
 
-			orders = append(orders, order)
-			return nil
-		})
+// GetOrderByTrackNumber retrieves the newest complete order with the track number in a single query.
+func (s *Storage) GetOrderByTrackNumber(ctx context.Context, trackNumber string) (models.Order, error) {
+	const fn = "GetOrderByTrackNumber"
+
+	order, err := scanOrder(s.pool.QueryRow(ctx, queries["getOrderByTrackNumber"], trackNumber))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return models.Order{}, fmt.Errorf("(%s) | track number %s: %w", fn, trackNumber, ErrOrderNotFound)
+	} else if err != nil {
+		return models.Order{}, fmt.Errorf("(%s) | failed to scan row: %w", fn, err)
 	}
-	rows.Close()
+	return order, nil
+}
 
-	if err := g.Wait(); err != nil {
-		return nil, fmt.Errorf("(%s) | Failed read order rows: %w", fn, err)
+// GetOrderByTransaction retrieves the newest complete order paid by the transaction in a single query.
+func (s *Storage) GetOrderByTransaction(ctx context.Context, transaction string) (models.Order, error) {
+	const fn = "GetOrderByTransaction"
+
+	order, err := scanOrder(s.pool.QueryRow(ctx, queries["getOrderByTransaction"], transaction))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return models.Order{}, fmt.Errorf("(%s) | transaction %s: %w", fn, transaction, ErrOrderNotFound)
+	} else if err != nil {
+		return models.Order{}, fmt.Errorf("(%s) | failed to scan row: %w", fn, err)
 	}
-	gCtx.Done()
+	return order, nil
+}
 
-	log.Printf("(%s) | %d orders found!", fn, len(orders))
+// GetCustomerOrders retrieves all complete orders of the customer, newest (by date_created) first.
+func (s *Storage) GetCustomerOrders(ctx context.Context, customerID string) ([]models.Order, error) {
+	const fn = "GetCustomerOrders"
+
+	orders, err := s.queryOrders(ctx, queries["getCustomerOrders"], customerID)
+	if err != nil {
+		return nil, fmt.Errorf("(%s) | %w", fn, err)
+	}
 	return orders, nil
 }
 
-// GetOrderByUID retrieves the order by its UID from the database, scans the relevant data,
-// and finalizes the order by adding shipping, payment, and item information, then returns it.
-func (s *Storage) GetOrderByUID(ctx context.Context, orderUID string) (models.Order, error) {
-	const fn = "GetOrder"
+// headlineOptions are the ts_headline options of the search snippets.
+var headlineOptions = fmt.Sprintf("StartSel=%s, StopSel=%s, MaxWords=12, MinWords=4", search.MarkStart, search.MarkStop)
 
-	var order models.Order
-	var deliveryID, paymentID int
+// SearchOrders returns the orders whose searchable text matches the query, best ranked first,
+// with their snippets, in a single query.
+func (s *Storage) SearchOrders(ctx context.Context, q search.Query) ([]search.Result, error) {
+	const fn = "SearchOrders"
 
-	scanArgs, err := extractStructFields(&order, true)
+	rows, err := s.pool.Query(ctx, queries["searchOrders"], q.Text, q.Limit, headlineOptions)
 	if err != nil {
-		return models.Order{}, fmt.Errorf("(%s) | failed to extract: %w", fn, err)
+		return nil, fmt.Errorf("(%s) | failed to execute query: %w", fn, err)
 	}
-	scanArgs = append(scanArgs, &deliveryID, &paymentID)
-	if err := s.pool.QueryRow(ctx, queries["getOrderByUID"], orderUID).Scan(scanArgs...); err != nil {
-		return models.Order{}, fmt.Errorf("(%s) | failed to scan row: %w", fn, err)
+	defer rows.Close()
+
+	results := []search.Result{}
+	for rows.Next() {
+		var result search.Result
+		if result.Order, err = scanOrder(rows, &result.Rank, &result.Snippet); err != nil {
+			return nil, fmt.Errorf("(%s) | failed to scan row: %w", fn, err)
+		}
+		result.Snippet = search.SafeSnippet(result.Snippet)
+		results = append(results, result)
 	}
-	if err := s.finalizeOrder(ctx, &order, deliveryID, paymentID); err != nil {
-		return models.Order{}, fmt.Errorf("(%s) | failed to call finalizeOrder: %w", fn, err)
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("(%s) | failed to read order rows: %w", fn, err)
 	}
-	return order, nil
+	return results, nil
 }
 
-// finalizeOrder concurrently fetches the delivery, payment, and items for the order
-// and populates the respective fields in the order object, handling errors during the process.
-func (s *Storage) finalizeOrder(ctx context.Context, order *models.Order, dID, pID int) error {
-	const fn = "finalizeOrder"
+// Stat returns the statistics of the connection pool, e.g. to measure the connections used by a read path.
+func (s *Storage) Stat() *pgxpool.Stat {
+	return s.pool.Stat()
+}
 
-	g, gCtx := errgroup.WithContext(ctx)
-	defer gCtx.Done()
+// GetOrderHistory retrieves all received revisions of the order from its audit trail, oldest first.
+func (s *Storage) GetOrderHistory(ctx context.Context, orderUID string) ([]models.Revision, error) {
+	const fn = "GetOrderHistory"
 
-	g.Go(func() error {
-		err := s.getEntityRow(gCtx, queries["getDelivery"], []interface{}{dID}, &order.Delivery)
-		if err != nil {
-			return fmt.Errorf("(%s) | failed to get Delivery: %w", fn, err)
+	rows, err := s.pool.Query(ctx, queries["getOrderRevisions"], orderUID)
+	if err != nil {
+		return nil, fmt.Errorf("(%s) | failed to get revisions: %w", fn, err)
+	}
+	defer rows.Close()
+
+	var revisions []models.Revision
+	for rows.Next() {
+		var (
+			rev       models.Revision
+			payload   []byte
+			topic     *string
+			partition *int32
+			offset    *int64
+		)
+		if err := rows.Scan(&rev.ID, &rev.OrderUID, &rev.Version, &payload, &topic, &partition, &offset, &rev.ReceivedAt); err != nil {
+			return nil, fmt.Errorf("(%s) | failed to scan row: %w", fn, err)
 		}
-		return nil
-	})
+		rev.Payload = payload
+		if topic != nil && partition != nil && offset != nil {
+			rev.Source = &models.Source{Topic: *topic, Partition: *partition, Offset: *offset}
+		}
+		revisions = append(revisions, rev)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("(%s) | failed to read revisions: %w", fn, err)
+	}
 
-	g.Go(func() error {
-		err := s.getEntityRow(gCtx, queries["getPayment"], []interface{}{pID}, &order.Payment)
-		if err != nil {
-			return fmt.Errorf("(%s) | failed to get Payment: %w", fn, err)
+	log.Printf("(%s) | %d revisions found by orderUID: %v", fn, len(revisions), orderUID)
+	return revisions, nil
+}
+
+/*
+	DB Deleting Functions
+*/
+
+// DeleteOrder deletes the order along with its lines and revisions in a single transaction,
+// then garbage-collects its delivery and payment if no other order references them.
+// It returns false if the order does not exist.
+func (s *Storage) DeleteOrder(ctx context.Context, orderUID string) (bool, error) {
+	const fn = "DeleteOrder"
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return false, fmt.Errorf("(%s) | failed to begin transaction: %w", fn, err)
+	}
+	defer tx.Rollback(ctx) // no-op after a successful commit
+
+	var deliveryID, paymentID int32
+	err = tx.QueryRow(ctx, queries["deleteOrder"], orderUID).Scan(&deliveryID, &paymentID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("(%s) | failed to delete order: %w", fn, err)
+	}
+
+	if _, err := deleteOrphans(ctx, tx, []int32{deliveryID}, []int32{paymentID}); err != nil {
+		return false, fmt.Errorf("(%s) | %w", fn, err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return false, fmt.Errorf("(%s) | failed to commit transaction: %w", fn, err)
+	}
+
+	log.Printf("(%s) | Order deleted with ID: %s\n", fn, orderUID)
+	return true, nil
+}
+
+// AnonymizeOrder scrubs the personal data of the order's delivery (name, phone, zip, address, email)
+// in the order and in its revisions, keeping the city, the region, the payment and the items.
+// The previous delivery is garbage-collected if no other order references it.
+// It returns false if the order does not exist.
+func (s *Storage) AnonymizeOrder(ctx context.Context, orderUID string) (bool, error) {
+	const fn = "AnonymizeOrder"
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return false, fmt.Errorf("(%s) | failed to begin transaction: %w", fn, err)
+	}
+	defer tx.Rollback(ctx) // no-op after a successful commit
+
+	var deliveryID int32
+	err = tx.QueryRow(ctx, queries["lockOrderDelivery"], orderUID).Scan(&deliveryID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("(%s) | failed to lock order: %w", fn, err)
+	}
+
+	var delivery models.Delivery
+	if err := tx.QueryRow(ctx, queries["getDelivery"], deliveryID).Scan(deliveryDest(&delivery)...); err != nil {
+		return false, fmt.Errorf("(%s) | failed to get Delivery: %w", fn, err)
+	}
+
+	anonymized := anonymizeDelivery(delivery)
+	newDeliveryID, err := saveEntityRow(ctx, tx, queries["insertDelivery"], "Delivery", deliveryArgs(&anonymized))
+	if err != nil {
+		return false, fmt.Errorf("(%s) | failed to save Delivery: %w", fn, err)
+	}
+	if _, err := tx.Exec(ctx, queries["setOrderDelivery"], orderUID, newDeliveryID); err != nil {
+		return false, fmt.Errorf("(%s) | failed to update order: %w", fn, err)
+	}
+
+	payload, err := json.Marshal(anonymized)
+	if err != nil {
+		return false, fmt.Errorf("(%s) | failed to marshal Delivery: %w", fn, err)
+	}
+	if _, err := tx.Exec(ctx, queries["anonymizeRevisions"], orderUID, payload); err != nil {
+		return false, fmt.Errorf("(%s) | failed to anonymize revisions: %w", fn, err)
+	}
+
+	if _, err := deleteOrphans(ctx, tx, []int32{deliveryID}, []int32{}); err != nil {
+		return false, fmt.Errorf("(%s) | %w", fn, err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return false, fmt.Errorf("(%s) | failed to commit transaction: %w", fn, err)
+	}
+
+	log.Printf("(%s) | Order anonymized with ID: %s\n", fn, orderUID)
+	return true, nil
+}
+
+// lockOrderRefs locks the stored orders with the UIDs for update
+// and returns the IDs of their deliveries and payments (never nil).
+func lockOrderRefs(ctx context.Context, tx pgx.Tx, uids []string) ([]int32, []int32, error) {
+	const fn = "lockOrderRefs"
+
+	rows, err := tx.Query(ctx, queries["lockOrderRefs"], uids)
+	if err != nil {
+		return nil, nil, fmt.Errorf("(%s) | failed to lock stored orders: %w", fn, err)
+	}
+	defer rows.Close()
+
+	deliveryIDs, paymentIDs := make([]int32, 0, len(uids)), make([]int32, 0, len(uids))
+	for rows.Next() {
+		var deliveryID, paymentID int32
+		if err := rows.Scan(&deliveryID, &paymentID); err != nil {
+			return nil, nil, fmt.Errorf("(%s) | failed to scan row: %w", fn, err)
 		}
-		return nil
-	})
+		deliveryIDs = append(deliveryIDs, deliveryID)
+		paymentIDs = append(paymentIDs, paymentID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("(%s) | failed to read stored orders: %w", fn, err)
+	}
+	return deliveryIDs, paymentIDs, nil
+}
 
-	g.Go(func() error {
-		var err error
-		order.Items, err = s.getOrderItems(gCtx, order.OrderUID)
+// deleteOrphans deletes the deliveries and payments with the given IDs that no order references.
+// Deliveries and payments are shared by the orders with the same content, so the rows are locked first:
+// a concurrent transaction that reuses one of them (its insert locks the row) either commits before,
+// and the check of the delete statement, running with a newer snapshot, sees its order,
+// or inserts the row anew after the delete.
+func deleteOrphans(ctx context.Context, tx pgx.Tx, deliveryIDs, paymentIDs []int32) (int64, error) {
+	const fn = "deleteOrphans"
+
+	var deleted int64
+	for _, step := range []struct {
+		lock, delete string
+		ids          []int32
+	}{
+		{queries["lockDeliveries"], queries["deleteOrphanDeliveries"], deliveryIDs},
+		{queries["lockPayments"], queries["deleteOrphanPayments"], paymentIDs},
+	} {
+		if len(step.ids) == 0 {
+			continue
+		}
+		if _, err := tx.Exec(ctx, step.lock, step.ids); err != nil {
+			return deleted, fmt.Errorf("(%s) | failed to lock rows: %w", fn, err)
+		}
+		tag, err := tx.Exec(ctx, step.delete, step.ids)
 		if err != nil {
-			return fmt.Errorf("(%s) | failed to get order items: %w", fn, err)
+			return deleted, fmt.Errorf("(%s) | failed to delete orphans: %w", fn, err)
 		}
-		return nil
-	})
+		deleted += tag.RowsAffected()
+	}
+	return deleted, nil
+}
 
-	if err := g.Wait(); err != nil {
-		return fmt.Errorf("(%s) | Order finalization error: %w", fn, err)
+// anonymizeDelivery returns the delivery with its personal data scrubbed.
+func anonymizeDelivery(delivery models.Delivery) models.Delivery {
+	return models.Delivery{
+		City:   delivery.City,
+		Region: delivery.Region,
 	}
-	gCtx.Done()
-	return nil
 }
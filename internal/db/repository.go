@@ -0,0 +1,74 @@
+package db
+
+import (
+	"context"
+	"demo_service/internal/config"
+	"demo_service/internal/listing"
+	"demo_service/internal/models"
+	"demo_service/internal/search"
+	"errors"
+	"fmt"
+)
+
+// ErrOrderNotFound is returned when the requested order does not exist.
+var ErrOrderNotFound = errors.New("order not found")
+
+// OrderRepository stores orders along with the audit trail of their received revisions.
+// It is implemented by Storage (PostgreSQL) and Memory; Open selects one of them by the configuration.
+type OrderRepository interface {
+	// SaveOrder saves the order according to the write mode, filling in its stored version and update time.
+	// It returns false if the order has not been written.
+	SaveOrder(ctx context.Context, order *models.Order) (bool, error)
+	// SaveOrders saves the orders atomically and returns those that have been written.
+	SaveOrders(ctx context.Context, orders []models.Order) ([]models.Order, error)
+	// GetOrderByUID returns the order, or an error wrapping ErrOrderNotFound.
+	GetOrderByUID(ctx context.Context, orderUID string) (models.Order, error)
+	// GetOrderByTrackNumber returns the newest (by date_created) order with the track number,
+	// or an error wrapping ErrOrderNotFound.
+	GetOrderByTrackNumber(ctx context.Context, trackNumber string) (models.Order, error)
+	// GetOrderByTransaction returns the newest (by date_created) order paid by the transaction,
+	// or an error wrapping ErrOrderNotFound.
+	GetOrderByTransaction(ctx context.Context, transaction string) (models.Order, error)
+	// GetCustomerOrders returns all orders of the customer, newest (by date_created) first.
+	GetCustomerOrders(ctx context.Context, customerID string) ([]models.Order, error)
+	// ListOrders returns up to limit orders starting at offset, newest (by date_created) first.
+	ListOrders(ctx context.Context, offset, limit int) ([]models.Order, error)
+	// FindOrders returns a page of the orders matching the filter of the query, in its sort order.
+	FindOrders(ctx context.Context, q listing.Query) (listing.Page, error)
+	// SearchOrders returns the orders whose searchable text matches the query, best ranked first,
+	// with the snippets of their matches.
+	SearchOrders(ctx context.Context, q search.Query) ([]search.Result, error)
+	// GetLastLimitOrders returns the last limit orders by date_created, oldest first.
+	GetLastLimitOrders(ctx context.Context, limit int) ([]models.Order, error)
+	// GetOrderHistory returns the received revisions of the order, oldest first.
+	GetOrderHistory(ctx context.Context, orderUID string) ([]models.Revision, error)
+	// DeleteOrder deletes the order and its revisions. It returns false if the order does not exist.
+	DeleteOrder(ctx context.Context, orderUID string) (bool, error)
+	// AnonymizeOrder scrubs the personal delivery data of the order and its revisions.
+	// It returns false if the order does not exist.
+	AnonymizeOrder(ctx context.Context, orderUID string) (bool, error)
+	// Close releases the resources of the repository.
+	Close()
+}
+
+// Open creates the order repository of the backend selected in the database configuration.
+func Open(ctx context.Context, dbCfg config.DataBase) (OrderRepository, error) {
+	const fn = "Open"
+
+	switch dbCfg.Backend {
+	case config.BackendPostgres:
+		storage, err := New(ctx, dbCfg)
+		if err != nil {
+			return nil, err
+		}
+		return storage, nil
+	case config.BackendMemory:
+		memory, err := NewMemory(dbCfg)
+		if err != nil {
+			return nil, err
+		}
+		return memory, nil
+	default:
+		return nil, fmt.Errorf("(%s) | unknown storage backend: %q", fn, dbCfg.Backend)
+	}
+}
@@ -0,0 +1,158 @@
+package e2e
+
+import (
+	"context"
+	"demo_service/internal/kafka"
+	"demo_service/internal/models"
+	"encoding/json"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+)
+
+// start runs a harness with a dead-letter topic for the test and closes it at the end.
+func start(t *testing.T) *Harness {
+	t.Helper()
+
+	cfg := Config()
+	cfg.Broker.DLQ.Enabled = true
+	h, err := Start(cfg, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := h.Close(); err != nil {
+			t.Error(err)
+		}
+	})
+	return h
+}
+
+// sampleOrder returns the order of model.json with the UID.
+func sampleOrder(t *testing.T, uid string) models.Order {
+	t.Helper()
+
+	data, err := os.ReadFile("../../model.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var order models.Order
+	if err := json.Unmarshal(data, &order); err != nil {
+		t.Fatal(err)
+	}
+	order.OrderUID = uid
+	return order
+}
+
+// wait waits until the harness has processed every published message.
+func wait(t *testing.T, h *Harness) {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := h.Wait(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// getOrder requests the order from the HTTP API, returning the status code and the decoded order.
+func getOrder(t *testing.T, h *Harness, uid string) (int, models.Order) {
+	t.Helper()
+
+	resp, err := http.Get(h.Server.URL + "/order/" + uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var order models.Order
+	if resp.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(resp.Body).Decode(&order); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return resp.StatusCode, order
+}
+
+func TestValidOrderIsServed(t *testing.T) {
+	h := start(t)
+	want := sampleOrder(t, "e2e-valid")
+
+	if err := h.Publish(want); err != nil {
+		t.Fatal(err)
+	}
+	wait(t, h)
+
+	status, got := getOrder(t, h, want.OrderUID)
+	if status != http.StatusOK {
+		t.Fatalf("GET /order/%s status = %d; want 200", want.OrderUID, status)
+	}
+	if got.TrackNumber != want.TrackNumber || got.Payment.Transaction != want.Payment.Transaction ||
+		len(got.Items) != len(want.Items) {
+		t.Errorf("stored order = %+v; want %+v", got, want)
+	}
+	if got.Source == nil || got.Source.Topic != h.Config.Broker.Topic {
+		t.Errorf("stored order source = %+v; want the %s topic", got.Source, h.Config.Broker.Topic)
+	}
+	if dead := h.Broker.Messages(h.Config.Broker.DLQ.Topic); len(dead) != 0 {
+		t.Errorf("%d message(s) dead-lettered; want none", len(dead))
+	}
+}
+
+func TestInvalidOrderIsDeadLettered(t *testing.T) {
+	h := start(t)
+	invalid := sampleOrder(t, "e2e-invalid")
+	invalid.TrackNumber = ""
+
+	if err := h.Publish(invalid); err != nil {
+		t.Fatal(err)
+	}
+	wait(t, h)
+
+	if status, _ := getOrder(t, h, invalid.OrderUID); status != http.StatusNotFound {
+		t.Errorf("GET /order/%s status = %d; want 404", invalid.OrderUID, status)
+	}
+	dead := h.Broker.Messages(h.Config.Broker.DLQ.Topic)
+	if len(dead) != 1 {
+		t.Fatalf("%d message(s) dead-lettered; want 1", len(dead))
+	}
+	if key := string(dead[0].Key); key != invalid.OrderUID {
+		t.Errorf("dead-lettered key = %q; want %q", key, invalid.OrderUID)
+	}
+	var stage string
+	for _, header := range dead[0].Headers {
+		if string(header.Key) == kafka.HeaderDLQStage {
+			stage = string(header.Value)
+		}
+	}
+	if stage != string(kafka.StageValidate) {
+		t.Errorf("dead-letter stage = %q; want %q", stage, kafka.StageValidate)
+	}
+}
+
+func TestTombstoneDeletesOrder(t *testing.T) {
+	h := start(t)
+	order := sampleOrder(t, "e2e-tombstone")
+
+	if err := h.Publish(order); err != nil {
+		t.Fatal(err)
+	}
+	wait(t, h)
+	if status, _ := getOrder(t, h, order.OrderUID); status != http.StatusOK {
+		t.Fatalf("GET /order/%s status = %d before the tombstone; want 200", order.OrderUID, status)
+	}
+
+	if err := h.PublishTombstone(order.OrderUID, false); err != nil {
+		t.Fatal(err)
+	}
+	wait(t, h)
+
+	// Заказ удалён и из хранилища, и из кэша
+	if status, _ := getOrder(t, h, order.OrderUID); status != http.StatusNotFound {
+		t.Errorf("GET /order/%s status = %d after the tombstone; want 404", order.OrderUID, status)
+	}
+	if _, ok := h.Cache.Get(order.OrderUID); ok {
+		t.Error("deleted order is still cached")
+	}
+}
@@ -0,0 +1,192 @@
+// Package e2e runs the whole order pipeline in one process for end-to-end tests.
+//
+// The in-memory Kafka broker feeds the consumer, the consumed orders go through the order module
+// into the in-memory storage and the cache, and the HTTP API is served by an httptest.Server.
+// No network or external service is involved, so the pipeline can be exercised with go test:
+//
+//	h, err := e2e.Start(e2e.Config(), 3)
+//	...
+//	defer h.Close()
+//	h.Publish(order)
+//	h.Wait(ctx)
+//	resp, err := http.Get(h.Server.URL + "/order/" + order.OrderUID)
+package e2e
+
+import (
+	"context"
+	"demo_service/internal/cache"
+	"demo_service/internal/config"
+	"demo_service/internal/db"
+	"demo_service/internal/ingest"
+	"demo_service/internal/kafka"
+	"demo_service/internal/models"
+	order "demo_service/internal/modules"
+	"demo_service/internal/retry"
+	"demo_service/internal/rules"
+	"demo_service/internal/server"
+	"demo_service/internal/transport"
+	"fmt"
+	"net/http/httptest"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// pollInterval is how often Wait checks the lag of the consumer group.
+const pollInterval = 10 * time.Millisecond
+
+// Harness is a running pipeline. Its components are exposed for assertions and fault injection.
+type Harness struct {
+	Config   *config.Config
+	Broker   *kafka.MemoryBroker
+	Producer sarama.SyncProducer
+	Storage  *db.Memory
+	Cache    *cache.Cache
+	Orders   *order.Order
+	Server   *httptest.Server
+
+	codec     kafka.Codec
+	transport transport.Transport
+	cancel    context.CancelFunc
+	done      chan struct{}
+}
+
+// Config returns a configuration suited for the harness: upsert write mode, JSON payloads,
+// short retry backoffs and a dead-letter topic that is disabled by default.
+func Config() *config.Config {
+	return &config.Config{
+		DB:        config.DataBase{WriteMode: config.WriteModeUpsert},
+		Transport: config.Transport{Type: config.TransportKafka},
+		Broker: config.Broker{
+			GroupID: "e2e",
+			Topic:   "orders",
+			Workers: 1,
+			Codec:   kafka.CodecJSON,
+			DLQ:     config.DLQ{Topic: "orders.dlq"},
+			Retry:   config.Retry{MaxAttempts: 3, InitialBackoff: 10 * time.Millisecond, MaxBackoff: 100 * time.Millisecond},
+			Batch:   config.Batch{Size: 1, Linger: 50 * time.Millisecond},
+		},
+		Cache:   config.Cache{Capacity: 100},
+		Version: "e2e",
+	}
+}
+
+// Start wires the pipeline with the configuration and an in-memory broker
+// whose topics have the given number of partitions, and starts consuming.
+func Start(cfg *config.Config, partitions int) (*Harness, error) {
+	const fn = "e2e.Start"
+
+	storage, err := db.NewMemory(cfg.DB)
+	if err != nil {
+		return nil, fmt.Errorf("(%s) | %w", fn, err)
+	}
+	rulesEngine, err := rules.New(cfg.Rules)
+	if err != nil {
+		return nil, fmt.Errorf("(%s) | %w", fn, err)
+	}
+	codecs, err := kafka.NewCodecs(cfg.Broker)
+	if err != nil {
+		return nil, fmt.Errorf("(%s) | %w", fn, err)
+	}
+
+	broker := kafka.NewMemoryBroker(partitions)
+	consumer, err := transport.NewMemory(broker, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("(%s) | %w", fn, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cacheInstance := cache.New(cfg.Cache.Capacity)
+	module := order.New(ctx, cacheInstance, storage, rulesEngine)
+	policy := retry.New(cfg.Broker.Retry, db.IsTransient)
+
+	h := &Harness{
+		Config:    cfg,
+		Broker:    broker,
+		Producer:  broker.Producer(),
+		Storage:   storage,
+		Cache:     cacheInstance,
+		Orders:    module,
+		codec:     codecs.Default(),
+		transport: consumer,
+		cancel:    cancel,
+		done:      make(chan struct{}),
+	}
+
+	go func() {
+		defer close(h.done)
+		consumer.Start(ctx, transport.NewHandler(ctx, module, policy))
+	}()
+
+	api := server.New(ctx, module, &cfg.HTTPServer)
+	if controller, ok := consumer.(server.ConsumerController); ok {
+		api.SetConsumerController(controller)
+	}
+	api.SetIngester(ingest.New(module, policy))
+	h.Server = httptest.NewServer(api.Handler())
+
+	return h, nil
+}
+
+// Publish sends the order to the topic with the default codec, keyed by its UID.
+func (h *Harness) Publish(order models.Order) error {
+	return h.PublishWith(h.codec, order)
+}
+
+// PublishWith sends the order to the topic with the codec, keyed by its UID.
+func (h *Harness) PublishWith(codec kafka.Codec, order models.Order) error {
+	const fn = "PublishWith"
+
+	value, err := codec.Encode(order)
+	if err != nil {
+		return fmt.Errorf("(%s) | %w", fn, err)
+	}
+	_, _, err = h.Producer.SendMessage(&sarama.ProducerMessage{
+		Topic: h.Config.Broker.Topic,
+		Key:   sarama.StringEncoder(order.OrderUID),
+		Value: sarama.ByteEncoder(value),
+		Headers: []sarama.RecordHeader{
+			{Key: []byte(kafka.HeaderContentType), Value: []byte(codec.ContentType())},
+		},
+	})
+	return err
+}
+
+// PublishTombstone sends a tombstone that deletes the order, or anonymizes it.
+func (h *Harness) PublishTombstone(orderUID string, anonymize bool) error {
+	message := &sarama.ProducerMessage{
+		Topic: h.Config.Broker.Topic,
+		Key:   sarama.StringEncoder(orderUID),
+	}
+	if anonymize {
+		message.Headers = []sarama.RecordHeader{
+			{Key: []byte(kafka.HeaderOrderAction), Value: []byte(kafka.ActionAnonymize)},
+		}
+	}
+	_, _, err := h.Producer.SendMessage(message)
+	return err
+}
+
+// Wait blocks until every message published to the topic has been processed and its offset committed,
+// or ctx is done.
+func (h *Harness) Wait(ctx context.Context) error {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for h.Broker.Lag(h.Config.Broker.Topic) > 0 {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("(Wait) | %d message(s) not processed: %w", h.Broker.Lag(h.Config.Broker.Topic), ctx.Err())
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// Close stops consuming and shuts the API server down.
+func (h *Harness) Close() error {
+	h.cancel()
+	<-h.done
+	h.Server.Close()
+	return h.transport.Close()
+}
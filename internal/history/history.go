@@ -0,0 +1,123 @@
+// Package history builds the change history of an order from its stored revisions.
+//
+// Each revision holds the order as JSON. Consecutive revisions are compared field by field,
+// walking nested objects and arrays, and every differing leaf is reported with its path
+// (e.g. "delivery.city" or "items[1].status") and its old and new values.
+package history
+
+import (
+	"bytes"
+	"demo_service/internal/models"
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// Change describes a single field that differs between two revisions.
+// Old is absent for added fields and New is absent for removed ones.
+type Change struct {
+	Path string `json:"path"`
+	Old  any    `json:"old,omitempty"`
+	New  any    `json:"new,omitempty"`
+}
+
+// Entry is a revision of an order along with the changes relative to the previous revision.
+type Entry struct {
+	models.Revision
+	Changes []Change `json:"changes"`
+}
+
+// Build returns the history entries for the revisions, which must be ordered oldest first.
+// The first revision has no changes.
+func Build(revisions []models.Revision) ([]Entry, error) {
+	const fn = "Build"
+
+	entries := make([]Entry, 0, len(revisions))
+	var prev any
+	for i, rev := range revisions {
+		var cur any
+		if err := json.Unmarshal(rev.Payload, &cur); err != nil {
+			return nil, fmt.Errorf("(%s) | failed to decode revision %d: %w", fn, rev.ID, err)
+		}
+
+		changes := []Change{}
+		if i > 0 {
+			diff("", prev, cur, &changes)
+		}
+		entries = append(entries, Entry{Revision: rev, Changes: changes})
+		prev = cur
+	}
+	return entries, nil
+}
+
+// diff appends the differences between two decoded JSON values under the given path.
+func diff(path string, oldVal, newVal any, changes *[]Change) {
+	switch o := oldVal.(type) {
+	case map[string]any:
+		if n, ok := newVal.(map[string]any); ok {
+			for _, key := range unionKeys(o, n) {
+				ov, inOld := o[key]
+				nv, inNew := n[key]
+				sub := join(path, key)
+				switch {
+				case !inOld:
+					*changes = append(*changes, Change{Path: sub, New: nv})
+				case !inNew:
+					*changes = append(*changes, Change{Path: sub, Old: ov})
+				default:
+					diff(sub, ov, nv, changes)
+				}
+			}
+			return
+		}
+	case []any:
+		if n, ok := newVal.([]any); ok {
+			for i := 0; i < len(o) || i < len(n); i++ {
+				sub := fmt.Sprintf("%s[%d]", path, i)
+				switch {
+				case i >= len(o):
+					*changes = append(*changes, Change{Path: sub, New: n[i]})
+				case i >= len(n):
+					*changes = append(*changes, Change{Path: sub, Old: o[i]})
+				default:
+					diff(sub, o[i], n[i], changes)
+				}
+			}
+			return
+		}
+	}
+
+	if !equal(oldVal, newVal) {
+		*changes = append(*changes, Change{Path: path, Old: oldVal, New: newVal})
+	}
+}
+
+// unionKeys returns the keys of both maps in sorted order.
+func unionKeys(a, b map[string]any) []string {
+	keys := make([]string, 0, len(a)+len(b))
+	for k := range a {
+		keys = append(keys, k)
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// join appends the key to the path.
+func join(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// equal compares two decoded JSON leaf values.
+func equal(a, b any) bool {
+	ab, errA := json.Marshal(a)
+	bb, errB := json.Marshal(b)
+	return errA == nil && errB == nil && bytes.Equal(ab, bb)
+}
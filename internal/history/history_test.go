@@ -0,0 +1,105 @@
+package history
+
+import (
+	"demo_service/internal/models"
+	"encoding/json"
+	"testing"
+)
+
+// revisions returns the revisions of the order with the payloads, oldest first.
+func revisions(payloads ...string) []models.Revision {
+	revs := make([]models.Revision, 0, len(payloads))
+	for i, payload := range payloads {
+		revs = append(revs, models.Revision{ID: int64(i + 1), OrderUID: "order-1", Payload: json.RawMessage(payload)})
+	}
+	return revs
+}
+
+// changesJSON returns the changes as JSON, the form they are served in.
+func changesJSON(t *testing.T, changes []Change) string {
+	t.Helper()
+
+	data, err := json.Marshal(changes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestBuild(t *testing.T) {
+	entries, err := Build(revisions(
+		`{"order_uid":"order-1","delivery":{"city":"Moscow"}}`,
+		`{"order_uid":"order-1","delivery":{"city":"Kazan"}}`,
+		`{"order_uid":"order-1","delivery":{"city":"Kazan"}}`,
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("Build() = %d entries; want 3", len(entries))
+	}
+	for i, entry := range entries {
+		if entry.ID != int64(i+1) {
+			t.Errorf("entry %d has revision %d; want the revisions in order", i, entry.ID)
+		}
+	}
+
+	// У первой ревизии нет изменений, но список пуст, а не null
+	if got := changesJSON(t, entries[0].Changes); got != `[]` {
+		t.Errorf("changes of the first revision = %s; want []", got)
+	}
+	if got, want := changesJSON(t, entries[1].Changes), `[{"path":"delivery.city","old":"Moscow","new":"Kazan"}]`; got != want {
+		t.Errorf("changes of the second revision = %s; want %s", got, want)
+	}
+	if got := changesJSON(t, entries[2].Changes); got != `[]` {
+		t.Errorf("changes of an identical revision = %s; want []", got)
+	}
+}
+
+func TestBuildEmpty(t *testing.T) {
+	entries, err := Build(nil)
+	if err != nil || len(entries) != 0 {
+		t.Errorf("Build(nil) = %v, %v; want no entries", entries, err)
+	}
+}
+
+func TestBuildInvalidPayload(t *testing.T) {
+	if _, err := Build(revisions(`{"order_uid":"order-1"}`, `{"order_uid":`)); err == nil {
+		t.Error("Build() with a malformed payload: want an error")
+	}
+}
+
+func TestDiff(t *testing.T) {
+	cases := []struct {
+		name     string
+		old, new string
+		want     string
+	}{
+		{"Unchanged", `{"a":1,"b":{"c":[1,2]}}`, `{"b":{"c":[1,2]},"a":1}`, `[]`},
+		{"ChangedField", `{"payment":{"amount":100}}`, `{"payment":{"amount":0}}`,
+			`[{"path":"payment.amount","old":100,"new":0}]`},
+		{"AddedField", `{"a":1}`, `{"a":1,"b":"x"}`, `[{"path":"b","new":"x"}]`},
+		{"RemovedField", `{"a":1,"b":"x"}`, `{"a":1}`, `[{"path":"b","old":"x"}]`},
+		{"AddedNestedObject", `{}`, `{"delivery":{"city":"Kazan"}}`, `[{"path":"delivery","new":{"city":"Kazan"}}]`},
+		{"FieldsInKeyOrder", `{"b":1,"a":1}`, `{"b":2,"a":2}`,
+			`[{"path":"a","old":1,"new":2},{"path":"b","old":1,"new":2}]`},
+		{"ChangedItem", `{"items":[{"status":1},{"status":1}]}`, `{"items":[{"status":1},{"status":2}]}`,
+			`[{"path":"items[1].status","old":1,"new":2}]`},
+		{"AddedItem", `{"items":[{"rid":"a"}]}`, `{"items":[{"rid":"a"},{"rid":"b"}]}`,
+			`[{"path":"items[1]","new":{"rid":"b"}}]`},
+		{"RemovedItem", `{"items":[{"rid":"a"},{"rid":"b"}]}`, `{"items":[{"rid":"a"}]}`,
+			`[{"path":"items[1]","old":{"rid":"b"}}]`},
+		{"TypeChanged", `{"size":"0"}`, `{"size":0}`, `[{"path":"size","old":"0","new":0}]`},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			entries, err := Build(revisions(c.old, c.new))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := changesJSON(t, entries[1].Changes); got != c.want {
+				t.Errorf("changes = %s; want %s", got, c.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,149 @@
+// Package ingest stores orders received outside of the message broker:
+// through the HTTP API or from files.
+//
+// The input is a stream of JSON values: a single order (as in model.json), an array of orders,
+// or newline-delimited orders (NDJSON), in any combination. Every order goes through the same
+// validation, business rules and persistence as the orders consumed from the broker,
+// and gets its own Result, so one bad order does not affect the others.
+package ingest
+
+import (
+	"bytes"
+	"context"
+	"demo_service/internal/models"
+	"demo_service/internal/retry"
+	"demo_service/internal/rules"
+	"demo_service/internal/validation"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Result statuses.
+const (
+	// StatusCreated means the order has been stored for the first time.
+	StatusCreated = "created"
+	// StatusUpdated means the order has replaced a stored revision.
+	StatusUpdated = "updated"
+	// StatusUnchanged means the stored revision of the order has been kept.
+	StatusUnchanged = "unchanged"
+	// StatusInvalid means the order could not be decoded or has not passed validation.
+	StatusInvalid = "invalid"
+	// StatusRejected means the order has violated a rejecting business rule.
+	StatusRejected = "rejected"
+	// StatusFailed means the order could not be stored; the request may be retried.
+	StatusFailed = "failed"
+)
+
+// Result is the outcome of ingesting a single order. Index is its position in the input.
+type Result struct {
+	Index    int               `json:"index"`
+	OrderUID string            `json:"order_uid,omitempty"`
+	Status   string            `json:"status"`
+	Version  int               `json:"version,omitempty"`
+	Checks   []models.Check    `json:"checks,omitempty"`
+	Errors   validation.Errors `json:"errors,omitempty"`
+	Error    string            `json:"error,omitempty"`
+}
+
+// Stored reports whether the order has been persisted (or already was).
+func (r Result) Stored() bool {
+	return r.Status == StatusCreated || r.Status == StatusUpdated || r.Status == StatusUnchanged
+}
+
+// Saver validates, checks and persists an order, returning it as stored and whether it has been written.
+type Saver interface {
+	StoreOrder(ctx context.Context, order models.Order) (models.Order, bool, error)
+}
+
+// Ingester decodes orders from an input and stores them one by one.
+type Ingester struct {
+	saver  Saver
+	policy *retry.Policy
+}
+
+// New creates an Ingester storing orders with the saver.
+// Transient failures are retried with the policy, as for orders consumed from the broker.
+func New(saver Saver, policy *retry.Policy) *Ingester {
+	return &Ingester{saver: saver, policy: policy}
+}
+
+// Ingest decodes the orders from r, stores them and passes the result of each one to emit.
+// It returns an error if the input is not well-formed JSON or emit fails;
+// the results emitted before that remain valid.
+func (in *Ingester) Ingest(ctx context.Context, r io.Reader, emit func(Result) error) error {
+	const fn = "Ingest"
+
+	index := 0
+	ingest := func(raw json.RawMessage) error {
+		result := in.ingest(ctx, raw)
+		result.Index = index
+		index++
+		return emit(result)
+	}
+
+	dec := json.NewDecoder(r)
+	for {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("(%s) | malformed input after %d order(s): %w", fn, index, err)
+		}
+
+		if trimmed := bytes.TrimSpace(raw); len(trimmed) > 0 && trimmed[0] == '[' {
+			var items []json.RawMessage
+			if err := json.Unmarshal(raw, &items); err != nil {
+				return fmt.Errorf("(%s) | malformed array after %d order(s): %w", fn, index, err)
+			}
+			for _, item := range items {
+				if err := ingest(item); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if err := ingest(raw); err != nil {
+			return err
+		}
+	}
+}
+
+// ingest decodes and stores a single order.
+func (in *Ingester) ingest(ctx context.Context, raw json.RawMessage) Result {
+	var order models.Order
+	if err := json.Unmarshal(raw, &order); err != nil {
+		return Result{Status: StatusInvalid, Error: err.Error()}
+	}
+	// Метаданные брокера не принимаются от клиента
+	order.Source = nil
+
+	var stored models.Order
+	var written bool
+	err := in.policy.Do(ctx, func() error {
+		var err error
+		stored, written, err = in.saver.StoreOrder(ctx, order)
+		return err
+	})
+
+	result := Result{OrderUID: order.OrderUID}
+	var validationErrs validation.Errors
+	var rejected *rules.RejectedError
+	switch {
+	case errors.As(err, &validationErrs):
+		result.Status, result.Errors = StatusInvalid, validationErrs
+	case errors.As(err, &rejected):
+		result.Status, result.Checks, result.Error = StatusRejected, rejected.Checks, rejected.Error()
+	case err != nil:
+		result.Status, result.Error = StatusFailed, err.Error()
+	case !written:
+		result.Status = StatusUnchanged
+	default:
+		result.Status, result.Version, result.Checks = StatusUpdated, stored.Version, stored.Checks
+		if stored.Version <= 1 {
+			result.Status = StatusCreated
+		}
+	}
+	return result
+}
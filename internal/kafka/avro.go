@@ -0,0 +1,673 @@
+package kafka
+
+import (
+	"bytes"
+	"context"
+	"demo_service/internal/models"
+	_ "embed" // Avro schema of an order
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+// orderAvroSchema is the Avro schema orders are encoded with.
+//
+//go:embed schema/order.avsc
+var orderAvroSchema string
+
+// avroMagicByte starts a payload in the schema registry wire format:
+// the magic byte, the 4-byte big-endian schema ID and the Avro binary encoding of the value.
+const avroMagicByte = 0
+
+// AvroCodec encodes orders in Avro with the schema registry wire format.
+//
+// Encoding registers schema/order.avsc under the configured subject once and prefixes payloads with its ID.
+// Decoding resolves the writer schema of a payload by its ID from the registry (caching it),
+// decodes the value with it and maps the fields onto the order by name,
+// so producers may add, drop or reorder fields. A schema that cannot be fetched for any reason
+// but its absence from the registry fails decoding with an error wrapping ErrRegistryUnavailable.
+type AvroCodec struct {
+	registry SchemaRegistry
+	subject  string
+	schema   *avroSchema
+
+	mu      sync.Mutex
+	id      int // ID of schema in the registry, 0 until registered
+	schemas map[int]*avroSchema
+	fetches map[int]*schemaFetch // schemas being fetched from the registry
+}
+
+// schemaFetch is a fetch of a writer schema shared by all the decoders waiting for it.
+type schemaFetch struct {
+	done   chan struct{}
+	schema *avroSchema
+	err    error
+}
+
+// NewAvroCodec creates an Avro codec that resolves and registers schemas in the registry.
+func NewAvroCodec(registry SchemaRegistry, subject string) (*AvroCodec, error) {
+	const fn = "NewAvroCodec"
+
+	schema, err := parseAvroSchema(orderAvroSchema)
+	if err != nil {
+		return nil, fmt.Errorf("(%s) | invalid order schema: %w", fn, err)
+	}
+	return &AvroCodec{
+		registry: registry,
+		subject:  subject,
+		schema:   schema,
+		schemas:  make(map[int]*avroSchema),
+		fetches:  make(map[int]*schemaFetch),
+	}, nil
+}
+
+// Name implements Codec.
+func (c *AvroCodec) Name() string { return CodecAvro }
+
+// ContentType implements Codec.
+func (c *AvroCodec) ContentType() string { return ContentTypeAvro }
+
+// Encode implements Codec.
+func (c *AvroCodec) Encode(order models.Order) ([]byte, error) {
+	id, err := c.schemaID()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := json.Marshal(order)
+	if err != nil {
+		return nil, err
+	}
+	var value any
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&value); err != nil {
+		return nil, err
+	}
+
+	b := make([]byte, 5, 5+len(data))
+	b[0] = avroMagicByte
+	binary.BigEndian.PutUint32(b[1:], uint32(id)) //nolint:gosec // registry IDs are positive int32
+	return c.schema.encode(b, value)
+}
+
+// Decode implements Codec.
+func (c *AvroCodec) Decode(ctx context.Context, data []byte) (models.Order, error) {
+	if len(data) < 5 || data[0] != avroMagicByte {
+		return models.Order{}, errors.New("avro payload is not in the schema registry wire format")
+	}
+	schema, err := c.writerSchema(ctx, int(binary.BigEndian.Uint32(data[1:5])))
+	if err != nil {
+		return models.Order{}, err
+	}
+
+	r := &avroReader{data: data[5:]}
+	value, err := schema.decode(r)
+	if err != nil {
+		return models.Order{}, fmt.Errorf("invalid avro order: %w", err)
+	}
+	if len(r.data) > 0 {
+		return models.Order{}, fmt.Errorf("invalid avro order: %d trailing bytes", len(r.data))
+	}
+
+	// Поля записи сопоставляются с полями заказа по именам, как в JSON
+	fields, err := json.Marshal(value)
+	if err != nil {
+		return models.Order{}, err
+	}
+	var order models.Order
+	if err := json.Unmarshal(fields, &order); err != nil {
+		return models.Order{}, fmt.Errorf("invalid avro order: %w", err)
+	}
+	return order, nil
+}
+
+// schemaID registers the order schema on first use and returns its ID.
+func (c *AvroCodec) schemaID() (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.id == 0 {
+		id, err := c.registry.Register(context.Background(), c.subject, orderAvroSchema)
+		if err != nil {
+			return 0, err
+		}
+		c.id = id
+	}
+	return c.id, nil
+}
+
+// writerSchema returns the schema with the ID, fetching it from the registry on first use.
+// Concurrent calls for the same ID share one fetch, made without holding the lock;
+// failed fetches are not cached, so the next call tries again.
+func (c *AvroCodec) writerSchema(ctx context.Context, id int) (*avroSchema, error) {
+	c.mu.Lock()
+	if schema, ok := c.schemas[id]; ok {
+		c.mu.Unlock()
+		return schema, nil
+	}
+	if fetch, ok := c.fetches[id]; ok {
+		c.mu.Unlock()
+		select {
+		case <-fetch.done:
+			return fetch.schema, fetch.err
+		case <-ctx.Done():
+			return nil, fmt.Errorf("%w: schema %d: %w", ErrRegistryUnavailable, id, ctx.Err())
+		}
+	}
+	fetch := &schemaFetch{done: make(chan struct{})}
+	c.fetches[id] = fetch
+	c.mu.Unlock()
+
+	fetch.schema, fetch.err = c.fetchSchema(ctx, id)
+
+	c.mu.Lock()
+	if fetch.err == nil {
+		c.schemas[id] = fetch.schema
+	}
+	delete(c.fetches, id)
+	c.mu.Unlock()
+	close(fetch.done)
+	return fetch.schema, fetch.err
+}
+
+// fetchSchema fetches the schema with the ID from the registry and parses it.
+// Any failure but a missing or invalid schema wraps ErrRegistryUnavailable.
+func (c *AvroCodec) fetchSchema(ctx context.Context, id int) (*avroSchema, error) {
+	raw, err := c.registry.Schema(ctx, id)
+	if err != nil {
+		if errors.Is(err, ErrSchemaNotFound) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: %w", ErrRegistryUnavailable, err)
+	}
+	schema, err := parseAvroSchema(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid avro schema %d: %w", id, err)
+	}
+	return schema, nil
+}
+
+// avroSchema is a parsed Avro schema.
+// Records decode to map[string]any, arrays to []any, maps to map[string]any,
+// enums and strings to string, bytes and fixed to []byte, int and long to int64
+// (or time.Time with a timestamp logical type), float to float32 and double to float64.
+type avroSchema struct {
+	typ         string
+	logicalType string
+	name        string
+	fields      []avroField   // record
+	items       *avroSchema   // array
+	values      *avroSchema   // map
+	symbols     []string      // enum
+	size        int           // fixed
+	branches    []*avroSchema // union
+}
+
+// avroField is a field of a record schema.
+type avroField struct {
+	name       string
+	schema     *avroSchema
+	def        any
+	hasDefault bool
+}
+
+// parseAvroSchema parses a schema from its JSON representation.
+func parseAvroSchema(schema string) (*avroSchema, error) {
+	var raw any
+	dec := json.NewDecoder(strings.NewReader(schema))
+	dec.UseNumber()
+	if err := dec.Decode(&raw); err != nil {
+		return nil, err
+	}
+	return parseAvroType(raw, "", make(map[string]*avroSchema))
+}
+
+// parseAvroType parses a type of the schema; named holds the named types defined so far.
+func parseAvroType(raw any, namespace string, named map[string]*avroSchema) (*avroSchema, error) {
+	switch t := raw.(type) {
+	case string:
+		switch t {
+		case "null", "boolean", "int", "long", "float", "double", "bytes", "string":
+			return &avroSchema{typ: t}, nil
+		}
+		if s, ok := named[avroFullName(t, namespace)]; ok {
+			return s, nil
+		}
+		if s, ok := named[t]; ok {
+			return s, nil
+		}
+		return nil, fmt.Errorf("unknown type %q", t)
+
+	case []any:
+		s := &avroSchema{typ: "union"}
+		for _, branch := range t {
+			bs, err := parseAvroType(branch, namespace, named)
+			if err != nil {
+				return nil, err
+			}
+			s.branches = append(s.branches, bs)
+		}
+		return s, nil
+
+	case map[string]any:
+		typ, ok := t["type"].(string)
+		if !ok {
+			return parseAvroType(t["type"], namespace, named)
+		}
+		s := &avroSchema{typ: typ}
+		s.logicalType, _ = t["logicalType"].(string)
+
+		switch typ {
+		case "record", "error", "enum", "fixed":
+			name, _ := t["name"].(string)
+			if name == "" {
+				return nil, fmt.Errorf("%s without a name", typ)
+			}
+			if ns, ok := t["namespace"].(string); ok {
+				namespace = ns
+			}
+			s.name = avroFullName(name, namespace)
+			if i := strings.LastIndex(s.name, "."); i >= 0 {
+				namespace = s.name[:i]
+			}
+			named[s.name] = s
+		}
+
+		switch typ {
+		case "record", "error":
+			s.typ = "record"
+			fields, _ := t["fields"].([]any)
+			for _, rawField := range fields {
+				f, ok := rawField.(map[string]any)
+				if !ok {
+					return nil, fmt.Errorf("invalid field of record %s", s.name)
+				}
+				name, _ := f["name"].(string)
+				fs, err := parseAvroType(f["type"], namespace, named)
+				if err != nil {
+					return nil, fmt.Errorf("field %s.%s: %w", s.name, name, err)
+				}
+				def, hasDefault := f["default"]
+				s.fields = append(s.fields, avroField{name: name, schema: fs, def: def, hasDefault: hasDefault})
+			}
+		case "enum":
+			symbols, _ := t["symbols"].([]any)
+			for _, symbol := range symbols {
+				str, _ := symbol.(string)
+				s.symbols = append(s.symbols, str)
+			}
+		case "array":
+			items, err := parseAvroType(t["items"], namespace, named)
+			if err != nil {
+				return nil, err
+			}
+			s.items = items
+		case "map":
+			values, err := parseAvroType(t["values"], namespace, named)
+			if err != nil {
+				return nil, err
+			}
+			s.values = values
+		case "fixed":
+			size, _ := t["size"].(json.Number)
+			n, err := size.Int64()
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("fixed %s has invalid size", s.name)
+			}
+			s.size = int(n)
+		default:
+			if _, err := parseAvroType(typ, namespace, named); err != nil {
+				return nil, err
+			}
+		}
+		return s, nil
+
+	default:
+		return nil, fmt.Errorf("invalid schema: %v", raw)
+	}
+}
+
+// avroFullName qualifies the name with the namespace unless it is already qualified.
+func avroFullName(name, namespace string) string {
+	if namespace == "" || strings.Contains(name, ".") {
+		return name
+	}
+	return namespace + "." + name
+}
+
+// errAvroShort is returned when the payload ends in the middle of a value.
+var errAvroShort = errors.New("unexpected end of avro data")
+
+// avroReader consumes the Avro binary encoding.
+type avroReader struct {
+	data []byte
+}
+
+// long reads a zig-zag encoded variable-length integer.
+func (r *avroReader) long() (int64, error) {
+	v, n := binary.Varint(r.data)
+	if n <= 0 {
+		return 0, errAvroShort
+	}
+	r.data = r.data[n:]
+	return v, nil
+}
+
+// next reads n bytes.
+func (r *avroReader) next(n int64) ([]byte, error) {
+	if n < 0 || n > int64(len(r.data)) {
+		return nil, errAvroShort
+	}
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b, nil
+}
+
+// blocks reads the blocks of an array or a map, calling item for each of their items.
+func (r *avroReader) blocks(item func() error) error {
+	for {
+		count, err := r.long()
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			return nil
+		}
+		if count < 0 {
+			// Отрицательный счетчик означает, что за ним следует размер блока в байтах
+			count = -count
+			if _, err := r.long(); err != nil {
+				return err
+			}
+		}
+		if count > int64(len(r.data)) {
+			return errAvroShort
+		}
+		for i := int64(0); i < count; i++ {
+			if err := item(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// decode reads a value of the schema.
+func (s *avroSchema) decode(r *avroReader) (any, error) {
+	switch s.typ {
+	case "null":
+		return nil, nil
+	case "boolean":
+		b, err := r.next(1)
+		if err != nil {
+			return nil, err
+		}
+		return b[0] != 0, nil
+	case "int", "long":
+		v, err := r.long()
+		if err != nil {
+			return nil, err
+		}
+		switch s.logicalType {
+		case "timestamp-millis":
+			return time.UnixMilli(v).UTC(), nil
+		case "timestamp-micros":
+			return time.UnixMicro(v).UTC(), nil
+		}
+		return v, nil
+	case "float":
+		b, err := r.next(4)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float32frombits(binary.LittleEndian.Uint32(b)), nil
+	case "double":
+		b, err := r.next(8)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.LittleEndian.Uint64(b)), nil
+	case "bytes", "string":
+		n, err := r.long()
+		if err != nil {
+			return nil, err
+		}
+		b, err := r.next(n)
+		if err != nil {
+			return nil, err
+		}
+		if s.typ == "string" {
+			return string(b), nil
+		}
+		return bytes.Clone(b), nil
+	case "fixed":
+		b, err := r.next(int64(s.size))
+		if err != nil {
+			return nil, err
+		}
+		return bytes.Clone(b), nil
+	case "enum":
+		i, err := r.long()
+		if err != nil {
+			return nil, err
+		}
+		if i < 0 || i >= int64(len(s.symbols)) {
+			return nil, fmt.Errorf("enum %s has no symbol %d", s.name, i)
+		}
+		return s.symbols[i], nil
+	case "record":
+		record := make(map[string]any, len(s.fields))
+		for _, f := range s.fields {
+			v, err := f.schema.decode(r)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", f.name, err)
+			}
+			record[f.name] = v
+		}
+		return record, nil
+	case "array":
+		list := []any{}
+		err := r.blocks(func() error {
+			v, err := s.items.decode(r)
+			list = append(list, v)
+			return err
+		})
+		return list, err
+	case "map":
+		m := map[string]any{}
+		err := r.blocks(func() error {
+			n, err := r.long()
+			if err != nil {
+				return err
+			}
+			key, err := r.next(n)
+			if err != nil {
+				return err
+			}
+			v, err := s.values.decode(r)
+			m[string(key)] = v
+			return err
+		})
+		return m, err
+	case "union":
+		i, err := r.long()
+		if err != nil {
+			return nil, err
+		}
+		if i < 0 || i >= int64(len(s.branches)) {
+			return nil, fmt.Errorf("union has no branch %d", i)
+		}
+		return s.branches[i].decode(r)
+	default:
+		return nil, fmt.Errorf("unsupported type %q", s.typ)
+	}
+}
+
+// encode appends the Avro binary encoding of the value to b.
+// The value is the generic JSON representation (decoded with json.Number) of the data;
+// missing record fields take their schema defaults.
+func (s *avroSchema) encode(b []byte, value any) ([]byte, error) {
+	switch s.typ {
+	case "null":
+		if value != nil {
+			return nil, fmt.Errorf("expected null, got %T", value)
+		}
+		return b, nil
+	case "boolean":
+		v, ok := value.(bool)
+		if !ok {
+			return nil, fmt.Errorf("expected boolean, got %T", value)
+		}
+		if v {
+			return append(b, 1), nil
+		}
+		return append(b, 0), nil
+	case "int", "long":
+		v, err := s.integer(value)
+		if err != nil {
+			return nil, err
+		}
+		if s.typ == "int" && (v < math.MinInt32 || v > math.MaxInt32) {
+			return nil, fmt.Errorf("%d overflows int", v)
+		}
+		return binary.AppendVarint(b, v), nil
+	case "float", "double":
+		n, ok := value.(json.Number)
+		if !ok {
+			return nil, fmt.Errorf("expected number, got %T", value)
+		}
+		v, err := n.Float64()
+		if err != nil {
+			return nil, err
+		}
+		if s.typ == "float" {
+			return binary.LittleEndian.AppendUint32(b, math.Float32bits(float32(v))), nil
+		}
+		return binary.LittleEndian.AppendUint64(b, math.Float64bits(v)), nil
+	case "bytes", "string", "fixed":
+		v, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("expected string, got %T", value)
+		}
+		if s.typ == "fixed" {
+			if len(v) != s.size {
+				return nil, fmt.Errorf("fixed %s must be %d bytes long", s.name, s.size)
+			}
+			return append(b, v...), nil
+		}
+		b = binary.AppendVarint(b, int64(len(v)))
+		return append(b, v...), nil
+	case "enum":
+		v, _ := value.(string)
+		for i, symbol := range s.symbols {
+			if symbol == v {
+				return binary.AppendVarint(b, int64(i)), nil
+			}
+		}
+		return nil, fmt.Errorf("enum %s has no symbol %q", s.name, v)
+	case "record":
+		record, ok := value.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("expected record %s, got %T", s.name, value)
+		}
+		for _, f := range s.fields {
+			v, ok := record[f.name]
+			if !ok && f.hasDefault {
+				v = f.def
+			}
+			var err error
+			if b, err = f.schema.encode(b, v); err != nil {
+				return nil, fmt.Errorf("%s: %w", f.name, err)
+			}
+		}
+		return b, nil
+	case "array":
+		list, ok := value.([]any)
+		if !ok && value != nil {
+			return nil, fmt.Errorf("expected array, got %T", value)
+		}
+		if len(list) > 0 {
+			b = binary.AppendVarint(b, int64(len(list)))
+			for _, v := range list {
+				var err error
+				if b, err = s.items.encode(b, v); err != nil {
+					return nil, err
+				}
+			}
+		}
+		return binary.AppendVarint(b, 0), nil
+	case "map":
+		m, ok := value.(map[string]any)
+		if !ok && value != nil {
+			return nil, fmt.Errorf("expected map, got %T", value)
+		}
+		if len(m) > 0 {
+			b = binary.AppendVarint(b, int64(len(m)))
+			for key, v := range m {
+				b = binary.AppendVarint(b, int64(len(key)))
+				b = append(b, key...)
+				var err error
+				if b, err = s.values.encode(b, v); err != nil {
+					return nil, err
+				}
+			}
+		}
+		return binary.AppendVarint(b, 0), nil
+	case "union":
+		for i, branch := range s.branches {
+			if branch.accepts(value) {
+				return branch.encode(binary.AppendVarint(b, int64(i)), value)
+			}
+		}
+		return nil, fmt.Errorf("no union branch accepts %T", value)
+	default:
+		return nil, fmt.Errorf("unsupported type %q", s.typ)
+	}
+}
+
+// integer converts the value of an int or long; timestamps are accepted in RFC 3339.
+func (s *avroSchema) integer(value any) (int64, error) {
+	switch v := value.(type) {
+	case json.Number:
+		return v.Int64()
+	case string:
+		t, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			return 0, err
+		}
+		switch s.logicalType {
+		case "timestamp-millis":
+			return t.UnixMilli(), nil
+		case "timestamp-micros":
+			return t.UnixMicro(), nil
+		}
+		return 0, fmt.Errorf("expected number, got %q", v)
+	default:
+		return 0, fmt.Errorf("expected number, got %T", value)
+	}
+}
+
+// accepts reports whether the value can be encoded with the schema; it selects the branch of a union.
+func (s *avroSchema) accepts(value any) bool {
+	switch value.(type) {
+	case nil:
+		return s.typ == "null"
+	case bool:
+		return s.typ == "boolean"
+	case json.Number:
+		return s.typ == "int" || s.typ == "long" || s.typ == "float" || s.typ == "double"
+	case string:
+		return s.typ == "string" || s.typ == "bytes" || s.typ == "enum" || s.typ == "fixed" || s.logicalType != ""
+	case []any:
+		return s.typ == "array"
+	case map[string]any:
+		return s.typ == "record" || s.typ == "map"
+	default:
+		return false
+	}
+}
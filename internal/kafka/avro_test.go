@@ -0,0 +1,247 @@
+package kafka
+
+import (
+	"context"
+	"demo_service/internal/config"
+	"demo_service/internal/models"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// stubRegistry is a SchemaRegistry keeping the registered schemas in memory.
+// While failing is set it returns errUnreachable, and while block is open Schema waits on it.
+type stubRegistry struct {
+	mu      sync.Mutex
+	schemas map[int]string
+	failing bool
+	block   chan struct{}
+	fetches atomic.Int32
+}
+
+var errUnreachable = errors.New("connection refused")
+
+func newStubRegistry() *stubRegistry {
+	return &stubRegistry{schemas: make(map[int]string)}
+}
+
+func (r *stubRegistry) Schema(ctx context.Context, id int) (string, error) {
+	r.fetches.Add(1)
+	r.mu.Lock()
+	block := r.block
+	r.mu.Unlock()
+	if block != nil {
+		select {
+		case <-block:
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.failing {
+		return "", errUnreachable
+	}
+	schema, ok := r.schemas[id]
+	if !ok {
+		return "", ErrSchemaNotFound
+	}
+	return schema, nil
+}
+
+func (r *stubRegistry) Register(_ context.Context, _, schema string) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.failing {
+		return 0, errUnreachable
+	}
+	id := len(r.schemas) + 1
+	r.schemas[id] = schema
+	return id, nil
+}
+
+// encodeAvro encodes an order with a codec of its own, so the decoding codec has no schema cached.
+func encodeAvro(t *testing.T, registry SchemaRegistry, uid string) []byte {
+	t.Helper()
+
+	codec, err := NewAvroCodec(registry, "orders-value")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := codec.Encode(models.Order{OrderUID: uid})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestAvroCodecRoundTrip(t *testing.T) {
+	registry := newStubRegistry()
+	data := encodeAvro(t, registry, "order-1")
+
+	codec, err := NewAvroCodec(registry, "orders-value")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 2 {
+		order, err := codec.Decode(context.Background(), data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if order.OrderUID != "order-1" {
+			t.Errorf("OrderUID = %q; want order-1", order.OrderUID)
+		}
+	}
+	if n := registry.fetches.Load(); n != 1 {
+		t.Errorf("registry fetches = %d; want 1, the schema is cached", n)
+	}
+}
+
+func TestAvroCodecRegistryUnavailable(t *testing.T) {
+	registry := newStubRegistry()
+	data := encodeAvro(t, registry, "order-1")
+
+	codec, err := NewAvroCodec(registry, "orders-value")
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry.mu.Lock()
+	registry.failing = true
+	registry.mu.Unlock()
+
+	_, err = codec.Decode(context.Background(), data)
+	if !errors.Is(err, ErrRegistryUnavailable) || !errors.Is(err, errUnreachable) {
+		t.Fatalf("Decode error = %v; want ErrRegistryUnavailable wrapping the registry error", err)
+	}
+
+	// Неудачная загрузка не кэшируется: после восстановления реестра сообщение декодируется
+	registry.mu.Lock()
+	registry.failing = false
+	registry.mu.Unlock()
+	if _, err := codec.Decode(context.Background(), data); err != nil {
+		t.Fatalf("Decode after the registry recovered: %v", err)
+	}
+}
+
+func TestAvroCodecUnknownSchema(t *testing.T) {
+	registry := newStubRegistry()
+	data := encodeAvro(t, registry, "order-1")
+	data[4] = 42 // schema ID 42 is not registered
+
+	codec, err := NewAvroCodec(registry, "orders-value")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = codec.Decode(context.Background(), data)
+	if !errors.Is(err, ErrSchemaNotFound) || errors.Is(err, ErrRegistryUnavailable) {
+		t.Fatalf("Decode error = %v; want ErrSchemaNotFound only", err)
+	}
+}
+
+func TestAvroCodecFetchesSchemaOnce(t *testing.T) {
+	registry := newStubRegistry()
+	data := encodeAvro(t, registry, "order-1")
+
+	codec, err := NewAvroCodec(registry, "orders-value")
+	if err != nil {
+		t.Fatal(err)
+	}
+	block := make(chan struct{})
+	registry.mu.Lock()
+	registry.block = block
+	registry.mu.Unlock()
+
+	const decoders = 8
+	errs := make(chan error, decoders)
+	for range decoders {
+		go func() {
+			_, err := codec.Decode(context.Background(), data)
+			errs <- err
+		}()
+	}
+
+	// Пока схема загружается, кодек не заблокирован: кодирование не ждёт реестр
+	deadline := time.Now().Add(time.Second)
+	for registry.fetches.Load() == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if _, err := codec.Encode(models.Order{OrderUID: "order-2"}); err != nil {
+		t.Fatal(err)
+	}
+
+	close(block)
+	for range decoders {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := registry.fetches.Load(); n != 1 {
+		t.Errorf("registry fetches = %d; want 1 shared by concurrent decoders", n)
+	}
+}
+
+func TestAvroCodecDecodeCanceled(t *testing.T) {
+	registry := newStubRegistry()
+	data := encodeAvro(t, registry, "order-1")
+
+	codec, err := NewAvroCodec(registry, "orders-value")
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry.mu.Lock()
+	registry.block = make(chan struct{})
+	registry.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = codec.Decode(ctx, data)
+	if !errors.Is(err, ErrRegistryUnavailable) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Decode error = %v; want ErrRegistryUnavailable wrapping the context error", err)
+	}
+}
+
+func TestRegistryClientErrors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/schemas/ids/1":
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"error_code":40403,"message":"Schema not found"}`))
+		default:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	codec, err := NewAvroCodec(NewRegistryClient(config.SchemaRegistry{URL: srv.URL, Timeout: time.Second}), "orders-value")
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := func(id byte) []byte { return []byte{avroMagicByte, 0, 0, 0, id} }
+
+	if _, err := codec.Decode(context.Background(), payload(1)); !errors.Is(err, ErrSchemaNotFound) {
+		t.Errorf("Decode error = %v; want ErrSchemaNotFound for 404", err)
+	}
+	if _, err := codec.Decode(context.Background(), payload(2)); !errors.Is(err, ErrRegistryUnavailable) {
+		t.Errorf("Decode error = %v; want ErrRegistryUnavailable for 503", err)
+	}
+}
+
+func TestDecodeFailedRegistryUnavailable(t *testing.T) {
+	message := &sarama.ConsumerMessage{Topic: "orders", Value: []byte{avroMagicByte}}
+
+	// Недоступность реестра не отправляет сообщение в DLQ даже без режима at-least-once
+	h := &kafkaConsumerHandler{}
+	if h.decodeFailed(message, errors.Join(ErrRegistryUnavailable, errUnreachable)) {
+		t.Error("decodeFailed = true for an unavailable registry; want the message to be redelivered")
+	}
+	if !h.decodeFailed(message, errors.New("invalid avro order")) {
+		t.Error("decodeFailed = false for an invalid payload without a dead-letter topic in at-most-once mode")
+	}
+}
@@ -0,0 +1,145 @@
+package kafka
+
+import (
+	"context"
+	"demo_service/internal/models"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// consumeBatches accumulates messages from the claim until the batch is full
+// or the linger time since the first message of the batch has passed, then processes the batch.
+// After a batch is processed, the offset of its last message is marked
+// (and committed in at-least-once mode). If the batch cannot be processed in at-least-once mode,
+// the session is aborted so no offset advances past it.
+func (h *kafkaConsumerHandler) consumeBatches(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	const fn = "consumeBatches"
+
+	size := h.batchSize
+	if size < 1 {
+		size = 1
+	}
+	batch := make([]*sarama.ConsumerMessage, 0, size)
+
+	linger := time.NewTimer(h.batchLinger)
+	linger.Stop()
+	defer linger.Stop()
+
+	flush := func() bool {
+		defer func() { batch = batch[:0] }()
+		linger.Stop()
+
+		if len(batch) == 0 {
+			return true
+		}
+		last := batch[len(batch)-1]
+		if !h.processBatch(session, batch) {
+			if session.Context().Err() == nil {
+				log.Printf("(%s) | Batch up to offset %d of %s/%d is not committed, aborting session\n",
+					fn, last.Offset, last.Topic, last.Partition)
+				h.abort()
+			}
+			return false
+		}
+		session.MarkMessage(last, "")
+		if h.atLeastOnce {
+			session.Commit()
+		}
+		return true
+	}
+
+	for {
+		select {
+		case message, ok := <-claim.Messages():
+			if !ok {
+				flush()
+				return nil
+			}
+			batch = append(batch, message)
+			if len(batch) == 1 {
+				linger.Reset(h.batchLinger)
+			}
+			if len(batch) >= size && !flush() {
+				return nil
+			}
+		case <-linger.C:
+			if !flush() {
+				return nil
+			}
+		case <-session.Context().Done():
+			return nil
+		}
+	}
+}
+
+// processBatch decodes the messages and passes the orders to the batch handler.
+// Tombstones split the batch: the orders received before a tombstone are processed first.
+// It returns true if the outcome for every message of the batch is final.
+func (h *kafkaConsumerHandler) processBatch(session sarama.ConsumerGroupSession, batch []*sarama.ConsumerMessage) bool {
+	orders := make([]models.Order, 0, len(batch))
+	messages := make([]*sarama.ConsumerMessage, 0, len(batch))
+	for _, message := range batch {
+		if message.Value == nil {
+			if !h.processOrders(session, orders, messages) || !h.processTombstone(session, message) {
+				return false
+			}
+			orders, messages = orders[:0], messages[:0]
+			continue
+		}
+
+		order, err := h.decode(session.Context(), message)
+		if err != nil {
+			if !h.decodeFailed(message, err) {
+				return false
+			}
+			continue
+		}
+		orders = append(orders, order)
+		messages = append(messages, message)
+	}
+	return h.processOrders(session, orders, messages)
+}
+
+// processOrders passes the decoded orders to the batch handler.
+// If the batch as a whole fails, it is split and every order is processed on its own,
+// so a single bad order does not fail the others; the failed ones go to the dead-letter topic.
+// It returns true if the outcome for every order is final.
+func (h *kafkaConsumerHandler) processOrders(session sarama.ConsumerGroupSession, orders []models.Order, messages []*sarama.ConsumerMessage) bool {
+	const fn = "processOrders"
+
+	if len(orders) == 0 {
+		return true
+	}
+
+	interrupted := func(err error) bool {
+		if errors.Is(err, context.Canceled) || session.Context().Err() != nil {
+			log.Printf("(%s) | Processing interrupted, batch is not marked: %v\n", fn, err)
+			return true
+		}
+		return false
+	}
+
+	err := h.batchHandler(orders)
+	if err == nil {
+		return true
+	}
+	if interrupted(err) {
+		return false
+	}
+	if len(orders) == 1 {
+		return h.processFailed(messages[0], err)
+	}
+
+	log.Printf("(%s) | Batch of %d orders failed, processing one by one: %v\n", fn, len(orders), err)
+	for i, order := range orders {
+		if err := h.batchHandler([]models.Order{order}); err != nil {
+			if interrupted(err) || !h.processFailed(messages[i], err) {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,145 @@
+package kafka
+
+import (
+	"context"
+	"demo_service/internal/config"
+	"demo_service/internal/models"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/IBM/sarama"
+)
+
+// HeaderContentType is the message header that names the payload format.
+// Both the content type and the codec name are accepted as its value.
+const HeaderContentType = "content-type"
+
+// Codec names used in the configuration.
+const (
+	CodecJSON     = "json"
+	CodecProtobuf = "protobuf"
+	CodecAvro     = "avro"
+)
+
+// Content types set in the HeaderContentType header.
+const (
+	ContentTypeJSON     = "application/json"
+	ContentTypeProtobuf = "application/x-protobuf"
+	ContentTypeAvro     = "application/avro"
+)
+
+// Codec converts orders to and from a message payload format.
+type Codec interface {
+	// Name returns the name of the codec used in the configuration.
+	Name() string
+	// ContentType returns the value of the HeaderContentType header for payloads of the codec.
+	ContentType() string
+	// Encode serializes the order into a message payload.
+	Encode(order models.Order) ([]byte, error)
+	// Decode deserializes a message payload into an order.
+	// The context bounds the lookups the codec may need, such as fetching a schema from the registry.
+	Decode(ctx context.Context, data []byte) (models.Order, error)
+}
+
+// Codecs selects the codec of a message by its HeaderContentType header,
+// falling back to the configured default codec for messages without one.
+type Codecs struct {
+	codecs   []Codec
+	fallback Codec
+}
+
+// NewCodecs creates the codecs available with the broker configuration:
+// JSON and Protobuf are always available, Avro only with a schema registry URL.
+// It returns an error if the configured default codec is unknown or unavailable.
+func NewCodecs(brokerCfg config.Broker) (*Codecs, error) {
+	const fn = "NewCodecs"
+
+	codecs := []Codec{JSONCodec{}, ProtobufCodec{}}
+	if brokerCfg.SchemaRegistry.URL != "" {
+		avro, err := NewAvroCodec(NewRegistryClient(brokerCfg.SchemaRegistry), brokerCfg.SchemaRegistry.Subject)
+		if err != nil {
+			return nil, fmt.Errorf("(%s) | %w", fn, err)
+		}
+		codecs = append(codecs, avro)
+	}
+
+	c := &Codecs{codecs: codecs}
+	fallback, ok := c.Get(brokerCfg.Codec)
+	if !ok {
+		return nil, fmt.Errorf("(%s) | unknown or unavailable codec: %q", fn, brokerCfg.Codec)
+	}
+	c.fallback = fallback
+	return c, nil
+}
+
+// Get returns the codec with the given name or content type.
+func (c *Codecs) Get(name string) (Codec, bool) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for _, codec := range c.codecs {
+		if name == codec.Name() || name == codec.ContentType() {
+			return codec, true
+		}
+	}
+	return nil, false
+}
+
+// All returns all available codecs.
+func (c *Codecs) All() []Codec {
+	return c.codecs
+}
+
+// Default returns the codec used for messages without a content type.
+func (c *Codecs) Default() Codec {
+	return c.fallback
+}
+
+// Decode selects the codec of the message by its content type header and decodes its value.
+func (c *Codecs) Decode(ctx context.Context, message *sarama.ConsumerMessage) (models.Order, error) {
+	var contentType string
+	for _, header := range message.Headers {
+		if header != nil && strings.EqualFold(string(header.Key), HeaderContentType) {
+			contentType = string(header.Value)
+			break
+		}
+	}
+	return c.DecodeContentType(ctx, contentType, message.Value)
+}
+
+// DecodeContentType decodes the payload with the codec of the content type,
+// or with the default codec if the content type is empty.
+func (c *Codecs) DecodeContentType(ctx context.Context, contentType string, data []byte) (models.Order, error) {
+	codec := c.fallback
+	if contentType != "" {
+		// Параметры (например, "; charset=utf-8") не влияют на выбор кодека
+		name, _, _ := strings.Cut(contentType, ";")
+		var ok bool
+		if codec, ok = c.Get(name); !ok {
+			return models.Order{}, fmt.Errorf("unsupported content type: %q", contentType)
+		}
+	}
+	return codec.Decode(ctx, data)
+}
+
+// JSONCodec encodes orders as JSON, the format of model.json.
+type JSONCodec struct{}
+
+// Name implements Codec.
+func (JSONCodec) Name() string { return CodecJSON }
+
+// ContentType implements Codec.
+func (JSONCodec) ContentType() string { return ContentTypeJSON }
+
+// Encode implements Codec.
+func (JSONCodec) Encode(order models.Order) ([]byte, error) {
+	return json.Marshal(order)
+}
+
+// Decode implements Codec.
+func (JSONCodec) Decode(_ context.Context, data []byte) (models.Order, error) {
+	var order models.Order
+	if err := json.Unmarshal(data, &order); err != nil {
+		return models.Order{}, err
+	}
+	return order, nil
+}
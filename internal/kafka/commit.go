@@ -0,0 +1,75 @@
+package kafka
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// commitInterval is the interval between the offset commits in at-least-once mode.
+const commitInterval = time.Second
+
+// offsetCommitter marks the messages of a claim whose outcome is final and, in at-least-once mode,
+// commits the marked offsets every commitInterval and once more when it is closed,
+// rather than after every message: a commit is a round trip to the broker.
+// Since only final messages are marked, a commit never advances past a failed message.
+type offsetCommitter struct {
+	session sarama.ConsumerGroupSession
+	commit  bool
+	marked  atomic.Bool // offsets have been marked since the last commit
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// newOffsetCommitter creates the committer of the session; commit enables the explicit commits.
+func newOffsetCommitter(session sarama.ConsumerGroupSession, commit bool) *offsetCommitter {
+	c := &offsetCommitter{
+		session: session,
+		commit:  commit,
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	if !commit {
+		close(c.done)
+		return c
+	}
+
+	go func() {
+		defer close(c.done)
+
+		ticker := time.NewTicker(commitInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				c.flush()
+			case <-c.stop:
+				return
+			}
+		}
+	}()
+	return c
+}
+
+// mark marks the message as processed; its offset is committed with the next commit.
+func (c *offsetCommitter) mark(message *sarama.ConsumerMessage) {
+	c.session.MarkMessage(message, "")
+	if c.commit {
+		c.marked.Store(true)
+	}
+}
+
+// close stops the periodic commits and commits the offsets marked since the last one.
+func (c *offsetCommitter) close() {
+	close(c.stop)
+	<-c.done
+	c.flush()
+}
+
+// flush commits the marked offsets, if any.
+func (c *offsetCommitter) flush() {
+	if c.marked.Swap(false) {
+		c.session.Commit()
+	}
+}
@@ -5,6 +5,9 @@
 // The ConsumerAdapter listens for messages from the specified topic,
 // processes them using a provided handler function, and manages Kafka consumer group sessions.
 //
+// Message payloads are decoded by a Codec (JSON, Protobuf or Avro) selected
+// by the content-type header of the message or by the configuration.
+//
 // The package supports graceful shutdown and error handling during message processing.
 package kafka
 
@@ -12,66 +15,232 @@ import (
 	"context"
 	"demo_service/internal/config"
 	"demo_service/internal/models"
-	"encoding/json"
+	"demo_service/internal/retry"
+	"demo_service/internal/rules"
+	"demo_service/internal/validation"
+	"errors"
 	"fmt"
 	"log"
+	"time"
 
 	"github.com/IBM/sarama"
 )
 
+// MessageHandler processes a single decoded order.
+// The order's Source holds the metadata of the message it was decoded from.
+type MessageHandler func(order models.Order) error
+
+// BatchHandler processes a batch of decoded orders as a whole.
+type BatchHandler func(orders []models.Order) error
+
+// TombstoneHandler processes a tombstone: a message with a null value that removes the order
+// identified by the message key. With the HeaderOrderAction header set to ActionAnonymize,
+// the order is anonymized instead of deleted.
+type TombstoneHandler func(tombstone models.Tombstone) error
+
+// HeaderOrderAction is the tombstone header that selects the action applied to the order.
+const HeaderOrderAction = "x-order-action"
+
+// ActionAnonymize is the HeaderOrderAction value that anonymizes the order instead of deleting it.
+const ActionAnonymize = "anonymize"
+
 // ConsumerAdapter represents a Kafka consumer group that consumes messages from a specific topic.
 // It wraps around the `sarama.ConsumerGroup` and holds the topic name to facilitate message consumption.
 type ConsumerAdapter struct {
+	client        sarama.Client
 	consumerGroup sarama.ConsumerGroup
+	lifecycle     *lifecycle
 	topic         string
+	codecs        *Codecs
+	dlq           *DeadLetterQueue
+	tombstones    TombstoneHandler
+	atLeastOnce   bool
+	workers       int
+	batchSize     int
+	batchLinger   time.Duration
 }
 
+// rejoinDelay is the pause before rejoining the group after a session
+// has been aborted because a message could not be processed.
+const rejoinDelay = 5 * time.Second
+
 // NewConsumerAdapter creates a new instance of ConsumerAdapter,
 // which is a Kafka consumer group that consumes messages from a specified topic.
 //
 // It sets up the necessary Kafka consumer configurations,
 // including the version and rebalance strategy,
 // and initializes the consumer group for the provided brokers.
+//
+// In at-least-once mode auto-commit is disabled and offsets are committed
+// explicitly, only up to the messages that have been processed.
 func NewConsumerAdapter(brokerCfg config.Broker) (*ConsumerAdapter, error) {
 	const fn = "NewConsumerAdapter"
 
+	codecs, err := NewCodecs(brokerCfg)
+	if err != nil {
+		return nil, fmt.Errorf("(%s) | %w", fn, err)
+	}
+
 	config := sarama.NewConfig()
 	config.Version = sarama.V3_6_0_0 // Установите версию Kafka
 	config.Consumer.Group.Rebalance.Strategy = sarama.NewBalanceStrategyRoundRobin()
+	if brokerCfg.AtLeastOnce {
+		config.Consumer.Offsets.AutoCommit.Enable = false
+	}
 
-	consumerGroup, err := sarama.NewConsumerGroup(brokerCfg.Hosts, brokerCfg.GroupID, config)
+	client, err := sarama.NewClient(brokerCfg.Hosts, config)
 	if err != nil {
 		return nil, fmt.Errorf("(%s) | Error creating ConsumerAdapter: %w", fn, err)
 	}
+	consumerGroup, err := sarama.NewConsumerGroupFromClient(brokerCfg.GroupID, client)
+	if err != nil {
+		client.Close()
+		return nil, fmt.Errorf("(%s) | Error creating ConsumerAdapter: %w", fn, err)
+	}
 
-	log.Printf("(%s) | Kafka created!\n", fn)
-
-	return &ConsumerAdapter{
+	adapter := &ConsumerAdapter{
+		client:        client,
 		consumerGroup: consumerGroup,
+		lifecycle:     &lifecycle{client: client, group: consumerGroup, topic: brokerCfg.Topic},
 		topic:         brokerCfg.Topic,
-	}, nil
+		codecs:        codecs,
+		atLeastOnce:   brokerCfg.AtLeastOnce,
+		workers:       brokerCfg.Workers,
+		batchSize:     brokerCfg.Batch.Size,
+		batchLinger:   brokerCfg.Batch.Linger,
+	}
+
+	if brokerCfg.DLQ.Enabled {
+		producer, err := newDeadLetterProducer(brokerCfg.Hosts)
+		if err != nil {
+			consumerGroup.Close()
+			client.Close()
+			return nil, fmt.Errorf("(%s) | %w", fn, err)
+		}
+		adapter.dlq = NewDeadLetterQueue(producer, brokerCfg.DLQ.Topic)
+	}
+
+	log.Printf("(%s) | Kafka created!\n", fn)
+
+	return adapter, nil
+}
+
+// SetDeadLetterQueue replaces the dead-letter queue used for messages that fail processing.
+// Passing nil disables the dead-letter mechanism. It must be called before Start.
+func (k *ConsumerAdapter) SetDeadLetterQueue(dlq *DeadLetterQueue) {
+	k.dlq = dlq
+}
+
+// SetCodecs replaces the codecs used to decode message payloads. It must be called before Start.
+func (k *ConsumerAdapter) SetCodecs(codecs *Codecs) {
+	k.codecs = codecs
+}
+
+// SetTombstoneHandler sets the handler of tombstones (messages with a null value).
+// Without it, tombstones are skipped. It must be called before Start.
+func (k *ConsumerAdapter) SetTombstoneHandler(handler TombstoneHandler) {
+	k.tombstones = handler
+}
+
+// Pause stops fetching messages from all partitions claimed by this member until Resume is called.
+// The pause holds across rebalances: newly claimed partitions are paused as well.
+// Other members of the group are not paused.
+func (k *ConsumerAdapter) Pause() {
+	const fn = "Pause"
+
+	k.lifecycle.pause()
+	log.Printf("(%s) | Consumption paused\n", fn)
+}
+
+// Resume resumes fetching messages from all partitions claimed by this member.
+func (k *ConsumerAdapter) Resume() {
+	const fn = "Resume"
+
+	k.lifecycle.resume()
+	log.Printf("(%s) | Consumption resumed\n", fn)
+}
+
+// Paused reports whether consumption is paused.
+func (k *ConsumerAdapter) Paused() bool {
+	return k.lifecycle.isPaused()
+}
+
+// ResetOffsets moves the committed offsets of the group to the target (earliest, an offset or a timestamp).
+//
+// The current session is restarted and the reset is applied when the next one is set up,
+// before any partition starts consuming, so messages already in flight cannot commit over it.
+// Every partition of the topic is reset at once, so the reset waits for a session that claims all of them:
+// the other members of the group have to be stopped first.
+// It blocks until the reset is applied or ctx is done; if partitions are still claimed by other members then,
+// the reset is withdrawn and an error wrapping ErrPartitionsNotClaimed is returned.
+func (k *ConsumerAdapter) ResetOffsets(ctx context.Context, target ResetTarget) (ResetResult, error) {
+	return k.lifecycle.resetOffsets(ctx, target)
 }
 
 // Start begins the consumption of messages from the Kafka topic.
 // It continuously consumes messages using the provided consumer group
 // and processes them using the provided message handler function.
 //
+// With more than one configured worker, the messages of a partition are processed concurrently
+// by a pool of workers, keeping the order of messages per order UID.
+//
 // The consumer will keep running in a loop until the provided context is canceled or an error occurs.
-func (k *ConsumerAdapter) Start(ctx context.Context, messageHandler func(order models.Order) error) {
-	const fn = "Start"
+// If a session is aborted because a message could not be processed in at-least-once mode,
+// the consumer rejoins the group after a short delay and resumes from the last committed offset.
+func (k *ConsumerAdapter) Start(ctx context.Context, messageHandler MessageHandler) {
+	k.consume(ctx, &kafkaConsumerHandler{
+		messageHandler:   messageHandler,
+		tombstoneHandler: k.tombstones,
+		codecs:           k.codecs,
+		workers:          k.workers,
+		dlq:              k.dlq,
+		atLeastOnce:      k.atLeastOnce,
+	})
+}
 
-	handler := &kafkaConsumerHandler{
-		messageHandler: messageHandler,
-	}
+// StartBatch works like Start, but accumulates up to the configured batch size of messages
+// or waits for the configured linger time, and passes the decoded orders to the batch handler at once.
+// Offsets are marked (and committed in at-least-once mode) per batch.
+func (k *ConsumerAdapter) StartBatch(ctx context.Context, batchHandler BatchHandler) {
+	k.consume(ctx, &kafkaConsumerHandler{
+		batchHandler:     batchHandler,
+		tombstoneHandler: k.tombstones,
+		codecs:           k.codecs,
+		batchSize:        k.batchSize,
+		batchLinger:      k.batchLinger,
+		dlq:              k.dlq,
+		atLeastOnce:      k.atLeastOnce,
+	})
+}
+
+// consume runs consumer group sessions with the handler until ctx is canceled.
+func (k *ConsumerAdapter) consume(ctx context.Context, handler *kafkaConsumerHandler) {
+	const fn = "consume"
 
 	for {
-		if err := k.consumerGroup.Consume(ctx, []string{k.topic}, handler); err != nil {
+		sessionCtx, abort := context.WithCancel(ctx)
+		handler.abort = abort
+		handler.lifecycle = k.lifecycle
+		k.lifecycle.sessionStarted(abort)
+
+		if err := k.consumerGroup.Consume(sessionCtx, []string{k.topic}, handler); err != nil {
 			log.Printf("(%s) | Error reading messages: %v\n", fn, err)
 		}
+		aborted := sessionCtx.Err() != nil
+		abort()
+
 		// Если контекст завершен, выходим из цикла
 		if ctx.Err() != nil {
 			break
 		}
+		if aborted && !k.lifecycle.resetPending() {
+			log.Printf("(%s) | Session aborted, rejoining in %s\n", fn, rejoinDelay)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(rejoinDelay):
+			}
+		}
 	}
 }
 
@@ -79,17 +248,41 @@ func (k *ConsumerAdapter) Start(ctx context.Context, messageHandler func(order m
 //
 // This method ensures that the consumer group is closed and any ongoing consumption is properly terminated.
 func (k *ConsumerAdapter) Close() error {
-	return k.consumerGroup.Close()
+	const fn = "Close"
+
+	err := k.consumerGroup.Close()
+	if k.client != nil {
+		if clientErr := k.client.Close(); clientErr != nil && err == nil {
+			err = fmt.Errorf("(%s) | Error closing Kafka client: %w", fn, clientErr)
+		}
+	}
+	if k.dlq != nil {
+		if dlqErr := k.dlq.Close(); dlqErr != nil && err == nil {
+			err = fmt.Errorf("(%s) | Error closing DLQ producer: %w", fn, dlqErr)
+		}
+	}
+	return err
 }
 
 // kafkaConsumerHandler represents the Kafka message handler for the consumer,
 // which accepts messages from Kafka and passes them to the processing function.
 type kafkaConsumerHandler struct {
-	messageHandler func(order models.Order) error
+	messageHandler   MessageHandler
+	batchHandler     BatchHandler
+	tombstoneHandler TombstoneHandler
+	codecs           *Codecs
+	workers          int
+	batchSize        int
+	batchLinger      time.Duration
+	dlq              *DeadLetterQueue
+	atLeastOnce      bool
+	abort            context.CancelFunc
+	lifecycle        *lifecycle
 }
 
-// Setup - is called before the start of processing. Doesn't do anything yet.
-func (h *kafkaConsumerHandler) Setup(sarama.ConsumerGroupSession) error {
+// Setup - is called before the start of processing. Applies a pending offset reset.
+func (h *kafkaConsumerHandler) Setup(session sarama.ConsumerGroupSession) error {
+	h.lifecycle.setup(session)
 	return nil
 }
 
@@ -99,25 +292,187 @@ func (h *kafkaConsumerHandler) Cleanup(sarama.ConsumerGroupSession) error {
 }
 
 // ConsumeClaim processes messages from a Kafka consumer group claim.
-// It unmarshals each message's value into an `Order` object
+// It decodes each message's value into an `Order` object with the codec of the message
 // and processes it using the provided message handler.
 // After processing each message, it marks the message as processed in the session.
-// If there are errors in unmarshalling or processing,
-// they are logged but do not interrupt the consumption of further messages.
+// If there are errors in unmarshalling or processing, they are logged,
+// the message is republished to the dead-letter topic (if configured),
+// and the consumption of further messages continues.
+// A message whose processing was interrupted by the session shutting down is not marked,
+// so it is redelivered.
+//
+// In at-least-once mode a message is marked only once its outcome is final:
+// it has been processed or handed over to the dead-letter topic. Otherwise the session is aborted,
+// so no offset advances past the failed message and it is redelivered after rejoining.
+// The marked offsets are committed periodically and when the claim ends (see offsetCommitter).
 func (h *kafkaConsumerHandler) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
 	const fn = "ConsumeClaim"
-	for message := range claim.Messages() {
-		var order models.Order
-		if err := json.Unmarshal(message.Value, &order); err != nil {
-			log.Printf("(%s) | Error unmarshalling JSON: %v\n", fn, err)
-			continue
+
+	h.lifecycle.claimStarted(claim)
+	if h.batchHandler != nil {
+		return h.consumeBatches(session, claim)
+	}
+	if h.workers > 1 {
+		return h.consumeParallel(session, claim)
+	}
+
+	committer := newOffsetCommitter(session, h.atLeastOnce)
+	defer committer.close()
+	for {
+		select {
+		case message, ok := <-claim.Messages():
+			if !ok {
+				return nil
+			}
+			if !h.process(session, message) {
+				if session.Context().Err() == nil {
+					log.Printf("(%s) | Offset %d of %s/%d is not committed, aborting session\n",
+						fn, message.Offset, message.Topic, message.Partition)
+					h.abort()
+				}
+				return nil
+			}
+			committer.mark(message)
+		case <-session.Context().Done():
+			return nil
 		}
+	}
+}
+
+// process decodes the message and passes the order to the message handler.
+// It returns true if the outcome for the message is final and it may be marked.
+func (h *kafkaConsumerHandler) process(session sarama.ConsumerGroupSession, message *sarama.ConsumerMessage) bool {
+	const fn = "process"
+
+	if message.Value == nil {
+		return h.processTombstone(session, message)
+	}
 
-		if err := h.messageHandler(order); err != nil {
-			log.Printf("(%s) | Message processing error: %v\n", fn, err)
+	order, err := h.decode(session.Context(), message)
+	if err != nil {
+		return h.decodeFailed(message, err)
+	}
+
+	if err := h.messageHandler(order); err != nil {
+		if errors.Is(err, context.Canceled) || session.Context().Err() != nil {
+			log.Printf("(%s) | Processing interrupted, message is not marked: %v\n", fn, err)
+			return false
 		}
+		return h.processFailed(message, err)
+	}
+	return true
+}
+
+// processTombstone passes the tombstone to the tombstone handler.
+// It returns true if the outcome for the message is final and it may be marked.
+func (h *kafkaConsumerHandler) processTombstone(session sarama.ConsumerGroupSession, message *sarama.ConsumerMessage) bool {
+	const fn = "processTombstone"
 
-		session.MarkMessage(message, "")
+	if len(message.Key) == 0 {
+		return h.decodeFailed(message, errors.New("tombstone without an order UID key"))
 	}
-	return nil
+	if h.tombstoneHandler == nil {
+		log.Printf("(%s) | No tombstone handler, skipping tombstone for %s\n", fn, message.Key)
+		return true
+	}
+
+	tombstone := models.Tombstone{OrderUID: string(message.Key)}
+	for _, header := range message.Headers {
+		if header != nil && string(header.Key) == HeaderOrderAction && string(header.Value) == ActionAnonymize {
+			tombstone.Anonymize = true
+		}
+	}
+
+	if err := h.tombstoneHandler(tombstone); err != nil {
+		if errors.Is(err, context.Canceled) || session.Context().Err() != nil {
+			log.Printf("(%s) | Processing interrupted, message is not marked: %v\n", fn, err)
+			return false
+		}
+		return h.processFailed(message, err)
+	}
+	return true
+}
+
+// decode decodes the message value into an order and attaches the message metadata as its source.
+func (h *kafkaConsumerHandler) decode(ctx context.Context, message *sarama.ConsumerMessage) (models.Order, error) {
+	order, err := h.codecs.Decode(ctx, message)
+	if err != nil {
+		return models.Order{}, err
+	}
+	order.Source = messageSource(message)
+	return order, nil
+}
+
+// messageSource returns the ingestion metadata of the message:
+// its position, key, headers and timestamp.
+func messageSource(message *sarama.ConsumerMessage) *models.Source {
+	source := &models.Source{
+		Topic:     message.Topic,
+		Partition: message.Partition,
+		Offset:    message.Offset,
+		Key:       string(message.Key),
+		Timestamp: message.Timestamp,
+	}
+	if len(message.Headers) > 0 {
+		source.Headers = make(map[string]string, len(message.Headers))
+		for _, header := range message.Headers {
+			if header != nil {
+				source.Headers[string(header.Key)] = string(header.Value)
+			}
+		}
+	}
+	return source
+}
+
+// decodeFailed hands an undecodable message over to the dead-letter topic.
+// A message that could not be decoded because the schema registry is unavailable is not dead-lettered:
+// the outcome is not final, so the session is aborted and the message is redelivered after rejoining.
+// It returns true if the outcome for the message is final.
+func (h *kafkaConsumerHandler) decodeFailed(message *sarama.ConsumerMessage, err error) bool {
+	const fn = "decodeFailed"
+
+	if errors.Is(err, ErrRegistryUnavailable) {
+		log.Printf("(%s) | Message is not decoded, it will be redelivered: %v\n", fn, err)
+		return false
+	}
+	log.Printf("(%s) | Error decoding message: %v\n", fn, err)
+	return h.deadLetter(message, StageDecode, err, 1) || !h.atLeastOnce
+}
+
+// processFailed hands a message whose processing failed over to the dead-letter topic.
+// It returns true if the outcome for the message is final.
+func (h *kafkaConsumerHandler) processFailed(message *sarama.ConsumerMessage, err error) bool {
+	const fn = "processFailed"
+
+	log.Printf("(%s) | Message processing error: %v\n", fn, err)
+	return h.deadLetter(message, failureStage(err), err, retry.Attempts(err)) || !h.atLeastOnce
+}
+
+// failureStage returns the dead-letter stage that matches the processing error.
+func failureStage(err error) Stage {
+	var validationErrs validation.Errors
+	var rejected *rules.RejectedError
+	switch {
+	case errors.As(err, &validationErrs):
+		return StageValidate
+	case errors.As(err, &rejected):
+		return StageRules
+	default:
+		return StageProcess
+	}
+}
+
+// deadLetter republishes the message to the dead-letter topic if one is configured.
+// It returns true if the message has been handed over to the dead-letter topic.
+func (h *kafkaConsumerHandler) deadLetter(message *sarama.ConsumerMessage, stage Stage, cause error, attempts int) bool {
+	const fn = "deadLetter"
+
+	if h.dlq == nil {
+		return false
+	}
+	if err := h.dlq.Publish(message, stage, cause, attempts); err != nil {
+		log.Printf("(%s) | %v\n", fn, err)
+		return false
+	}
+	return true
 }
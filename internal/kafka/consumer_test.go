@@ -0,0 +1,162 @@
+package kafka
+
+import (
+	"context"
+	"demo_service/internal/config"
+	"demo_service/internal/models"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/IBM/sarama"
+)
+
+// fakeSession records the offsets marked or reset and the commits made in a consumer group session.
+type fakeSession struct {
+	ctx    context.Context
+	claims map[string][]int32
+
+	mu    sync.Mutex
+	calls []string
+}
+
+func (s *fakeSession) record(call string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls = append(s.calls, call)
+}
+
+func (s *fakeSession) recorded() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.calls)
+}
+
+func (s *fakeSession) Claims() map[string][]int32 { return s.claims }
+func (s *fakeSession) MemberID() string           { return "fake" }
+func (s *fakeSession) GenerationID() int32        { return 1 }
+func (s *fakeSession) ResetOffset(_ string, p int32, o int64, _ string) {
+	s.record(fmt.Sprintf("reset %d %d", p, o))
+}
+func (s *fakeSession) MarkOffset(_ string, _ int32, o int64, _ string) {
+	s.record(fmt.Sprintf("mark %d", o))
+}
+func (s *fakeSession) MarkMessage(m *sarama.ConsumerMessage, _ string) {
+	s.record(fmt.Sprintf("mark %d", m.Offset+1))
+}
+func (s *fakeSession) Commit()                  { s.record("commit") }
+func (s *fakeSession) Context() context.Context { return s.ctx }
+
+// fakeClaim delivers the messages of its channel.
+type fakeClaim struct {
+	messages chan *sarama.ConsumerMessage
+}
+
+func (c *fakeClaim) Topic() string                            { return "orders" }
+func (c *fakeClaim) Partition() int32                         { return 0 }
+func (c *fakeClaim) InitialOffset() int64                     { return 0 }
+func (c *fakeClaim) HighWaterMarkOffset() int64               { return 0 }
+func (c *fakeClaim) Messages() <-chan *sarama.ConsumerMessage { return c.messages }
+
+// consumeClaim runs ConsumeClaim of an at-least-once handler with the message handler over the orders
+// and returns the calls made to the session and whether the session has been aborted.
+func consumeClaim(t *testing.T, workers int, handle MessageHandler, uids ...string) ([]string, bool) {
+	t.Helper()
+
+	return runClaim(t, &kafkaConsumerHandler{
+		messageHandler: handle,
+		workers:        workers,
+		atLeastOnce:    true,
+		lifecycle:      &lifecycle{},
+	}, uids...)
+}
+
+// runClaim runs ConsumeClaim of the handler over the orders, with the JSON codec unless the handler has codecs,
+// and returns the calls made to the session and whether the session has been aborted.
+func runClaim(t *testing.T, handler *kafkaConsumerHandler, uids ...string) ([]string, bool) {
+	t.Helper()
+
+	if handler.codecs == nil {
+		codecs, err := NewCodecs(config.Broker{Codec: CodecJSON})
+		if err != nil {
+			t.Fatal(err)
+		}
+		handler.codecs = codecs
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	aborted := false
+	handler.abort = func() { aborted = true; cancel() }
+
+	claim := &fakeClaim{messages: make(chan *sarama.ConsumerMessage, len(uids))}
+	for i, uid := range uids {
+		value, err := json.Marshal(models.Order{OrderUID: uid})
+		if err != nil {
+			t.Fatal(err)
+		}
+		claim.messages <- &sarama.ConsumerMessage{Topic: "orders", Offset: int64(i), Key: []byte(uid), Value: value}
+	}
+	close(claim.messages)
+
+	session := &fakeSession{ctx: ctx}
+	if err := handler.ConsumeClaim(session, claim); err != nil {
+		t.Fatal(err)
+	}
+	return session.recorded(), aborted
+}
+
+func TestConsumeClaimFailureIsNotMarked(t *testing.T) {
+	calls, aborted := consumeClaim(t, 1, func(models.Order) error {
+		return errors.New("database is down")
+	}, "order-1", "order-2")
+
+	if len(calls) != 0 {
+		t.Errorf("session calls = %v; want none after a failed message", calls)
+	}
+	if !aborted {
+		t.Error("session has not been aborted")
+	}
+}
+
+func TestConsumeClaimFailureStopsAtFailedMessage(t *testing.T) {
+	calls, aborted := consumeClaim(t, 1, func(order models.Order) error {
+		if order.OrderUID == "order-2" {
+			return errors.New("database is down")
+		}
+		return nil
+	}, "order-1", "order-2", "order-3")
+
+	if want := []string{"mark 1", "commit"}; !slices.Equal(calls, want) {
+		t.Errorf("session calls = %v; want %v", calls, want)
+	}
+	if !aborted {
+		t.Error("session has not been aborted")
+	}
+}
+
+func TestConsumeClaimSuccessMarksThenCommits(t *testing.T) {
+	calls, aborted := consumeClaim(t, 1, func(models.Order) error { return nil }, "order-1")
+
+	if want := []string{"mark 1", "commit"}; !slices.Equal(calls, want) {
+		t.Errorf("session calls = %v; want %v", calls, want)
+	}
+	if aborted {
+		t.Error("session has been aborted")
+	}
+}
+
+func TestConsumeParallelCommitsContiguousOffsets(t *testing.T) {
+	calls, aborted := consumeClaim(t, 4, func(models.Order) error { return nil },
+		"order-1", "order-2", "order-3", "order-4", "order-5")
+
+	if aborted {
+		t.Fatal("session has been aborted")
+	}
+	if len(calls) < 2 || calls[len(calls)-2] != "mark 5" || calls[len(calls)-1] != "commit" {
+		t.Errorf("session calls = %v; want the last offset marked, then a commit", calls)
+	}
+}
@@ -0,0 +1,117 @@
+package kafka
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// Headers attached to every message republished to the dead-letter topic.
+const (
+	HeaderDLQStage     = "x-dlq-stage"
+	HeaderDLQError     = "x-dlq-error"
+	HeaderDLQTopic     = "x-dlq-source-topic"
+	HeaderDLQPartition = "x-dlq-source-partition"
+	HeaderDLQOffset    = "x-dlq-source-offset"
+	HeaderDLQAttempts  = "x-dlq-attempts"
+	HeaderDLQFailedAt  = "x-dlq-failed-at"
+)
+
+// Stage identifies the processing step at which a message failed.
+type Stage string
+
+const (
+	// StageDecode means the message value could not be decoded into an order.
+	StageDecode Stage = "decode"
+	// StageValidate means the order was rejected by the validation rules.
+	StageValidate Stage = "validate"
+	// StageRules means the order was rejected by the business rules.
+	StageRules Stage = "rules"
+	// StageProcess means the message handler (persistence, caching) returned an error.
+	StageProcess Stage = "process"
+)
+
+// DeadLetterQueue republishes messages that failed processing to a dedicated topic,
+// keeping the original key, value and headers and describing the failure in extra headers.
+type DeadLetterQueue struct {
+	producer sarama.SyncProducer
+	topic    string
+}
+
+// NewDeadLetterQueue creates a DeadLetterQueue that publishes to the given topic
+// using the provided producer. The producer can be a mock in tests.
+func NewDeadLetterQueue(producer sarama.SyncProducer, topic string) *DeadLetterQueue {
+	return &DeadLetterQueue{
+		producer: producer,
+		topic:    topic,
+	}
+}
+
+// newDeadLetterProducer creates a synchronous producer for the dead-letter topic.
+func newDeadLetterProducer(hosts []string) (sarama.SyncProducer, error) {
+	const fn = "newDeadLetterProducer"
+
+	config := sarama.NewConfig()
+	config.Version = sarama.V3_6_0_0
+	config.Producer.RequiredAcks = sarama.WaitForAll
+	config.Producer.Return.Successes = true
+
+	producer, err := sarama.NewSyncProducer(hosts, config)
+	if err != nil {
+		return nil, fmt.Errorf("(%s) | Error creating DLQ producer: %w", fn, err)
+	}
+	return producer, nil
+}
+
+// Publish sends the original message bytes to the dead-letter topic with headers
+// describing the failure stage, the error text, the source position and the attempt count.
+func (q *DeadLetterQueue) Publish(message *sarama.ConsumerMessage, stage Stage, cause error, attempts int) error {
+	const fn = "DeadLetterQueue.Publish"
+
+	headers := make([]sarama.RecordHeader, 0, len(message.Headers)+7)
+	for _, h := range message.Headers {
+		if h != nil {
+			headers = append(headers, *h)
+		}
+	}
+
+	errText := ""
+	if cause != nil {
+		errText = cause.Error()
+	}
+
+	headers = append(headers,
+		sarama.RecordHeader{Key: []byte(HeaderDLQStage), Value: []byte(stage)},
+		sarama.RecordHeader{Key: []byte(HeaderDLQError), Value: []byte(errText)},
+		sarama.RecordHeader{Key: []byte(HeaderDLQTopic), Value: []byte(message.Topic)},
+		sarama.RecordHeader{Key: []byte(HeaderDLQPartition), Value: []byte(strconv.FormatInt(int64(message.Partition), 10))},
+		sarama.RecordHeader{Key: []byte(HeaderDLQOffset), Value: []byte(strconv.FormatInt(message.Offset, 10))},
+		sarama.RecordHeader{Key: []byte(HeaderDLQAttempts), Value: []byte(strconv.Itoa(attempts))},
+		sarama.RecordHeader{Key: []byte(HeaderDLQFailedAt), Value: []byte(time.Now().UTC().Format(time.RFC3339Nano))},
+	)
+
+	dlqMessage := &sarama.ProducerMessage{
+		Topic:   q.topic,
+		Value:   sarama.ByteEncoder(message.Value),
+		Headers: headers,
+	}
+	if message.Key != nil {
+		dlqMessage.Key = sarama.ByteEncoder(message.Key)
+	}
+
+	if _, _, err := q.producer.SendMessage(dlqMessage); err != nil {
+		return fmt.Errorf("(%s) | Error publishing to %s: %w", fn, q.topic, err)
+	}
+
+	log.Printf("(%s) | Message %s/%d/%d moved to %s (stage: %s)\n",
+		fn, message.Topic, message.Partition, message.Offset, q.topic, stage)
+	return nil
+}
+
+// Close closes the underlying producer.
+func (q *DeadLetterQueue) Close() error {
+	return q.producer.Close()
+}
@@ -0,0 +1,304 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// Offset reset targets.
+const (
+	// ResetEarliest moves the group to the earliest available offset of every partition.
+	ResetEarliest = "earliest"
+	// ResetOffset moves the group to the given offset of every partition.
+	ResetOffset = "offset"
+	// ResetTimestamp moves the group to the first offset of every partition at or after the given time.
+	ResetTimestamp = "timestamp"
+)
+
+// ErrResetInProgress is returned by ResetOffsets while another reset has not been applied yet.
+var ErrResetInProgress = errors.New("offset reset is already in progress")
+
+// ErrPartitionsNotClaimed is returned by ResetOffsets if the reset could not be applied in time
+// because some partitions are claimed by other members of the group.
+var ErrPartitionsNotClaimed = errors.New("partitions are claimed by other members of the group")
+
+// ResetTarget selects the offsets ResetOffsets moves the group to.
+type ResetTarget struct {
+	To        string    `json:"to"`
+	Offset    int64     `json:"offset,omitempty"`
+	Timestamp time.Time `json:"timestamp,omitempty"`
+}
+
+// Validate checks that the target is complete.
+func (t ResetTarget) Validate() error {
+	switch t.To {
+	case ResetEarliest:
+		return nil
+	case ResetOffset:
+		if t.Offset < 0 {
+			return fmt.Errorf("offset must not be negative: %d", t.Offset)
+		}
+		return nil
+	case ResetTimestamp:
+		if t.Timestamp.IsZero() {
+			return errors.New("timestamp is required")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown reset target %q, expected %s, %s or %s",
+			t.To, ResetEarliest, ResetOffset, ResetTimestamp)
+	}
+}
+
+// ResetResult reports the offsets the partitions have been moved to.
+type ResetResult struct {
+	Offsets map[int32]int64 `json:"offsets"`
+}
+
+// pendingReset is an offset reset waiting to be applied at the start of a session
+// that claims every partition of the topic.
+type pendingReset struct {
+	target    ResetTarget
+	done      chan struct{}
+	result    ResetResult
+	err       error
+	unclaimed []int32 // partitions not claimed by the last session, guarded by lifecycle.mu
+}
+
+// offsetReader looks up the partitions of a topic and their offsets.
+// It is implemented by sarama.Client and by MemoryBroker.
+type offsetReader interface {
+	Partitions(topic string) ([]int32, error)
+	GetOffset(topic string, partitionID int32, time int64) (int64, error)
+}
+
+// lifecycle holds the administrative state of the consumer: the pause flag and a pending offset reset.
+//
+// Both survive rebalances: partitions claimed while paused are paused as soon as their claim starts,
+// and a reset is applied in the setup of a session, before any claim of the new generation starts consuming,
+// so committed offsets of the previous generation cannot overwrite it.
+//
+// Both are local to this member of the group: the pause does not stop the other members,
+// and a reset is only applied by a session that claims every partition of the topic,
+// so it moves all of them or none. It waits for such a session while other members are running.
+type lifecycle struct {
+	client offsetReader
+	group  sarama.ConsumerGroup
+	topic  string
+
+	mu     sync.Mutex
+	paused bool
+	reset  *pendingReset
+	abort  context.CancelFunc // aborts the current session
+}
+
+// pause stops fetching from all claimed partitions.
+func (l *lifecycle) pause() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.paused = true
+	l.group.PauseAll()
+}
+
+// resume resumes fetching from all claimed partitions.
+func (l *lifecycle) resume() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.paused = false
+	l.group.ResumeAll()
+}
+
+// isPaused reports whether the consumer is paused.
+func (l *lifecycle) isPaused() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.paused
+}
+
+// sessionStarted records the cancel function of the session being started.
+func (l *lifecycle) sessionStarted(abort context.CancelFunc) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.abort = abort
+}
+
+// resetPending reports whether a reset waits for the next session.
+func (l *lifecycle) resetPending() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.reset != nil
+}
+
+// claimStarted pauses the partition of a new claim if the consumer is paused.
+func (l *lifecycle) claimStarted(claim sarama.ConsumerGroupClaim) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.paused {
+		l.group.Pause(map[string][]int32{claim.Topic(): {claim.Partition()}})
+	}
+}
+
+// resetOffsets schedules the reset, restarts the session so it is applied, and waits for the result.
+func (l *lifecycle) resetOffsets(ctx context.Context, target ResetTarget) (ResetResult, error) {
+	if err := target.Validate(); err != nil {
+		return ResetResult{}, err
+	}
+
+	l.mu.Lock()
+	if l.reset != nil {
+		l.mu.Unlock()
+		return ResetResult{}, ErrResetInProgress
+	}
+	reset := &pendingReset{target: target, done: make(chan struct{})}
+	l.reset = reset
+	abort := l.abort
+	l.mu.Unlock()
+
+	if abort != nil {
+		abort()
+	}
+
+	select {
+	case <-reset.done:
+		return reset.result, reset.err
+	case <-ctx.Done():
+	}
+
+	l.mu.Lock()
+	withdrawn := l.reset == reset
+	if withdrawn {
+		l.reset = nil
+	}
+	unclaimed := reset.unclaimed
+	l.mu.Unlock()
+
+	if !withdrawn {
+		// Сброс уже применяется сессией
+		<-reset.done
+		return reset.result, reset.err
+	}
+	if len(unclaimed) > 0 {
+		return ResetResult{}, fmt.Errorf("%w: %v", ErrPartitionsNotClaimed, unclaimed)
+	}
+	return ResetResult{}, ctx.Err()
+}
+
+// setup applies the pending reset, if any, if the session claims every partition of the topic.
+// Otherwise the reset stays pending until a later session does.
+func (l *lifecycle) setup(session sarama.ConsumerGroupSession) {
+	const fn = "setup"
+
+	l.mu.Lock()
+	reset := l.reset
+	l.mu.Unlock()
+
+	if reset == nil {
+		return
+	}
+
+	partitions, err := l.client.Partitions(l.topic)
+	if err != nil {
+		l.finishReset(reset, ResetResult{}, fmt.Errorf("(%s) | failed to list partitions of %s: %w", fn, l.topic, err))
+		return
+	}
+
+	claimed := session.Claims()[l.topic]
+	var unclaimed []int32
+	for _, partition := range partitions {
+		if !slices.Contains(claimed, partition) {
+			unclaimed = append(unclaimed, partition)
+		}
+	}
+	if len(unclaimed) > 0 {
+		l.mu.Lock()
+		reset.unclaimed = unclaimed
+		l.mu.Unlock()
+		log.Printf("(%s) | Offset reset deferred: partitions %v of %s are claimed by other members\n", fn, unclaimed, l.topic)
+		return
+	}
+
+	// Сначала вычисляем все смещения, чтобы при ошибке не сбросить партиции частично
+	result := ResetResult{Offsets: make(map[int32]int64, len(partitions))}
+	for _, partition := range partitions {
+		offset, err := l.targetOffset(partition, reset.target)
+		if err != nil {
+			l.finishReset(reset, ResetResult{}, fmt.Errorf("(%s) | failed to resolve offset of %s/%d: %w", fn, l.topic, partition, err))
+			return
+		}
+		result.Offsets[partition] = offset
+	}
+
+	if !l.takeReset(reset) {
+		return // withdrawn by ResetOffsets
+	}
+	for partition, offset := range result.Offsets {
+		session.ResetOffset(l.topic, partition, offset, "")
+		log.Printf("(%s) | Offset of %s/%d reset to %d\n", fn, l.topic, partition, offset)
+	}
+	session.Commit()
+	reset.result = result
+	close(reset.done)
+}
+
+// takeReset removes the reset from the pending state. It returns false if it has been withdrawn.
+func (l *lifecycle) takeReset(reset *pendingReset) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.reset != reset {
+		return false
+	}
+	l.reset = nil
+	return true
+}
+
+// finishReset completes the pending reset with the result, unless it has been withdrawn.
+func (l *lifecycle) finishReset(reset *pendingReset, result ResetResult, err error) {
+	if !l.takeReset(reset) {
+		return
+	}
+	reset.result, reset.err = result, err
+	close(reset.done)
+}
+
+// targetOffset resolves the offset of the partition for the target, within the available range.
+func (l *lifecycle) targetOffset(partition int32, target ResetTarget) (int64, error) {
+	oldest, err := l.client.GetOffset(l.topic, partition, sarama.OffsetOldest)
+	if err != nil {
+		return 0, err
+	}
+	newest, err := l.client.GetOffset(l.topic, partition, sarama.OffsetNewest)
+	if err != nil {
+		return 0, err
+	}
+
+	switch target.To {
+	case ResetOffset:
+		return min(max(target.Offset, oldest), newest), nil
+	case ResetTimestamp:
+		offset, err := l.client.GetOffset(l.topic, partition, target.Timestamp.UnixMilli())
+		if err != nil {
+			return 0, err
+		}
+		if offset < 0 {
+			// Нет сообщений позже указанного времени
+			return newest, nil
+		}
+		return offset, nil
+	default:
+		return oldest, nil
+	}
+}
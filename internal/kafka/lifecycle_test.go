@@ -0,0 +1,172 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// resetTopic is the topic of the offset reset tests.
+const resetTopic = "orders"
+
+// newResetBroker returns a broker with the partitions and n messages of resetTopic,
+// produced a minute apart starting at the time.
+func newResetBroker(t *testing.T, partitions, n int, start time.Time) *MemoryBroker {
+	t.Helper()
+
+	broker := NewMemoryBroker(partitions)
+	producer := broker.Producer()
+	for i := 0; i < n; i++ {
+		_, _, err := producer.SendMessage(&sarama.ProducerMessage{
+			Topic:     resetTopic,
+			Key:       sarama.StringEncoder(fmt.Sprintf("order-%d", i)),
+			Value:     sarama.StringEncoder("{}"),
+			Timestamp: start.Add(time.Duration(i) * time.Minute),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return broker
+}
+
+// startReset runs ResetOffsets of the lifecycle in the background once its session is restarted,
+// and returns the channel of its outcome.
+func startReset(t *testing.T, ctx context.Context, l *lifecycle, target ResetTarget) <-chan error {
+	t.Helper()
+
+	restarted := make(chan struct{})
+	l.sessionStarted(func() { close(restarted) })
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := l.resetOffsets(ctx, target)
+		done <- err
+	}()
+	<-restarted
+	return done
+}
+
+func TestTargetOffset(t *testing.T) {
+	start := time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)
+	l := &lifecycle{client: newResetBroker(t, 1, 3, start), topic: resetTopic}
+
+	cases := []struct {
+		name   string
+		target ResetTarget
+		want   int64
+	}{
+		{"Earliest", ResetTarget{To: ResetEarliest}, 0},
+		{"Offset", ResetTarget{To: ResetOffset, Offset: 1}, 1},
+		{"OffsetPastNewest", ResetTarget{To: ResetOffset, Offset: 10}, 3},
+		{"TimestampBeforeFirst", ResetTarget{To: ResetTimestamp, Timestamp: start.Add(-time.Hour)}, 0},
+		{"TimestampBetween", ResetTarget{To: ResetTimestamp, Timestamp: start.Add(30 * time.Second)}, 1},
+		{"TimestampExact", ResetTarget{To: ResetTimestamp, Timestamp: start.Add(2 * time.Minute)}, 2},
+		{"TimestampAfterLast", ResetTarget{To: ResetTimestamp, Timestamp: start.Add(time.Hour)}, 3},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := l.targetOffset(0, c.target)
+			if err != nil {
+				t.Fatalf("targetOffset() error = %v", err)
+			}
+			if got != c.want {
+				t.Errorf("targetOffset() = %d; want %d", got, c.want)
+			}
+		})
+	}
+
+	if _, err := l.targetOffset(5, ResetTarget{To: ResetEarliest}); err == nil {
+		t.Error("targetOffset() of an unknown partition: want an error")
+	}
+}
+
+func TestSetupResetsEveryPartition(t *testing.T) {
+	broker := newResetBroker(t, 2, 6, time.Now())
+	l := &lifecycle{client: broker, topic: resetTopic}
+	done := startReset(t, context.Background(), l, ResetTarget{To: ResetEarliest})
+
+	session := &fakeSession{ctx: context.Background(), claims: map[string][]int32{resetTopic: {0, 1}}}
+	l.setup(session)
+
+	if err := <-done; err != nil {
+		t.Fatalf("resetOffsets() error = %v", err)
+	}
+	calls := session.recorded()
+	if len(calls) != 3 || calls[2] != "commit" {
+		t.Fatalf("session calls = %v; want both partitions reset, then a commit", calls)
+	}
+	if resets := calls[:2]; !slices.Contains(resets, "reset 0 0") || !slices.Contains(resets, "reset 1 0") {
+		t.Errorf("session calls = %v; want partitions 0 and 1 reset to 0", calls)
+	}
+	if l.resetPending() {
+		t.Error("reset is still pending after it has been applied")
+	}
+}
+
+func TestSetupDefersResetUntilEveryPartitionIsClaimed(t *testing.T) {
+	broker := newResetBroker(t, 2, 6, time.Now())
+	l := &lifecycle{client: broker, topic: resetTopic}
+	done := startReset(t, context.Background(), l, ResetTarget{To: ResetOffset, Offset: 1})
+
+	// Партиция 1 принадлежит другому участнику группы: ничего не сбрасывается
+	partial := &fakeSession{ctx: context.Background(), claims: map[string][]int32{resetTopic: {0}}}
+	l.setup(partial)
+	if calls := partial.recorded(); len(calls) != 0 {
+		t.Errorf("session calls = %v; want none while partition 1 is not claimed", calls)
+	}
+	if !l.resetPending() {
+		t.Fatal("reset is not pending after a session that does not claim every partition")
+	}
+
+	full := &fakeSession{ctx: context.Background(), claims: map[string][]int32{resetTopic: {0, 1}}}
+	l.setup(full)
+	if err := <-done; err != nil {
+		t.Fatalf("resetOffsets() error = %v", err)
+	}
+	calls := full.recorded()
+	if len(calls) != 3 || calls[2] != "commit" {
+		t.Errorf("session calls = %v; want both partitions reset, then a commit", calls)
+	}
+}
+
+func TestResetOffsetsNotClaimed(t *testing.T) {
+	broker := newResetBroker(t, 2, 6, time.Now())
+	l := &lifecycle{client: broker, topic: resetTopic}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := startReset(t, ctx, l, ResetTarget{To: ResetEarliest})
+
+	partial := &fakeSession{ctx: context.Background(), claims: map[string][]int32{resetTopic: {1}}}
+	l.setup(partial)
+	cancel()
+
+	if err := <-done; !errors.Is(err, ErrPartitionsNotClaimed) {
+		t.Fatalf("resetOffsets() error = %v; want ErrPartitionsNotClaimed", err)
+	}
+	if l.resetPending() {
+		t.Error("reset is still pending after it has been withdrawn")
+	}
+
+	// Отозванный сброс не применяется следующей сессией
+	full := &fakeSession{ctx: context.Background(), claims: map[string][]int32{resetTopic: {0, 1}}}
+	l.setup(full)
+	if calls := full.recorded(); len(calls) != 0 {
+		t.Errorf("session calls = %v; want none after the reset has been withdrawn", calls)
+	}
+}
+
+func TestResetOffsetsInProgress(t *testing.T) {
+	l := &lifecycle{client: newResetBroker(t, 1, 1, time.Now()), topic: resetTopic}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	startReset(t, ctx, l, ResetTarget{To: ResetEarliest})
+
+	if _, err := l.resetOffsets(context.Background(), ResetTarget{To: ResetEarliest}); !errors.Is(err, ErrResetInProgress) {
+		t.Errorf("second resetOffsets() error = %v; want ErrResetInProgress", err)
+	}
+}
@@ -0,0 +1,577 @@
+package kafka
+
+import (
+	"context"
+	"demo_service/internal/config"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// MemoryBroker is an in-process stand-in for a Kafka cluster, for end-to-end tests
+// and local runs without Zookeeper and Kafka.
+//
+// Messages sent with its Producer are appended to in-memory partition logs (partitioned by key,
+// like the default partitioner of sarama) and consumed by a ConsumerAdapter created with
+// NewMemoryConsumerAdapter through the same decoding, processing, dead-lettering and offset marking
+// as with a cluster. The consumer group claims all partitions of the topic in every session;
+// a group without committed offsets starts from the oldest message.
+type MemoryBroker struct {
+	partitions int
+
+	mu        sync.Mutex
+	logs      map[string][][]*sarama.ConsumerMessage // topic -> partition -> messages
+	committed map[topicPartition]int64               // next offset to consume by the group
+	appended  chan struct{}                          // closed and replaced when a message is appended
+}
+
+// topicPartition identifies a partition of a topic.
+type topicPartition struct {
+	topic     string
+	partition int32
+}
+
+// NewMemoryBroker creates an empty in-memory broker whose topics have the given number of partitions.
+func NewMemoryBroker(partitions int) *MemoryBroker {
+	if partitions < 1 {
+		partitions = 1
+	}
+	return &MemoryBroker{
+		partitions: partitions,
+		logs:       make(map[string][][]*sarama.ConsumerMessage),
+		committed:  make(map[topicPartition]int64),
+		appended:   make(chan struct{}),
+	}
+}
+
+// Producer returns a producer that appends messages to the broker.
+// It can be used wherever a sarama.SyncProducer is expected, e.g. for the dead-letter queue.
+func (b *MemoryBroker) Producer() sarama.SyncProducer {
+	return &memoryProducer{broker: b}
+}
+
+// Messages returns all messages of the topic, ordered by partition and offset.
+func (b *MemoryBroker) Messages(topic string) []*sarama.ConsumerMessage {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var messages []*sarama.ConsumerMessage
+	for _, partition := range b.logs[topic] {
+		messages = append(messages, partition...)
+	}
+	return messages
+}
+
+// Committed returns the offset committed by the consumer group for the partition:
+// the offset of the next message to consume.
+func (b *MemoryBroker) Committed(topic string, partition int32) int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.committed[topicPartition{topic, partition}]
+}
+
+// Lag returns the number of messages of the topic that the consumer group has not committed yet.
+func (b *MemoryBroker) Lag(topic string) int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var lag int64
+	for partition, messages := range b.logs[topic] {
+		lag += int64(len(messages)) - b.committed[topicPartition{topic, int32(partition)}]
+	}
+	return lag
+}
+
+// Partitions returns the partitions of the topic.
+func (b *MemoryBroker) Partitions(topic string) ([]int32, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	partitions := make([]int32, len(b.topicLog(topic)))
+	for i := range partitions {
+		partitions[i] = int32(i)
+	}
+	return partitions, nil
+}
+
+// GetOffset returns the oldest or the newest offset of the partition (sarama.OffsetOldest, sarama.OffsetNewest),
+// or the offset of the first message produced at or after the time in milliseconds,
+// -1 if there is no such message. It mirrors sarama.Client.
+func (b *MemoryBroker) GetOffset(topic string, partition int32, time int64) (int64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	logs := b.topicLog(topic)
+	if partition < 0 || int(partition) >= len(logs) {
+		return 0, sarama.ErrUnknownTopicOrPartition
+	}
+	messages := logs[partition]
+
+	switch time {
+	case sarama.OffsetOldest:
+		return 0, nil
+	case sarama.OffsetNewest:
+		return int64(len(messages)), nil
+	}
+	for _, message := range messages {
+		if message.Timestamp.UnixMilli() >= time {
+			return message.Offset, nil
+		}
+	}
+	return -1, nil
+}
+
+// topicLog returns the partition logs of the topic, creating it on first use. b.mu must be held.
+func (b *MemoryBroker) topicLog(topic string) [][]*sarama.ConsumerMessage {
+	logs, ok := b.logs[topic]
+	if !ok {
+		logs = make([][]*sarama.ConsumerMessage, b.partitions)
+		b.logs[topic] = logs
+	}
+	return logs
+}
+
+// append appends the produced message to its partition and returns the partition and the offset.
+func (b *MemoryBroker) append(msg *sarama.ProducerMessage) (int32, int64, error) {
+	key, err := encodeValue(msg.Key)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to encode key: %w", err)
+	}
+	value, err := encodeValue(msg.Value)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to encode value: %w", err)
+	}
+
+	headers := make([]*sarama.RecordHeader, 0, len(msg.Headers))
+	for _, header := range msg.Headers {
+		headers = append(headers, &sarama.RecordHeader{Key: header.Key, Value: header.Value})
+	}
+	timestamp := msg.Timestamp
+	if timestamp.IsZero() {
+		timestamp = time.Now()
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	logs := b.topicLog(msg.Topic)
+	partition, err := sarama.NewHashPartitioner(msg.Topic).Partition(msg, int32(len(logs)))
+	if err != nil {
+		return 0, 0, err
+	}
+	offset := int64(len(logs[partition]))
+	logs[partition] = append(logs[partition], &sarama.ConsumerMessage{
+		Topic:     msg.Topic,
+		Partition: partition,
+		Offset:    offset,
+		Key:       key,
+		Value:     value,
+		Headers:   headers,
+		Timestamp: timestamp,
+	})
+	msg.Partition, msg.Offset = partition, offset
+
+	close(b.appended)
+	b.appended = make(chan struct{})
+	return partition, offset, nil
+}
+
+// next returns the message of the partition at the offset, if it has been produced,
+// and a channel that is closed when the next message is appended to any partition.
+func (b *MemoryBroker) next(tp topicPartition, offset int64) (*sarama.ConsumerMessage, <-chan struct{}) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	messages := b.topicLog(tp.topic)[tp.partition]
+	if offset < int64(len(messages)) {
+		return messages[offset], b.appended
+	}
+	return nil, b.appended
+}
+
+// encodeValue returns the bytes of a message key or value, nil for a nil encoder.
+func encodeValue(encoder sarama.Encoder) ([]byte, error) {
+	if encoder == nil {
+		return nil, nil
+	}
+	return encoder.Encode()
+}
+
+// NewMemoryConsumerAdapter creates a ConsumerAdapter that consumes the configured topic
+// from the in-memory broker instead of a Kafka cluster.
+// Dead-lettered messages are published to the DLQ topic of the same broker.
+func NewMemoryConsumerAdapter(broker *MemoryBroker, brokerCfg config.Broker) (*ConsumerAdapter, error) {
+	const fn = "NewMemoryConsumerAdapter"
+
+	codecs, err := NewCodecs(brokerCfg)
+	if err != nil {
+		return nil, fmt.Errorf("(%s) | %w", fn, err)
+	}
+
+	group := &memoryGroup{broker: broker, autoCommit: !brokerCfg.AtLeastOnce, errors: make(chan error)}
+	adapter := &ConsumerAdapter{
+		consumerGroup: group,
+		lifecycle:     &lifecycle{client: broker, group: group, topic: brokerCfg.Topic},
+		topic:         brokerCfg.Topic,
+		codecs:        codecs,
+		atLeastOnce:   brokerCfg.AtLeastOnce,
+		workers:       brokerCfg.Workers,
+		batchSize:     brokerCfg.Batch.Size,
+		batchLinger:   brokerCfg.Batch.Linger,
+	}
+	if brokerCfg.DLQ.Enabled {
+		adapter.dlq = NewDeadLetterQueue(broker.Producer(), brokerCfg.DLQ.Topic)
+	}
+	return adapter, nil
+}
+
+// memoryProducer is the sarama.SyncProducer of a MemoryBroker. It is not transactional.
+type memoryProducer struct {
+	broker *MemoryBroker
+}
+
+// SendMessage implements sarama.SyncProducer.
+func (p *memoryProducer) SendMessage(msg *sarama.ProducerMessage) (int32, int64, error) {
+	return p.broker.append(msg)
+}
+
+// SendMessages implements sarama.SyncProducer.
+func (p *memoryProducer) SendMessages(msgs []*sarama.ProducerMessage) error {
+	for _, msg := range msgs {
+		if _, _, err := p.broker.append(msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close implements sarama.SyncProducer.
+func (p *memoryProducer) Close() error { return nil }
+
+// TxnStatus implements sarama.SyncProducer.
+func (p *memoryProducer) TxnStatus() sarama.ProducerTxnStatusFlag { return sarama.ProducerTxnFlagReady }
+
+// IsTransactional implements sarama.SyncProducer.
+func (p *memoryProducer) IsTransactional() bool { return false }
+
+// BeginTxn implements sarama.SyncProducer.
+func (p *memoryProducer) BeginTxn() error { return sarama.ErrNonTransactedProducer }
+
+// CommitTxn implements sarama.SyncProducer.
+func (p *memoryProducer) CommitTxn() error { return sarama.ErrNonTransactedProducer }
+
+// AbortTxn implements sarama.SyncProducer.
+func (p *memoryProducer) AbortTxn() error { return sarama.ErrNonTransactedProducer }
+
+// AddOffsetsToTxn implements sarama.SyncProducer.
+func (p *memoryProducer) AddOffsetsToTxn(map[string][]*sarama.PartitionOffsetMetadata, string) error {
+	return sarama.ErrNonTransactedProducer
+}
+
+// AddMessageToTxn implements sarama.SyncProducer.
+func (p *memoryProducer) AddMessageToTxn(*sarama.ConsumerMessage, string, *string) error {
+	return sarama.ErrNonTransactedProducer
+}
+
+// memoryGroup is the sarama.ConsumerGroup of a MemoryBroker.
+// With auto-commit, marked offsets are committed immediately.
+type memoryGroup struct {
+	broker     *MemoryBroker
+	autoCommit bool
+	errors     chan error
+
+	mu         sync.Mutex
+	closed     bool
+	generation int32
+	session    *memorySession
+}
+
+// Consume implements sarama.ConsumerGroup: it runs a session claiming all partitions of the topics
+// until ctx is canceled, the group is closed or one of the claims returns.
+func (g *memoryGroup) Consume(ctx context.Context, topics []string, handler sarama.ConsumerGroupHandler) error {
+	const fn = "memoryGroup.Consume"
+
+	g.mu.Lock()
+	if g.closed {
+		g.mu.Unlock()
+		return sarama.ErrClosedConsumerGroup
+	}
+	g.generation++
+	session := newMemorySession(ctx, g, topics)
+	g.session = session
+	g.mu.Unlock()
+	defer session.cancel()
+
+	if err := handler.Setup(session); err != nil {
+		return err
+	}
+
+	var wg sync.WaitGroup
+	for _, topic := range topics {
+		for _, partition := range session.claims[topic] {
+			claim := session.claim(topicPartition{topic, partition})
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				claim.feed()
+			}()
+			go func() {
+				defer wg.Done()
+				// Как и в sarama, завершение одной claim завершает сессию
+				defer session.cancel()
+				if err := handler.ConsumeClaim(session, claim); err != nil {
+					log.Printf("(%s) | %s/%d: %v\n", fn, topic, partition, err)
+				}
+			}()
+		}
+	}
+	wg.Wait()
+
+	err := handler.Cleanup(session)
+	if g.autoCommit {
+		session.Commit()
+	}
+	return err
+}
+
+// Errors implements sarama.ConsumerGroup. Errors are logged rather than returned.
+func (g *memoryGroup) Errors() <-chan error {
+	return g.errors
+}
+
+// Close implements sarama.ConsumerGroup: it ends the current session.
+func (g *memoryGroup) Close() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.closed {
+		return nil
+	}
+	g.closed = true
+	if g.session != nil {
+		g.session.cancel()
+	}
+	close(g.errors)
+	return nil
+}
+
+// Pause implements sarama.ConsumerGroup.
+func (g *memoryGroup) Pause(partitions map[string][]int32) {
+	g.withSession(func(s *memorySession) { s.setPaused(partitions, true) })
+}
+
+// Resume implements sarama.ConsumerGroup.
+func (g *memoryGroup) Resume(partitions map[string][]int32) {
+	g.withSession(func(s *memorySession) { s.setPaused(partitions, false) })
+}
+
+// PauseAll implements sarama.ConsumerGroup.
+func (g *memoryGroup) PauseAll() {
+	g.withSession(func(s *memorySession) { s.setPaused(s.claims, true) })
+}
+
+// ResumeAll implements sarama.ConsumerGroup.
+func (g *memoryGroup) ResumeAll() {
+	g.withSession(func(s *memorySession) { s.setPaused(s.claims, false) })
+}
+
+// withSession calls f with the current session, if any.
+func (g *memoryGroup) withSession(f func(s *memorySession)) {
+	g.mu.Lock()
+	session := g.session
+	g.mu.Unlock()
+
+	if session != nil {
+		f(session)
+	}
+}
+
+// memorySession is the sarama.ConsumerGroupSession of a memoryGroup.
+// Like partition consumers of sarama, the pause state does not outlive the session.
+type memorySession struct {
+	group      *memoryGroup
+	ctx        context.Context
+	cancel     context.CancelFunc
+	claims     map[string][]int32
+	generation int32
+
+	mu      sync.Mutex
+	offsets map[topicPartition]int64
+	paused  map[topicPartition]bool
+	resumed chan struct{} // closed and replaced when a partition is resumed
+}
+
+// newMemorySession starts a session of the group with the committed offsets of the topics. g.mu must be held.
+func newMemorySession(ctx context.Context, g *memoryGroup, topics []string) *memorySession {
+	sessionCtx, cancel := context.WithCancel(ctx)
+	s := &memorySession{
+		group:      g,
+		ctx:        sessionCtx,
+		cancel:     cancel,
+		claims:     make(map[string][]int32, len(topics)),
+		generation: g.generation,
+		offsets:    make(map[topicPartition]int64),
+		paused:     make(map[topicPartition]bool),
+		resumed:    make(chan struct{}),
+	}
+
+	g.broker.mu.Lock()
+	defer g.broker.mu.Unlock()
+	for _, topic := range topics {
+		for partition := range g.broker.topicLog(topic) {
+			tp := topicPartition{topic, int32(partition)}
+			s.claims[topic] = append(s.claims[topic], tp.partition)
+			s.offsets[tp] = g.broker.committed[tp]
+		}
+	}
+	return s
+}
+
+// Claims implements sarama.ConsumerGroupSession.
+func (s *memorySession) Claims() map[string][]int32 { return s.claims }
+
+// MemberID implements sarama.ConsumerGroupSession.
+func (s *memorySession) MemberID() string { return "memory" }
+
+// GenerationID implements sarama.ConsumerGroupSession.
+func (s *memorySession) GenerationID() int32 { return s.generation }
+
+// Context implements sarama.ConsumerGroupSession.
+func (s *memorySession) Context() context.Context { return s.ctx }
+
+// MarkOffset implements sarama.ConsumerGroupSession: the offset is only moved forward.
+func (s *memorySession) MarkOffset(topic string, partition int32, offset int64, _ string) {
+	s.mu.Lock()
+	tp := topicPartition{topic, partition}
+	if offset > s.offsets[tp] {
+		s.offsets[tp] = offset
+	}
+	s.mu.Unlock()
+
+	if s.group.autoCommit {
+		s.Commit()
+	}
+}
+
+// MarkMessage implements sarama.ConsumerGroupSession.
+func (s *memorySession) MarkMessage(msg *sarama.ConsumerMessage, metadata string) {
+	s.MarkOffset(msg.Topic, msg.Partition, msg.Offset+1, metadata)
+}
+
+// ResetOffset implements sarama.ConsumerGroupSession: the offset may also be moved back.
+func (s *memorySession) ResetOffset(topic string, partition int32, offset int64, _ string) {
+	s.mu.Lock()
+	s.offsets[topicPartition{topic, partition}] = offset
+	s.mu.Unlock()
+}
+
+// Commit implements sarama.ConsumerGroupSession.
+func (s *memorySession) Commit() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	broker := s.group.broker
+	broker.mu.Lock()
+	defer broker.mu.Unlock()
+	for tp, offset := range s.offsets {
+		broker.committed[tp] = offset
+	}
+}
+
+// setPaused pauses or resumes the partitions.
+func (s *memorySession) setPaused(partitions map[string][]int32, paused bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for topic, ids := range partitions {
+		for _, partition := range ids {
+			s.paused[topicPartition{topic, partition}] = paused
+		}
+	}
+	if !paused {
+		close(s.resumed)
+		s.resumed = make(chan struct{})
+	}
+}
+
+// claim creates the claim of the partition, starting from its current offset in the session.
+func (s *memorySession) claim(tp topicPartition) *memoryClaim {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return &memoryClaim{
+		session:  s,
+		tp:       tp,
+		initial:  s.offsets[tp],
+		messages: make(chan *sarama.ConsumerMessage),
+	}
+}
+
+// pauseState reports whether the partition is paused,
+// and returns a channel that is closed when a partition is resumed.
+func (s *memorySession) pauseState(tp topicPartition) (bool, <-chan struct{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.paused[tp], s.resumed
+}
+
+// memoryClaim is the sarama.ConsumerGroupClaim of a memorySession.
+type memoryClaim struct {
+	session  *memorySession
+	tp       topicPartition
+	initial  int64
+	messages chan *sarama.ConsumerMessage
+}
+
+// Topic implements sarama.ConsumerGroupClaim.
+func (c *memoryClaim) Topic() string { return c.tp.topic }
+
+// Partition implements sarama.ConsumerGroupClaim.
+func (c *memoryClaim) Partition() int32 { return c.tp.partition }
+
+// InitialOffset implements sarama.ConsumerGroupClaim.
+func (c *memoryClaim) InitialOffset() int64 { return c.initial }
+
+// HighWaterMarkOffset implements sarama.ConsumerGroupClaim.
+func (c *memoryClaim) HighWaterMarkOffset() int64 {
+	offset, _ := c.session.group.broker.GetOffset(c.tp.topic, c.tp.partition, sarama.OffsetNewest)
+	return offset
+}
+
+// Messages implements sarama.ConsumerGroupClaim.
+func (c *memoryClaim) Messages() <-chan *sarama.ConsumerMessage { return c.messages }
+
+// feed delivers the messages of the partition to the claim, waiting for new ones while it is caught up
+// or paused, until the session ends.
+func (c *memoryClaim) feed() {
+	defer close(c.messages)
+
+	ctx := c.session.ctx
+	offset := c.initial
+	for {
+		message, appended := c.session.group.broker.next(c.tp, offset)
+		paused, resumed := c.session.pauseState(c.tp)
+
+		if message != nil && !paused {
+			select {
+			case c.messages <- message:
+				offset++
+			case <-ctx.Done():
+				return
+			}
+			continue
+		}
+
+		select {
+		case <-appended:
+		case <-resumed:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
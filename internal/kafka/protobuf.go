@@ -0,0 +1,344 @@
+package kafka
+
+import (
+	"context"
+	"demo_service/internal/models"
+	"fmt"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// ProtobufCodec encodes orders as the Order message of schema/order.proto.
+//
+// The messages are encoded and decoded field by field on the wire format,
+// so no generated code is needed; the field numbers below follow the .proto file.
+// Unknown fields are skipped, so producers may use a newer revision of the schema.
+type ProtobufCodec struct{}
+
+// Name implements Codec.
+func (ProtobufCodec) Name() string { return CodecProtobuf }
+
+// ContentType implements Codec.
+func (ProtobufCodec) ContentType() string { return ContentTypeProtobuf }
+
+// Encode implements Codec.
+func (ProtobufCodec) Encode(order models.Order) ([]byte, error) {
+	var b []byte
+	b = appendProtoString(b, 1, order.OrderUID)
+	b = appendProtoString(b, 2, order.TrackNumber)
+	b = appendProtoString(b, 3, order.Entry)
+	b = appendProtoMessage(b, 4, encodeProtoDelivery(order.Delivery))
+	b = appendProtoMessage(b, 5, encodeProtoPayment(order.Payment))
+	for _, item := range order.Items {
+		b = appendProtoMessage(b, 6, encodeProtoItem(item))
+	}
+	b = appendProtoString(b, 7, order.Locale)
+	b = appendProtoString(b, 8, order.InternalSignature)
+	b = appendProtoString(b, 9, order.CustomerID)
+	b = appendProtoString(b, 10, order.DeliveryService)
+	b = appendProtoString(b, 11, order.Shardkey)
+	b = appendProtoInt(b, 12, int64(order.SmID))
+	if !order.DateCreated.IsZero() {
+		b = appendProtoMessage(b, 13, encodeProtoTimestamp(order.DateCreated))
+	}
+	b = appendProtoString(b, 14, order.OofShard)
+	return b, nil
+}
+
+// Decode implements Codec.
+func (ProtobufCodec) Decode(_ context.Context, data []byte) (models.Order, error) {
+	var order models.Order
+	err := consumeProtoFields(data, func(f protoField) error {
+		var err error
+		switch f.num {
+		case 1:
+			order.OrderUID, err = f.string()
+		case 2:
+			order.TrackNumber, err = f.string()
+		case 3:
+			order.Entry, err = f.string()
+		case 4:
+			err = f.message(func(b []byte) error { return decodeProtoDelivery(b, &order.Delivery) })
+		case 5:
+			err = f.message(func(b []byte) error { return decodeProtoPayment(b, &order.Payment) })
+		case 6:
+			var item models.Item
+			if err = f.message(func(b []byte) error { return decodeProtoItem(b, &item) }); err == nil {
+				order.Items = append(order.Items, item)
+			}
+		case 7:
+			order.Locale, err = f.string()
+		case 8:
+			order.InternalSignature, err = f.string()
+		case 9:
+			order.CustomerID, err = f.string()
+		case 10:
+			order.DeliveryService, err = f.string()
+		case 11:
+			order.Shardkey, err = f.string()
+		case 12:
+			order.SmID, err = f.int()
+		case 13:
+			err = f.message(func(b []byte) error { return decodeProtoTimestamp(b, &order.DateCreated) })
+		case 14:
+			order.OofShard, err = f.string()
+		}
+		return err
+	})
+	if err != nil {
+		return models.Order{}, fmt.Errorf("invalid protobuf order: %w", err)
+	}
+	return order, nil
+}
+
+func encodeProtoDelivery(d models.Delivery) []byte {
+	var b []byte
+	b = appendProtoString(b, 1, d.Name)
+	b = appendProtoString(b, 2, d.Phone)
+	b = appendProtoString(b, 3, d.Zip)
+	b = appendProtoString(b, 4, d.City)
+	b = appendProtoString(b, 5, d.Address)
+	b = appendProtoString(b, 6, d.Region)
+	b = appendProtoString(b, 7, d.Email)
+	return b
+}
+
+func decodeProtoDelivery(data []byte, d *models.Delivery) error {
+	return consumeProtoFields(data, func(f protoField) error {
+		var err error
+		switch f.num {
+		case 1:
+			d.Name, err = f.string()
+		case 2:
+			d.Phone, err = f.string()
+		case 3:
+			d.Zip, err = f.string()
+		case 4:
+			d.City, err = f.string()
+		case 5:
+			d.Address, err = f.string()
+		case 6:
+			d.Region, err = f.string()
+		case 7:
+			d.Email, err = f.string()
+		}
+		return err
+	})
+}
+
+func encodeProtoPayment(p models.Payment) []byte {
+	var b []byte
+	b = appendProtoString(b, 1, p.Transaction)
+	b = appendProtoString(b, 2, p.RequestID)
+	b = appendProtoString(b, 3, p.Currency)
+	b = appendProtoString(b, 4, p.Provider)
+	b = appendProtoInt(b, 5, int64(p.Amount))
+	b = appendProtoInt(b, 6, int64(p.PaymentDT))
+	b = appendProtoString(b, 7, p.Bank)
+	b = appendProtoInt(b, 8, int64(p.DeliveryCost))
+	b = appendProtoInt(b, 9, int64(p.GoodsTotal))
+	b = appendProtoInt(b, 10, int64(p.CustomFee))
+	return b
+}
+
+func decodeProtoPayment(data []byte, p *models.Payment) error {
+	return consumeProtoFields(data, func(f protoField) error {
+		var err error
+		switch f.num {
+		case 1:
+			p.Transaction, err = f.string()
+		case 2:
+			p.RequestID, err = f.string()
+		case 3:
+			p.Currency, err = f.string()
+		case 4:
+			p.Provider, err = f.string()
+		case 5:
+			p.Amount, err = f.int()
+		case 6:
+			p.PaymentDT, err = f.int()
+		case 7:
+			p.Bank, err = f.string()
+		case 8:
+			p.DeliveryCost, err = f.int()
+		case 9:
+			p.GoodsTotal, err = f.int()
+		case 10:
+			p.CustomFee, err = f.int()
+		}
+		return err
+	})
+}
+
+func encodeProtoItem(i models.Item) []byte {
+	var b []byte
+	b = appendProtoInt(b, 1, int64(i.ChrtID))
+	b = appendProtoString(b, 2, i.TrackNumber)
+	b = appendProtoInt(b, 3, int64(i.Price))
+	b = appendProtoString(b, 4, i.RID)
+	b = appendProtoString(b, 5, i.Name)
+	b = appendProtoInt(b, 6, int64(i.Sale))
+	b = appendProtoString(b, 7, i.Size)
+	b = appendProtoInt(b, 8, int64(i.TotalPrice))
+	b = appendProtoInt(b, 9, int64(i.NMID))
+	b = appendProtoString(b, 10, i.Brand)
+	b = appendProtoInt(b, 11, int64(i.Status))
+	return b
+}
+
+func decodeProtoItem(data []byte, i *models.Item) error {
+	return consumeProtoFields(data, func(f protoField) error {
+		var err error
+		switch f.num {
+		case 1:
+			i.ChrtID, err = f.int()
+		case 2:
+			i.TrackNumber, err = f.string()
+		case 3:
+			i.Price, err = f.int()
+		case 4:
+			i.RID, err = f.string()
+		case 5:
+			i.Name, err = f.string()
+		case 6:
+			i.Sale, err = f.int()
+		case 7:
+			i.Size, err = f.string()
+		case 8:
+			i.TotalPrice, err = f.int()
+		case 9:
+			i.NMID, err = f.int()
+		case 10:
+			i.Brand, err = f.string()
+		case 11:
+			i.Status, err = f.int()
+		}
+		return err
+	})
+}
+
+// encodeProtoTimestamp encodes the time as google.protobuf.Timestamp.
+func encodeProtoTimestamp(t time.Time) []byte {
+	var b []byte
+	b = appendProtoInt(b, 1, t.Unix())
+	b = appendProtoInt(b, 2, int64(t.Nanosecond()))
+	return b
+}
+
+// decodeProtoTimestamp decodes google.protobuf.Timestamp into a UTC time.
+func decodeProtoTimestamp(data []byte, t *time.Time) error {
+	var seconds, nanos int
+	err := consumeProtoFields(data, func(f protoField) error {
+		var err error
+		switch f.num {
+		case 1:
+			seconds, err = f.int()
+		case 2:
+			nanos, err = f.int()
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	*t = time.Unix(int64(seconds), int64(nanos)).UTC()
+	return nil
+}
+
+// appendProtoString appends a string field, omitting the proto3 default (empty string).
+func appendProtoString(b []byte, num protowire.Number, value string) []byte {
+	if value == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, value)
+}
+
+// appendProtoInt appends an int64/int32 field, omitting the proto3 default (zero).
+// Negative values are sign-extended to ten bytes, as the int32 and int64 types require.
+func appendProtoInt(b []byte, num protowire.Number, value int64) []byte {
+	if value == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, uint64(value))
+}
+
+// appendProtoMessage appends an embedded message field.
+func appendProtoMessage(b []byte, num protowire.Number, message []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, message)
+}
+
+// protoField is a decoded field of a protobuf message.
+type protoField struct {
+	num    protowire.Number
+	typ    protowire.Type
+	varint uint64
+	bytes  []byte
+}
+
+// string returns the value of a string field.
+func (f protoField) string() (string, error) {
+	if f.typ != protowire.BytesType {
+		return "", f.wireTypeError()
+	}
+	return string(f.bytes), nil
+}
+
+// int returns the value of an int32 or int64 field.
+func (f protoField) int() (int, error) {
+	if f.typ != protowire.VarintType {
+		return 0, f.wireTypeError()
+	}
+	return int(int64(f.varint)), nil
+}
+
+// message decodes the value of an embedded message field.
+func (f protoField) message(decode func([]byte) error) error {
+	if f.typ != protowire.BytesType {
+		return f.wireTypeError()
+	}
+	if err := decode(f.bytes); err != nil {
+		return fmt.Errorf("field %d: %w", f.num, err)
+	}
+	return nil
+}
+
+func (f protoField) wireTypeError() error {
+	return fmt.Errorf("field %d has unexpected wire type %d", f.num, f.typ)
+}
+
+// consumeProtoFields parses the fields of a protobuf message and passes them to visit.
+// Fields of other wire types than varint and length-delimited are skipped.
+func consumeProtoFields(data []byte, visit func(f protoField) error) error {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		f := protoField{num: num, typ: typ}
+		switch typ {
+		case protowire.VarintType:
+			f.varint, n = protowire.ConsumeVarint(data)
+		case protowire.BytesType:
+			f.bytes, n = protowire.ConsumeBytes(data)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, data)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		if typ == protowire.VarintType || typ == protowire.BytesType {
+			if err := visit(f); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
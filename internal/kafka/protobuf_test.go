@@ -0,0 +1,170 @@
+package kafka
+
+import (
+	"bytes"
+	"context"
+	"demo_service/internal/models"
+	"reflect"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// fullOrder returns an order with every field of the Order message set, including repeated items
+// and a negative value, which is encoded as a ten-byte varint.
+func fullOrder() models.Order {
+	return models.Order{
+		OrderUID:    "b563feb7b2b84b6test",
+		TrackNumber: "WBILMTESTTRACK",
+		Entry:       "WBIL",
+		Delivery: models.Delivery{
+			Name: "Test Testov", Phone: "+9720000000", Zip: "2639809", City: "Kiryat Mozkin",
+			Address: "Ploshad Mira 15", Region: "Kraiot", Email: "test@gmail.com",
+		},
+		Payment: models.Payment{
+			Transaction: "b563feb7b2b84b6test", RequestID: "req-1", Currency: "USD", Provider: "wbpay",
+			Amount: 1817, PaymentDT: 1637907727, Bank: "alpha", DeliveryCost: 1500, GoodsTotal: 317, CustomFee: -1,
+		},
+		Items: []models.Item{
+			{ChrtID: 9934930, TrackNumber: "WBILMTESTTRACK", Price: 453, RID: "ab4219087a764ae0btest", Name: "Mascaras",
+				Sale: 30, Size: "0", TotalPrice: 317, NMID: 2389212, Brand: "Vivienne Sabo", Status: 202},
+			{ChrtID: 1, Name: "Brush", Price: 100, TotalPrice: 100},
+			{ChrtID: 9934930, TrackNumber: "WBILMTESTTRACK", Price: 453, RID: "ab4219087a764ae0btest", Name: "Mascaras",
+				Sale: 30, Size: "0", TotalPrice: 317, NMID: 2389212, Brand: "Vivienne Sabo", Status: 202},
+		},
+		Locale:            "en",
+		InternalSignature: "sig",
+		CustomerID:        "test",
+		DeliveryService:   "meest",
+		Shardkey:          "9",
+		SmID:              99,
+		DateCreated:       time.Date(2021, 11, 26, 6, 22, 19, 123456789, time.UTC),
+		OofShard:          "1",
+	}
+}
+
+// decodeProto decodes the data with the protobuf codec.
+func decodeProto(t *testing.T, data []byte) models.Order {
+	t.Helper()
+
+	order, err := ProtobufCodec{}.Decode(context.Background(), data)
+	if err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	return order
+}
+
+func TestProtobufCodecRoundTrip(t *testing.T) {
+	cases := map[string]models.Order{
+		"Full":  fullOrder(),
+		"Empty": {},
+		"NoItems": func() models.Order {
+			order := fullOrder()
+			order.Items = nil
+			return order
+		}(),
+	}
+	for name, want := range cases {
+		t.Run(name, func(t *testing.T) {
+			data, err := ProtobufCodec{}.Encode(want)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := decodeProto(t, data); !reflect.DeepEqual(got, want) {
+				t.Errorf("round trip = %+v; want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestProtobufCodecRepeatedItemsKeepOrder(t *testing.T) {
+	order := models.Order{OrderUID: "order-1"}
+	for _, rid := range []string{"c", "a", "b", "a"} {
+		order.Items = append(order.Items, models.Item{RID: rid})
+	}
+	data, err := ProtobufCodec{}.Encode(order)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := decodeProto(t, data)
+	if len(got.Items) != 4 {
+		t.Fatalf("decoded %d items; want 4", len(got.Items))
+	}
+	for i, item := range got.Items {
+		if item.RID != order.Items[i].RID {
+			t.Errorf("items[%d].rid = %q; want %q", i, item.RID, order.Items[i].RID)
+		}
+	}
+}
+
+func TestProtobufCodecSkipsUnknownFields(t *testing.T) {
+	order := fullOrder()
+	data, err := ProtobufCodec{}.Encode(order)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Поля более новой ревизии схемы всех типов, в том числе внутри повторяющегося элемента
+	var unknown []byte
+	unknown = protowire.AppendTag(unknown, 100, protowire.VarintType)
+	unknown = protowire.AppendVarint(unknown, 42)
+	unknown = protowire.AppendTag(unknown, 101, protowire.BytesType)
+	unknown = protowire.AppendString(unknown, "new field")
+	unknown = protowire.AppendTag(unknown, 102, protowire.Fixed32Type)
+	unknown = protowire.AppendFixed32(unknown, 7)
+	unknown = protowire.AppendTag(unknown, 103, protowire.Fixed64Type)
+	unknown = protowire.AppendFixed64(unknown, 7)
+
+	item := append(encodeProtoItem(models.Item{RID: "extra"}), unknown...)
+	data = appendProtoMessage(data, 6, item)
+	data = append(data, unknown...)
+
+	want := order
+	want.Items = append(append([]models.Item(nil), order.Items...), models.Item{RID: "extra"})
+	if got := decodeProto(t, data); !reflect.DeepEqual(got, want) {
+		t.Errorf("decoded = %+v; want %+v", got, want)
+	}
+}
+
+func TestProtobufCodecWireFormat(t *testing.T) {
+	// Номера полей — контракт schema/order.proto
+	data, err := ProtobufCodec{}.Encode(models.Order{OrderUID: "a", SmID: 1, Items: []models.Item{{Status: 2}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{
+		0x0a, 0x01, 'a', // 1: order_uid
+		0x22, 0x00, // 4: delivery
+		0x2a, 0x00, // 5: payment
+		0x32, 0x02, 0x58, 0x02, // 6: items { 11: status }
+		0x60, 0x01, // 12: sm_id
+	}
+	if !bytes.Equal(data, want) {
+		t.Errorf("Encode() = % x; want % x", data, want)
+	}
+}
+
+func TestProtobufCodecRejectsInvalidData(t *testing.T) {
+	valid, err := ProtobufCodec{}.Encode(fullOrder())
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrongType := protowire.AppendTag(nil, 1, protowire.VarintType)
+	wrongType = protowire.AppendVarint(wrongType, 1)
+
+	cases := map[string][]byte{
+		"Truncated":       valid[:len(valid)-1],
+		"WrongWireType":   wrongType,
+		"InvalidTag":      {0x00},
+		"TruncatedVarint": {0x60, 0xff},
+	}
+	for name, data := range cases {
+		t.Run(name, func(t *testing.T) {
+			if _, err := (ProtobufCodec{}).Decode(context.Background(), data); err == nil {
+				t.Error("Decode() error = nil; want an error")
+			}
+		})
+	}
+}
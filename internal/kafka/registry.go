@@ -0,0 +1,120 @@
+package kafka
+
+import (
+	"bytes"
+	"context"
+	"demo_service/internal/config"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// SchemaRegistry resolves schemas by their ID and registers schemas under a subject.
+type SchemaRegistry interface {
+	// Schema returns the schema with the given ID, or an error wrapping ErrSchemaNotFound if there is none.
+	Schema(ctx context.Context, id int) (string, error)
+	// Register registers the schema under the subject (if it is not registered yet) and returns its ID.
+	Register(ctx context.Context, subject, schema string) (int, error)
+}
+
+var (
+	// ErrSchemaNotFound is returned by a SchemaRegistry for a schema ID it does not know.
+	ErrSchemaNotFound = errors.New("schema not found")
+	// ErrRegistryUnavailable marks failures to reach the schema registry: the payload may be valid,
+	// so the message is retried rather than dead-lettered.
+	ErrRegistryUnavailable = errors.New("schema registry is unavailable")
+)
+
+// registryContentType is the content type of the schema registry REST API.
+const registryContentType = "application/vnd.schemaregistry.v1+json"
+
+// RegistryClient is a SchemaRegistry talking to a Confluent-compatible schema registry over HTTP.
+type RegistryClient struct {
+	baseURL string
+	client  *http.Client
+}
+
+// NewRegistryClient creates a client of the schema registry at the configured URL.
+func NewRegistryClient(cfg config.SchemaRegistry) *RegistryClient {
+	return &RegistryClient{
+		baseURL: strings.TrimRight(cfg.URL, "/"),
+		client:  &http.Client{Timeout: cfg.Timeout},
+	}
+}
+
+// Schema implements SchemaRegistry (GET /schemas/ids/{id}).
+func (c *RegistryClient) Schema(ctx context.Context, id int) (string, error) {
+	const fn = "Schema"
+
+	var resp struct {
+		Schema string `json:"schema"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/schemas/ids/"+strconv.Itoa(id), nil, &resp); err != nil {
+		return "", fmt.Errorf("(%s) | failed to get schema %d: %w", fn, id, err)
+	}
+	return resp.Schema, nil
+}
+
+// Register implements SchemaRegistry (POST /subjects/{subject}/versions).
+func (c *RegistryClient) Register(ctx context.Context, subject, schema string) (int, error) {
+	const fn = "Register"
+
+	req := struct {
+		Schema string `json:"schema"`
+	}{Schema: schema}
+	var resp struct {
+		ID int `json:"id"`
+	}
+	if err := c.do(ctx, http.MethodPost, "/subjects/"+url.PathEscape(subject)+"/versions", req, &resp); err != nil {
+		return 0, fmt.Errorf("(%s) | failed to register schema under %s: %w", fn, subject, err)
+	}
+	return resp.ID, nil
+}
+
+// do sends a request to the registry and decodes the JSON response into out.
+func (c *RegistryClient) do(ctx context.Context, method, path string, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", registryContentType)
+	if in != nil {
+		req.Header.Set("Content-Type", registryContentType)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var regErr struct {
+			ErrorCode int    `json:"error_code"`
+			Message   string `json:"message"`
+		}
+		err := fmt.Errorf("registry responded with %s", resp.Status)
+		if json.NewDecoder(resp.Body).Decode(&regErr) == nil && regErr.Message != "" {
+			err = fmt.Errorf("registry error %d: %s", regErr.ErrorCode, regErr.Message)
+		}
+		if resp.StatusCode == http.StatusNotFound {
+			return fmt.Errorf("%w: %w", ErrSchemaNotFound, err)
+		}
+		return err
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,139 @@
+package kafka
+
+import (
+	"context"
+	"hash/fnv"
+	"log"
+	"sync"
+
+	"github.com/IBM/sarama"
+)
+
+// workerQueueSize is the number of messages buffered for each worker.
+const workerQueueSize = 16
+
+// consumeParallel fans the messages of the claim out to a pool of workers by hash of the order UID,
+// so the messages of one order are processed in order by the same worker,
+// while the messages of different orders are processed concurrently.
+//
+// Since later messages may finish first, the offsets are tracked per partition and
+// only the highest offset up to which all messages have been processed is marked
+// (and committed periodically in at-least-once mode, outside of the tracker lock).
+// If a message cannot be processed in at-least-once mode, the session is aborted
+// so no offset advances past it.
+func (h *kafkaConsumerHandler) consumeParallel(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	committer := newOffsetCommitter(session, h.atLeastOnce)
+	defer committer.close()
+	tracker := newOffsetTracker(committer.mark)
+
+	queues := make([]chan *sarama.ConsumerMessage, h.workers)
+	var wg sync.WaitGroup
+	for i := range queues {
+		queues[i] = make(chan *sarama.ConsumerMessage, workerQueueSize)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			h.work(session, tracker, queues[i])
+		}()
+	}
+	defer func() {
+		for _, queue := range queues {
+			close(queue)
+		}
+		wg.Wait()
+	}()
+
+	for {
+		select {
+		case message, ok := <-claim.Messages():
+			if !ok {
+				return nil
+			}
+			tracker.add(message)
+			select {
+			case queues[h.workerOf(session.Context(), message)] <- message:
+			case <-session.Context().Done():
+				return nil
+			}
+		case <-session.Context().Done():
+			return nil
+		}
+	}
+}
+
+// work processes the messages of the queue until it is closed.
+// Once the session is done, the remaining messages are skipped: they are not marked and will be redelivered.
+func (h *kafkaConsumerHandler) work(session sarama.ConsumerGroupSession, tracker *offsetTracker, queue <-chan *sarama.ConsumerMessage) {
+	const fn = "work"
+
+	for message := range queue {
+		if session.Context().Err() != nil {
+			continue
+		}
+		if !h.process(session, message) {
+			if session.Context().Err() == nil {
+				log.Printf("(%s) | Offset %d of %s/%d is not committed, aborting session\n",
+					fn, message.Offset, message.Topic, message.Partition)
+				h.abort()
+			}
+			continue
+		}
+		tracker.complete(message)
+	}
+}
+
+// workerOf returns the index of the worker for the message by hash of its order UID.
+// The UID is taken from the message key, or from the decoded payload for messages without a key.
+func (h *kafkaConsumerHandler) workerOf(ctx context.Context, message *sarama.ConsumerMessage) int {
+	key := message.Key
+	if len(key) == 0 && message.Value != nil {
+		if order, err := h.decode(ctx, message); err == nil {
+			key = []byte(order.OrderUID)
+		}
+	}
+	hash := fnv.New32a()
+	hash.Write(key)
+	return int(hash.Sum32() % uint32(h.workers)) //nolint:gosec // workers is positive
+}
+
+// offsetTracker tracks the messages of a partition dispatched to the workers
+// and marks the highest offset up to which all of them have been processed.
+// Offsets are tracked in the order of dispatch, so gaps in the offsets (e.g. after compaction) do not stall it.
+type offsetTracker struct {
+	mu      sync.Mutex
+	pending []*sarama.ConsumerMessage // dispatched messages in offset order, not yet marked
+	done    map[int64]bool
+	mark    func(message *sarama.ConsumerMessage)
+}
+
+// newOffsetTracker creates a tracker that calls mark with the last message of every contiguous processed run.
+func newOffsetTracker(mark func(message *sarama.ConsumerMessage)) *offsetTracker {
+	return &offsetTracker{done: make(map[int64]bool), mark: mark}
+}
+
+// add registers a message dispatched for processing.
+func (t *offsetTracker) add(message *sarama.ConsumerMessage) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.pending = append(t.pending, message)
+}
+
+// complete records the message as processed and marks the highest contiguous processed offset if it has advanced.
+func (t *offsetTracker) complete(message *sarama.ConsumerMessage) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.done[message.Offset] = true
+
+	var last *sarama.ConsumerMessage
+	for len(t.pending) > 0 && t.done[t.pending[0].Offset] {
+		last = t.pending[0]
+		delete(t.done, last.Offset)
+		t.pending[0] = nil
+		t.pending = t.pending[1:]
+	}
+	if last != nil {
+		t.mark(last)
+	}
+}
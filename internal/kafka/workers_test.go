@@ -0,0 +1,168 @@
+package kafka
+
+import (
+	"demo_service/internal/models"
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/IBM/sarama"
+)
+
+// trackMessages returns the messages at the offsets, added to a tracker that records the marked offsets.
+func trackMessages(offsets ...int64) (*offsetTracker, []*sarama.ConsumerMessage, *[]int64) {
+	var marked []int64
+	tracker := newOffsetTracker(func(message *sarama.ConsumerMessage) {
+		marked = append(marked, message.Offset)
+	})
+	messages := make([]*sarama.ConsumerMessage, 0, len(offsets))
+	for _, offset := range offsets {
+		message := &sarama.ConsumerMessage{Topic: "orders", Offset: offset}
+		tracker.add(message)
+		messages = append(messages, message)
+	}
+	return tracker, messages, &marked
+}
+
+func TestOffsetTrackerOutOfOrderCompletion(t *testing.T) {
+	tracker, messages, marked := trackMessages(0, 1, 2, 3)
+
+	// Пока сообщение 0 не обработано, более поздние не отмечаются
+	tracker.complete(messages[2])
+	tracker.complete(messages[1])
+	if len(*marked) != 0 {
+		t.Fatalf("marked %v; want nothing while offset 0 is in flight", *marked)
+	}
+
+	tracker.complete(messages[0])
+	if want := []int64{2}; !slices.Equal(*marked, want) {
+		t.Fatalf("marked %v; want %v once the gap is filled", *marked, want)
+	}
+
+	tracker.complete(messages[3])
+	if want := []int64{2, 3}; !slices.Equal(*marked, want) {
+		t.Errorf("marked %v; want %v", *marked, want)
+	}
+}
+
+func TestOffsetTrackerFailedMessageHoldsBack(t *testing.T) {
+	tracker, messages, marked := trackMessages(0, 1, 2)
+
+	// Сообщение 1 не обработано: отметка не продвигается дальше 0
+	tracker.complete(messages[0])
+	tracker.complete(messages[2])
+	if want := []int64{0}; !slices.Equal(*marked, want) {
+		t.Errorf("marked %v; want %v while offset 1 has failed", *marked, want)
+	}
+}
+
+func TestOffsetTrackerOffsetGaps(t *testing.T) {
+	// Смещения с пропусками, например после компактизации топика
+	tracker, messages, marked := trackMessages(3, 7, 8)
+
+	tracker.complete(messages[1])
+	tracker.complete(messages[0])
+	tracker.complete(messages[2])
+	if want := []int64{7, 8}; !slices.Equal(*marked, want) {
+		t.Errorf("marked %v; want %v", *marked, want)
+	}
+}
+
+// markedOffsets returns the offsets marked in the session calls.
+func markedOffsets(t *testing.T, calls []string) []int64 {
+	t.Helper()
+
+	var offsets []int64
+	for _, call := range calls {
+		if value, ok := strings.CutPrefix(call, "mark "); ok {
+			offset, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				t.Fatal(err)
+			}
+			offsets = append(offsets, offset)
+		}
+	}
+	return offsets
+}
+
+func TestConsumeParallelFailedMessageHoldsCommit(t *testing.T) {
+	calls, aborted := consumeClaim(t, 4, func(order models.Order) error {
+		if order.OrderUID == "order-3" {
+			return errors.New("database is down")
+		}
+		return nil
+	}, "order-1", "order-2", "order-3", "order-4", "order-5")
+
+	if !aborted {
+		t.Error("session has not been aborted")
+	}
+	// order-3 имеет смещение 2: отмеченное смещение (следующее к чтению) не может превышать 2
+	for _, offset := range markedOffsets(t, calls) {
+		if offset > 2 {
+			t.Errorf("session calls = %v; want no offset marked past the failed message", calls)
+			break
+		}
+	}
+}
+
+func TestConsumeParallelDeadLetteredMessageIsCommitted(t *testing.T) {
+	broker := NewMemoryBroker(1)
+	calls, aborted := runClaim(t, &kafkaConsumerHandler{
+		messageHandler: func(order models.Order) error {
+			if order.OrderUID == "order-3" {
+				return errors.New("order is rejected")
+			}
+			return nil
+		},
+		workers:     4,
+		dlq:         NewDeadLetterQueue(broker.Producer(), "orders-dlq"),
+		atLeastOnce: true,
+		lifecycle:   &lifecycle{},
+	}, "order-1", "order-2", "order-3", "order-4", "order-5")
+
+	if aborted {
+		t.Fatal("session has been aborted")
+	}
+	if len(calls) < 2 || calls[len(calls)-2] != "mark 5" || calls[len(calls)-1] != "commit" {
+		t.Errorf("session calls = %v; want the last offset marked past the dead-lettered message, then a commit", calls)
+	}
+	if dead := broker.Messages("orders-dlq"); len(dead) != 1 || string(dead[0].Key) != "order-3" {
+		t.Errorf("dead-lettered %d messages; want order-3 only", len(dead))
+	}
+}
+
+func TestConsumeParallelDeadLetterFailureHoldsCommit(t *testing.T) {
+	calls, aborted := runClaim(t, &kafkaConsumerHandler{
+		messageHandler: func(order models.Order) error {
+			if order.OrderUID == "order-3" {
+				return errors.New("order is rejected")
+			}
+			return nil
+		},
+		workers:     4,
+		dlq:         NewDeadLetterQueue(failingProducer{}, "orders-dlq"),
+		atLeastOnce: true,
+		lifecycle:   &lifecycle{},
+	}, "order-1", "order-2", "order-3", "order-4", "order-5")
+
+	if !aborted {
+		t.Error("session has not been aborted")
+	}
+	for _, offset := range markedOffsets(t, calls) {
+		if offset > 2 {
+			t.Errorf("session calls = %v; want no offset marked past the message that was not dead-lettered", calls)
+			break
+		}
+	}
+}
+
+// failingProducer is a sarama.SyncProducer whose sends fail.
+type failingProducer struct {
+	sarama.SyncProducer
+}
+
+func (failingProducer) SendMessage(*sarama.ProducerMessage) (int32, int64, error) {
+	return 0, 0, errors.New("broker is down")
+}
@@ -0,0 +1,269 @@
+// Package listing describes the paginated listing of orders: filters, sorting and cursors.
+//
+// Pages are built with keyset pagination: orders are sorted by the sort field and then by their UID,
+// and the cursor of the next page holds the key of the last order of the current one,
+// so a page is read with an index range scan however deep it is and it is stable under concurrent inserts.
+// The storage backends share the semantics defined here: Filter.Match, Query.Compare and NewPage.
+package listing
+
+import (
+	"demo_service/internal/models"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Sort fields.
+const (
+	SortDateCreated = "date_created"
+	SortUpdatedAt   = "updated_at"
+	SortAmount      = "amount"
+)
+
+// Page size limits.
+const (
+	DefaultLimit = 20
+	MaxLimit     = 100
+)
+
+// ErrInvalidQuery is returned when the parameters of a listing are malformed.
+var ErrInvalidQuery = errors.New("invalid listing query")
+
+// Filter selects the listed orders. Empty fields do not filter.
+// CreatedFrom is inclusive and CreatedTo exclusive; the amount range (of the payment) is inclusive.
+type Filter struct {
+	CustomerID      string
+	TrackNumber     string
+	DeliveryService string
+	Entry           string
+	Locale          string
+	CreatedFrom     time.Time
+	CreatedTo       time.Time
+	Provider        string
+	Currency        string
+	AmountMin       *int
+	AmountMax       *int
+}
+
+// Query is a request of a page of orders.
+type Query struct {
+	Filter Filter
+	Sort   string
+	Desc   bool
+	Limit  int
+	After  *Cursor
+}
+
+// Cursor is the key of the last order of a page, the next page starts after it.
+// It is bound to the sort it has been created with.
+type Cursor struct {
+	Sort     string    `json:"s"`
+	Desc     bool      `json:"d,omitempty"`
+	Time     time.Time `json:"t,omitempty"`
+	Amount   int       `json:"a,omitempty"`
+	OrderUID string    `json:"u"`
+}
+
+// Page is a page of orders along with the cursor of the next page, empty on the last page.
+type Page struct {
+	Orders []models.Order `json:"orders"`
+	Next   string         `json:"next_cursor,omitempty"`
+}
+
+// ParseQuery reads the query from URL parameters:
+// customer_id, track_number, delivery_service, entry, locale, created_from, created_to (RFC 3339),
+// payment.provider, payment.currency, amount_min, amount_max, sort (a sort field, "-" prefixed for descending order;
+// newest first by default), limit and cursor. Errors wrap ErrInvalidQuery.
+func ParseQuery(values url.Values) (Query, error) {
+	const fn = "ParseQuery"
+
+	q := Query{
+		Filter: Filter{
+			CustomerID:      values.Get("customer_id"),
+			TrackNumber:     values.Get("track_number"),
+			DeliveryService: values.Get("delivery_service"),
+			Entry:           values.Get("entry"),
+			Locale:          values.Get("locale"),
+			Provider:        values.Get("payment.provider"),
+			Currency:        values.Get("payment.currency"),
+		},
+		Sort:  SortDateCreated,
+		Desc:  true,
+		Limit: DefaultLimit,
+	}
+
+	var err error
+	if q.Filter.CreatedFrom, err = parseTime(values, "created_from"); err != nil {
+		return Query{}, fmt.Errorf("(%s) | %w", fn, err)
+	}
+	if q.Filter.CreatedTo, err = parseTime(values, "created_to"); err != nil {
+		return Query{}, fmt.Errorf("(%s) | %w", fn, err)
+	}
+	if q.Filter.AmountMin, err = parseInt(values, "amount_min"); err != nil {
+		return Query{}, fmt.Errorf("(%s) | %w", fn, err)
+	}
+	if q.Filter.AmountMax, err = parseInt(values, "amount_max"); err != nil {
+		return Query{}, fmt.Errorf("(%s) | %w", fn, err)
+	}
+
+	if sort := values.Get("sort"); sort != "" {
+		q.Sort, q.Desc = strings.TrimPrefix(sort, "-"), strings.HasPrefix(sort, "-")
+		switch q.Sort {
+		case SortDateCreated, SortUpdatedAt, SortAmount:
+		default:
+			return Query{}, fmt.Errorf("(%s) | %w: unknown sort field %q", fn, ErrInvalidQuery, q.Sort)
+		}
+	}
+
+	if limit, err := parseInt(values, "limit"); err != nil {
+		return Query{}, fmt.Errorf("(%s) | %w", fn, err)
+	} else if limit != nil {
+		if *limit < 1 || *limit > MaxLimit {
+			return Query{}, fmt.Errorf("(%s) | %w: limit must be between 1 and %d", fn, ErrInvalidQuery, MaxLimit)
+		}
+		q.Limit = *limit
+	}
+
+	if cursor := values.Get("cursor"); cursor != "" {
+		if q.After, err = decodeCursor(cursor); err != nil {
+			return Query{}, fmt.Errorf("(%s) | %w", fn, err)
+		}
+		if q.After.Sort != q.Sort || q.After.Desc != q.Desc {
+			return Query{}, fmt.Errorf("(%s) | %w: the cursor belongs to another sort", fn, ErrInvalidQuery)
+		}
+	}
+	return q, nil
+}
+
+// Match reports whether the order passes the filter.
+func (f Filter) Match(order models.Order) bool {
+	switch {
+	case f.CustomerID != "" && order.CustomerID != f.CustomerID,
+		f.TrackNumber != "" && order.TrackNumber != f.TrackNumber,
+		f.DeliveryService != "" && order.DeliveryService != f.DeliveryService,
+		f.Entry != "" && order.Entry != f.Entry,
+		f.Locale != "" && order.Locale != f.Locale,
+		!f.CreatedFrom.IsZero() && order.DateCreated.Before(f.CreatedFrom),
+		!f.CreatedTo.IsZero() && !order.DateCreated.Before(f.CreatedTo),
+		f.Provider != "" && order.Payment.Provider != f.Provider,
+		f.Currency != "" && order.Payment.Currency != f.Currency,
+		f.AmountMin != nil && order.Payment.Amount < *f.AmountMin,
+		f.AmountMax != nil && order.Payment.Amount > *f.AmountMax:
+		return false
+	}
+	return true
+}
+
+// Compare returns a negative number if the order a is listed before b, a positive one if after, 0 if they are the same.
+func (q Query) Compare(a, b models.Order) int {
+	return q.compareKey(q.CursorOf(a), q.CursorOf(b))
+}
+
+// IsAfter reports whether the order is listed after the cursor of the query, always true without one.
+func (q Query) IsAfter(order models.Order) bool {
+	return q.After == nil || q.compareKey(q.CursorOf(order), *q.After) > 0
+}
+
+// CursorOf returns the cursor pointing at the order in the sort of the query.
+func (q Query) CursorOf(order models.Order) Cursor {
+	c := Cursor{Sort: q.Sort, Desc: q.Desc, OrderUID: order.OrderUID}
+	switch q.Sort {
+	case SortUpdatedAt:
+		c.Time = order.UpdatedAt
+	case SortAmount:
+		c.Amount = order.Payment.Amount
+	default:
+		c.Time = order.DateCreated
+	}
+	return c
+}
+
+// compareKey compares the keys of the cursors in the sort of the query.
+func (q Query) compareKey(a, b Cursor) int {
+	var cmp int
+	if q.Sort == SortAmount {
+		cmp = a.Amount - b.Amount
+	} else {
+		cmp = a.Time.Compare(b.Time)
+	}
+	if cmp == 0 {
+		cmp = strings.Compare(a.OrderUID, b.OrderUID)
+	}
+	if q.Desc {
+		return -cmp
+	}
+	return cmp
+}
+
+// NewPage builds the page from up to Limit+1 orders read in the listing order:
+// the extra order only tells there is a next page.
+func NewPage(q Query, orders []models.Order) (Page, error) {
+	page := Page{Orders: orders}
+	if page.Orders == nil {
+		page.Orders = []models.Order{}
+	}
+	if len(orders) <= q.Limit {
+		return page, nil
+	}
+
+	page.Orders = orders[:q.Limit]
+	next, err := encodeCursor(q.CursorOf(page.Orders[q.Limit-1]))
+	if err != nil {
+		return Page{}, err
+	}
+	page.Next = next
+	return page, nil
+}
+
+// encodeCursor returns the opaque representation of the cursor.
+func encodeCursor(c Cursor) (string, error) {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return "", fmt.Errorf("(encodeCursor) | %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// decodeCursor parses the opaque representation of a cursor.
+func decodeCursor(s string) (*Cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed cursor", ErrInvalidQuery)
+	}
+	var c Cursor
+	if err := json.Unmarshal(data, &c); err != nil || c.OrderUID == "" {
+		return nil, fmt.Errorf("%w: malformed cursor", ErrInvalidQuery)
+	}
+	return &c, nil
+}
+
+// parseTime parses the RFC 3339 time parameter, in UTC; the zero time if it is absent.
+func parseTime(values url.Values, name string) (time.Time, error) {
+	value := values.Get(name)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: %s must be an RFC 3339 time", ErrInvalidQuery, name)
+	}
+	return t.UTC(), nil
+}
+
+// parseInt parses the integer parameter; nil if it is absent.
+func parseInt(values url.Values, name string) (*int, error) {
+	value := values.Get(name)
+	if value == "" {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s must be an integer", ErrInvalidQuery, name)
+	}
+	return &n, nil
+}
@@ -0,0 +1,462 @@
+// Package migrate applies the versioned SQL migrations of the database schema.
+//
+// Migrations are read from files named NNNN_name.up.sql and NNNN_name.down.sql (the ones of the service
+// are embedded in the migrations package). Applied versions are recorded in the schema_versions table.
+// Every migration runs in its own transaction, and all changes are made under a session advisory lock,
+// so several instances of the service starting at once apply each migration exactly once.
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// lockKey identifies the advisory lock held while migrating.
+const lockKey int64 = 0x64656d6f5f6d6967
+
+// ErrSchemaBehind is returned by Check when migrations known to the service are not applied.
+var ErrSchemaBehind = errors.New("database schema is behind")
+
+var fileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+var queries = map[string]string{
+	"createVersions": `
+		CREATE TABLE IF NOT EXISTS schema_versions (
+			version    INTEGER PRIMARY KEY,
+			name       TEXT      NOT NULL,
+			applied_at TIMESTAMP NOT NULL DEFAULT now()
+		);
+	`,
+	"getVersions": `
+		SELECT version, applied_at
+		FROM schema_versions
+		ORDER BY version;
+	`,
+	"insertVersion": `
+		INSERT INTO schema_versions (version, name)
+		VALUES ($1, $2);
+	`,
+	"deleteVersion": `
+		DELETE FROM schema_versions
+		WHERE version = $1;
+	`,
+	"lock": `
+		SELECT pg_advisory_lock($1);
+	`,
+	"unlock": `
+		SELECT pg_advisory_unlock($1);
+	`,
+	// The schema could have been migrated by golang-migrate (the migrate/migrate container) before.
+	"getLegacyVersion": `
+		SELECT version, dirty
+		FROM schema_migrations
+		LIMIT 1;
+	`,
+	"legacyExists": `
+		SELECT to_regclass('schema_migrations') IS NOT NULL;
+	`,
+}
+
+// Migration is a version of the schema with the statements that apply and revert it.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// State is a migration along with the time it was applied at, nil if it is pending.
+type State struct {
+	Version   int        `json:"version"`
+	Name      string     `json:"name"`
+	AppliedAt *time.Time `json:"applied_at,omitempty"`
+}
+
+// Migrator applies migrations to the database.
+type Migrator struct {
+	pool       *pgxpool.Pool
+	migrations []Migration
+}
+
+// Load reads the migrations from the root of fsys, ordered by version.
+// Every version must have both an up and a down file.
+func Load(fsys fs.FS) ([]Migration, error) {
+	const fn = "migrate.Load"
+
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, fmt.Errorf("(%s) | %w", fn, err)
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		match := fileName.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			continue
+		}
+		version, err := strconv.Atoi(match[1])
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("(%s) | %s: invalid version", fn, entry.Name())
+		}
+		body, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, fmt.Errorf("(%s) | %w", fn, err)
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		} else if m.Name != match[2] {
+			return nil, fmt.Errorf("(%s) | version %d has two names: %q and %q", fn, version, m.Name, match[2])
+		}
+		if match[3] == "up" {
+			m.Up = string(body)
+		} else {
+			m.Down = string(body)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("(%s) | migration %04d_%s must have both up and down files", fn, m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// New creates a Migrator of the database with the migrations read from fsys.
+func New(pool *pgxpool.Pool, fsys fs.FS) (*Migrator, error) {
+	migrations, err := Load(fsys)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{pool: pool, migrations: migrations}, nil
+}
+
+// Latest returns the version of the last known migration, 0 if there are none.
+func (m *Migrator) Latest() int {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// Status returns the known migrations with the time they were applied at.
+// Applied versions that are not known (applied by a newer release) are listed too.
+func (m *Migrator) Status(ctx context.Context) ([]State, error) {
+	var states []State
+	err := m.withLock(ctx, func(conn *pgxpool.Conn) error {
+		applied, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range m.migrations {
+			state := State{Version: migration.Version, Name: migration.Name}
+			if at, ok := applied[migration.Version]; ok {
+				state.AppliedAt = &at
+				delete(applied, migration.Version)
+			}
+			states = append(states, state)
+		}
+		for version, at := range applied {
+			states = append(states, State{Version: version, Name: "(unknown)", AppliedAt: &at})
+		}
+		sort.Slice(states, func(i, j int) bool { return states[i].Version < states[j].Version })
+		return nil
+	})
+	return states, err
+}
+
+// Check returns an error wrapping ErrSchemaBehind if any known migration is not applied.
+func (m *Migrator) Check(ctx context.Context) error {
+	const fn = "migrate.Check"
+
+	states, err := m.Status(ctx)
+	if err != nil {
+		return err
+	}
+
+	current, pending := 0, 0
+	for _, state := range states {
+		if state.AppliedAt == nil {
+			pending++
+		} else if state.Version > current {
+			current = state.Version
+		}
+	}
+	if pending > 0 {
+		return fmt.Errorf("(%s) | %w: version %d, %d migration(s) pending up to %d",
+			fn, ErrSchemaBehind, current, pending, m.Latest())
+	}
+	return nil
+}
+
+// Up applies all pending migrations and returns the number of applied ones.
+func (m *Migrator) Up(ctx context.Context) (int, error) {
+	return m.To(ctx, m.Latest())
+}
+
+// Down reverts the last steps applied migrations and returns the number of reverted ones.
+func (m *Migrator) Down(ctx context.Context, steps int) (int, error) {
+	const fn = "migrate.Down"
+
+	if steps <= 0 {
+		return 0, fmt.Errorf("(%s) | the number of steps must be positive", fn)
+	}
+
+	count := 0
+	err := m.withLock(ctx, func(conn *pgxpool.Conn) error {
+		applied, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+		versions := make([]int, 0, len(applied))
+		for version := range applied {
+			versions = append(versions, version)
+		}
+		sort.Sort(sort.Reverse(sort.IntSlice(versions)))
+		if len(versions) > steps {
+			versions = versions[:steps]
+		}
+
+		for _, version := range versions {
+			if err := m.revert(ctx, conn, version); err != nil {
+				return err
+			}
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
+
+// To migrates the schema to the version: pending migrations up to it are applied in ascending order,
+// and applied migrations above it are reverted in descending order. It returns the number of changed migrations.
+func (m *Migrator) To(ctx context.Context, version int) (int, error) {
+	const fn = "migrate.To"
+
+	if version < 0 {
+		return 0, fmt.Errorf("(%s) | invalid version: %d", fn, version)
+	}
+	if version != 0 && m.find(version) == nil {
+		return 0, fmt.Errorf("(%s) | unknown version: %d", fn, version)
+	}
+
+	count := 0
+	err := m.withLock(ctx, func(conn *pgxpool.Conn) error {
+		applied, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		var above []int
+		for v := range applied {
+			if v > version {
+				above = append(above, v)
+			}
+		}
+		sort.Sort(sort.Reverse(sort.IntSlice(above)))
+		for _, v := range above {
+			if err := m.revert(ctx, conn, v); err != nil {
+				return err
+			}
+			count++
+		}
+
+		for _, migration := range m.migrations {
+			if _, ok := applied[migration.Version]; ok || migration.Version > version {
+				continue
+			}
+			if err := m.apply(ctx, conn, migration); err != nil {
+				return err
+			}
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
+
+// find returns the known migration of the version, or nil.
+func (m *Migrator) find(version int) *Migration {
+	for i := range m.migrations {
+		if m.migrations[i].Version == version {
+			return &m.migrations[i]
+		}
+	}
+	return nil
+}
+
+// apply runs the up statements of the migration and records its version in one transaction.
+func (m *Migrator) apply(ctx context.Context, conn *pgxpool.Conn, migration Migration) error {
+	const fn = "migrate.apply"
+
+	err := inTx(ctx, conn, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, migration.Up); err != nil {
+			return err
+		}
+		_, err := tx.Exec(ctx, queries["insertVersion"], migration.Version, migration.Name)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("(%s) | %04d_%s: %w", fn, migration.Version, migration.Name, err)
+	}
+	log.Printf("(%s) | Applied migration %04d_%s\n", fn, migration.Version, migration.Name)
+	return nil
+}
+
+// revert runs the down statements of the migration and removes its version in one transaction.
+func (m *Migrator) revert(ctx context.Context, conn *pgxpool.Conn, version int) error {
+	const fn = "migrate.revert"
+
+	migration := m.find(version)
+	if migration == nil {
+		return fmt.Errorf("(%s) | migration %d is applied but not known, it cannot be reverted", fn, version)
+	}
+
+	err := inTx(ctx, conn, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, migration.Down); err != nil {
+			return err
+		}
+		_, err := tx.Exec(ctx, queries["deleteVersion"], migration.Version)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("(%s) | %04d_%s: %w", fn, migration.Version, migration.Name, err)
+	}
+	log.Printf("(%s) | Reverted migration %04d_%s\n", fn, migration.Version, migration.Name)
+	return nil
+}
+
+// withLock runs f on a single connection holding the advisory lock,
+// after the versions table has been created and, if needed, baselined.
+func (m *Migrator) withLock(ctx context.Context, f func(conn *pgxpool.Conn) error) error {
+	const fn = "migrate.withLock"
+
+	conn, err := m.pool.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("(%s) | failed to acquire a connection: %w", fn, err)
+	}
+	defer conn.Release()
+
+	if _, err := conn.Exec(ctx, queries["lock"], lockKey); err != nil {
+		return fmt.Errorf("(%s) | failed to take the migration lock: %w", fn, err)
+	}
+	defer func() {
+		// Контекст мог быть отменен, но блокировку нужно снять до возврата соединения в пул
+		if _, err := conn.Exec(context.Background(), queries["unlock"], lockKey); err != nil {
+			log.Printf("(%s) | Error releasing the migration lock: %v\n", fn, err)
+			conn.Conn().Close(context.Background())
+		}
+	}()
+
+	if _, err := conn.Exec(ctx, queries["createVersions"]); err != nil {
+		return fmt.Errorf("(%s) | failed to create the versions table: %w", fn, err)
+	}
+	if err := m.baseline(ctx, conn); err != nil {
+		return err
+	}
+	return f(conn)
+}
+
+// baseline records the migrations already applied by golang-migrate
+// when the versions table is empty and its schema_migrations table exists.
+func (m *Migrator) baseline(ctx context.Context, conn *pgxpool.Conn) error {
+	const fn = "migrate.baseline"
+
+	applied, err := appliedVersions(ctx, conn)
+	if err != nil || len(applied) > 0 {
+		return err
+	}
+
+	var exists bool
+	if err := conn.QueryRow(ctx, queries["legacyExists"]).Scan(&exists); err != nil {
+		return fmt.Errorf("(%s) | %w", fn, err)
+	}
+	if !exists {
+		return nil
+	}
+
+	var (
+		legacy int64
+		dirty  bool
+	)
+	err = conn.QueryRow(ctx, queries["getLegacyVersion"]).Scan(&legacy, &dirty)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("(%s) | %w", fn, err)
+	}
+	if dirty {
+		return fmt.Errorf("(%s) | schema_migrations is dirty at version %d, fix the schema by hand first", fn, legacy)
+	}
+
+	return inTx(ctx, conn, func(tx pgx.Tx) error {
+		for _, migration := range m.migrations {
+			if int64(migration.Version) > legacy {
+				break
+			}
+			if _, err := tx.Exec(ctx, queries["insertVersion"], migration.Version, migration.Name); err != nil {
+				return fmt.Errorf("(%s) | %w", fn, err)
+			}
+		}
+		log.Printf("(%s) | Adopted version %d from schema_migrations\n", fn, legacy)
+		return nil
+	})
+}
+
+// appliedVersions returns the applied versions with the time they were applied at.
+func appliedVersions(ctx context.Context, conn *pgxpool.Conn) (map[int]time.Time, error) {
+	const fn = "migrate.appliedVersions"
+
+	rows, err := conn.Query(ctx, queries["getVersions"])
+	if err != nil {
+		return nil, fmt.Errorf("(%s) | %w", fn, err)
+	}
+	defer rows.Close()
+
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var (
+			version int
+			at      time.Time
+		)
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, fmt.Errorf("(%s) | %w", fn, err)
+		}
+		applied[version] = at
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("(%s) | %w", fn, err)
+	}
+	return applied, nil
+}
+
+// inTx runs f in a transaction on the connection, committing it if f succeeds.
+func inTx(ctx context.Context, conn *pgxpool.Conn, f func(tx pgx.Tx) error) error {
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx) // no-op after a successful commit
+
+	if err := f(tx); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
@@ -0,0 +1,356 @@
+package migrate
+
+import (
+	"context"
+	"demo_service/migrations"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// postgresDSNEnv names the environment variable with the DSN of the PostgreSQL database of the tests.
+// Every test migrates a schema of its own, which is dropped at the end.
+const postgresDSNEnv = "TEST_POSTGRES_DSN"
+
+// testFS returns migrations that create the tables a, b and c. Applying one of them twice fails.
+func testFS() fstest.MapFS {
+	fsys := fstest.MapFS{}
+	for i, table := range []string{"a", "b", "c"} {
+		name := fmt.Sprintf("%04d_create_%s", i+1, table)
+		fsys[name+".up.sql"] = &fstest.MapFile{Data: []byte("CREATE TABLE " + table + " (id INTEGER);")}
+		fsys[name+".down.sql"] = &fstest.MapFile{Data: []byte("DROP TABLE " + table + ";")}
+	}
+	return fsys
+}
+
+func TestLoadOrdersByVersion(t *testing.T) {
+	fsys := fstest.MapFS{
+		"0010_tenth.up.sql":    {Data: []byte("up 10")},
+		"0010_tenth.down.sql":  {Data: []byte("down 10")},
+		"0002_second.up.sql":   {Data: []byte("up 2")},
+		"0002_second.down.sql": {Data: []byte("down 2")},
+		"0001_first.down.sql":  {Data: []byte("down 1")},
+		"0001_first.up.sql":    {Data: []byte("up 1")},
+		"README.md":            {Data: []byte("not a migration")},
+		"0003_nested.up.sql/x": {Data: []byte("a directory")},
+	}
+
+	loaded, err := Load(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Migration{
+		{Version: 1, Name: "first", Up: "up 1", Down: "down 1"},
+		{Version: 2, Name: "second", Up: "up 2", Down: "down 2"},
+		{Version: 10, Name: "tenth", Up: "up 10", Down: "down 10"},
+	}
+	if len(loaded) != len(want) {
+		t.Fatalf("Load() = %+v; want %+v", loaded, want)
+	}
+	for i := range want {
+		if loaded[i] != want[i] {
+			t.Errorf("migration %d = %+v; want %+v", i, loaded[i], want[i])
+		}
+	}
+}
+
+func TestLoadRejectsInvalidSets(t *testing.T) {
+	cases := []struct {
+		name string
+		fsys fstest.MapFS
+		want string
+	}{
+		{"DuplicateVersion", fstest.MapFS{
+			"0001_first.up.sql":    {Data: []byte("up")},
+			"0001_first.down.sql":  {Data: []byte("down")},
+			"0001_second.up.sql":   {Data: []byte("up")},
+			"0001_second.down.sql": {Data: []byte("down")},
+		}, "two names"},
+		{"MissingDown", fstest.MapFS{
+			"0001_first.up.sql": {Data: []byte("up")},
+		}, "both up and down"},
+		{"EmptyUp", fstest.MapFS{
+			"0001_first.up.sql":   {Data: []byte("")},
+			"0001_first.down.sql": {Data: []byte("down")},
+		}, "both up and down"},
+		{"ZeroVersion", fstest.MapFS{
+			"0000_zero.up.sql":   {Data: []byte("up")},
+			"0000_zero.down.sql": {Data: []byte("down")},
+		}, "invalid version"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := Load(c.fsys)
+			if err == nil || !strings.Contains(err.Error(), c.want) {
+				t.Errorf("Load() error = %v; want it to mention %q", err, c.want)
+			}
+		})
+	}
+}
+
+func TestLoadServiceMigrations(t *testing.T) {
+	loaded, err := Load(migrations.FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, m := range loaded {
+		if m.Version != i+1 {
+			t.Errorf("migration %d has version %d; want versions without gaps", i, m.Version)
+		}
+	}
+}
+
+// openSchema connects to the test database with a new schema as the search path and drops the schema at the end.
+// It skips the test if the DSN is not set.
+func openSchema(t *testing.T) *pgxpool.Pool {
+	t.Helper()
+
+	dsn := os.Getenv(postgresDSNEnv)
+	if dsn == "" {
+		t.Skipf("%s is not set", postgresDSNEnv)
+	}
+	ctx := context.Background()
+
+	admin, err := pgxpool.Connect(ctx, dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema := fmt.Sprintf("migrate_test_%d", time.Now().UnixNano())
+	if _, err := admin.Exec(ctx, "CREATE SCHEMA "+schema); err != nil {
+		admin.Close()
+		t.Fatal(err)
+	}
+
+	cfg, err := pgxpool.ParseConfig(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.ConnConfig.RuntimeParams["search_path"] = schema
+	pool, err := pgxpool.ConnectConfig(ctx, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		pool.Close()
+		if _, err := admin.Exec(ctx, "DROP SCHEMA "+schema+" CASCADE"); err != nil {
+			t.Error(err)
+		}
+		admin.Close()
+	})
+	return pool
+}
+
+// newMigrator returns a Migrator of the pool with the migrations of testFS.
+func newMigrator(t *testing.T, pool *pgxpool.Pool) *Migrator {
+	t.Helper()
+
+	migrator, err := New(pool, testFS())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return migrator
+}
+
+// appliedList returns the applied versions in ascending order.
+func appliedList(t *testing.T, pool *pgxpool.Pool) []int {
+	t.Helper()
+
+	rows, err := pool.Query(context.Background(), queries["getVersions"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	var versions []int
+	for rows.Next() {
+		var (
+			version int
+			at      time.Time
+		)
+		if err := rows.Scan(&version, &at); err != nil {
+			t.Fatal(err)
+		}
+		versions = append(versions, version)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return versions
+}
+
+func TestUp(t *testing.T) {
+	pool := openSchema(t)
+	migrator := newMigrator(t, pool)
+	ctx := context.Background()
+
+	if err := migrator.Check(ctx); err == nil {
+		t.Error("Check() before Up: want ErrSchemaBehind")
+	}
+	if n, err := migrator.Up(ctx); err != nil || n != 3 {
+		t.Fatalf("Up() = %d, %v; want 3 migrations applied", n, err)
+	}
+	if got := appliedList(t, pool); fmt.Sprint(got) != "[1 2 3]" {
+		t.Errorf("applied versions = %v; want [1 2 3]", got)
+	}
+	if err := migrator.Check(ctx); err != nil {
+		t.Errorf("Check() after Up: %v", err)
+	}
+
+	// Повторный запуск ничего не применяет: миграции не идемпотентны и упали бы
+	if n, err := migrator.Up(ctx); err != nil || n != 0 {
+		t.Errorf("second Up() = %d, %v; want nothing applied", n, err)
+	}
+
+	if n, err := migrator.Down(ctx, 2); err != nil || n != 2 {
+		t.Fatalf("Down(2) = %d, %v; want 2 migrations reverted", n, err)
+	}
+	if got := appliedList(t, pool); fmt.Sprint(got) != "[1]" {
+		t.Errorf("applied versions after Down(2) = %v; want [1]", got)
+	}
+}
+
+func TestUpFailedMigrationIsNotRecorded(t *testing.T) {
+	pool := openSchema(t)
+	fsys := testFS()
+	fsys["0002_create_b.up.sql"] = &fstest.MapFile{Data: []byte("CREATE TABLE b (id INTEGER); SELECT 1/0;")}
+	migrator, err := New(pool, fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := migrator.Up(context.Background()); err == nil || n != 1 {
+		t.Fatalf("Up() = %d, %v; want migration 1 applied and an error for migration 2", n, err)
+	}
+	if got := appliedList(t, pool); fmt.Sprint(got) != "[1]" {
+		t.Errorf("applied versions = %v; want [1]", got)
+	}
+	var exists bool
+	if err := pool.QueryRow(context.Background(), "SELECT to_regclass('b') IS NOT NULL").Scan(&exists); err != nil {
+		t.Fatal(err)
+	}
+	if exists {
+		t.Error("table b exists; want the failed migration rolled back")
+	}
+}
+
+func TestUpAdoptsLegacyBaseline(t *testing.T) {
+	pool := openSchema(t)
+	ctx := context.Background()
+
+	// Схема, которую golang-migrate довел до версии 2
+	_, err := pool.Exec(ctx, `
+		CREATE TABLE a (id INTEGER);
+		CREATE TABLE b (id INTEGER);
+		CREATE TABLE schema_migrations (version BIGINT PRIMARY KEY, dirty BOOLEAN NOT NULL);
+		INSERT INTO schema_migrations (version, dirty) VALUES (2, false);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	migrator := newMigrator(t, pool)
+	if n, err := migrator.Up(ctx); err != nil || n != 1 {
+		t.Fatalf("Up() = %d, %v; want only migration 3 applied", n, err)
+	}
+	if got := appliedList(t, pool); fmt.Sprint(got) != "[1 2 3]" {
+		t.Errorf("applied versions = %v; want [1 2 3]", got)
+	}
+}
+
+func TestUpRejectsDirtyLegacyBaseline(t *testing.T) {
+	pool := openSchema(t)
+	ctx := context.Background()
+
+	_, err := pool.Exec(ctx, `
+		CREATE TABLE schema_migrations (version BIGINT PRIMARY KEY, dirty BOOLEAN NOT NULL);
+		INSERT INTO schema_migrations (version, dirty) VALUES (2, true);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	migrator := newMigrator(t, pool)
+	if _, err := migrator.Up(ctx); err == nil || !strings.Contains(err.Error(), "dirty") {
+		t.Errorf("Up() error = %v; want the dirty baseline reported", err)
+	}
+	if got := appliedList(t, pool); len(got) != 0 {
+		t.Errorf("applied versions = %v; want none", got)
+	}
+}
+
+func TestUpWaitsForAdvisoryLock(t *testing.T) {
+	pool := openSchema(t)
+	ctx := context.Background()
+
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Release()
+	if _, err := conn.Exec(ctx, queries["lock"], lockKey); err != nil {
+		t.Fatal(err)
+	}
+
+	migrator := newMigrator(t, pool)
+	done := make(chan error, 1)
+	go func() {
+		_, err := migrator.Up(ctx)
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		t.Fatalf("Up() returned while the lock was held: %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	if _, err := conn.Exec(ctx, queries["unlock"], lockKey); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Up() error = %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Up() has not returned after the lock was released")
+	}
+}
+
+func TestConcurrentUpAppliesOnce(t *testing.T) {
+	pool := openSchema(t)
+
+	const instances = 4
+	var (
+		wg    sync.WaitGroup
+		mu    sync.Mutex
+		total int
+	)
+	for i := 0; i < instances; i++ {
+		migrator := newMigrator(t, pool)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			n, err := migrator.Up(context.Background())
+			if err != nil {
+				t.Error(err)
+			}
+			mu.Lock()
+			total += n
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	if total != 3 {
+		t.Errorf("%d migrations applied by %d instances; want each of the 3 applied once", total, instances)
+	}
+	if got := appliedList(t, pool); fmt.Sprint(got) != "[1 2 3]" {
+		t.Errorf("applied versions = %v; want [1 2 3]", got)
+	}
+}
@@ -4,7 +4,10 @@
 // The structures are designed to work with both the database and the JSON representation of the data.
 package models
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 // Order represents an order placed by a customer, including its unique identifier,
 // tracking information, delivery and payment details, items in the order,
@@ -24,6 +27,58 @@ type Order struct {
 	SmID              int       `json:"sm_id" db:"sm_id"`
 	DateCreated       time.Time `json:"date_created" db:"date_created"`
 	OofShard          string    `json:"oof_shard" db:"oof_shard"`
+	Checks            []Check   `json:"checks,omitempty" db:"-"`
+	Version           int       `json:"version" db:"-"`
+	UpdatedAt         time.Time `json:"updated_at" db:"-"`
+	Source            *Source   `json:"source,omitempty" db:"-"`
+}
+
+// Source is the ingestion metadata of the broker record an order has been received from:
+// its position (topic, partition, offset), key, headers and timestamp.
+type Source struct {
+	Topic     string            `json:"topic"`
+	Partition int32             `json:"partition"`
+	Offset    int64             `json:"offset"`
+	Key       string            `json:"key,omitempty"`
+	Headers   map[string]string `json:"headers,omitempty"`
+	Timestamp time.Time         `json:"timestamp,omitempty"`
+}
+
+// Tombstone is a request received from the broker to remove an order:
+// it is deleted, or anonymized if Anonymize is set.
+type Tombstone struct {
+	OrderUID  string
+	Anonymize bool
+}
+
+// Revision represents a received revision of an order in its audit trail.
+// Version is the order version the revision has been stored as,
+// or nil if it has not been applied (e.g. a resend of an existing order).
+type Revision struct {
+	ID         int64           `json:"id"`
+	OrderUID   string          `json:"order_uid"`
+	Version    *int            `json:"version"`
+	Payload    json.RawMessage `json:"payload"`
+	Source     *Source         `json:"source,omitempty"`
+	ReceivedAt time.Time       `json:"received_at"`
+}
+
+// IdempotencyRecord is the stored outcome of a request made with an Idempotency-Key.
+// StatusCode and Response are nil while the request is in progress.
+type IdempotencyRecord struct {
+	Key         string
+	RequestHash string
+	StatusCode  *int
+	Response    []byte
+	CreatedAt   time.Time
+}
+
+// Check represents the result of a business rule that an order has violated
+// but was nevertheless accepted with (warning or tag action).
+type Check struct {
+	Rule    string `json:"rule"`
+	Action  string `json:"action"`
+	Message string `json:"message"`
 }
 
 // Delivery represents a single pelivery in an order
@@ -1,12 +1,17 @@
 // Package order provides functionality for managing orders.
-// It includes operations for retrieving orders from the cache or database,
-// and for saving them in the cache. The package utilizes a caching layer
+// It includes operations for validating and saving orders, deleting and anonymizing them,
+// retrieving orders (by their UID, track number, payment transaction or customer)
+// from the cache or database, and for saving them in the cache. The package utilizes a caching layer
 // to optimize performance by reducing redundant database queries.
 package order
 
 import (
 	"context"
+	"demo_service/internal/history"
+	"demo_service/internal/listing"
 	"demo_service/internal/models"
+	"demo_service/internal/search"
+	"demo_service/internal/validation"
 	"fmt"
 )
 
@@ -14,28 +19,118 @@ import (
 type Cache interface {
 	Set(key string, value models.Order) bool
 	Get(key string) (models.Order, bool)
+	GetByTrackNumber(trackNumber string) (models.Order, bool)
+	GetByTransaction(transaction string) (models.Order, bool)
+	SetByTrackNumber(trackNumber string, order models.Order) bool
+	SetByTransaction(transaction string, order models.Order) bool
+	GetCustomerOrders(customerID string) ([]models.Order, bool)
+	SetCustomerOrders(customerID string, orders []models.Order) bool
+	Delete(key string) bool
 }
 
 // DB interface defines methods for interacting with the order database.
 type DB interface {
-	SaveOrder(ctx context.Context, order models.Order) error
+	SaveOrder(ctx context.Context, order *models.Order) (bool, error)
+	SaveOrders(ctx context.Context, orders []models.Order) ([]models.Order, error)
 	GetOrderByUID(ctx context.Context, orderUID string) (models.Order, error)
+	GetOrderByTrackNumber(ctx context.Context, trackNumber string) (models.Order, error)
+	GetOrderByTransaction(ctx context.Context, transaction string) (models.Order, error)
+	GetCustomerOrders(ctx context.Context, customerID string) ([]models.Order, error)
+	FindOrders(ctx context.Context, q listing.Query) (listing.Page, error)
+	SearchOrders(ctx context.Context, q search.Query) ([]search.Result, error)
+	GetOrderHistory(ctx context.Context, orderUID string) ([]models.Revision, error)
+	DeleteOrder(ctx context.Context, orderUID string) (bool, error)
+	AnonymizeOrder(ctx context.Context, orderUID string) (bool, error)
 }
 
-// Order struct represents the order of the module with context, cache and database.
+// Checker interface defines methods for evaluating business rules on an order.
+type Checker interface {
+	Check(order *models.Order) error
+}
+
+// Order struct represents the order of the module with context, cache, database and business rules.
 type Order struct {
-	ctx   context.Context
-	cache Cache
-	db    DB
+	ctx     context.Context
+	cache   Cache
+	db      DB
+	checker Checker
 }
 
-// New creates a new module Order object with the specified context, cache and database.
-func New(ctx context.Context, cache Cache, db DB) *Order {
+// New creates a new module Order object with the specified context, cache, database and business rules.
+func New(ctx context.Context, cache Cache, db DB, checker Checker) *Order {
 	return &Order{
-		ctx:   ctx,
-		cache: cache,
-		db:    db,
+		ctx:     ctx,
+		cache:   cache,
+		db:      db,
+		checker: checker,
+	}
+}
+
+// SaveOrder validates the order, evaluates the business rules,
+// persists it in the database and puts the stored revision in the cache.
+// A validation failure is returned as validation.Errors and nothing is written,
+// as is a violation of a rejecting business rule.
+// If the database keeps its current revision of the order, the cache is left untouched.
+func (o *Order) SaveOrder(ctx context.Context, order models.Order) error {
+	_, _, err := o.StoreOrder(ctx, order)
+	return err
+}
+
+// StoreOrder works like SaveOrder, but also returns the order as stored
+// (with the business rule checks, version and update time) and whether it has been written.
+func (o *Order) StoreOrder(ctx context.Context, order models.Order) (models.Order, bool, error) {
+	const fn = "StoreOrder"
+
+	if err := validation.Validate(order); err != nil {
+		return order, false, fmt.Errorf("(%s) | %w", fn, err)
+	}
+	if o.checker != nil {
+		if err := o.checker.Check(&order); err != nil {
+			return order, false, fmt.Errorf("(%s) | %w", fn, err)
+		}
+	}
+	written, err := o.db.SaveOrder(ctx, &order)
+	if err != nil {
+		return order, false, fmt.Errorf("(%s) | %w", fn, err)
+	}
+	if !written {
+		return order, false, nil
+	}
+	if !o.cache.Set(order.OrderUID, order) {
+		return order, true, fmt.Errorf("(%s) | error caching order: %s", fn, order.OrderUID)
+	}
+	return order, true, nil
+}
+
+// SaveOrders validates every order and evaluates the business rules,
+// then persists all of them in the database at once and puts the stored revisions in the cache.
+// If any order is invalid, nothing is written and the error of the first invalid order is returned.
+func (o *Order) SaveOrders(ctx context.Context, orders []models.Order) error {
+	const fn = "SaveOrders"
+
+	checked := make([]models.Order, 0, len(orders))
+	for _, order := range orders {
+		if err := validation.Validate(order); err != nil {
+			return fmt.Errorf("(%s) | order %s: %w", fn, order.OrderUID, err)
+		}
+		if o.checker != nil {
+			if err := o.checker.Check(&order); err != nil {
+				return fmt.Errorf("(%s) | order %s: %w", fn, order.OrderUID, err)
+			}
+		}
+		checked = append(checked, order)
+	}
+
+	written, err := o.db.SaveOrders(ctx, checked)
+	if err != nil {
+		return fmt.Errorf("(%s) | %w", fn, err)
 	}
+	for _, order := range written {
+		if !o.cache.Set(order.OrderUID, order) {
+			return fmt.Errorf("(%s) | error caching order: %s", fn, order.OrderUID)
+		}
+	}
+	return nil
 }
 
 // GetOrder retrieves the order by its unique ID (orderUID).
@@ -63,3 +158,121 @@ func (o *Order) saveOrderInCacheAndGetIt(ctx context.Context, orderUID string) (
 	o.cache.Set(orderUID, order)
 	return &order, nil
 }
+
+// GetOrderByTrackNumber retrieves the newest order with the track number,
+// from the cache if it is known to be there, otherwise from the database, caching it.
+func (o *Order) GetOrderByTrackNumber(ctx context.Context, trackNumber string) (*models.Order, error) {
+	const fn = "GetOrderByTrackNumber"
+
+	if order, ok := o.cache.GetByTrackNumber(trackNumber); ok {
+		return &order, nil
+	}
+	order, err := o.db.GetOrderByTrackNumber(ctx, trackNumber)
+	if err != nil {
+		return nil, fmt.Errorf("(%s) | %w", fn, err)
+	}
+
+	o.cache.SetByTrackNumber(trackNumber, order)
+	return &order, nil
+}
+
+// GetOrderByTransaction retrieves the newest order paid by the transaction,
+// from the cache if it is known to be there, otherwise from the database, caching it.
+func (o *Order) GetOrderByTransaction(ctx context.Context, transaction string) (*models.Order, error) {
+	const fn = "GetOrderByTransaction"
+
+	if order, ok := o.cache.GetByTransaction(transaction); ok {
+		return &order, nil
+	}
+	order, err := o.db.GetOrderByTransaction(ctx, transaction)
+	if err != nil {
+		return nil, fmt.Errorf("(%s) | %w", fn, err)
+	}
+
+	o.cache.SetByTransaction(transaction, order)
+	return &order, nil
+}
+
+// GetCustomerOrders retrieves all orders of the customer, newest first.
+// They are served from the cache while all of them are there, otherwise read from the database and cached.
+func (o *Order) GetCustomerOrders(ctx context.Context, customerID string) ([]models.Order, error) {
+	const fn = "GetCustomerOrders"
+
+	if orders, ok := o.cache.GetCustomerOrders(customerID); ok {
+		return orders, nil
+	}
+	orders, err := o.db.GetCustomerOrders(ctx, customerID)
+	if err != nil {
+		return nil, fmt.Errorf("(%s) | %w", fn, err)
+	}
+
+	o.cache.SetCustomerOrders(customerID, orders)
+	return orders, nil
+}
+
+// ListOrders returns a page of the stored orders matching the query.
+// The listing is always read from the database, which holds every order, unlike the cache.
+func (o *Order) ListOrders(ctx context.Context, q listing.Query) (listing.Page, error) {
+	const fn = "ListOrders"
+
+	page, err := o.db.FindOrders(ctx, q)
+	if err != nil {
+		return listing.Page{}, fmt.Errorf("(%s) | %w", fn, err)
+	}
+	return page, nil
+}
+
+// SearchOrders returns the stored orders matching the full-text query, best ranked first,
+// with highlighted snippets. Like the listing, the search is always run by the database.
+func (o *Order) SearchOrders(ctx context.Context, q search.Query) ([]search.Result, error) {
+	const fn = "SearchOrders"
+
+	results, err := o.db.SearchOrders(ctx, q)
+	if err != nil {
+		return nil, fmt.Errorf("(%s) | %w", fn, err)
+	}
+	return results, nil
+}
+
+// GetOrderHistory retrieves the received revisions of the order from the database
+// along with the field-level changes between consecutive revisions.
+func (o *Order) GetOrderHistory(ctx context.Context, orderUID string) ([]history.Entry, error) {
+	const fn = "GetOrderHistory"
+
+	revisions, err := o.db.GetOrderHistory(ctx, orderUID)
+	if err != nil {
+		return nil, fmt.Errorf("(%s) | %w", fn, err)
+	}
+	entries, err := history.Build(revisions)
+	if err != nil {
+		return nil, fmt.Errorf("(%s) | %w", fn, err)
+	}
+	return entries, nil
+}
+
+// DeleteOrder deletes the order from the database and evicts it from the cache.
+// It returns false if the order does not exist.
+func (o *Order) DeleteOrder(ctx context.Context, orderUID string) (bool, error) {
+	const fn = "DeleteOrder"
+
+	deleted, err := o.db.DeleteOrder(ctx, orderUID)
+	if err != nil {
+		return false, fmt.Errorf("(%s) | %w", fn, err)
+	}
+	o.cache.Delete(orderUID)
+	return deleted, nil
+}
+
+// AnonymizeOrder scrubs the personal delivery data of the order in the database
+// and evicts it from the cache, so it is reloaded anonymized.
+// It returns false if the order does not exist.
+func (o *Order) AnonymizeOrder(ctx context.Context, orderUID string) (bool, error) {
+	const fn = "AnonymizeOrder"
+
+	anonymized, err := o.db.AnonymizeOrder(ctx, orderUID)
+	if err != nil {
+		return false, fmt.Errorf("(%s) | %w", fn, err)
+	}
+	o.cache.Delete(orderUID)
+	return anonymized, nil
+}
@@ -0,0 +1,421 @@
+// Package natsjs provides an adapter for consuming orders from a NATS JetStream stream.
+//
+// The ConsumerAdapter pulls messages through a durable consumer, so the position survives restarts
+// and is shared by all instances of the service. Every message is acknowledged explicitly
+// once its outcome is final: it is acked when processed, terminated when it can never be processed
+// (undecodable, invalid or rejected by business rules), and negatively acknowledged otherwise,
+// so JetStream redelivers it (at most the configured number of times).
+// A message that is terminated, or fails on its last delivery, is republished to the dead-letter subject first,
+// if one is configured; if that fails, the message is negatively acknowledged instead, so it is not lost silently.
+//
+// Payloads are decoded with the codecs of the kafka package, selected by the content-type header.
+// A message with an empty payload is a tombstone for the order named by the HeaderOrderUID header.
+package natsjs
+
+import (
+	"context"
+	"demo_service/internal/config"
+	"demo_service/internal/kafka"
+	"demo_service/internal/models"
+	"demo_service/internal/rules"
+	"demo_service/internal/validation"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// HeaderOrderUID is the header that names the order removed by a tombstone.
+const HeaderOrderUID = "x-order-uid"
+
+// pollWait bounds a single fetch of messages, so cancellation is noticed in time.
+const pollWait = time.Second
+
+// MessageHandler processes a single decoded order.
+// The order's Source holds the metadata of the message it was decoded from.
+type MessageHandler func(order models.Order) error
+
+// BatchHandler processes a batch of decoded orders as a whole.
+type BatchHandler func(orders []models.Order) error
+
+// TombstoneHandler processes a tombstone.
+type TombstoneHandler func(tombstone models.Tombstone) error
+
+// acknowledger settles JetStream messages. It is replaced in tests, since acknowledging needs a NATS connection.
+type acknowledger interface {
+	Ack(msg *nats.Msg) error
+	Nak(msg *nats.Msg) error
+	Term(msg *nats.Msg) error
+}
+
+// subscriptionAcks acknowledges messages through the subscription they were fetched from.
+type subscriptionAcks struct{}
+
+func (subscriptionAcks) Ack(msg *nats.Msg) error  { return msg.Ack() }
+func (subscriptionAcks) Nak(msg *nats.Msg) error  { return msg.Nak() }
+func (subscriptionAcks) Term(msg *nats.Msg) error { return msg.Term() }
+
+// ConsumerAdapter consumes orders from a JetStream stream through a durable pull consumer.
+type ConsumerAdapter struct {
+	conn        *nats.Conn
+	sub         *nats.Subscription
+	acks        acknowledger
+	codecs      *kafka.Codecs
+	tombstones  TombstoneHandler
+	dlq         *DeadLetterQueue
+	maxDeliver  int
+	batchSize   int
+	batchLinger time.Duration
+}
+
+// NewConsumerAdapter connects to NATS, creates the stream and the durable consumer
+// if they do not exist and subscribes to the consumer.
+func NewConsumerAdapter(natsCfg config.NATS, brokerCfg config.Broker) (*ConsumerAdapter, error) {
+	const fn = "natsjs.NewConsumerAdapter"
+
+	codecs, err := kafka.NewCodecs(brokerCfg)
+	if err != nil {
+		return nil, fmt.Errorf("(%s) | %w", fn, err)
+	}
+
+	conn, err := nats.Connect(natsCfg.URL, nats.Name("demo_service"))
+	if err != nil {
+		return nil, fmt.Errorf("(%s) | Error connecting to NATS: %w", fn, err)
+	}
+
+	js, err := conn.JetStream()
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("(%s) | Error creating JetStream context: %w", fn, err)
+	}
+
+	if err := ensureConsumer(js, natsCfg); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("(%s) | %w", fn, err)
+	}
+
+	var dlq *DeadLetterQueue
+	if natsCfg.DLQ.Enabled {
+		if err := ensureStream(js, natsCfg.DLQ.Stream, natsCfg.DLQ.Subject); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("(%s) | %w", fn, err)
+		}
+		dlq = NewDeadLetterQueue(js, natsCfg.DLQ.Subject)
+	}
+
+	// Подписка привязана к созданному заранее consumer, поэтому Close не удаляет его
+	sub, err := js.PullSubscribe(natsCfg.Subject, natsCfg.Durable, nats.Bind(natsCfg.Stream, natsCfg.Durable))
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("(%s) | Error subscribing durable %s: %w", fn, natsCfg.Durable, err)
+	}
+
+	log.Printf("(%s) | NATS JetStream consumer created!\n", fn)
+
+	return &ConsumerAdapter{
+		conn:        conn,
+		sub:         sub,
+		acks:        subscriptionAcks{},
+		codecs:      codecs,
+		dlq:         dlq,
+		maxDeliver:  natsCfg.MaxDeliver,
+		batchSize:   brokerCfg.Batch.Size,
+		batchLinger: brokerCfg.Batch.Linger,
+	}, nil
+}
+
+// ensureConsumer creates the stream and the durable consumer with explicit acks if they do not exist.
+// Existing ones are used as they are.
+func ensureConsumer(js nats.JetStreamContext, natsCfg config.NATS) error {
+	if err := ensureStream(js, natsCfg.Stream, natsCfg.Subject); err != nil {
+		return err
+	}
+
+	if _, err := js.ConsumerInfo(natsCfg.Stream, natsCfg.Durable); errors.Is(err, nats.ErrConsumerNotFound) {
+		_, err = js.AddConsumer(natsCfg.Stream, &nats.ConsumerConfig{
+			Durable:       natsCfg.Durable,
+			FilterSubject: natsCfg.Subject,
+			AckPolicy:     nats.AckExplicitPolicy,
+			AckWait:       natsCfg.AckWait,
+			MaxDeliver:    natsCfg.MaxDeliver,
+		})
+		if err != nil {
+			return fmt.Errorf("error creating consumer %s: %w", natsCfg.Durable, err)
+		}
+	} else if err != nil {
+		return fmt.Errorf("error getting consumer %s: %w", natsCfg.Durable, err)
+	}
+	return nil
+}
+
+// ensureStream creates the stream that stores the subject if it does not exist.
+// An existing one is used as it is.
+func ensureStream(js nats.JetStreamContext, stream, subject string) error {
+	if _, err := js.StreamInfo(stream); errors.Is(err, nats.ErrStreamNotFound) {
+		_, err = js.AddStream(&nats.StreamConfig{Name: stream, Subjects: []string{subject}})
+		if err != nil {
+			return fmt.Errorf("error creating stream %s: %w", stream, err)
+		}
+	} else if err != nil {
+		return fmt.Errorf("error getting stream %s: %w", stream, err)
+	}
+	return nil
+}
+
+// SetDeadLetterQueue replaces the dead-letter queue used for messages that can never be processed.
+// Passing nil disables the dead-letter mechanism. It must be called before Start.
+func (a *ConsumerAdapter) SetDeadLetterQueue(dlq *DeadLetterQueue) {
+	a.dlq = dlq
+}
+
+// SetTombstoneHandler sets the handler of tombstones (messages with an empty payload).
+// Without it, tombstones are acknowledged and skipped. It must be called before Start.
+func (a *ConsumerAdapter) SetTombstoneHandler(handler TombstoneHandler) {
+	a.tombstones = handler
+}
+
+// Start fetches messages one by one and processes them with the message handler until ctx is canceled.
+func (a *ConsumerAdapter) Start(ctx context.Context, messageHandler MessageHandler) {
+	a.consume(ctx, 1, pollWait, func(messages []*nats.Msg) {
+		for _, msg := range messages {
+			a.process(ctx, msg, messageHandler)
+		}
+	})
+}
+
+// StartBatch works like Start, but fetches up to the configured batch size of messages,
+// waiting at most the configured linger time, and passes the decoded orders to the batch handler at once.
+func (a *ConsumerAdapter) StartBatch(ctx context.Context, batchHandler BatchHandler) {
+	linger := a.batchLinger
+	if linger <= 0 {
+		linger = pollWait
+	}
+	a.consume(ctx, max(a.batchSize, 1), linger, func(messages []*nats.Msg) {
+		a.processBatch(ctx, messages, batchHandler)
+	})
+}
+
+// consume fetches batches of messages until ctx is canceled.
+func (a *ConsumerAdapter) consume(ctx context.Context, size int, wait time.Duration, process func([]*nats.Msg)) {
+	const fn = "consume"
+
+	for ctx.Err() == nil {
+		messages, err := a.sub.Fetch(size, nats.MaxWait(wait))
+		if errors.Is(err, nats.ErrTimeout) || (err == nil && len(messages) == 0) {
+			continue
+		}
+		if err != nil {
+			if errors.Is(err, nats.ErrConnectionClosed) || errors.Is(err, nats.ErrBadSubscription) {
+				return
+			}
+			log.Printf("(%s) | Error fetching messages: %v\n", fn, err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(pollWait):
+			}
+			continue
+		}
+		process(messages)
+	}
+}
+
+// process decodes the message, passes the order to the handler and acknowledges the message.
+func (a *ConsumerAdapter) process(ctx context.Context, msg *nats.Msg, handler MessageHandler) {
+	if len(msg.Data) == 0 {
+		a.processTombstone(ctx, msg)
+		return
+	}
+
+	order, err := a.decode(ctx, msg)
+	if err != nil {
+		a.decodeFailed(ctx, msg, err)
+		return
+	}
+	a.settle(ctx, msg, handler(order))
+}
+
+// processBatch decodes the messages and passes the orders to the batch handler.
+// Tombstones split the batch: the orders received before a tombstone are processed first.
+func (a *ConsumerAdapter) processBatch(ctx context.Context, messages []*nats.Msg, handler BatchHandler) {
+	var orders []models.Order
+	var pending []*nats.Msg
+	for _, msg := range messages {
+		if len(msg.Data) == 0 {
+			a.processOrders(ctx, orders, pending, handler)
+			orders, pending = nil, nil
+			a.processTombstone(ctx, msg)
+			continue
+		}
+
+		order, err := a.decode(ctx, msg)
+		if err != nil {
+			a.decodeFailed(ctx, msg, err)
+			continue
+		}
+		orders = append(orders, order)
+		pending = append(pending, msg)
+	}
+	a.processOrders(ctx, orders, pending, handler)
+}
+
+// processOrders passes the orders to the batch handler and acknowledges their messages.
+// If the batch as a whole fails, every order is processed and acknowledged on its own.
+func (a *ConsumerAdapter) processOrders(ctx context.Context, orders []models.Order, messages []*nats.Msg, handler BatchHandler) {
+	const fn = "processOrders"
+
+	if len(orders) == 0 {
+		return
+	}
+	err := handler(orders)
+	if err == nil || len(orders) == 1 || ctx.Err() != nil {
+		for _, msg := range messages {
+			a.settle(ctx, msg, err)
+		}
+		return
+	}
+
+	log.Printf("(%s) | Batch of %d orders failed, processing one by one: %v\n", fn, len(orders), err)
+	for i, order := range orders {
+		a.settle(ctx, messages[i], handler([]models.Order{order}))
+	}
+}
+
+// processTombstone passes the tombstone to the tombstone handler and acknowledges the message.
+func (a *ConsumerAdapter) processTombstone(ctx context.Context, msg *nats.Msg) {
+	const fn = "processTombstone"
+
+	uid := msg.Header.Get(HeaderOrderUID)
+	if uid == "" {
+		a.terminate(msg, kafka.StageDecode, errors.New("tombstone without an order UID header"))
+		return
+	}
+	if a.tombstones == nil {
+		log.Printf("(%s) | No tombstone handler, skipping tombstone for %s\n", fn, uid)
+		a.settle(ctx, msg, nil)
+		return
+	}
+
+	tombstone := models.Tombstone{
+		OrderUID:  uid,
+		Anonymize: msg.Header.Get(kafka.HeaderOrderAction) == kafka.ActionAnonymize,
+	}
+	a.settle(ctx, msg, a.tombstones(tombstone))
+}
+
+// decode decodes the message payload into an order and attaches the message metadata as its source.
+func (a *ConsumerAdapter) decode(ctx context.Context, msg *nats.Msg) (models.Order, error) {
+	order, err := a.codecs.DecodeContentType(ctx, msg.Header.Get(kafka.HeaderContentType), msg.Data)
+	if err != nil {
+		return models.Order{}, err
+	}
+	order.Source = messageSource(msg)
+	return order, nil
+}
+
+// decodeFailed terminates an undecodable message. A message that could not be decoded
+// because the schema registry is unavailable is negatively acknowledged for redelivery instead.
+func (a *ConsumerAdapter) decodeFailed(ctx context.Context, msg *nats.Msg, err error) {
+	if errors.Is(err, kafka.ErrRegistryUnavailable) {
+		a.settle(ctx, msg, err)
+		return
+	}
+	a.terminate(msg, kafka.StageDecode, fmt.Errorf("error decoding message: %w", err))
+}
+
+// messageSource returns the ingestion metadata of the message: its subject, stream sequence,
+// message ID (as the key), headers and timestamp.
+func messageSource(msg *nats.Msg) *models.Source {
+	source := &models.Source{
+		Topic: msg.Subject,
+		Key:   msg.Header.Get(nats.MsgIdHdr),
+	}
+	if meta, err := msg.Metadata(); err == nil {
+		source.Offset = int64(meta.Sequence.Stream) //nolint:gosec // stream sequences fit in int64
+		source.Timestamp = meta.Timestamp
+	}
+	if len(msg.Header) > 0 {
+		source.Headers = make(map[string]string, len(msg.Header))
+		for key := range msg.Header {
+			source.Headers[key] = msg.Header.Get(key)
+		}
+	}
+	return source
+}
+
+// settle acknowledges the message according to the outcome of its processing.
+// Processing interrupted by shutdown and transient failures are negatively acknowledged for redelivery,
+// unless it was the last delivery; invalid or rejected orders are terminated, since redelivery would not help.
+func (a *ConsumerAdapter) settle(ctx context.Context, msg *nats.Msg, err error) {
+	const fn = "settle"
+
+	var validationErrs validation.Errors
+	var rejected *rules.RejectedError
+	switch {
+	case err == nil:
+		if ackErr := a.acks.Ack(msg); ackErr != nil {
+			log.Printf("(%s) | Error acknowledging message: %v\n", fn, ackErr)
+		}
+	case errors.As(err, &validationErrs):
+		a.terminate(msg, kafka.StageValidate, err)
+	case errors.As(err, &rejected):
+		a.terminate(msg, kafka.StageRules, err)
+	case errors.Is(err, context.Canceled) || ctx.Err() != nil:
+		log.Printf("(%s) | Processing interrupted, message will be redelivered: %v\n", fn, err)
+		a.nak(msg)
+	case a.lastDelivery(msg):
+		a.terminate(msg, kafka.StageProcess, err)
+	default:
+		log.Printf("(%s) | Message processing error, message will be redelivered: %v\n", fn, err)
+		a.nak(msg)
+	}
+}
+
+// lastDelivery reports whether JetStream will not redeliver the message once more.
+func (a *ConsumerAdapter) lastDelivery(msg *nats.Msg) bool {
+	if a.maxDeliver <= 0 {
+		return false
+	}
+	meta, err := msg.Metadata()
+	return err == nil && meta.NumDelivered >= uint64(a.maxDeliver)
+}
+
+// terminate stops the redelivery of a message that can never be processed,
+// republishing it to the dead-letter subject first if one is configured.
+// If the message cannot be republished, it is negatively acknowledged for redelivery instead.
+func (a *ConsumerAdapter) terminate(msg *nats.Msg, stage kafka.Stage, cause error) {
+	const fn = "terminate"
+
+	if a.dlq == nil {
+		log.Printf("(%s) | Message dropped: %v\n", fn, cause)
+	} else if err := a.dlq.Publish(msg, stage, cause); err != nil {
+		log.Printf("(%s) | %v, message will be redelivered\n", fn, err)
+		a.nak(msg)
+		return
+	}
+	if err := a.acks.Term(msg); err != nil {
+		log.Printf("(%s) | Error terminating message: %v\n", fn, err)
+	}
+}
+
+// nak negatively acknowledges the message, so JetStream redelivers it.
+func (a *ConsumerAdapter) nak(msg *nats.Msg) {
+	const fn = "nak"
+
+	if err := a.acks.Nak(msg); err != nil {
+		log.Printf("(%s) | Error negatively acknowledging message: %v\n", fn, err)
+	}
+}
+
+// Close drains the subscription (the durable consumer is kept) and closes the NATS connection.
+func (a *ConsumerAdapter) Close() error {
+	const fn = "Close"
+
+	err := a.sub.Drain()
+	a.conn.Close()
+	if err != nil {
+		return fmt.Errorf("(%s) | Error draining subscription: %w", fn, err)
+	}
+	return nil
+}
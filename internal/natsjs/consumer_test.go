@@ -0,0 +1,229 @@
+package natsjs
+
+import (
+	"context"
+	"demo_service/internal/config"
+	"demo_service/internal/kafka"
+	"demo_service/internal/models"
+	"demo_service/internal/retry"
+	"demo_service/internal/rules"
+	"demo_service/internal/validation"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/nats-io/nats.go"
+)
+
+// fakeAcks records how messages are settled, by their message ID.
+type fakeAcks struct {
+	calls []string
+}
+
+func (f *fakeAcks) record(kind string, msg *nats.Msg) error {
+	f.calls = append(f.calls, kind+" "+msg.Header.Get(nats.MsgIdHdr))
+	return nil
+}
+
+func (f *fakeAcks) Ack(msg *nats.Msg) error  { return f.record("ack", msg) }
+func (f *fakeAcks) Nak(msg *nats.Msg) error  { return f.record("nak", msg) }
+func (f *fakeAcks) Term(msg *nats.Msg) error { return f.record("term", msg) }
+
+// fakePublisher records the published messages, or fails every publish if err is set.
+type fakePublisher struct {
+	err      error
+	messages []*nats.Msg
+}
+
+func (p *fakePublisher) PublishMsg(msg *nats.Msg, _ ...nats.PubOpt) (*nats.PubAck, error) {
+	if p.err != nil {
+		return nil, p.err
+	}
+	p.messages = append(p.messages, msg)
+	return &nats.PubAck{Stream: "ORDERS_DLQ", Sequence: uint64(len(p.messages))}, nil
+}
+
+// newAdapter returns an adapter with the JSON codec that settles messages with fake acks
+// and dead-letters them with the publisher, if it is not nil.
+func newAdapter(t *testing.T, publisher *fakePublisher) (*ConsumerAdapter, *fakeAcks) {
+	t.Helper()
+
+	codecs, err := kafka.NewCodecs(config.Broker{Codec: kafka.CodecJSON})
+	if err != nil {
+		t.Fatal(err)
+	}
+	acks := &fakeAcks{}
+	adapter := &ConsumerAdapter{acks: acks, codecs: codecs, maxDeliver: 5}
+	if publisher != nil {
+		adapter.SetDeadLetterQueue(NewDeadLetterQueue(publisher, "orders.dlq"))
+	}
+	return adapter, acks
+}
+
+// jsMsg returns a JetStream message of the order at the stream sequence, delivered the given number of times.
+// An empty uid makes a message without a payload.
+func jsMsg(uid string, sequence, delivered int) *nats.Msg {
+	msg := &nats.Msg{
+		Subject: "orders",
+		Header:  nats.Header{},
+		Reply:   fmt.Sprintf("$JS.ACK.ORDERS.order-consumer.%d.%d.%d.1700000000000000000.0", delivered, sequence, sequence),
+		Sub:     &nats.Subscription{},
+	}
+	msg.Header.Set(nats.MsgIdHdr, fmt.Sprintf("msg-%d", sequence))
+	if uid != "" {
+		msg.Data = fmt.Appendf(nil, `{"order_uid":%q}`, uid)
+	}
+	return msg
+}
+
+func TestSettle(t *testing.T) {
+	invalid := validation.Errors{{Field: "order_uid", Rule: "required", Message: "is required"}}
+	rejected := &rules.RejectedError{Checks: []models.Check{{Rule: "goods_total"}}}
+	transient := errors.New("database is down")
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	cases := []struct {
+		name      string
+		ctx       context.Context
+		err       error
+		delivered int
+		want      string
+		stage     kafka.Stage
+	}{
+		{"Processed", context.Background(), nil, 1, "ack msg-1", ""},
+		{"Transient", context.Background(), transient, 1, "nak msg-1", ""},
+		{"Interrupted", canceled, transient, 5, "nak msg-1", ""},
+		{"Invalid", context.Background(), &retry.Error{Attempts: 1, Err: invalid}, 1, "term msg-1", kafka.StageValidate},
+		{"Rejected", context.Background(), rejected, 1, "term msg-1", kafka.StageRules},
+		{"LastDelivery", context.Background(), transient, 5, "term msg-1", kafka.StageProcess},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			publisher := &fakePublisher{}
+			adapter, acks := newAdapter(t, publisher)
+
+			adapter.settle(c.ctx, jsMsg("order-1", 1, c.delivered), c.err)
+
+			if want := []string{c.want}; !slices.Equal(acks.calls, want) {
+				t.Errorf("settled %v; want %v", acks.calls, want)
+			}
+			if c.stage == "" {
+				if len(publisher.messages) != 0 {
+					t.Errorf("dead-lettered %d messages; want none", len(publisher.messages))
+				}
+				return
+			}
+			if len(publisher.messages) != 1 {
+				t.Fatalf("dead-lettered %d messages; want 1", len(publisher.messages))
+			}
+			if got := publisher.messages[0].Header.Get(kafka.HeaderDLQStage); got != string(c.stage) {
+				t.Errorf("dead-letter stage = %q; want %q", got, c.stage)
+			}
+		})
+	}
+}
+
+func TestSettleWithoutDeadLetterQueue(t *testing.T) {
+	adapter, acks := newAdapter(t, nil)
+
+	adapter.settle(context.Background(), jsMsg("order-1", 1, 1), &rules.RejectedError{})
+	if want := []string{"term msg-1"}; !slices.Equal(acks.calls, want) {
+		t.Errorf("settled %v; want %v", acks.calls, want)
+	}
+}
+
+func TestDeadLetterFailureRedelivers(t *testing.T) {
+	adapter, acks := newAdapter(t, &fakePublisher{err: errors.New("no responders")})
+
+	adapter.settle(context.Background(), jsMsg("order-1", 1, 1), &rules.RejectedError{})
+	if want := []string{"nak msg-1"}; !slices.Equal(acks.calls, want) {
+		t.Errorf("settled %v; want the message redelivered when it cannot be dead-lettered", acks.calls)
+	}
+}
+
+func TestUndecodableMessageIsDeadLettered(t *testing.T) {
+	publisher := &fakePublisher{}
+	adapter, acks := newAdapter(t, publisher)
+
+	msg := jsMsg("", 7, 2)
+	msg.Data = []byte("not json")
+	msg.Header.Set("x-trace-id", "trace-1")
+	adapter.process(context.Background(), msg, func(models.Order) error {
+		t.Fatal("handler called for an undecodable message")
+		return nil
+	})
+
+	if want := []string{"term msg-7"}; !slices.Equal(acks.calls, want) {
+		t.Errorf("settled %v; want %v", acks.calls, want)
+	}
+	if len(publisher.messages) != 1 {
+		t.Fatalf("dead-lettered %d messages; want 1", len(publisher.messages))
+	}
+	dead := publisher.messages[0]
+	if dead.Subject != "orders.dlq" || string(dead.Data) != "not json" {
+		t.Errorf("dead-lettered %q to %s; want the original payload on orders.dlq", dead.Data, dead.Subject)
+	}
+	wantHeaders := map[string]string{
+		kafka.HeaderDLQStage:    string(kafka.StageDecode),
+		kafka.HeaderDLQTopic:    "orders",
+		kafka.HeaderDLQOffset:   "7",
+		kafka.HeaderDLQAttempts: "2",
+		"x-trace-id":            "trace-1",
+	}
+	for key, want := range wantHeaders {
+		if got := dead.Header.Get(key); got != want {
+			t.Errorf("header %s = %q; want %q", key, got, want)
+		}
+	}
+}
+
+func TestTombstoneWithoutOrderUIDIsDeadLettered(t *testing.T) {
+	publisher := &fakePublisher{}
+	adapter, acks := newAdapter(t, publisher)
+
+	adapter.process(context.Background(), jsMsg("", 1, 1), func(models.Order) error { return nil })
+	if want := []string{"term msg-1"}; !slices.Equal(acks.calls, want) {
+		t.Errorf("settled %v; want %v", acks.calls, want)
+	}
+	if len(publisher.messages) != 1 {
+		t.Errorf("dead-lettered %d messages; want 1", len(publisher.messages))
+	}
+}
+
+func TestProcessBatchSettlesEveryMessage(t *testing.T) {
+	publisher := &fakePublisher{}
+	adapter, acks := newAdapter(t, publisher)
+
+	var tombstones []string
+	adapter.SetTombstoneHandler(func(tombstone models.Tombstone) error {
+		tombstones = append(tombstones, tombstone.OrderUID)
+		return nil
+	})
+	tombstone := jsMsg("", 3, 1)
+	tombstone.Header.Set(HeaderOrderUID, "order-0")
+
+	// Пакет с отклонённым заказом обрабатывается по одному: остальные подтверждаются
+	messages := []*nats.Msg{jsMsg("order-1", 1, 1), jsMsg("order-2", 2, 1), tombstone, jsMsg("order-4", 4, 1)}
+	adapter.processBatch(context.Background(), messages, func(orders []models.Order) error {
+		for _, order := range orders {
+			if order.OrderUID == "order-2" {
+				return &rules.RejectedError{}
+			}
+		}
+		return nil
+	})
+
+	want := []string{"ack msg-1", "term msg-2", "ack msg-3", "ack msg-4"}
+	if !slices.Equal(acks.calls, want) {
+		t.Errorf("settled %v; want %v", acks.calls, want)
+	}
+	if !slices.Equal(tombstones, []string{"order-0"}) {
+		t.Errorf("tombstones = %v; want [order-0]", tombstones)
+	}
+	if len(publisher.messages) != 1 || publisher.messages[0].Header.Get(nats.MsgIdHdr) != "msg-2" {
+		t.Errorf("dead-lettered %d messages; want msg-2 only", len(publisher.messages))
+	}
+}
@@ -0,0 +1,74 @@
+package natsjs
+
+import (
+	"demo_service/internal/kafka"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Publisher publishes messages to JetStream. nats.JetStreamContext implements it.
+type Publisher interface {
+	PublishMsg(msg *nats.Msg, opts ...nats.PubOpt) (*nats.PubAck, error)
+}
+
+// DeadLetterQueue republishes messages that can never be processed to a dedicated subject,
+// keeping the original payload and headers and describing the failure in the headers of the kafka package.
+type DeadLetterQueue struct {
+	publisher Publisher
+	subject   string
+}
+
+// NewDeadLetterQueue creates a DeadLetterQueue that publishes to the given subject
+// using the provided publisher. The publisher can be a fake in tests.
+func NewDeadLetterQueue(publisher Publisher, subject string) *DeadLetterQueue {
+	return &DeadLetterQueue{
+		publisher: publisher,
+		subject:   subject,
+	}
+}
+
+// Publish sends the original message to the dead-letter subject with headers describing the failure stage,
+// the error text, the source subject and stream sequence and the number of deliveries.
+// It waits for the stream to acknowledge the message.
+func (q *DeadLetterQueue) Publish(msg *nats.Msg, stage kafka.Stage, cause error) error {
+	const fn = "DeadLetterQueue.Publish"
+
+	header := make(nats.Header, len(msg.Header)+6)
+	for key, values := range msg.Header {
+		header[key] = append([]string(nil), values...)
+	}
+
+	errText := ""
+	if cause != nil {
+		errText = cause.Error()
+	}
+	attempts := 1
+	var sequence uint64
+	if meta, err := msg.Metadata(); err == nil {
+		attempts = int(meta.NumDelivered) //nolint:gosec // the number of deliveries is bounded by MaxDeliver
+		sequence = meta.Sequence.Stream
+	}
+
+	header.Set(kafka.HeaderDLQStage, string(stage))
+	header.Set(kafka.HeaderDLQError, errText)
+	header.Set(kafka.HeaderDLQTopic, msg.Subject)
+	header.Set(kafka.HeaderDLQOffset, strconv.FormatUint(sequence, 10))
+	header.Set(kafka.HeaderDLQAttempts, strconv.Itoa(attempts))
+	header.Set(kafka.HeaderDLQFailedAt, time.Now().UTC().Format(time.RFC3339Nano))
+
+	dlqMsg := &nats.Msg{
+		Subject: q.subject,
+		Header:  header,
+		Data:    msg.Data,
+	}
+	if _, err := q.publisher.PublishMsg(dlqMsg); err != nil {
+		return fmt.Errorf("(%s) | Error publishing to %s: %w", fn, q.subject, err)
+	}
+
+	log.Printf("(%s) | Message %s/%d moved to %s (stage: %s)\n", fn, msg.Subject, sequence, q.subject, stage)
+	return nil
+}
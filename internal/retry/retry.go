@@ -0,0 +1,144 @@
+// Package retry provides a retry policy with jittered exponential backoff.
+//
+// The policy separates transient errors (a database that is briefly unreachable,
+// a timeout, a serialization failure) from permanent ones (validation errors,
+// constraint violations). Only transient errors are retried; permanent errors
+// and exhausted attempts are returned immediately wrapped in an *Error
+// that records how many attempts have been made.
+//
+// Waiting between attempts respects context cancellation.
+package retry
+
+import (
+	"context"
+	"demo_service/internal/config"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"time"
+)
+
+// Classifier reports whether an error is transient and the operation is worth retrying.
+type Classifier func(err error) bool
+
+// Policy describes how many times and how often a failed operation is retried.
+type Policy struct {
+	maxAttempts    int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	isTransient    Classifier
+}
+
+// Error is returned by Do when the operation has finally failed.
+// It wraps the last error and records the number of attempts made.
+type Error struct {
+	Attempts int
+	Err      error
+}
+
+// Error implements the error interface.
+func (e *Error) Error() string {
+	return fmt.Sprintf("failed after %d attempt(s): %v", e.Attempts, e.Err)
+}
+
+// Unwrap returns the last error returned by the operation.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// permanentError marks an error as not worth retrying regardless of the classifier.
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent wraps err so that Do never retries it.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// Attempts returns the number of attempts recorded in err,
+// or 1 if err was not produced by a Policy.
+func Attempts(err error) int {
+	var retryErr *Error
+	if errors.As(err, &retryErr) {
+		return retryErr.Attempts
+	}
+	return 1
+}
+
+// New creates a Policy from the retry configuration.
+// isTransient decides which errors are retried; if it is nil, nothing is retried.
+func New(cfg config.Retry, isTransient Classifier) *Policy {
+	p := &Policy{
+		maxAttempts:    cfg.MaxAttempts,
+		initialBackoff: cfg.InitialBackoff,
+		maxBackoff:     cfg.MaxBackoff,
+		isTransient:    isTransient,
+	}
+	if p.maxAttempts < 1 {
+		p.maxAttempts = 1
+	}
+	if p.maxBackoff < p.initialBackoff {
+		p.maxBackoff = p.initialBackoff
+	}
+	return p
+}
+
+// Do runs op until it succeeds, returns a permanent error, the attempts are exhausted
+// or ctx is canceled. On failure the returned error is an *Error.
+// If ctx is canceled while waiting, the context error is returned as is,
+// because the outcome of the operation is not final.
+func (p *Policy) Do(ctx context.Context, op func() error) error {
+	var err error
+	for attempt := 1; ; attempt++ {
+		if err = op(); err == nil {
+			return nil
+		}
+
+		if attempt >= p.maxAttempts || !p.retryable(err) {
+			return &Error{Attempts: attempt, Err: err}
+		}
+
+		timer := time.NewTimer(p.backoff(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// retryable reports whether err should be retried.
+func (p *Policy) retryable(err error) bool {
+	var permanent *permanentError
+	if errors.As(err, &permanent) {
+		return false
+	}
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	return p.isTransient != nil && p.isTransient(err)
+}
+
+// backoff returns the delay before the next attempt using "full jitter":
+// a random duration between zero and the exponentially growing ceiling.
+func (p *Policy) backoff(attempt int) time.Duration {
+	ceiling := p.initialBackoff
+	for i := 1; i < attempt && ceiling < p.maxBackoff; i++ {
+		ceiling *= 2
+	}
+	if ceiling > p.maxBackoff {
+		ceiling = p.maxBackoff
+	}
+	if ceiling <= 0 {
+		return 0
+	}
+	return rand.N(ceiling) //nolint:gosec // jitter does not need a cryptographic source
+}
@@ -0,0 +1,154 @@
+package retry
+
+import (
+	"context"
+	"demo_service/internal/config"
+	"errors"
+	"testing"
+	"time"
+)
+
+// errTransient is the only error the policies of the tests retry.
+var errTransient = errors.New("database is down")
+
+func isTransient(err error) bool {
+	return errors.Is(err, errTransient)
+}
+
+func TestBackoff(t *testing.T) {
+	p := New(config.Retry{MaxAttempts: 10, InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}, isTransient)
+
+	cases := []struct {
+		attempt int
+		ceiling time.Duration
+	}{
+		{1, 100 * time.Millisecond},
+		{2, 200 * time.Millisecond},
+		{3, 400 * time.Millisecond},
+		{4, 800 * time.Millisecond},
+		{5, time.Second},
+		{9, time.Second},
+	}
+	for _, c := range cases {
+		// Задержка случайна в [0, ceiling): за 1000 попыток максимум почти наверняка выше ceiling/2
+		var longest time.Duration
+		for range 1000 {
+			d := p.backoff(c.attempt)
+			if d < 0 || d >= c.ceiling {
+				t.Fatalf("backoff(%d) = %v; want within [0, %v)", c.attempt, d, c.ceiling)
+			}
+			longest = max(longest, d)
+		}
+		if longest < c.ceiling/2 {
+			t.Errorf("backoff(%d) is at most %v; want it to grow to %v", c.attempt, longest, c.ceiling)
+		}
+	}
+}
+
+func TestBackoffWithoutInitialBackoff(t *testing.T) {
+	p := New(config.Retry{MaxAttempts: 3}, isTransient)
+	if d := p.backoff(2); d != 0 {
+		t.Errorf("backoff(2) = %v; want 0", d)
+	}
+}
+
+func TestDo(t *testing.T) {
+	errConstraint := errors.New("constraint violation")
+
+	cases := []struct {
+		name     string
+		errs     []error
+		attempts int
+		// wantErr is the error Do must fail with, nil if it must succeed
+		wantErr error
+	}{
+		{"Success", nil, 1, nil},
+		{"TransientThenSuccess", []error{errTransient, errTransient}, 3, nil},
+		{"AttemptCap", []error{errTransient, errTransient, errTransient, errTransient}, 3, errTransient},
+		{"NotTransient", []error{errConstraint}, 1, errConstraint},
+		{"Permanent", []error{Permanent(errTransient)}, 1, errTransient},
+		{"Canceled", []error{context.Canceled}, 1, context.Canceled},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			p := New(config.Retry{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond}, isTransient)
+
+			calls := 0
+			err := p.Do(context.Background(), func() error {
+				calls++
+				if calls <= len(c.errs) {
+					return c.errs[calls-1]
+				}
+				return nil
+			})
+
+			if calls != c.attempts {
+				t.Errorf("op called %d times; want %d", calls, c.attempts)
+			}
+			if c.wantErr == nil {
+				if err != nil {
+					t.Fatalf("Do() error = %v; want nil", err)
+				}
+				return
+			}
+
+			var retryErr *Error
+			if !errors.As(err, &retryErr) {
+				t.Fatalf("Do() error = %v; want *Error", err)
+			}
+			if retryErr.Attempts != c.attempts || Attempts(err) != c.attempts {
+				t.Errorf("Attempts = %d; want %d", retryErr.Attempts, c.attempts)
+			}
+			if !errors.Is(err, c.wantErr) {
+				t.Errorf("Do() error = %v; want it to wrap %v", err, c.wantErr)
+			}
+		})
+	}
+}
+
+func TestDoCanceledWhileWaiting(t *testing.T) {
+	p := New(config.Retry{MaxAttempts: 5, InitialBackoff: time.Hour, MaxBackoff: time.Hour}, isTransient)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	calls := 0
+	start := time.Now()
+	err := p.Do(ctx, func() error {
+		calls++
+		cancel()
+		return errTransient
+	})
+
+	// Ожидание прерывается сразу, а ошибка контекста возвращается как есть: исход не окончателен
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Do() error = %v; want context.Canceled", err)
+	}
+	var retryErr *Error
+	if errors.As(err, &retryErr) {
+		t.Errorf("Do() error = %v; want the context error, not *Error", err)
+	}
+	if calls != 1 {
+		t.Errorf("op called %d times; want 1", calls)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Do() returned after %v; want it to stop waiting on cancellation", elapsed)
+	}
+}
+
+func TestNewClampsConfiguration(t *testing.T) {
+	p := New(config.Retry{MaxAttempts: 0, InitialBackoff: time.Second, MaxBackoff: time.Millisecond}, nil)
+	if p.maxAttempts != 1 {
+		t.Errorf("maxAttempts = %d; want 1", p.maxAttempts)
+	}
+	if p.maxBackoff != time.Second {
+		t.Errorf("maxBackoff = %v; want the initial backoff", p.maxBackoff)
+	}
+
+	calls := 0
+	err := p.Do(context.Background(), func() error {
+		calls++
+		return errTransient
+	})
+	if err == nil || calls != 1 {
+		t.Errorf("Do() without a classifier: %d calls, error %v; want 1 call and an error", calls, err)
+	}
+}
@@ -0,0 +1,176 @@
+// Package rules provides a configurable engine of business-rule consistency checks for orders.
+//
+// Each rule computes an invariant of the order (payment totals, item prices with sale applied)
+// and reports a violation. What happens to a violating order is configured per rule:
+// it can be rejected, accepted with a warning, or silently tagged.
+// Warnings and tags are attached to the order as models.Check results,
+// so they are stored alongside it and exposed through the API.
+package rules
+
+import (
+	"demo_service/internal/config"
+	"demo_service/internal/models"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Action is applied to an order that violates a rule.
+type Action string
+
+const (
+	// ActionReject rejects the order, it is not persisted.
+	ActionReject Action = "reject"
+	// ActionWarn accepts the order, logs a warning and records the check result.
+	ActionWarn Action = "warn"
+	// ActionTag accepts the order and records the check result.
+	ActionTag Action = "tag"
+	// ActionOff disables the rule.
+	ActionOff Action = "off"
+)
+
+// Rule names used in the configuration and in check results.
+const (
+	RuleGoodsTotal     = "goods_total"
+	RulePaymentAmount  = "payment_amount"
+	RuleItemTotalPrice = "item_total_price"
+)
+
+// defaultAction is used for rules that are not mentioned in the configuration.
+const defaultAction = ActionWarn
+
+// rule is a single business invariant; check returns a message per violation.
+type rule struct {
+	name  string
+	check func(order *models.Order) []string
+}
+
+var allRules = []rule{
+	{name: RuleGoodsTotal, check: checkGoodsTotal},
+	{name: RulePaymentAmount, check: checkPaymentAmount},
+	{name: RuleItemTotalPrice, check: checkItemTotalPrice},
+}
+
+// RejectedError is returned by Apply when a rule with the reject action is violated.
+type RejectedError struct {
+	Checks []models.Check
+}
+
+// Error implements the error interface.
+func (e *RejectedError) Error() string {
+	msgs := make([]string, 0, len(e.Checks))
+	for _, c := range e.Checks {
+		msgs = append(msgs, fmt.Sprintf("%s: %s", c.Rule, c.Message))
+	}
+	return "order rejected by business rules: " + strings.Join(msgs, "; ")
+}
+
+// Engine evaluates business rules against orders.
+type Engine struct {
+	actions map[string]Action
+}
+
+// New creates an Engine from the rules configuration.
+// It returns an error for unknown rule names or actions.
+func New(cfg config.Rules) (*Engine, error) {
+	const fn = "rules.New"
+
+	known := make(map[string]bool, len(allRules))
+	for _, r := range allRules {
+		known[r.name] = true
+	}
+
+	actions := make(map[string]Action, len(cfg.Actions))
+	for name, action := range cfg.Actions {
+		if !known[name] {
+			return nil, fmt.Errorf("(%s) | unknown rule: %s", fn, name)
+		}
+		switch a := Action(action); a {
+		case ActionReject, ActionWarn, ActionTag, ActionOff:
+			actions[name] = a
+		default:
+			return nil, fmt.Errorf("(%s) | unknown action %q for rule %s", fn, action, name)
+		}
+	}
+
+	return &Engine{actions: actions}, nil
+}
+
+// Check evaluates all enabled rules against the order and records warnings and tags in order.Checks,
+// replacing any previous results. If a rejecting rule is violated, a *RejectedError is returned.
+func (e *Engine) Check(order *models.Order) error {
+	const fn = "Check"
+
+	var accepted, rejected []models.Check
+	for _, r := range allRules {
+		action := e.action(r.name)
+		if action == ActionOff {
+			continue
+		}
+		for _, msg := range r.check(order) {
+			c := models.Check{Rule: r.name, Action: string(action), Message: msg}
+			switch action {
+			case ActionReject:
+				rejected = append(rejected, c)
+			case ActionWarn:
+				log.Printf("(%s) | Order %s: %s: %s\n", fn, order.OrderUID, r.name, msg)
+				accepted = append(accepted, c)
+			default:
+				accepted = append(accepted, c)
+			}
+		}
+	}
+
+	if len(rejected) > 0 {
+		return &RejectedError{Checks: rejected}
+	}
+	order.Checks = accepted
+	return nil
+}
+
+// action returns the configured action for the rule.
+func (e *Engine) action(name string) Action {
+	if a, ok := e.actions[name]; ok {
+		return a
+	}
+	return defaultAction
+}
+
+// checkGoodsTotal verifies that payment.goods_total equals the sum of item total prices.
+func checkGoodsTotal(order *models.Order) []string {
+	sum := 0
+	for _, item := range order.Items {
+		sum += item.TotalPrice
+	}
+	if sum != order.Payment.GoodsTotal {
+		return []string{fmt.Sprintf("goods_total %d differs from the sum of item total prices %d",
+			order.Payment.GoodsTotal, sum)}
+	}
+	return nil
+}
+
+// checkPaymentAmount verifies that payment.amount equals goods_total + delivery_cost + custom_fee.
+func checkPaymentAmount(order *models.Order) []string {
+	p := order.Payment
+	expected := p.GoodsTotal + p.DeliveryCost + p.CustomFee
+	if p.Amount != expected {
+		return []string{fmt.Sprintf("amount %d differs from goods_total + delivery_cost + custom_fee = %d",
+			p.Amount, expected)}
+	}
+	return nil
+}
+
+// checkItemTotalPrice verifies that each item's total_price is its price with the sale percent applied.
+// Both rounding down and rounding up of the discounted price are accepted.
+func checkItemTotalPrice(order *models.Order) []string {
+	var msgs []string
+	for i, item := range order.Items {
+		discounted := item.Price * (100 - item.Sale)
+		floor, ceil := discounted/100, (discounted+99)/100
+		if item.TotalPrice != floor && item.TotalPrice != ceil {
+			msgs = append(msgs, fmt.Sprintf("items[%d].total_price %d differs from price %d with sale %d%% = %d",
+				i, item.TotalPrice, item.Price, item.Sale, floor))
+		}
+	}
+	return msgs
+}
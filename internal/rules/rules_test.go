@@ -0,0 +1,115 @@
+package rules
+
+import (
+	"demo_service/internal/config"
+	"demo_service/internal/models"
+	"errors"
+	"slices"
+	"testing"
+)
+
+// consistentOrder returns an order that satisfies every rule:
+// 453 with a 30% sale is 317.1, goods_total is 317 and amount is 317 + 1500 + 0.
+func consistentOrder() models.Order {
+	return models.Order{
+		OrderUID: "order-1",
+		Payment:  models.Payment{Amount: 1817, DeliveryCost: 1500, GoodsTotal: 317},
+		Items:    []models.Item{{Price: 453, Sale: 30, TotalPrice: 317}},
+	}
+}
+
+func TestCheck(t *testing.T) {
+	cases := []struct {
+		name    string
+		actions map[string]string
+		mutate  func(o *models.Order)
+		// want lists the recorded checks as "rule action", rejected the checks of the RejectedError
+		want     []string
+		rejected []string
+	}{
+		{"Consistent", nil, func(o *models.Order) {}, nil, nil},
+		{"ItemTotalRoundedUp", nil, func(o *models.Order) {
+			o.Items[0].TotalPrice = 318
+			o.Payment.GoodsTotal = 318
+			o.Payment.Amount = 1818
+		}, nil, nil},
+		{"GoodsTotalWarnsByDefault", nil, func(o *models.Order) {
+			o.Payment.GoodsTotal = 300
+			o.Payment.Amount = 1800
+		}, []string{"goods_total warn"}, nil},
+		{"PaymentAmountWarnsByDefault", nil, func(o *models.Order) { o.Payment.Amount = 1 }, []string{"payment_amount warn"}, nil},
+		{"ItemTotalPricePerItem", nil, func(o *models.Order) {
+			o.Items = append(o.Items, models.Item{Price: 100, TotalPrice: 100})
+			o.Items[0].TotalPrice = 1
+			o.Items[1].TotalPrice = 1
+			o.Payment.GoodsTotal = 2
+			o.Payment.Amount = 1502
+		}, []string{"item_total_price warn", "item_total_price warn"}, nil},
+		{"Tag", map[string]string{RulePaymentAmount: "tag"}, func(o *models.Order) { o.Payment.Amount = 1 },
+			[]string{"payment_amount tag"}, nil},
+		{"Off", map[string]string{RulePaymentAmount: "off"}, func(o *models.Order) { o.Payment.Amount = 1 }, nil, nil},
+		{"Reject", map[string]string{RuleGoodsTotal: "reject", RulePaymentAmount: "tag"}, func(o *models.Order) {
+			o.Payment.GoodsTotal = 300
+			o.Payment.Amount = 1
+		}, nil, []string{"goods_total reject"}},
+		{"RejectNotViolated", map[string]string{RuleGoodsTotal: "reject"}, func(o *models.Order) { o.Payment.Amount = 1 },
+			[]string{"payment_amount warn"}, nil},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			engine, err := New(config.Rules{Actions: c.actions})
+			if err != nil {
+				t.Fatal(err)
+			}
+			order := consistentOrder()
+			c.mutate(&order)
+			// Результаты предыдущей проверки заменяются
+			previous := []models.Check{{Rule: "previous", Action: string(ActionTag)}}
+			order.Checks = previous
+
+			err = engine.Check(&order)
+			if c.rejected != nil {
+				var rejected *RejectedError
+				if !errors.As(err, &rejected) {
+					t.Fatalf("Check() error = %v; want *RejectedError", err)
+				}
+				if got := summarize(rejected.Checks); !slices.Equal(got, c.rejected) {
+					t.Errorf("rejected checks = %v; want %v", got, c.rejected)
+				}
+				if !slices.Equal(order.Checks, previous) {
+					t.Errorf("checks of a rejected order = %v; want them unchanged", order.Checks)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Check() error = %v", err)
+			}
+			if got := summarize(order.Checks); !slices.Equal(got, c.want) {
+				t.Errorf("checks = %v; want %v", got, c.want)
+			}
+		})
+	}
+}
+
+// summarize returns the checks as "rule action".
+func summarize(checks []models.Check) []string {
+	var got []string
+	for _, c := range checks {
+		got = append(got, c.Rule+" "+c.Action)
+	}
+	return got
+}
+
+func TestNewRejectsUnknownConfiguration(t *testing.T) {
+	cases := map[string]map[string]string{
+		"UnknownRule":   {"shipping_cost": "warn"},
+		"UnknownAction": {RuleGoodsTotal: "ignore"},
+	}
+	for name, actions := range cases {
+		t.Run(name, func(t *testing.T) {
+			if _, err := New(config.Rules{Actions: actions}); err == nil {
+				t.Error("New() error = nil; want an error")
+			}
+		})
+	}
+}
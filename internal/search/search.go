@@ -0,0 +1,171 @@
+// Package search describes the full-text search over orders: the query, the ranked results
+// and their highlighted snippets.
+//
+// An order is found by the words of its searchable text (see Text): the names and brands of its items,
+// the name, city, address and email of its delivery. PostgreSQL indexes the text in a tsvector column
+// and ranks the matches with ts_rank; Match approximates it for the in-memory storage:
+// every word of the query must occur in the text, and the rank is the number of the matched words.
+package search
+
+import (
+	"demo_service/internal/models"
+	"errors"
+	"fmt"
+	"html"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Result limits.
+const (
+	DefaultLimit = 10
+	MaxLimit     = 50
+)
+
+// Snippet highlighting: the matched words are wrapped in the mark element.
+const (
+	MarkStart = "<mark>"
+	MarkStop  = "</mark>"
+)
+
+// snippetWords is the number of words of an in-memory snippet.
+const snippetWords = 12
+
+// ErrInvalidQuery is returned when the parameters of a search are malformed.
+var ErrInvalidQuery = errors.New("invalid search query")
+
+// Query is a full-text search request.
+type Query struct {
+	Text  string
+	Limit int
+}
+
+// Result is an order found by a search, with its rank (higher is better)
+// and an HTML snippet of its searchable text with the matched words highlighted.
+type Result struct {
+	Order   models.Order `json:"order"`
+	Rank    float32      `json:"rank"`
+	Snippet string       `json:"snippet"`
+}
+
+// ParseQuery reads the query from URL parameters: q (required, in the web search syntax of PostgreSQL,
+// websearch_to_tsquery) and limit. Errors wrap ErrInvalidQuery.
+func ParseQuery(values url.Values) (Query, error) {
+	const fn = "search.ParseQuery"
+
+	q := Query{Text: strings.TrimSpace(values.Get("q")), Limit: DefaultLimit}
+	if q.Text == "" {
+		return Query{}, fmt.Errorf("(%s) | %w: q is required", fn, ErrInvalidQuery)
+	}
+	if value := values.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > MaxLimit {
+			return Query{}, fmt.Errorf("(%s) | %w: limit must be between 1 and %d", fn, ErrInvalidQuery, MaxLimit)
+		}
+		q.Limit = limit
+	}
+	return q, nil
+}
+
+// Text returns the searchable text of the order, like the order_search_text function of the database.
+func Text(order models.Order) string {
+	var parts []string
+	for _, item := range order.Items {
+		parts = append(parts, item.Name, item.Brand)
+	}
+	d := order.Delivery
+	parts = append(parts, d.Name, d.City, d.Address, d.Email)
+	return strings.Join(strings.Fields(strings.Join(parts, " ")), " ")
+}
+
+// Match searches the text of the order for the words of the query.
+// It returns the rank and the snippet of the order, or false if some word does not occur.
+func (q Query) Match(order models.Order) (Result, bool) {
+	terms := make(map[string]bool)
+	for _, word := range strings.Fields(q.Text) {
+		if term := normalize(word); term != "" {
+			terms[term] = true
+		}
+	}
+	if len(terms) == 0 {
+		return Result{}, false
+	}
+
+	words := strings.Fields(Text(order))
+	found := make(map[string]bool)
+	first, hits := -1, 0
+	for i, word := range words {
+		if !matches(word, terms, found) {
+			continue
+		}
+		if first < 0 {
+			first = i
+		}
+		hits++
+	}
+	if len(found) < len(terms) {
+		return Result{}, false
+	}
+
+	start := max(0, min(first-snippetWords/4, len(words)-snippetWords))
+	snippet := make([]string, 0, snippetWords)
+	for _, word := range words[start:min(start+snippetWords, len(words))] {
+		if matches(word, terms, nil) {
+			snippet = append(snippet, MarkStart+html.EscapeString(word)+MarkStop)
+		} else {
+			snippet = append(snippet, html.EscapeString(word))
+		}
+	}
+	return Result{
+		Order:   order,
+		Rank:    float32(hits),
+		Snippet: strings.Join(snippet, " "),
+	}, true
+}
+
+// SafeSnippet escapes the HTML of a snippet highlighted with MarkStart and MarkStop by the database,
+// keeping only the mark elements, as the searchable text is stored unescaped.
+func SafeSnippet(snippet string) string {
+	snippet = html.EscapeString(snippet)
+	snippet = strings.ReplaceAll(snippet, html.EscapeString(MarkStart), MarkStart)
+	return strings.ReplaceAll(snippet, html.EscapeString(MarkStop), MarkStop)
+}
+
+// matches reports whether the word holds one of the terms, recording the terms it holds in found if it is set.
+func matches(word string, terms, found map[string]bool) bool {
+	matched := false
+	for _, token := range tokens(word) {
+		if terms[token] {
+			matched = true
+			if found != nil {
+				found[token] = true
+			}
+		}
+	}
+	return matched
+}
+
+// normalize returns the word lowercased and trimmed of punctuation.
+func normalize(word string) string {
+	return strings.ToLower(strings.TrimFunc(word, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}))
+}
+
+// tokens returns the normalized word along with its parts split at the characters
+// other than letters and digits (e.g. of a hyphenated word).
+func tokens(word string) []string {
+	word = normalize(word)
+	if word == "" {
+		return nil
+	}
+	parts := strings.FieldsFunc(word, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	if len(parts) == 1 && parts[0] == word {
+		return parts
+	}
+	return append(parts, word)
+}
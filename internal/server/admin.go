@@ -0,0 +1,96 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"demo_service/internal/kafka"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ConsumerController defines the administrative control of the order consumer.
+type ConsumerController interface {
+	Pause()
+	Resume()
+	Paused() bool
+	ResetOffsets(ctx context.Context, target kafka.ResetTarget) (kafka.ResetResult, error)
+}
+
+// resetTimeout bounds the wait for an offset reset, which is applied when the consumer rejoins the group.
+// It is below the write timeout of the server so the result can still be reported.
+const resetTimeout = 8 * time.Second
+
+// admin wraps the handler with bearer token authentication.
+func (s *APIServer) admin(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.config.AdminToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+func (s *APIServer) consumerStatus(w http.ResponseWriter, _ *http.Request) {
+	s.writeConsumerStatus(w)
+}
+
+func (s *APIServer) pauseConsumer(w http.ResponseWriter, _ *http.Request) {
+	s.consumer.Pause()
+	s.writeConsumerStatus(w)
+}
+
+func (s *APIServer) resumeConsumer(w http.ResponseWriter, _ *http.Request) {
+	s.consumer.Resume()
+	s.writeConsumerStatus(w)
+}
+
+// resetConsumerOffsets resets the offsets of the consumer group to the target from the request body,
+// e.g. {"to": "timestamp", "timestamp": "2024-01-02T15:04:05Z"}, and responds with the new offsets.
+func (s *APIServer) resetConsumerOffsets(w http.ResponseWriter, r *http.Request) {
+	var target kafka.ResetTarget
+	if err := json.NewDecoder(r.Body).Decode(&target); err != nil {
+		http.Error(w, "Invalid reset target: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := target.Validate(); err != nil {
+		http.Error(w, "Invalid reset target: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), resetTimeout)
+	defer cancel()
+
+	result, err := s.consumer.ResetOffsets(ctx, target)
+	switch {
+	case errors.Is(err, kafka.ErrResetInProgress), errors.Is(err, kafka.ErrPartitionsNotClaimed):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case errors.Is(err, context.DeadlineExceeded):
+		http.Error(w, "Offset reset has not been applied in time", http.StatusGatewayTimeout)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (s *APIServer) writeConsumerStatus(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"paused": s.consumer.Paused(),
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,156 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"demo_service/internal/ingest"
+	"demo_service/internal/models"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+)
+
+// Ingester stores the orders decoded from an input, reporting the result of each one.
+type Ingester interface {
+	Ingest(ctx context.Context, r io.Reader, emit func(ingest.Result) error) error
+}
+
+// IdempotencyStore keeps the responses of requests made with an Idempotency-Key.
+type IdempotencyStore interface {
+	// ReserveIdempotencyKey returns nil if the key has been reserved for the request,
+	// or the record of the key if it is already in use.
+	ReserveIdempotencyKey(ctx context.Context, key, requestHash string) (*models.IdempotencyRecord, error)
+	CompleteIdempotencyKey(ctx context.Context, key string, statusCode int, response []byte) error
+	ReleaseIdempotencyKey(ctx context.Context, key string) error
+}
+
+const (
+	// maxIngestBody limits the size of an order ingestion request.
+	maxIngestBody = 16 << 20
+	// maxIdempotencyKey limits the length of an Idempotency-Key.
+	maxIdempotencyKey = 255
+)
+
+// ingestResponse is the body of the response to an order ingestion request.
+type ingestResponse struct {
+	Results []ingest.Result `json:"results"`
+	Error   string          `json:"error,omitempty"`
+}
+
+// postOrders stores the orders from the request body: a single order, an array of orders
+// or newline-delimited orders. It responds with the result of every order:
+// 500 if storing any of them has failed, otherwise 200 OK if at least one of them has been stored
+// and 422 Unprocessable Entity if all of them have been refused. A malformed body gets 400 Bad Request
+// along with the results of the orders preceding the malformed part.
+//
+// A request with an Idempotency-Key header is processed once: a repeated request with the same key
+// and body gets the stored response, a request with the same key and a different body is refused.
+// A 500 response is not stored, so the request can be retried with the same key until every order
+// has a final result; the orders stored by an earlier attempt are then reported as unchanged.
+func (s *APIServer) postOrders(w http.ResponseWriter, r *http.Request) {
+	const fn = "postOrders"
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxIngestBody))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, "Failed to read request body", http.StatusBadRequest)
+		return
+	}
+
+	var key string
+	if s.idempotency != nil {
+		key = r.Header.Get("Idempotency-Key")
+	}
+	if key != "" {
+		if len(key) > maxIdempotencyKey {
+			http.Error(w, "Idempotency-Key is too long", http.StatusBadRequest)
+			return
+		}
+		sum := sha256.Sum256(body)
+		hash := hex.EncodeToString(sum[:])
+		record, err := s.idempotency.ReserveIdempotencyKey(s.ctx, key, hash)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if record != nil {
+			replayIngest(w, record, hash)
+			return
+		}
+	}
+
+	resp := ingestResponse{Results: []ingest.Result{}}
+	err = s.ingester.Ingest(s.ctx, bytes.NewReader(body), func(result ingest.Result) error {
+		resp.Results = append(resp.Results, result)
+		return nil
+	})
+	if err != nil {
+		resp.Error = err.Error()
+	}
+	status := ingestStatus(resp, err)
+
+	data, err := json.Marshal(resp)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if key != "" {
+		// Неудачный запрос можно повторить с тем же ключом
+		if status == http.StatusInternalServerError || len(resp.Results) == 0 {
+			err = s.idempotency.ReleaseIdempotencyKey(s.ctx, key)
+		} else {
+			err = s.idempotency.CompleteIdempotencyKey(s.ctx, key, status, data)
+		}
+		if err != nil {
+			log.Printf("(%s) | idempotency key %q: %v\n", fn, key, err)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(data)
+}
+
+// ingestStatus returns the status code of the response to an order ingestion request.
+func ingestStatus(resp ingestResponse, err error) int {
+	var stored, failed bool
+	for _, result := range resp.Results {
+		stored = stored || result.Stored()
+		failed = failed || result.Status == ingest.StatusFailed
+	}
+
+	switch {
+	case failed:
+		return http.StatusInternalServerError
+	case err != nil || len(resp.Results) == 0:
+		return http.StatusBadRequest
+	case stored:
+		return http.StatusOK
+	default:
+		return http.StatusUnprocessableEntity
+	}
+}
+
+// replayIngest responds to a repeated request with the stored response of the key.
+func replayIngest(w http.ResponseWriter, record *models.IdempotencyRecord, requestHash string) {
+	switch {
+	case record.RequestHash != requestHash:
+		http.Error(w, "Idempotency-Key has already been used with a different request", http.StatusUnprocessableEntity)
+	case record.StatusCode == nil:
+		http.Error(w, "A request with this Idempotency-Key is in progress", http.StatusConflict)
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Idempotent-Replayed", "true")
+		w.WriteHeader(*record.StatusCode)
+		_, _ = w.Write(record.Response)
+	}
+}
@@ -0,0 +1,201 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"demo_service/internal/config"
+	"demo_service/internal/db"
+	"demo_service/internal/ingest"
+	"demo_service/internal/models"
+	"demo_service/internal/retry"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeSaver stores orders in memory. An order whose UID is in failOnce fails to be stored the first time.
+type fakeSaver struct {
+	mu       sync.Mutex
+	failOnce map[string]bool
+	orders   map[string]models.Order
+	calls    int
+}
+
+func (s *fakeSaver) StoreOrder(_ context.Context, order models.Order) (models.Order, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.calls++
+	if s.failOnce[order.OrderUID] {
+		delete(s.failOnce, order.OrderUID)
+		return models.Order{}, false, errors.New("database is down")
+	}
+	if _, ok := s.orders[order.OrderUID]; ok {
+		return order, false, nil
+	}
+	if s.orders == nil {
+		s.orders = make(map[string]models.Order)
+	}
+	order.Version = 1
+	s.orders[order.OrderUID] = order
+	return order, true, nil
+}
+
+// newIngestServer returns the handler of an API that stores the posted orders with the saver,
+// keeping the Idempotency-Key responses in an in-memory storage.
+func newIngestServer(t *testing.T, saver *fakeSaver) (http.Handler, *db.Memory) {
+	t.Helper()
+
+	store, err := db.NewMemory(config.DataBase{Backend: config.BackendMemory, WriteMode: config.WriteModeInsert})
+	if err != nil {
+		t.Fatal(err)
+	}
+	api := New(context.Background(), nil, &config.HTTPServer{})
+	// Без повторов: временная ошибка сразу дает 500
+	api.SetIngester(ingest.New(saver, retry.New(config.Retry{MaxAttempts: 1}, nil)))
+	api.SetIdempotencyStore(store)
+	return api.Handler(), store
+}
+
+// postOrders posts the body with the Idempotency-Key and returns the response.
+func postOrders(t *testing.T, handler http.Handler, key, body string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader(body))
+	if key != "" {
+		req.Header.Set("Idempotency-Key", key)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+// statuses returns the statuses of the results of an ingestion response.
+func statuses(t *testing.T, rec *httptest.ResponseRecorder) []string {
+	t.Helper()
+
+	var resp ingestResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("invalid response %q: %v", rec.Body.String(), err)
+	}
+	got := make([]string, 0, len(resp.Results))
+	for _, result := range resp.Results {
+		got = append(got, result.Status)
+	}
+	return got
+}
+
+const twoOrders = `[{"order_uid":"order-1"},{"order_uid":"order-2"}]`
+
+func TestPostOrdersReplaysStoredResponse(t *testing.T) {
+	saver := &fakeSaver{}
+	handler, _ := newIngestServer(t, saver)
+
+	first := postOrders(t, handler, "key-1", twoOrders)
+	if first.Code != http.StatusOK {
+		t.Fatalf("first response: %d %s", first.Code, first.Body)
+	}
+	second := postOrders(t, handler, "key-1", twoOrders)
+	if second.Code != http.StatusOK || second.Body.String() != first.Body.String() {
+		t.Errorf("replayed response = %d %s; want %d %s", second.Code, second.Body, first.Code, first.Body)
+	}
+	if second.Header().Get("Idempotent-Replayed") != "true" {
+		t.Error("replayed response has no Idempotent-Replayed header")
+	}
+	if saver.calls != 2 {
+		t.Errorf("orders stored %d times; want 2, by the first request only", saver.calls)
+	}
+}
+
+func TestPostOrdersRefusesDifferentBodyWithSameKey(t *testing.T) {
+	saver := &fakeSaver{}
+	handler, _ := newIngestServer(t, saver)
+
+	if rec := postOrders(t, handler, "key-1", twoOrders); rec.Code != http.StatusOK {
+		t.Fatalf("first response: %d %s", rec.Code, rec.Body)
+	}
+	rec := postOrders(t, handler, "key-1", `{"order_uid":"order-3"}`)
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("response to a different body = %d %s; want 422", rec.Code, rec.Body)
+	}
+	if _, ok := saver.orders["order-3"]; ok {
+		t.Error("order-3 stored under a key used by another request")
+	}
+}
+
+func TestPostOrdersInProgress(t *testing.T) {
+	handler, store := newIngestServer(t, &fakeSaver{})
+
+	// Ключ зарезервирован тем же запросом, который еще обрабатывается
+	sum := sha256.Sum256([]byte(twoOrders))
+	if _, err := store.ReserveIdempotencyKey(context.Background(), "key-1", hex.EncodeToString(sum[:])); err != nil {
+		t.Fatal(err)
+	}
+	if rec := postOrders(t, handler, "key-1", twoOrders); rec.Code != http.StatusConflict {
+		t.Errorf("response while the key is in progress = %d %s; want 409", rec.Code, rec.Body)
+	}
+}
+
+func TestPostOrdersReleasesKeyOnFailure(t *testing.T) {
+	saver := &fakeSaver{failOnce: map[string]bool{"order-2": true}}
+	handler, store := newIngestServer(t, saver)
+
+	rec := postOrders(t, handler, "key-1", twoOrders)
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("response = %d %s; want 500", rec.Code, rec.Body)
+	}
+	if got := statuses(t, rec); strings.Join(got, ",") != "created,failed" {
+		t.Errorf("statuses = %v; want [created failed]", got)
+	}
+
+	// Ключ освобожден: его можно зарезервировать снова
+	record, err := store.ReserveIdempotencyKey(context.Background(), "key-1", "hash")
+	if err != nil || record != nil {
+		t.Errorf("ReserveIdempotencyKey() after a 500 = %+v, %v; want the key released", record, err)
+	}
+}
+
+func TestPostOrdersRetryAfterFailureIsIdempotent(t *testing.T) {
+	saver := &fakeSaver{failOnce: map[string]bool{"order-2": true}}
+	handler, _ := newIngestServer(t, saver)
+
+	if rec := postOrders(t, handler, "key-1", twoOrders); rec.Code != http.StatusInternalServerError {
+		t.Fatalf("first response = %d %s; want 500", rec.Code, rec.Body)
+	}
+
+	// Повтор с тем же ключом сохраняет только то, что не удалось сохранить
+	retried := postOrders(t, handler, "key-1", twoOrders)
+	if retried.Code != http.StatusOK {
+		t.Fatalf("retried response = %d %s; want 200", retried.Code, retried.Body)
+	}
+	if got := statuses(t, retried); strings.Join(got, ",") != "unchanged,created" {
+		t.Errorf("retried statuses = %v; want [unchanged created]", got)
+	}
+	if len(saver.orders) != 2 {
+		t.Errorf("%d orders stored; want 2", len(saver.orders))
+	}
+
+	replayed := postOrders(t, handler, "key-1", twoOrders)
+	if replayed.Code != http.StatusOK || replayed.Body.String() != retried.Body.String() {
+		t.Errorf("response after the retry = %d %s; want the retried response replayed", replayed.Code, replayed.Body)
+	}
+}
+
+func TestPostOrdersWithoutKey(t *testing.T) {
+	saver := &fakeSaver{}
+	handler, _ := newIngestServer(t, saver)
+
+	for i := 0; i < 2; i++ {
+		if rec := postOrders(t, handler, "", twoOrders); rec.Code != http.StatusOK || rec.Header().Get("Idempotent-Replayed") != "" {
+			t.Fatalf("response %d = %d %s; want 200 without a replay", i, rec.Code, rec.Body)
+		}
+	}
+	if saver.calls != 4 {
+		t.Errorf("orders stored %d times; want 4, every request is processed", saver.calls)
+	}
+}
@@ -1,31 +1,55 @@
 // Package server provides the implementation of the HTTP API server that handles
 // requests related to orders. It defines the APIServer struct, which holds the
 // configuration, router, and orderer for interacting with orders. The server
-// exposes an HTTP endpoint to retrieve an order by its unique identifier (UID).
+// exposes HTTP endpoints to retrieve an order by its unique identifier (UID), track
+// number or payment transaction, the orders of a customer, to list orders page by page,
+// to search them by their text, the history of its received revisions, as well as to
+// post new orders.
+// Authenticated admin endpoints delete or anonymize an order and control the consumption
+// of orders from the broker.
 package server
 
 import (
 	"context"
 	"demo_service/internal/config"
+	"demo_service/internal/history"
+	"demo_service/internal/listing"
 	"demo_service/internal/models"
+	"demo_service/internal/search"
 	"encoding/json"
+	"errors"
 	"net/http"
+	"sync"
 	"time"
 )
 
 // Orderer defines the methods for interacting with orders,
-// including retrieving an order by its UID.
+// including retrieving an order and its history by its UID, looking orders up
+// by their track number, payment transaction or customer, listing and searching orders.
 type Orderer interface {
 	GetOrder(ctx context.Context, orderUID string) (*models.Order, error)
+	GetOrderByTrackNumber(ctx context.Context, trackNumber string) (*models.Order, error)
+	GetOrderByTransaction(ctx context.Context, transaction string) (*models.Order, error)
+	GetCustomerOrders(ctx context.Context, customerID string) ([]models.Order, error)
+	ListOrders(ctx context.Context, q listing.Query) (listing.Page, error)
+	SearchOrders(ctx context.Context, q search.Query) ([]search.Result, error)
+	GetOrderHistory(ctx context.Context, orderUID string) ([]history.Entry, error)
+	DeleteOrder(ctx context.Context, orderUID string) (bool, error)
+	AnonymizeOrder(ctx context.Context, orderUID string) (bool, error)
 }
 
 // APIServer represents the HTTP API server with configuration, router, context,
 // and orderer for handling requests.
 type APIServer struct {
-	config *config.HTTPServer
-	router *http.ServeMux
-	ctx    context.Context
-	ord    Orderer
+	config   *config.HTTPServer
+	router   *http.ServeMux
+	ctx      context.Context
+	ord      Orderer
+	consumer ConsumerController
+
+	ingester    Ingester
+	idempotency IdempotencyStore
+	routerOnce  sync.Once
 }
 
 // New creates a new APIServer instance with the provided context,
@@ -41,13 +65,37 @@ func New(ctx context.Context, ord Orderer, config *config.HTTPServer) *APIServer
 	}
 }
 
+// SetConsumerController sets the consumer controlled through the admin endpoints.
+// It must be called before Start.
+func (s *APIServer) SetConsumerController(consumer ConsumerController) {
+	s.consumer = consumer
+}
+
+// SetIngester sets the ingester of the orders posted to the API.
+// It must be called before Start; without it, POST /orders is not served.
+func (s *APIServer) SetIngester(ingester Ingester) {
+	s.ingester = ingester
+}
+
+// SetIdempotencyStore sets the store of the Idempotency-Key responses of POST /orders.
+// It must be called before Start; without it, the Idempotency-Key header is ignored.
+func (s *APIServer) SetIdempotencyStore(store IdempotencyStore) {
+	s.idempotency = store
+}
+
+// Handler returns the router of the API, configuring it on first use.
+// It lets the API be served by another server, e.g. httptest.Server.
+func (s *APIServer) Handler() http.Handler {
+	s.routerOnce.Do(s.configureRouter)
+	return s.router
+}
+
 // Start initializes the HTTP server with specified timeout settings and router,
 // then starts listening for requests.
 func (s *APIServer) Start() error {
-	s.configureRouter()
 	server := &http.Server{
 		Addr:         s.config.Address,
-		Handler:      s.router,
+		Handler:      s.Handler(),
 		ReadTimeout:  30 * time.Second,  // Request read timeout
 		WriteTimeout: 10 * time.Second,  // Response Record Timeout
 		IdleTimeout:  120 * time.Second, // Keep-alive connections timeout
@@ -69,10 +117,173 @@ func (s *APIServer) getOrder(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func (s *APIServer) getOrderByTrackNumber(w http.ResponseWriter, r *http.Request) {
+	s.lookupOrder(w, s.ord.GetOrderByTrackNumber, r.PathValue("track"))
+}
+
+func (s *APIServer) getOrderByTransaction(w http.ResponseWriter, r *http.Request) {
+	s.lookupOrder(w, s.ord.GetOrderByTransaction, r.PathValue("tx"))
+}
+
+// lookupOrder responds with the order found by the key, or with 404 Not Found.
+func (s *APIServer) lookupOrder(w http.ResponseWriter,
+	lookup func(ctx context.Context, key string) (*models.Order, error), key string) {
+	order, err := lookup(s.ctx, key)
+	if err != nil {
+		http.Error(w, "Order not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(order); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (s *APIServer) getCustomerOrders(w http.ResponseWriter, r *http.Request) {
+	customerID := r.PathValue("id")
+	orders, err := s.ord.GetCustomerOrders(s.ctx, customerID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(orders) == 0 {
+		http.Error(w, "Customer orders not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(map[string]interface{}{
+		"customer_id": customerID,
+		"orders":      orders,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// listOrders responds with a page of the orders matching the filters of the URL query
+// (see listing.ParseQuery) and the cursor of the next page.
+func (s *APIServer) listOrders(w http.ResponseWriter, r *http.Request) {
+	q, err := listing.ParseQuery(r.URL.Query())
+	if errors.Is(err, listing.ErrInvalidQuery) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	page, err := s.ord.ListOrders(s.ctx, q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(page); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// searchOrders responds with the orders matching the full-text query of the URL (see search.ParseQuery),
+// best ranked first, with highlighted snippets.
+func (s *APIServer) searchOrders(w http.ResponseWriter, r *http.Request) {
+	q, err := search.ParseQuery(r.URL.Query())
+	if errors.Is(err, search.ErrInvalidQuery) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	results, err := s.ord.SearchOrders(s.ctx, q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(map[string]interface{}{
+		"query":   q.Text,
+		"results": results,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (s *APIServer) getOrderHistory(w http.ResponseWriter, r *http.Request) {
+	uid := r.PathValue("uid")
+	entries, err := s.ord.GetOrderHistory(s.ctx, uid)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(entries) == 0 {
+		http.Error(w, "Order history not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(map[string]interface{}{
+		"order_uid": uid,
+		"revisions": entries,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (s *APIServer) deleteOrder(w http.ResponseWriter, r *http.Request) {
+	s.changeOrder(w, r, s.ord.DeleteOrder)
+}
+
+func (s *APIServer) anonymizeOrder(w http.ResponseWriter, r *http.Request) {
+	s.changeOrder(w, r, s.ord.AnonymizeOrder)
+}
+
+// changeOrder applies the change to the order from the path and responds with 204 No Content,
+// or with 404 Not Found if the order does not exist.
+func (s *APIServer) changeOrder(w http.ResponseWriter, r *http.Request,
+	change func(ctx context.Context, orderUID string) (bool, error)) {
+	found, err := change(s.ctx, r.PathValue("uid"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		http.Error(w, "Order not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
 func (s *APIServer) configureRouter() {
 	s.router.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		http.ServeFile(w, r, "templates/index.html")
 	})
 	s.router.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("templates/static/"))))
-	s.router.HandleFunc("/order/", s.getOrder)
+	s.router.HandleFunc("GET /order/", s.getOrder)
+	s.router.HandleFunc("GET /orders", s.listOrders)
+	s.router.HandleFunc("GET /orders/search", s.searchOrders)
+	s.router.HandleFunc("GET /orders/by-track/{track}", s.getOrderByTrackNumber)
+	s.router.HandleFunc("GET /orders/by-transaction/{tx}", s.getOrderByTransaction)
+	s.router.HandleFunc("GET /customers/{id}/orders", s.getCustomerOrders)
+	s.router.HandleFunc("GET /order/{uid}/history", s.getOrderHistory)
+
+	if s.ingester != nil {
+		s.router.HandleFunc("POST /orders", s.postOrders)
+	}
+	if s.config.AdminToken != "" {
+		s.router.HandleFunc("DELETE /order/{uid}", s.admin(s.deleteOrder))
+		s.router.HandleFunc("POST /order/{uid}/anonymize", s.admin(s.anonymizeOrder))
+	}
+	if s.config.AdminToken != "" && s.consumer != nil {
+		s.router.HandleFunc("GET /admin/consumer", s.admin(s.consumerStatus))
+		s.router.HandleFunc("POST /admin/consumer/pause", s.admin(s.pauseConsumer))
+		s.router.HandleFunc("POST /admin/consumer/resume", s.admin(s.resumeConsumer))
+		s.router.HandleFunc("POST /admin/consumer/reset", s.admin(s.resetConsumerOffsets))
+	}
 }
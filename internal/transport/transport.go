@@ -0,0 +1,170 @@
+// Package transport abstracts the message broker orders are ingested from.
+//
+// A Transport delivers decoded orders and tombstones to a Handler until its context is canceled.
+// Kafka (internal/kafka) and NATS JetStream (internal/natsjs) are available;
+// New selects one of them by the Transport section of the configuration.
+// Transport-specific capabilities, such as the consumer control of Kafka,
+// are exposed by the returned value through additional interfaces.
+package transport
+
+import (
+	"context"
+	"demo_service/internal/config"
+	"demo_service/internal/kafka"
+	"demo_service/internal/models"
+	"demo_service/internal/natsjs"
+	"demo_service/internal/retry"
+	"fmt"
+	"log"
+)
+
+// Handler processes what is received from a transport.
+// Batch and Tombstone are optional: without Batch, orders are processed one by one with Order,
+// even if batching is configured; without Tombstone, tombstones are skipped.
+type Handler struct {
+	Order     func(order models.Order) error
+	Batch     func(orders []models.Order) error
+	Tombstone func(tombstone models.Tombstone) error
+}
+
+// Transport consumes orders from a message broker.
+type Transport interface {
+	// Start consumes messages and passes them to the handler until ctx is canceled.
+	Start(ctx context.Context, handler Handler)
+	// Close releases the connection to the broker.
+	Close() error
+}
+
+// New creates the transport selected in the configuration.
+func New(cfg *config.Config) (Transport, error) {
+	const fn = "transport.New"
+
+	switch cfg.Transport.Type {
+	case config.TransportKafka:
+		adapter, err := kafka.NewConsumerAdapter(cfg.Broker)
+		if err != nil {
+			return nil, err
+		}
+		return &kafkaTransport{ConsumerAdapter: adapter, batchSize: cfg.Broker.Batch.Size}, nil
+	case config.TransportNATS:
+		adapter, err := natsjs.NewConsumerAdapter(cfg.Transport.NATS, cfg.Broker)
+		if err != nil {
+			return nil, err
+		}
+		return &natsTransport{adapter: adapter, batchSize: cfg.Broker.Batch.Size}, nil
+	default:
+		return nil, fmt.Errorf("(%s) | unknown transport type: %q", fn, cfg.Transport.Type)
+	}
+}
+
+// NewMemory creates a Kafka transport that consumes from the in-memory broker instead of a cluster,
+// for end-to-end tests and local runs without Kafka.
+func NewMemory(broker *kafka.MemoryBroker, cfg *config.Config) (Transport, error) {
+	adapter, err := kafka.NewMemoryConsumerAdapter(broker, cfg.Broker)
+	if err != nil {
+		return nil, err
+	}
+	return &kafkaTransport{ConsumerAdapter: adapter, batchSize: cfg.Broker.Batch.Size}, nil
+}
+
+// Orders stores the received orders and applies the received tombstones.
+type Orders interface {
+	SaveOrder(ctx context.Context, order models.Order) error
+	SaveOrders(ctx context.Context, orders []models.Order) error
+	DeleteOrder(ctx context.Context, orderUID string) (bool, error)
+	AnonymizeOrder(ctx context.Context, orderUID string) (bool, error)
+}
+
+// NewHandler returns the handler that stores the received orders (one by one or in batches)
+// and applies the received tombstones with the order module.
+// Transient errors are retried with the policy.
+func NewHandler(ctx context.Context, orders Orders, policy *retry.Policy) Handler {
+	const fn = "consumerStart"
+
+	return Handler{
+		Order: RetryHandler(ctx, policy, func(order models.Order) error {
+			log.Printf("(%s) Message received: %s\n", fn, order.OrderUID)
+			if err := orders.SaveOrder(ctx, order); err != nil {
+				log.Printf("(%s) | Error saving order: %v\n", fn, err)
+				return err
+			}
+			return nil
+		}),
+		Batch: RetryHandler(ctx, policy, func(batch []models.Order) error {
+			log.Printf("(%s) Batch received: %d orders\n", fn, len(batch))
+			if err := orders.SaveOrders(ctx, batch); err != nil {
+				log.Printf("(%s) | Error saving orders: %v\n", fn, err)
+				return err
+			}
+			return nil
+		}),
+		Tombstone: RetryHandler(ctx, policy, func(tombstone models.Tombstone) error {
+			log.Printf("(%s) Tombstone received: %s\n", fn, tombstone.OrderUID)
+			var err error
+			if tombstone.Anonymize {
+				_, err = orders.AnonymizeOrder(ctx, tombstone.OrderUID)
+			} else {
+				_, err = orders.DeleteOrder(ctx, tombstone.OrderUID)
+			}
+			if err != nil {
+				log.Printf("(%s) | Error removing order: %v\n", fn, err)
+			}
+			return err
+		}),
+	}
+}
+
+// RetryHandler wraps a handler function (of an order, a batch or a tombstone) with the retry policy:
+// transient errors are retried with jittered exponential backoff until the outcome is final or ctx is canceled.
+func RetryHandler[T any](ctx context.Context, policy *retry.Policy, handler func(T) error) func(T) error {
+	if handler == nil {
+		return nil
+	}
+	return func(value T) error {
+		return policy.Do(ctx, func() error {
+			return handler(value)
+		})
+	}
+}
+
+// kafkaTransport is the Kafka transport.
+// It also exposes the consumer control (pause, resume and offset reset) of the adapter.
+type kafkaTransport struct {
+	*kafka.ConsumerAdapter
+	batchSize int
+}
+
+// Start implements Transport.
+func (t *kafkaTransport) Start(ctx context.Context, handler Handler) {
+	if handler.Tombstone != nil {
+		t.SetTombstoneHandler(handler.Tombstone)
+	}
+	if handler.Batch != nil && t.batchSize > 1 {
+		t.StartBatch(ctx, handler.Batch)
+		return
+	}
+	t.ConsumerAdapter.Start(ctx, handler.Order)
+}
+
+// natsTransport is the NATS JetStream transport.
+type natsTransport struct {
+	adapter   *natsjs.ConsumerAdapter
+	batchSize int
+}
+
+// Start implements Transport.
+func (t *natsTransport) Start(ctx context.Context, handler Handler) {
+	if handler.Tombstone != nil {
+		t.adapter.SetTombstoneHandler(handler.Tombstone)
+	}
+	if handler.Batch != nil && t.batchSize > 1 {
+		t.adapter.StartBatch(ctx, handler.Batch)
+		return
+	}
+	t.adapter.Start(ctx, handler.Order)
+}
+
+// Close implements Transport.
+func (t *natsTransport) Close() error {
+	return t.adapter.Close()
+}
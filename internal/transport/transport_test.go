@@ -0,0 +1,219 @@
+package transport
+
+import (
+	"context"
+	"demo_service/internal/config"
+	"demo_service/internal/kafka"
+	"demo_service/internal/models"
+	"demo_service/internal/retry"
+	"demo_service/internal/rules"
+	"demo_service/internal/validation"
+	"encoding/json"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// errTransient is the only error the retry policy of the tests retries.
+var errTransient = errors.New("database is down")
+
+// testPolicy returns a policy with three fast attempts that retries errTransient.
+func testPolicy() *retry.Policy {
+	return retry.New(config.Retry{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond},
+		func(err error) bool { return errors.Is(err, errTransient) })
+}
+
+// fakeOrders stores orders in memory. A failure returned by fail for an order UID is returned by SaveOrder instead.
+type fakeOrders struct {
+	fail func(uid string, attempt int) error
+
+	mu       sync.Mutex
+	attempts map[string]int
+	saved    []string
+	removed  []string
+}
+
+func (o *fakeOrders) SaveOrder(_ context.Context, order models.Order) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.attempts == nil {
+		o.attempts = make(map[string]int)
+	}
+	o.attempts[order.OrderUID]++
+	if o.fail != nil {
+		if err := o.fail(order.OrderUID, o.attempts[order.OrderUID]); err != nil {
+			return err
+		}
+	}
+	o.saved = append(o.saved, order.OrderUID)
+	return nil
+}
+
+func (o *fakeOrders) SaveOrders(ctx context.Context, orders []models.Order) error {
+	for _, order := range orders {
+		if err := o.SaveOrder(ctx, order); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (o *fakeOrders) DeleteOrder(_ context.Context, orderUID string) (bool, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.removed = append(o.removed, "delete "+orderUID)
+	return true, nil
+}
+
+func (o *fakeOrders) AnonymizeOrder(_ context.Context, orderUID string) (bool, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.removed = append(o.removed, "anonymize "+orderUID)
+	return true, nil
+}
+
+func TestNewHandlerOutcomes(t *testing.T) {
+	invalid := validation.Errors{{Field: "order_uid", Rule: "required", Message: "is required"}}
+
+	cases := []struct {
+		name     string
+		fail     func(uid string, attempt int) error
+		attempts int
+		// check reports whether the error is the outcome the adapters settle the message by
+		check func(err error) bool
+	}{
+		{"Stored", nil, 1, func(err error) bool { return err == nil }},
+		{"TransientThenStored", func(_ string, attempt int) error {
+			if attempt == 1 {
+				return errTransient
+			}
+			return nil
+		}, 2, func(err error) bool { return err == nil }},
+		{"Invalid", func(string, int) error { return invalid }, 1, func(err error) bool {
+			var validationErrs validation.Errors
+			return errors.As(err, &validationErrs)
+		}},
+		{"Rejected", func(string, int) error { return &rules.RejectedError{} }, 1, func(err error) bool {
+			var rejected *rules.RejectedError
+			return errors.As(err, &rejected)
+		}},
+		{"TransientExhausted", func(string, int) error { return errTransient }, 3, func(err error) bool {
+			return errors.Is(err, errTransient) && retry.Attempts(err) == 3
+		}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			orders := &fakeOrders{fail: c.fail}
+			handler := NewHandler(context.Background(), orders, testPolicy())
+
+			err := handler.Order(models.Order{OrderUID: "order-1"})
+			if !c.check(err) {
+				t.Errorf("Order() error = %v", err)
+			}
+			if got := orders.attempts["order-1"]; got != c.attempts {
+				t.Errorf("SaveOrder called %d times; want %d", got, c.attempts)
+			}
+		})
+	}
+}
+
+func TestNewHandlerInterrupted(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	orders := &fakeOrders{fail: func(string, int) error {
+		cancel()
+		return errTransient
+	}}
+	handler := NewHandler(ctx, orders, testPolicy())
+
+	// Прерванная обработка не окончательна: сообщение должно быть доставлено повторно
+	if err := handler.Order(models.Order{OrderUID: "order-1"}); !errors.Is(err, context.Canceled) {
+		t.Errorf("Order() error = %v; want context.Canceled", err)
+	}
+}
+
+func TestNewHandlerTombstones(t *testing.T) {
+	orders := &fakeOrders{}
+	handler := NewHandler(context.Background(), orders, testPolicy())
+
+	if err := handler.Tombstone(models.Tombstone{OrderUID: "order-1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := handler.Tombstone(models.Tombstone{OrderUID: "order-2", Anonymize: true}); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"delete order-1", "anonymize order-2"}; !slices.Equal(orders.removed, want) {
+		t.Errorf("removed %v; want %v", orders.removed, want)
+	}
+}
+
+func TestMemoryTransportSettlesMessages(t *testing.T) {
+	cfg := &config.Config{Broker: config.Broker{
+		GroupID:     "transport",
+		Topic:       "orders",
+		AtLeastOnce: true,
+		Workers:     1,
+		Codec:       kafka.CodecJSON,
+		DLQ:         config.DLQ{Enabled: true, Topic: "orders.dlq"},
+		Batch:       config.Batch{Size: 1},
+	}}
+	broker := kafka.NewMemoryBroker(1)
+	consumer, err := NewMemory(broker, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	producer := broker.Producer()
+	for _, uid := range []string{"order-1", "order-rejected", "order-transient"} {
+		value, err := json.Marshal(models.Order{OrderUID: uid})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := producer.SendMessage(&sarama.ProducerMessage{
+			Topic: "orders", Key: sarama.StringEncoder(uid), Value: sarama.ByteEncoder(value),
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	orders := &fakeOrders{fail: func(uid string, attempt int) error {
+		switch {
+		case uid == "order-rejected":
+			return &rules.RejectedError{}
+		case uid == "order-transient" && attempt == 1:
+			return errTransient
+		}
+		return nil
+	}}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		consumer.Start(ctx, NewHandler(ctx, orders, testPolicy()))
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for broker.Lag("orders") > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	<-done
+	if err := consumer.Close(); err != nil {
+		t.Error(err)
+	}
+
+	// Все сообщения закоммичены: отклонённое — после переноса в DLQ, временная ошибка — после повтора
+	if lag := broker.Lag("orders"); lag != 0 {
+		t.Errorf("lag = %d; want every message committed", lag)
+	}
+	if want := []string{"order-1", "order-transient"}; !slices.Equal(orders.saved, want) {
+		t.Errorf("saved %v; want %v", orders.saved, want)
+	}
+	if dead := broker.Messages("orders.dlq"); len(dead) != 1 || string(dead[0].Key) != "order-rejected" {
+		t.Errorf("dead-lettered %d messages; want order-rejected only", len(dead))
+	}
+}
@@ -0,0 +1,216 @@
+// Package validation checks orders against declarative schema rules before they are persisted.
+//
+// The rules describe required fields, maximum lengths matching the VARCHAR limits
+// of the database schema, email and phone formats, non-negative money values,
+// integers that fit the INTEGER columns they are stored in
+// and the consistency of item track numbers with the order.
+//
+// Validate returns all violations at once as field-level errors,
+// so the caller can report exactly what is wrong with an order.
+package validation
+
+import (
+	"demo_service/internal/models"
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// FieldError describes a single rule violation for a field of an order.
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// Error implements the error interface.
+func (e FieldError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Field, e.Message)
+}
+
+// Errors is the list of violations found in an order.
+type Errors []FieldError
+
+// Error implements the error interface.
+func (e Errors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, fe := range e {
+		msgs = append(msgs, fe.Error())
+	}
+	return "invalid order: " + strings.Join(msgs, "; ")
+}
+
+// Rule names reported in FieldError.Rule.
+const (
+	RuleRequired    = "required"
+	RuleMaxLength   = "max_length"
+	RuleFormat      = "format"
+	RuleNonNegative = "non_negative"
+	RuleRange       = "range"
+	RuleMatch       = "match"
+)
+
+var (
+	emailRe = regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[^@\s]+$`)
+	phoneRe = regexp.MustCompile(`^\+?[0-9(][0-9 ().\-]{4,19}$`)
+)
+
+// stringRule declares the constraints of a string field.
+type stringRule[T any] struct {
+	field    string
+	get      func(*T) string
+	required bool
+	max      int
+	format   *regexp.Regexp
+}
+
+// intRule declares the allowed range of an integer field.
+type intRule[T any] struct {
+	field string
+	get   func(*T) int
+	min   int
+	max   int // 0 means no upper bound
+}
+
+// maxInteger is the largest value of an INTEGER column.
+// Integers stored in INTEGER columns are limited to the int32 range, the money values to its non-negative part.
+const maxInteger = math.MaxInt32
+
+// The max lengths match migrations/0001_create_orders_schema.up.sql.
+var orderStrings = []stringRule[models.Order]{
+	{field: "order_uid", get: func(o *models.Order) string { return o.OrderUID }, required: true, max: 36},
+	{field: "track_number", get: func(o *models.Order) string { return o.TrackNumber }, required: true, max: 255},
+	{field: "entry", get: func(o *models.Order) string { return o.Entry }, required: true, max: 50},
+	{field: "locale", get: func(o *models.Order) string { return o.Locale }, required: true, max: 10},
+	{field: "internal_signature", get: func(o *models.Order) string { return o.InternalSignature }, max: 255},
+	{field: "customer_id", get: func(o *models.Order) string { return o.CustomerID }, required: true, max: 255},
+	{field: "delivery_service", get: func(o *models.Order) string { return o.DeliveryService }, required: true, max: 255},
+	{field: "shardkey", get: func(o *models.Order) string { return o.Shardkey }, max: 50},
+	{field: "oof_shard", get: func(o *models.Order) string { return o.OofShard }, max: 50},
+
+	{field: "delivery.name", get: func(o *models.Order) string { return o.Delivery.Name }, required: true, max: 255},
+	{field: "delivery.phone", get: func(o *models.Order) string { return o.Delivery.Phone }, required: true, max: 20, format: phoneRe},
+	{field: "delivery.zip", get: func(o *models.Order) string { return o.Delivery.Zip }, max: 20},
+	{field: "delivery.city", get: func(o *models.Order) string { return o.Delivery.City }, required: true, max: 255},
+	{field: "delivery.address", get: func(o *models.Order) string { return o.Delivery.Address }, required: true, max: 255},
+	{field: "delivery.region", get: func(o *models.Order) string { return o.Delivery.Region }, max: 255},
+	{field: "delivery.email", get: func(o *models.Order) string { return o.Delivery.Email }, required: true, max: 255, format: emailRe},
+
+	{field: "payment.transaction", get: func(o *models.Order) string { return o.Payment.Transaction }, required: true, max: 255},
+	{field: "payment.request_id", get: func(o *models.Order) string { return o.Payment.RequestID }, max: 255},
+	{field: "payment.currency", get: func(o *models.Order) string { return o.Payment.Currency }, required: true, max: 10},
+	{field: "payment.provider", get: func(o *models.Order) string { return o.Payment.Provider }, required: true, max: 50},
+	{field: "payment.bank", get: func(o *models.Order) string { return o.Payment.Bank }, max: 50},
+}
+
+var orderInts = []intRule[models.Order]{
+	{field: "sm_id", get: func(o *models.Order) int { return o.SmID }, min: math.MinInt32, max: maxInteger},
+
+	{field: "payment.amount", get: func(o *models.Order) int { return o.Payment.Amount }, max: maxInteger},
+	{field: "payment.payment_dt", get: func(o *models.Order) int { return o.Payment.PaymentDT }},
+	{field: "payment.delivery_cost", get: func(o *models.Order) int { return o.Payment.DeliveryCost }, max: maxInteger},
+	{field: "payment.goods_total", get: func(o *models.Order) int { return o.Payment.GoodsTotal }, max: maxInteger},
+	{field: "payment.custom_fee", get: func(o *models.Order) int { return o.Payment.CustomFee }, max: maxInteger},
+}
+
+var itemStrings = []stringRule[models.Item]{
+	{field: "track_number", get: func(i *models.Item) string { return i.TrackNumber }, required: true, max: 255},
+	{field: "rid", get: func(i *models.Item) string { return i.RID }, required: true, max: 255},
+	{field: "name", get: func(i *models.Item) string { return i.Name }, required: true, max: 255},
+	{field: "size", get: func(i *models.Item) string { return i.Size }, max: 50},
+	{field: "brand", get: func(i *models.Item) string { return i.Brand }, max: 255},
+}
+
+var itemInts = []intRule[models.Item]{
+	{field: "chrt_id", get: func(i *models.Item) int { return i.ChrtID }, min: math.MinInt32, max: maxInteger},
+	{field: "price", get: func(i *models.Item) int { return i.Price }, max: maxInteger},
+	{field: "sale", get: func(i *models.Item) int { return i.Sale }, max: 100},
+	{field: "total_price", get: func(i *models.Item) int { return i.TotalPrice }, max: maxInteger},
+	{field: "nm_id", get: func(i *models.Item) int { return i.NMID }, min: math.MinInt32, max: maxInteger},
+	{field: "status", get: func(i *models.Item) int { return i.Status }, min: math.MinInt32, max: maxInteger},
+}
+
+// Validate checks the order against all rules and returns Errors if any of them is violated.
+func Validate(order models.Order) error {
+	var errs Errors
+
+	checkStrings(&errs, "", orderStrings, &order)
+	checkInts(&errs, "", orderInts, &order)
+
+	if order.DateCreated.IsZero() {
+		errs = append(errs, FieldError{Field: "date_created", Rule: RuleRequired, Message: "is required"})
+	}
+
+	if len(order.Items) == 0 {
+		errs = append(errs, FieldError{Field: "items", Rule: RuleRequired, Message: "must contain at least one item"})
+	}
+	for i := range order.Items {
+		item := &order.Items[i]
+		prefix := fmt.Sprintf("items[%d].", i)
+
+		checkStrings(&errs, prefix, itemStrings, item)
+		checkInts(&errs, prefix, itemInts, item)
+
+		if item.TrackNumber != "" && item.TrackNumber != order.TrackNumber {
+			errs = append(errs, FieldError{
+				Field:   prefix + "track_number",
+				Rule:    RuleMatch,
+				Message: fmt.Sprintf("%q does not match order track number %q", item.TrackNumber, order.TrackNumber),
+			})
+		}
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// checkStrings applies string rules to the entity and appends violations to errs.
+func checkStrings[T any](errs *Errors, prefix string, rules []stringRule[T], entity *T) {
+	for _, r := range rules {
+		value := r.get(entity)
+		field := prefix + r.field
+
+		if value == "" {
+			if r.required {
+				*errs = append(*errs, FieldError{Field: field, Rule: RuleRequired, Message: "is required"})
+			}
+			continue
+		}
+		if r.max > 0 && utf8.RuneCountInString(value) > r.max {
+			*errs = append(*errs, FieldError{
+				Field:   field,
+				Rule:    RuleMaxLength,
+				Message: fmt.Sprintf("must be at most %d characters", r.max),
+			})
+		}
+		if r.format != nil && !r.format.MatchString(value) {
+			*errs = append(*errs, FieldError{Field: field, Rule: RuleFormat, Message: "has invalid format"})
+		}
+	}
+}
+
+// checkInts applies integer rules to the entity and appends violations to errs.
+func checkInts[T any](errs *Errors, prefix string, rules []intRule[T], entity *T) {
+	for _, r := range rules {
+		value := r.get(entity)
+		field := prefix + r.field
+
+		if value < r.min {
+			rule, msg := RuleNonNegative, "must not be negative"
+			if r.min != 0 {
+				rule, msg = RuleRange, fmt.Sprintf("must be at least %d", r.min)
+			}
+			*errs = append(*errs, FieldError{Field: field, Rule: rule, Message: msg})
+		} else if r.max > 0 && value > r.max {
+			*errs = append(*errs, FieldError{
+				Field:   field,
+				Rule:    RuleRange,
+				Message: fmt.Sprintf("must be at most %d", r.max),
+			})
+		}
+	}
+}
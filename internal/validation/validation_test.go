@@ -0,0 +1,115 @@
+package validation
+
+import (
+	"demo_service/internal/models"
+	"encoding/json"
+	"errors"
+	"math"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// validOrder returns the order of model.json, which passes every rule.
+func validOrder(t *testing.T) models.Order {
+	t.Helper()
+
+	data, err := os.ReadFile("../../model.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var order models.Order
+	if err := json.Unmarshal(data, &order); err != nil {
+		t.Fatal(err)
+	}
+	return order
+}
+
+func TestValidate(t *testing.T) {
+	cases := []struct {
+		name   string
+		mutate func(o *models.Order)
+		// want lists the violations as "field rule"
+		want []string
+	}{
+		{"Valid", func(o *models.Order) {}, nil},
+		{"ValidInt32Bounds", func(o *models.Order) {
+			o.SmID = math.MinInt32
+			o.Payment.Amount = math.MaxInt32
+			o.Items[0].ChrtID = math.MaxInt32
+			o.Items[0].NMID = math.MinInt32
+			o.Items[0].Status = math.MaxInt32
+		}, nil},
+		{"PaymentDTIsBigint", func(o *models.Order) { o.Payment.PaymentDT = math.MaxInt32 + 1 }, nil},
+		{"Required", func(o *models.Order) {
+			o.OrderUID = ""
+			o.Delivery.Email = ""
+			o.DateCreated = time.Time{}
+		}, []string{"order_uid required", "delivery.email required", "date_created required"}},
+		{"NoItems", func(o *models.Order) { o.Items = nil }, []string{"items required"}},
+		{"MaxLength", func(o *models.Order) { o.Locale = strings.Repeat("я", 11) }, []string{"locale max_length"}},
+		{"MaxLengthInRunes", func(o *models.Order) { o.Locale = strings.Repeat("я", 10) }, nil},
+		{"Format", func(o *models.Order) {
+			o.Delivery.Email = "test.gmail.com"
+			o.Delivery.Phone = "phone"
+		}, []string{"delivery.phone format", "delivery.email format"}},
+		{"NegativeMoney", func(o *models.Order) {
+			o.Payment.Amount = -1
+			o.Items[0].Price = -1
+		}, []string{"payment.amount non_negative", "items[0].price non_negative"}},
+		{"SaleAbove100", func(o *models.Order) { o.Items[0].Sale = 101 }, []string{"items[0].sale range"}},
+		{"AmountsAboveInt32", func(o *models.Order) {
+			o.Payment.Amount = math.MaxInt32 + 1
+			o.Payment.DeliveryCost = math.MaxInt32 + 1
+			o.Payment.GoodsTotal = math.MaxInt32 + 1
+			o.Payment.CustomFee = math.MaxInt32 + 1
+			o.Items[0].Price = math.MaxInt32 + 1
+			o.Items[0].TotalPrice = math.MaxInt32 + 1
+		}, []string{
+			"payment.amount range", "payment.delivery_cost range", "payment.goods_total range",
+			"payment.custom_fee range", "items[0].price range", "items[0].total_price range",
+		}},
+		{"IDsAboveInt32", func(o *models.Order) {
+			o.SmID = math.MaxInt32 + 1
+			o.Items[0].ChrtID = math.MaxInt32 + 1
+			o.Items[0].NMID = math.MaxInt32 + 1
+			o.Items[0].Status = math.MaxInt32 + 1
+		}, []string{"sm_id range", "items[0].chrt_id range", "items[0].nm_id range", "items[0].status range"}},
+		{"IDsBelowInt32", func(o *models.Order) {
+			o.SmID = math.MinInt32 - 1
+			o.Items[0].ChrtID = math.MinInt32 - 1
+		}, []string{"sm_id range", "items[0].chrt_id range"}},
+		{"ItemTrackNumber", func(o *models.Order) {
+			o.Items = append(o.Items, o.Items[0])
+			o.Items[1].TrackNumber = "OTHER"
+		}, []string{"items[1].track_number match"}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			order := validOrder(t)
+			c.mutate(&order)
+
+			err := Validate(order)
+			if c.want == nil {
+				if err != nil {
+					t.Fatalf("Validate() error = %v; want nil", err)
+				}
+				return
+			}
+
+			var errs Errors
+			if !errors.As(err, &errs) {
+				t.Fatalf("Validate() error = %v; want Errors", err)
+			}
+			got := make([]string, 0, len(errs))
+			for _, fe := range errs {
+				got = append(got, fe.Field+" "+fe.Rule)
+			}
+			if !slices.Equal(got, c.want) {
+				t.Errorf("Validate() violations = %v; want %v", got, c.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,3 @@
+-- Drop business-rule check results
+ALTER TABLE orders
+    DROP COLUMN IF EXISTS checks;
@@ -0,0 +1,3 @@
+-- Store business-rule check results alongside the order
+ALTER TABLE orders
+    ADD COLUMN IF NOT EXISTS checks JSONB NOT NULL DEFAULT '[]'::jsonb;
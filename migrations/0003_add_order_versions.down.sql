@@ -0,0 +1,4 @@
+-- Drop order revision tracking
+ALTER TABLE orders
+    DROP COLUMN IF EXISTS updated_at,
+    DROP COLUMN IF EXISTS version;
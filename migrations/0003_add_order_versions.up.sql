@@ -0,0 +1,4 @@
+-- Track revisions of an order: version is incremented on every accepted update
+ALTER TABLE orders
+    ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1,
+    ADD COLUMN IF NOT EXISTS updated_at TIMESTAMP NOT NULL DEFAULT now();
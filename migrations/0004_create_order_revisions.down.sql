@@ -0,0 +1,2 @@
+-- Drop audit trail of order revisions
+DROP TABLE IF EXISTS order_revisions;
@@ -0,0 +1,18 @@
+-- Create audit trail of every accepted revision of an order
+CREATE TABLE IF NOT EXISTS order_revisions (
+    id BIGSERIAL PRIMARY KEY,
+    order_uid VARCHAR(36) NOT NULL REFERENCES orders (order_uid) ON DELETE CASCADE,
+    version INTEGER,
+    payload JSONB NOT NULL,
+    source_topic VARCHAR(255),
+    source_partition INTEGER,
+    source_offset BIGINT,
+    received_at TIMESTAMP NOT NULL DEFAULT now(),
+    CONSTRAINT unique_revision_source UNIQUE (
+        source_topic,
+        source_partition,
+        source_offset
+    )
+);
+
+CREATE INDEX IF NOT EXISTS idx_order_revisions_order_uid ON order_revisions (order_uid, id);
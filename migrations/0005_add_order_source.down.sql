@@ -0,0 +1,3 @@
+-- Drop ingestion metadata
+ALTER TABLE orders
+    DROP COLUMN IF EXISTS source;
@@ -0,0 +1,3 @@
+-- Store ingestion metadata of the broker record an order was received from
+ALTER TABLE orders
+    ADD COLUMN IF NOT EXISTS source JSONB;
@@ -0,0 +1,2 @@
+-- Drop stored responses of idempotent requests
+DROP TABLE IF EXISTS idempotency_keys;
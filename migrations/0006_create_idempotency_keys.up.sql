@@ -0,0 +1,11 @@
+-- Store responses of order ingestion requests made with an Idempotency-Key
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    key VARCHAR(255) PRIMARY KEY,
+    request_hash VARCHAR(64) NOT NULL,
+    status_code INTEGER,
+    response BYTEA,
+    created_at TIMESTAMP NOT NULL DEFAULT now(),
+    completed_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_idempotency_keys_created_at ON idempotency_keys (created_at);
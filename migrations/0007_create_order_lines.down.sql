@@ -0,0 +1,50 @@
+-- Recreate shared items and their links (repeated items of an order collapse into one link again)
+CREATE TABLE IF NOT EXISTS items (
+    id SERIAL PRIMARY KEY,
+    chrt_id INTEGER NOT NULL,
+    track_number VARCHAR(255) NOT NULL,
+    price INTEGER NOT NULL,
+    rid VARCHAR(255) NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    sale INTEGER NOT NULL,
+    size VARCHAR(50) NOT NULL,
+    total_price INTEGER NOT NULL,
+    nm_id INTEGER NOT NULL,
+    brand VARCHAR(255) NOT NULL,
+    status INTEGER NOT NULL,
+    CONSTRAINT unique_items UNIQUE (
+        chrt_id,
+        track_number,
+        price,
+        rid,
+        name,
+        sale,
+        size,
+        total_price,
+        nm_id,
+        brand,
+        status
+    )
+);
+
+CREATE TABLE IF NOT EXISTS order_items (
+    id SERIAL PRIMARY KEY,
+    order_uid VARCHAR(36) NOT NULL REFERENCES orders (order_uid) ON DELETE CASCADE,
+    item_id INTEGER NOT NULL REFERENCES items (id) ON DELETE CASCADE,
+    CONSTRAINT unique_order_items UNIQUE (order_uid, item_id)
+);
+
+INSERT INTO items (chrt_id, track_number, price, rid, name, sale, size, total_price, nm_id, brand, status)
+SELECT DISTINCT chrt_id, track_number, price, rid, name, sale, size, total_price, nm_id, brand, status
+FROM order_lines
+ON CONFLICT ON CONSTRAINT unique_items DO NOTHING;
+
+INSERT INTO order_items (order_uid, item_id)
+SELECT l.order_uid, i.id
+FROM order_lines l
+JOIN items i USING (chrt_id, track_number, price, rid, name, sale, size, total_price, nm_id, brand, status)
+ORDER BY l.order_uid, l.line_no
+ON CONFLICT ON CONSTRAINT unique_order_items DO NOTHING;
+
+-- Drop order lines
+DROP TABLE IF EXISTS order_lines;
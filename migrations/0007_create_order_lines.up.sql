@@ -0,0 +1,56 @@
+-- Create order lines owned by the order: a line keeps its position in the order and the quantity
+-- of identical items in a row, instead of linking to items shared (and collapsed) between orders
+CREATE TABLE IF NOT EXISTS order_lines (
+    order_uid VARCHAR(36) NOT NULL REFERENCES orders (order_uid) ON DELETE CASCADE,
+    line_no INTEGER NOT NULL,
+    quantity INTEGER NOT NULL DEFAULT 1,
+    chrt_id INTEGER NOT NULL,
+    track_number VARCHAR(255) NOT NULL,
+    price INTEGER NOT NULL,
+    rid VARCHAR(255) NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    sale INTEGER NOT NULL,
+    size VARCHAR(50) NOT NULL,
+    total_price INTEGER NOT NULL,
+    nm_id INTEGER NOT NULL,
+    brand VARCHAR(255) NOT NULL,
+    status INTEGER NOT NULL,
+    PRIMARY KEY (order_uid, line_no),
+    CONSTRAINT positive_quantity CHECK (quantity > 0)
+);
+
+-- Backfill from the recorded revision the order is stored as: it keeps repeated items and their order
+WITH applied AS (
+    SELECT DISTINCT ON (r.order_uid) r.order_uid, r.payload -> 'items' AS items
+    FROM order_revisions r
+    JOIN orders o ON o.order_uid = r.order_uid AND o.version = r.version
+    WHERE jsonb_typeof(r.payload -> 'items') = 'array'
+    ORDER BY r.order_uid, r.id DESC
+)
+INSERT INTO order_lines (order_uid, line_no, quantity, chrt_id, track_number, price, rid, name, sale, size, total_price, nm_id, brand, status)
+SELECT a.order_uid, i.line_no, 1,
+    COALESCE((i.item ->> 'chrt_id')::INTEGER, 0),
+    COALESCE(i.item ->> 'track_number', ''),
+    COALESCE((i.item ->> 'price')::INTEGER, 0),
+    COALESCE(i.item ->> 'rid', ''),
+    COALESCE(i.item ->> 'name', ''),
+    COALESCE((i.item ->> 'sale')::INTEGER, 0),
+    COALESCE(i.item ->> 'size', ''),
+    COALESCE((i.item ->> 'total_price')::INTEGER, 0),
+    COALESCE((i.item ->> 'nm_id')::INTEGER, 0),
+    COALESCE(i.item ->> 'brand', ''),
+    COALESCE((i.item ->> 'status')::INTEGER, 0)
+FROM applied a
+CROSS JOIN LATERAL jsonb_array_elements(a.items) WITH ORDINALITY AS i (item, line_no);
+
+-- Backfill the orders stored before revisions were recorded from their linked items
+INSERT INTO order_lines (order_uid, line_no, quantity, chrt_id, track_number, price, rid, name, sale, size, total_price, nm_id, brand, status)
+SELECT oi.order_uid, row_number() OVER (PARTITION BY oi.order_uid ORDER BY oi.id), 1,
+    i.chrt_id, i.track_number, i.price, i.rid, i.name, i.sale, i.size, i.total_price, i.nm_id, i.brand, i.status
+FROM order_items oi
+JOIN items i ON i.id = oi.item_id
+WHERE NOT EXISTS (SELECT 1 FROM order_lines l WHERE l.order_uid = oi.order_uid);
+
+-- Drop shared items and their links
+DROP TABLE IF EXISTS order_items;
+DROP TABLE IF EXISTS items;
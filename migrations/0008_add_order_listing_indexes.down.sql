@@ -0,0 +1,12 @@
+-- Drop indexes of the order listing
+DROP INDEX IF EXISTS idx_payments_amount;
+DROP INDEX IF EXISTS idx_payments_provider;
+DROP INDEX IF EXISTS idx_orders_delivery_id;
+DROP INDEX IF EXISTS idx_orders_payment_id;
+DROP INDEX IF EXISTS idx_orders_locale;
+DROP INDEX IF EXISTS idx_orders_entry;
+DROP INDEX IF EXISTS idx_orders_delivery_service;
+DROP INDEX IF EXISTS idx_orders_track_number;
+DROP INDEX IF EXISTS idx_orders_customer_id;
+DROP INDEX IF EXISTS idx_orders_updated_at;
+DROP INDEX IF EXISTS idx_orders_date_created;
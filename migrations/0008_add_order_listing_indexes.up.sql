@@ -0,0 +1,13 @@
+-- Indexes of the order listing: keyset pagination by every sort field (with order_uid as the tie-breaker),
+-- the filters, and the joins of orders with their payments and deliveries
+CREATE INDEX IF NOT EXISTS idx_orders_date_created ON orders (date_created, order_uid);
+CREATE INDEX IF NOT EXISTS idx_orders_updated_at ON orders (updated_at, order_uid);
+CREATE INDEX IF NOT EXISTS idx_orders_customer_id ON orders (customer_id, date_created, order_uid);
+CREATE INDEX IF NOT EXISTS idx_orders_track_number ON orders (track_number);
+CREATE INDEX IF NOT EXISTS idx_orders_delivery_service ON orders (delivery_service, date_created, order_uid);
+CREATE INDEX IF NOT EXISTS idx_orders_entry ON orders (entry, date_created, order_uid);
+CREATE INDEX IF NOT EXISTS idx_orders_locale ON orders (locale, date_created, order_uid);
+CREATE INDEX IF NOT EXISTS idx_orders_payment_id ON orders (payment_id);
+CREATE INDEX IF NOT EXISTS idx_orders_delivery_id ON orders (delivery_id);
+CREATE INDEX IF NOT EXISTS idx_payments_provider ON payments (provider, currency);
+CREATE INDEX IF NOT EXISTS idx_payments_amount ON payments (amount);
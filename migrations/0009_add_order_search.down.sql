@@ -0,0 +1,15 @@
+-- Drop the full-text search over orders
+DROP INDEX IF EXISTS idx_orders_search_vector;
+
+DROP TRIGGER IF EXISTS deliveries_search_vector ON deliveries;
+DROP TRIGGER IF EXISTS order_lines_search_delete ON order_lines;
+DROP TRIGGER IF EXISTS order_lines_search_update ON order_lines;
+DROP TRIGGER IF EXISTS order_lines_search_insert ON order_lines;
+DROP TRIGGER IF EXISTS orders_search_vector ON orders;
+
+DROP FUNCTION IF EXISTS deliveries_search_vector();
+DROP FUNCTION IF EXISTS order_lines_search_vector();
+DROP FUNCTION IF EXISTS orders_search_vector();
+DROP FUNCTION IF EXISTS order_search_text(VARCHAR, INTEGER);
+
+ALTER TABLE orders DROP COLUMN IF EXISTS search_vector;
@@ -0,0 +1,76 @@
+-- Full-text search over orders: item names and brands, delivery name, city, address and email.
+-- The 'simple' configuration lowercases words without stemming, as the data mixes languages.
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS search_vector tsvector;
+
+-- The searchable text of an order, also used to highlight the matches of a search
+CREATE OR REPLACE FUNCTION order_search_text(p_order_uid VARCHAR, p_delivery_id INTEGER) RETURNS text AS $$
+    SELECT concat_ws(' ',
+        (SELECT string_agg(concat_ws(' ', l.name, l.brand), ' ' ORDER BY l.line_no)
+         FROM order_lines l
+         WHERE l.order_uid = p_order_uid),
+        (SELECT concat_ws(' ', d.name, d.city, d.address, d.email)
+         FROM deliveries d
+         WHERE d.id = p_delivery_id));
+$$ LANGUAGE sql STABLE;
+
+-- An order is indexed when it is written with its delivery...
+CREATE OR REPLACE FUNCTION orders_search_vector() RETURNS trigger AS $$
+BEGIN
+    NEW.search_vector := to_tsvector('simple', order_search_text(NEW.order_uid, NEW.delivery_id));
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS orders_search_vector ON orders;
+CREATE TRIGGER orders_search_vector
+    BEFORE INSERT OR UPDATE OF delivery_id ON orders
+    FOR EACH ROW EXECUTE FUNCTION orders_search_vector();
+
+-- ...reindexed once per statement writing its lines...
+CREATE OR REPLACE FUNCTION order_lines_search_vector() RETURNS trigger AS $$
+BEGIN
+    UPDATE orders o
+    SET search_vector = to_tsvector('simple', order_search_text(o.order_uid, o.delivery_id))
+    WHERE o.order_uid IN (SELECT order_uid FROM changed_lines);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS order_lines_search_insert ON order_lines;
+CREATE TRIGGER order_lines_search_insert
+    AFTER INSERT ON order_lines
+    REFERENCING NEW TABLE AS changed_lines
+    FOR EACH STATEMENT EXECUTE FUNCTION order_lines_search_vector();
+
+DROP TRIGGER IF EXISTS order_lines_search_update ON order_lines;
+CREATE TRIGGER order_lines_search_update
+    AFTER UPDATE ON order_lines
+    REFERENCING NEW TABLE AS changed_lines
+    FOR EACH STATEMENT EXECUTE FUNCTION order_lines_search_vector();
+
+DROP TRIGGER IF EXISTS order_lines_search_delete ON order_lines;
+CREATE TRIGGER order_lines_search_delete
+    AFTER DELETE ON order_lines
+    REFERENCING OLD TABLE AS changed_lines
+    FOR EACH STATEMENT EXECUTE FUNCTION order_lines_search_vector();
+
+-- ...and when the searchable fields of its delivery change
+CREATE OR REPLACE FUNCTION deliveries_search_vector() RETURNS trigger AS $$
+BEGIN
+    UPDATE orders o
+    SET search_vector = to_tsvector('simple', order_search_text(o.order_uid, o.delivery_id))
+    WHERE o.delivery_id = NEW.id;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS deliveries_search_vector ON deliveries;
+CREATE TRIGGER deliveries_search_vector
+    AFTER UPDATE OF name, city, address, email ON deliveries
+    FOR EACH ROW EXECUTE FUNCTION deliveries_search_vector();
+
+-- Index the stored orders
+UPDATE orders
+SET search_vector = to_tsvector('simple', order_search_text(order_uid, delivery_id));
+
+CREATE INDEX IF NOT EXISTS idx_orders_search_vector ON orders USING GIN (search_vector);
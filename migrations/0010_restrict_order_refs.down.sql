@@ -0,0 +1,6 @@
+-- Cascade the deletion of deliveries and payments into the orders again
+ALTER TABLE orders
+    DROP CONSTRAINT IF EXISTS orders_delivery_id_fkey,
+    ADD CONSTRAINT orders_delivery_id_fkey FOREIGN KEY (delivery_id) REFERENCES deliveries (id) ON DELETE CASCADE,
+    DROP CONSTRAINT IF EXISTS orders_payment_id_fkey,
+    ADD CONSTRAINT orders_payment_id_fkey FOREIGN KEY (payment_id) REFERENCES payments (id) ON DELETE CASCADE;
@@ -0,0 +1,7 @@
+-- Deliveries and payments are shared by the orders with the same content: deleting one of them
+-- must fail while an order references it, instead of cascading into that order
+ALTER TABLE orders
+    DROP CONSTRAINT IF EXISTS orders_delivery_id_fkey,
+    ADD CONSTRAINT orders_delivery_id_fkey FOREIGN KEY (delivery_id) REFERENCES deliveries (id) ON DELETE RESTRICT,
+    DROP CONSTRAINT IF EXISTS orders_payment_id_fkey,
+    ADD CONSTRAINT orders_payment_id_fkey FOREIGN KEY (payment_id) REFERENCES payments (id) ON DELETE RESTRICT;
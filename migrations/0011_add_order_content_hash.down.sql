@@ -0,0 +1,2 @@
+-- Drop the hash of the received content of an order
+ALTER TABLE orders DROP COLUMN IF EXISTS content_hash;
@@ -0,0 +1,3 @@
+-- Hash of the received content of an order: a redelivered revision with the same content
+-- is not applied again in upsert mode, so it does not increment the version
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS content_hash VARCHAR(64);
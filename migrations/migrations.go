@@ -0,0 +1,13 @@
+// Package migrations embeds the SQL migrations of the database schema,
+// so the service binary can apply them without the migration files on disk.
+//
+// A migration is a pair of files NNNN_name.up.sql and NNNN_name.down.sql,
+// where NNNN is its version; versions are applied in ascending order.
+package migrations
+
+import "embed"
+
+// FS contains the migration files.
+//
+//go:embed *.sql
+var FS embed.FS